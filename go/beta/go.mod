@@ -1,3 +1,15 @@
 module main
 
 go 1.23.5
+
+require (
+	filippo.io/age v1.2.1
+	github.com/BurntSushi/toml v1.5.0
+	github.com/ProtonMail/go-crypto v1.3.0
+	github.com/godbus/dbus/v5 v5.1.0
+	golang.org/x/crypto v0.33.0 // indirect
+)
+
+require golang.org/x/sys v0.30.0
+
+require github.com/cloudflare/circl v1.6.1 // indirect
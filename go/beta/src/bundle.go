@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+
+	"main/src/bundle"
+	"main/src/credentials"
+)
+
+// passphraseEnv holds the passphrase of encrypted profiles for
+// non-interactive use.
+const passphraseEnv = "SVPN_PASSPHRASE"
+
+// readPassphrase returns the passphrase from the environment or asks for
+// it on the terminal.
+func readPassphrase(prompt string) (string, error) {
+	if passphrase := os.Getenv(passphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+	return credentials.PromptSecret(prompt)
+}
+
+// newPassphrase asks for a passphrase to encrypt a profile with.
+func newPassphrase() (string, error) {
+	if passphrase := os.Getenv(passphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+	passphrase, err := credentials.PromptSecret("New passphrase for the profile: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", errors.New("the passphrase must not be empty")
+	}
+	confirm, err := credentials.PromptSecret("Repeat the passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if confirm != passphrase {
+		return "", errors.New("the passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+// readProfileConfig returns the plain config at path. Encrypted bundles
+// are decrypted in memory with passphrase.
+func readProfileConfig(path, passphrase string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading OpenVPN config: %v", err)
+	}
+	if bundle.Detect(data) == bundle.Plain {
+		return data, nil
+	}
+	if passphrase == "" {
+		return nil, fmt.Errorf("%s is encrypted and no passphrase was given", path)
+	}
+	plain, err := bundle.Decrypt(data, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting %s: %v", path, err)
+	}
+	return plain, nil
+}
+
+// unlockConfig reads and validates a config, asking for the passphrase
+// when it is encrypted. It returns the plain config and the passphrase,
+// which is empty for plain configs.
+func unlockConfig(path string) ([]byte, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading OpenVPN config: %v", err)
+	}
+	return unlockConfigData(path, data)
+}
+
+// unlockConfigData is unlockConfig for a config that is already in
+// memory; name is used in prompts and error messages.
+func unlockConfigData(name string, data []byte) ([]byte, string, error) {
+	if bundle.Detect(data) == bundle.Plain {
+		if _, err := validateOVPN(name, data); err != nil {
+			return nil, "", err
+		}
+		return data, "", nil
+	}
+
+	passphrase, err := readPassphrase(fmt.Sprintf("Passphrase for %s: ", name))
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading passphrase: %v", err)
+	}
+	plain, err := bundle.Decrypt(data, passphrase)
+	if err != nil {
+		return nil, "", fmt.Errorf("error decrypting %s: %v", name, err)
+	}
+	if _, err := validateOVPN(name, plain); err != nil {
+		return nil, "", err
+	}
+	return plain, passphrase, nil
+}
+
+// configFileName returns the name a profile config of the given format
+// is stored under.
+func configFileName(format bundle.Format) string {
+	switch format {
+	case bundle.Age:
+		return "config.ovpn.age"
+	case bundle.OpenPGP:
+		return "config.ovpn.gpg"
+	}
+	return "config.ovpn"
+}
+
+// configMemfd puts a config into an anonymous in-memory file and returns
+// it with a path OpenVPN can open, also as root through sudo, for as
+// long as the file stays open. The plaintext never touches a filesystem.
+func configMemfd(data []byte) (*os.File, string, error) {
+	fd, err := unix.MemfdCreate("svpn-config", unix.MFD_CLOEXEC|unix.MFD_ALLOW_SEALING)
+	if err != nil {
+		return nil, "", fmt.Errorf("error creating in-memory config: %v", err)
+	}
+	file := os.NewFile(uintptr(fd), "svpn-config")
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return nil, "", fmt.Errorf("error writing in-memory config: %v", err)
+	}
+	// Nobody may change the config once OpenVPN can see it
+	seals := unix.F_SEAL_SHRINK | unix.F_SEAL_GROW | unix.F_SEAL_WRITE | unix.F_SEAL_SEAL
+	if _, err := unix.FcntlInt(uintptr(fd), unix.F_ADD_SEALS, seals); err != nil {
+		file.Close()
+		return nil, "", fmt.Errorf("error sealing in-memory config: %v", err)
+	}
+	return file, fmt.Sprintf("/proc/%d/fd/%d", os.Getpid(), fd), nil
+}
@@ -0,0 +1,176 @@
+// Package bundle encrypts and decrypts OpenVPN profiles at rest. New
+// bundles are age files protected by a passphrase; OpenPGP messages
+// encrypted with a passphrase, such as those made by `gpg -c`, can be
+// read as well.
+package bundle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	openpgparmor "github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// ErrWrongPassphrase is returned when a bundle cannot be decrypted with
+// the given passphrase.
+var ErrWrongPassphrase = errors.New("wrong passphrase")
+
+// Format is the encoding of a profile file.
+type Format int
+
+const (
+	Plain Format = iota
+	Age
+	OpenPGP
+)
+
+func (f Format) String() string {
+	switch f {
+	case Age:
+		return "age"
+	case OpenPGP:
+		return "OpenPGP"
+	}
+	return "plain"
+}
+
+const (
+	ageHeader     = "age-encryption.org/v1\n"
+	pgpArmorBegin = "-----BEGIN PGP MESSAGE-----"
+)
+
+// Detect tells encrypted bundles from plain configs by their header.
+func Detect(data []byte) Format {
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	switch {
+	case bytes.HasPrefix(data, []byte(ageHeader)), bytes.HasPrefix(trimmed, []byte(armor.Header)):
+		return Age
+	case bytes.HasPrefix(trimmed, []byte(pgpArmorBegin)):
+		return OpenPGP
+	case len(data) > 0 && isPGPPacket(data[0]):
+		return OpenPGP
+	}
+	return Plain
+}
+
+// isPGPPacket reports whether b starts a symmetric-key (3) or public-key
+// (1) encrypted session key packet, in old or new packet format. Plain
+// configs are text and never start with a byte that has the top bit set.
+func isPGPPacket(b byte) bool {
+	if b&0x80 == 0 {
+		return false
+	}
+	tag := b & 0x3f
+	if b&0x40 == 0 {
+		tag = (b >> 2) & 0x0f
+	}
+	return tag == 3 || tag == 1
+}
+
+// Encrypt returns an armored age file of plain, protected by passphrase.
+func Encrypt(plain []byte, passphrase string) ([]byte, error) {
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	armored := armor.NewWriter(&out)
+	w, err := age.Encrypt(armored, recipient)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(plain); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if err := armored.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Decrypt returns the plain config of a bundle. Both formats
+// authenticate the content, so a successful Decrypt also means the
+// bundle was not tampered with.
+func Decrypt(data []byte, passphrase string) ([]byte, error) {
+	switch Detect(data) {
+	case Age:
+		return decryptAge(data, passphrase)
+	case OpenPGP:
+		return decryptOpenPGP(data, passphrase)
+	}
+	return nil, errors.New("not an encrypted bundle")
+}
+
+func decryptAge(data []byte, passphrase string) ([]byte, error) {
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	var in io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte(armor.Header)) {
+		in = armor.NewReader(in)
+	}
+	r, err := age.Decrypt(in, identity)
+	if err != nil {
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) || strings.Contains(err.Error(), "incorrect passphrase") {
+			return nil, ErrWrongPassphrase
+		}
+		return nil, fmt.Errorf("error decrypting age bundle: %v", err)
+	}
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting age bundle: %v", err)
+	}
+	return plain, nil
+}
+
+func decryptOpenPGP(data []byte, passphrase string) ([]byte, error) {
+	var in io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte(pgpArmorBegin)) {
+		block, err := openpgparmor.Decode(in)
+		if err != nil {
+			return nil, fmt.Errorf("error reading armored OpenPGP bundle: %v", err)
+		}
+		in = block.Body
+	}
+
+	// The prompt is called again after a wrong passphrase; give up then
+	tried := false
+	prompt := func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		if !symmetric || tried {
+			return nil, ErrWrongPassphrase
+		}
+		tried = true
+		return []byte(passphrase), nil
+	}
+
+	md, err := openpgp.ReadMessage(in, nil, prompt, &packet.Config{})
+	if err != nil {
+		if errors.Is(err, ErrWrongPassphrase) {
+			return nil, ErrWrongPassphrase
+		}
+		return nil, fmt.Errorf("error decrypting OpenPGP bundle: %v", err)
+	}
+	// Reading to the end checks the modification detection code
+	plain, err := io.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting OpenPGP bundle: %v", err)
+	}
+	if !md.IsSymmetricallyEncrypted {
+		return nil, errors.New("OpenPGP bundle is not encrypted with a passphrase")
+	}
+	return plain, nil
+}
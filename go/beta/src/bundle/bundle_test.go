@@ -0,0 +1,70 @@
+package bundle
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+const plain = "client\nremote vpn.example.com 1194 udp\n"
+
+func TestAge(t *testing.T) {
+	data, err := Encrypt([]byte(plain), "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Detect(data) == Plain {
+		t.Fatal("Detect() took an encrypted bundle for a plain config")
+	}
+	got, err := Decrypt(data, "correct horse")
+	if err != nil || string(got) != plain {
+		t.Errorf("Decrypt() = %q, %v, want the config", got, err)
+	}
+	if _, err := Decrypt(data, "wrong"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Decrypt() with a wrong passphrase = %v, want ErrWrongPassphrase", err)
+	}
+}
+
+// gpgSymmetric encrypts like `gpg -c`, armored like `gpg -ca` if asked.
+func gpgSymmetric(t *testing.T, passphrase string, armored bool) []byte {
+	var buf bytes.Buffer
+	var out io.WriteCloser = nopCloser{&buf}
+	if armored {
+		var err error
+		if out, err = armor.Encode(&buf, "PGP MESSAGE", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w, err := openpgp.SymmetricallyEncrypt(out, []byte(passphrase), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, plain)
+	w.Close()
+	out.Close()
+	return buf.Bytes()
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+func TestOpenPGP(t *testing.T) {
+	for _, armored := range []bool{false, true} {
+		data := gpgSymmetric(t, "correct horse", armored)
+		if Detect(data) == Plain {
+			t.Fatalf("Detect() took an OpenPGP message (armored: %v) for a plain config", armored)
+		}
+		got, err := Decrypt(data, "correct horse")
+		if err != nil || string(got) != plain {
+			t.Errorf("Decrypt() (armored: %v) = %q, %v, want the config", armored, got, err)
+		}
+		if _, err := Decrypt(data, "wrong"); !errors.Is(err, ErrWrongPassphrase) {
+			t.Errorf("Decrypt() (armored: %v) with a wrong passphrase = %v, want ErrWrongPassphrase", armored, err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"main/src/dns"
+	"main/src/leak"
+	"main/src/state"
+)
+
+// checkCommand tests a running tunnel for leaks: the egress address, the
+// DNS servers in use and IPv6.
+func checkCommand(fs *flag.FlagSet) func(args []string) (any, error) {
+	echoURL := fs.String("echo-url", leak.DefaultEchoURL, "URL that answers with the address a request came from")
+	timeout := fs.Duration("timeout", 30*time.Second, "Time limit for all checks")
+	return func(args []string) (any, error) {
+		profileName, err := profileArg(fs, args)
+		if err != nil {
+			return nil, err
+		}
+
+		store, err := userProfileStore()
+		if err != nil {
+			return nil, fail(exitFailure, err)
+		}
+		profile, err := store.ResolveActive(profileName)
+		if err != nil {
+			return nil, fail(exitConfig, err)
+		}
+		tunnel, err := runningTunnel(store, profile.Name)
+		if err != nil {
+			return nil, fail(exitNotRunning, err)
+		}
+
+		checker := &leak.Checker{
+			EchoURL:   *echoURL,
+			Tunnel:    *tunnel,
+			Uplink:    leak.Uplink(tunnel.Interface),
+			Resolvers: dns.Resolvers,
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		report := checker.Run(ctx)
+
+		if !jsonOutput() {
+			fmt.Printf("Leak test of profile %q on %s:\n", profile.Name, tunnel.Interface)
+			for _, result := range report.Results {
+				fmt.Printf("  %-4s  %-9s  %s\n", strings.ToUpper(string(result.Status)), result.Name, result.Detail)
+			}
+		}
+		if !report.Passed() {
+			return report, exitCode(exitFailure)
+		}
+		return report, nil
+	}
+}
+
+// runningTunnel describes the running tunnel of a profile for the leak
+// test.
+func runningTunnel(store *profileStore, profileName string) (*leak.Tunnel, error) {
+	info, err := state.Open(store.TunnelDir(profileName)).Read()
+	if err != nil || !info.OpenVPNAlive() {
+		return nil, fmt.Errorf("VPN profile %q is not running", profileName)
+	}
+	tunnel := &leak.Tunnel{Interface: tunnelInterface(info)}
+	if tunnel.Interface == "" {
+		return nil, fmt.Errorf("the tun device of profile %q is not known yet", profileName)
+	}
+
+	iface, err := net.InterfaceByName(tunnel.Interface)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up %s: %v", tunnel.Interface, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("error reading the addresses of %s: %v", tunnel.Interface, err)
+	}
+	for _, addr := range addrs {
+		if prefix, err := netip.ParsePrefix(addr.String()); err == nil {
+			tunnel.Addrs = append(tunnel.Addrs, prefix.Addr())
+		}
+	}
+
+	j, err := dns.LoadJournal(dnsJournalPath(store.PrivateDir(profileName)))
+	if err != nil {
+		return nil, err
+	}
+	if j != nil {
+		for _, server := range j.Servers {
+			if addr, err := netip.ParseAddr(server); err == nil {
+				tunnel.DNS = append(tunnel.DNS, addr)
+			}
+		}
+	}
+	return tunnel, nil
+}
@@ -0,0 +1,299 @@
+// Package cli is a small command tree on top of the flag package. Every
+// command gets its own flags, -h/--help generated from its metadata and
+// shell completions.
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Command is a node of the command tree.
+type Command struct {
+	Name string
+	// Args describes the positional arguments in the usage line, e.g.
+	// "[profile]".
+	Args string
+	// Summary is the one-line description shown in command lists.
+	Summary string
+	// Description is shown by --help below the summary.
+	Description string
+
+	// Setup defines the command's flags on fs and returns the function
+	// that runs it; the function parses fs itself. On the root command
+	// Setup defines the global flags and returns nil.
+	Setup func(fs *flag.FlagSet) func(args []string) (any, error)
+	// Run runs a command that has no flags. Commands return their
+	// result, printed as JSON on request, or why they failed.
+	Run func(args []string) (any, error)
+	// Commands are the subcommands. A command with subcommands only
+	// dispatches to them.
+	Commands []*Command
+
+	// Complete returns completion candidates for the next positional
+	// argument, given the ones before it.
+	Complete func(args []string) []string
+	// Values returns completion candidates for the values of flags.
+	Values map[string]func() []string
+
+	// Hidden commands work but are not listed or completed.
+	Hidden bool
+
+	parent *Command
+}
+
+// UsageError is returned by Execute for unknown commands and missing
+// arguments.
+type UsageError struct {
+	Command *Command
+	Err     error
+}
+
+func (e *UsageError) Error() string { return e.Err.Error() }
+
+// HiddenPrefix marks flags that are not shown in the help, e.g.
+// "Internal: ...".
+const HiddenPrefix = "Internal:"
+
+// Path returns the command line that selects c, e.g. "svpn profile add".
+func (c *Command) Path() string {
+	if c.parent == nil {
+		return c.Name
+	}
+	return c.parent.Path() + " " + c.Name
+}
+
+// Root returns the root of the tree c belongs to.
+func (c *Command) Root() *Command {
+	for c.parent != nil {
+		c = c.parent
+	}
+	return c
+}
+
+// Lookup returns the subcommand called name, or nil.
+func (c *Command) Lookup(name string) *Command {
+	for _, sub := range c.Commands {
+		sub.parent = c
+		if sub.Name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+// Execute runs the command that args select and returns what it
+// returns. `help [command...]`, -h and --help print the help of a
+// command instead.
+func (c *Command) Execute(args []string) (any, error) {
+	cmd := c
+	for len(cmd.Commands) > 0 {
+		if len(args) == 0 {
+			return nil, &UsageError{cmd, errors.New("missing command")}
+		}
+		if isHelp(args[0]) {
+			cmd.PrintHelp(os.Stdout)
+			return nil, nil
+		}
+		if args[0] == "help" {
+			return nil, cmd.help(args[1:])
+		}
+		sub := cmd.Lookup(args[0])
+		if sub == nil {
+			return nil, &UsageError{cmd, fmt.Errorf("unknown command %q", args[0])}
+		}
+		cmd, args = sub, args[1:]
+	}
+
+	if wantsHelp(args) {
+		cmd.PrintHelp(os.Stdout)
+		return nil, nil
+	}
+	switch {
+	case cmd.Setup != nil:
+		return cmd.Setup(cmd.FlagSet())(args)
+	case cmd.Run != nil:
+		return cmd.Run(args)
+	}
+	return nil, nil
+}
+
+// help prints the help of the command path names below c.
+func (c *Command) help(path []string) error {
+	cmd := c
+	for _, name := range path {
+		sub := cmd.Lookup(name)
+		if sub == nil {
+			return &UsageError{cmd, fmt.Errorf("unknown command %q", name)}
+		}
+		cmd = sub
+	}
+	cmd.PrintHelp(os.Stdout)
+	return nil
+}
+
+// FlagSet returns an empty flag set for the command, whose usage is the
+// command's help.
+func (c *Command) FlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(c.Path(), flag.ExitOnError)
+	fs.Usage = func() { c.PrintHelp(fs.Output()) }
+	return fs
+}
+
+// flags returns the command's flags, defined without running it.
+func (c *Command) flags() *flag.FlagSet {
+	fs := c.FlagSet()
+	if c.Setup != nil {
+		c.Setup(fs)
+	}
+	return fs
+}
+
+// globalFlags returns the global flags defined by the root command and
+// -h/--help, which Execute handles.
+func (c *Command) globalFlags() *flag.FlagSet {
+	fs := c.Root().flags()
+	help := fs.Bool("help", false, "Show the help of a command")
+	fs.BoolVar(help, "h", false, "")
+	return fs
+}
+
+// PrintHelp writes the help of the command.
+func (c *Command) PrintHelp(w io.Writer) {
+	root := c.Root()
+	usage := c.Path()
+	switch {
+	case len(c.Commands) > 0:
+		usage += " <command>"
+	case c.Setup != nil && c != root:
+		usage += " [flags]"
+	}
+	if c.Args != "" {
+		usage += " " + c.Args
+	}
+	fmt.Fprintf(w, "Usage: %s\n", usage)
+	if c.Summary != "" {
+		fmt.Fprintf(w, "\n%s\n", c.Summary)
+	}
+	if c.Description != "" {
+		fmt.Fprintf(w, "\n%s\n", strings.TrimRight(c.Description, "\n"))
+	}
+
+	if subs := c.visible(); len(subs) > 0 {
+		fmt.Fprintln(w, "\nCommands:")
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		for _, sub := range subs {
+			fmt.Fprintf(tw, "  %s\t%s\n", sub.Name, sub.Summary)
+		}
+		tw.Flush()
+	}
+
+	if c != root && len(c.Commands) == 0 {
+		printFlags(w, "Flags", c.flags())
+	}
+	printFlags(w, "Global flags", root.globalFlags())
+
+	if len(c.Commands) > 0 {
+		fmt.Fprintf(w, "\nRun '%s <command> --help' for details on a command.\n", c.Path())
+	}
+}
+
+func (c *Command) visible() []*Command {
+	var subs []*Command
+	for _, sub := range c.Commands {
+		sub.parent = c
+		if !sub.Hidden {
+			subs = append(subs, sub)
+		}
+	}
+	return subs
+}
+
+// printFlags lists the flags of fs, with aliases that share a value on
+// one line, e.g. "-o, --output string".
+func printFlags(w io.Writer, title string, fs *flag.FlagSet) {
+	type entry struct {
+		names []string
+		flag  *flag.Flag
+	}
+	var entries []*entry
+	byValue := map[flag.Value]*entry{}
+	fs.VisitAll(func(f *flag.Flag) {
+		if strings.HasPrefix(f.Usage, HiddenPrefix) {
+			return
+		}
+		if e, ok := byValue[f.Value]; ok {
+			e.names = append(e.names, f.Name)
+			if len(f.Name) > len(e.flag.Name) {
+				e.flag = f
+			}
+			return
+		}
+		e := &entry{names: []string{f.Name}, flag: f}
+		byValue[f.Value] = e
+		entries = append(entries, e)
+	})
+	if len(entries) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "\n%s:\n", title)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, e := range entries {
+		sort.Slice(e.names, func(i, j int) bool { return len(e.names[i]) < len(e.names[j]) })
+		var names []string
+		for _, name := range e.names {
+			names = append(names, FlagName(name))
+		}
+		kind, usage := flag.UnquoteUsage(e.flag)
+		label := strings.Join(names, ", ")
+		if kind != "" {
+			label += " " + kind
+		}
+		if !isZero(e.flag.DefValue) {
+			usage += fmt.Sprintf(" (default %s)", e.flag.DefValue)
+		}
+		fmt.Fprintf(tw, "  %s\t%s\n", label, usage)
+	}
+	tw.Flush()
+}
+
+// FlagName returns how a flag is written: -x for one letter, --name
+// otherwise.
+func FlagName(name string) string {
+	if len(name) == 1 {
+		return "-" + name
+	}
+	return "--" + name
+}
+
+func isZero(value string) bool {
+	switch value {
+	case "", "0", "false", "0s", "[]":
+		return true
+	}
+	return false
+}
+
+func isHelp(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help"
+}
+
+// wantsHelp reports whether args ask for help before a "--".
+func wantsHelp(args []string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			return false
+		}
+		if isHelp(arg) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,213 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Completions returns the completion candidates for the last of words,
+// which are the arguments typed after the program name. An empty result
+// lets the shell complete file names.
+func (c *Command) Completions(words []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	current := words[len(words)-1]
+	globals := c.globalFlags()
+
+	cmd, fs := c, globals
+	var positional []string
+	for i := 0; i < len(words)-1; i++ {
+		word := words[i]
+		if word == "--" {
+			continue
+		}
+		if strings.HasPrefix(word, "-") && word != "-" {
+			name, _, hasValue := strings.Cut(strings.TrimLeft(word, "-"), "=")
+			if f := lookupFlag(name, fs, globals); f != nil && !hasValue && !isBoolFlag(f) {
+				i++
+			}
+			continue
+		}
+		if len(cmd.Commands) > 0 {
+			sub := cmd.Lookup(word)
+			if sub == nil || sub.Hidden {
+				return nil
+			}
+			cmd, fs = sub, sub.flags()
+			continue
+		}
+		positional = append(positional, word)
+	}
+
+	// The value of a flag
+	if len(words) > 1 {
+		prev := words[len(words)-2]
+		if strings.HasPrefix(prev, "-") && !strings.Contains(prev, "=") {
+			if f := lookupFlag(strings.TrimLeft(prev, "-"), fs, globals); f != nil && !isBoolFlag(f) {
+				return filter(cmd.flagValues(canonicalName(f, fs, globals)), current)
+			}
+		}
+	}
+	if strings.HasPrefix(current, "-") {
+		if name, value, ok := strings.Cut(strings.TrimLeft(current, "-"), "="); ok {
+			f := lookupFlag(name, fs, globals)
+			if f == nil {
+				return nil
+			}
+			prefix := current[:len(current)-len(value)]
+			var candidates []string
+			for _, v := range filter(cmd.flagValues(canonicalName(f, fs, globals)), value) {
+				candidates = append(candidates, prefix+v)
+			}
+			return candidates
+		}
+		return filter(flagNames(fs, globals), current)
+	}
+
+	if len(cmd.Commands) > 0 {
+		var names []string
+		for _, sub := range cmd.visible() {
+			names = append(names, sub.Name)
+		}
+		return filter(names, current)
+	}
+	if cmd.Complete != nil {
+		return filter(cmd.Complete(positional), current)
+	}
+	return nil
+}
+
+// flagValues returns the candidates for a flag of the command or a
+// global flag.
+func (c *Command) flagValues(name string) []string {
+	for cmd := c; cmd != nil; cmd = cmd.parent {
+		if values, ok := cmd.Values[name]; ok {
+			return values()
+		}
+	}
+	return nil
+}
+
+func lookupFlag(name string, sets ...*flag.FlagSet) *flag.Flag {
+	for _, fs := range sets {
+		if f := fs.Lookup(name); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// canonicalName returns the longest name of a flag and its aliases,
+// which share its value, e.g. "output" for -o.
+func canonicalName(f *flag.Flag, sets ...*flag.FlagSet) string {
+	name := f.Name
+	for _, fs := range sets {
+		fs.VisitAll(func(alias *flag.Flag) {
+			if alias.Value == f.Value && len(alias.Name) > len(name) {
+				name = alias.Name
+			}
+		})
+	}
+	return name
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+func flagNames(sets ...*flag.FlagSet) []string {
+	var names []string
+	for _, fs := range sets {
+		fs.VisitAll(func(f *flag.Flag) {
+			if !strings.HasPrefix(f.Usage, HiddenPrefix) {
+				names = append(names, FlagName(f.Name))
+			}
+		})
+	}
+	sort.Strings(names)
+	return names
+}
+
+func filter(candidates []string, prefix string) []string {
+	var matches []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, prefix) {
+			matches = append(matches, c)
+		}
+	}
+	return matches
+}
+
+// Shells lists the shells Script supports.
+var Shells = []string{"bash", "zsh", "fish"}
+
+// Script returns the completion script of the program for a shell. The
+// script runs `<program> <completeCommand> <words...>` to get the
+// candidates, so that they include dynamic values such as profile names.
+func (c *Command) Script(shell, completeCommand string) (string, error) {
+	name := c.Root().Name
+	var script string
+	switch shell {
+	case "bash":
+		script = bashScript
+	case "zsh":
+		script = zshScript
+	case "fish":
+		script = fishScript
+	default:
+		return "", fmt.Errorf("unsupported shell %q (expected %s)", shell, strings.Join(Shells, ", "))
+	}
+	return strings.NewReplacer("PROGRAM", name, "COMPLETE", completeCommand).Replace(script), nil
+}
+
+const bashScript = `# bash completion for PROGRAM
+_PROGRAM() {
+    local line=${COMP_LINE:0:COMP_POINT} words candidate
+    read -ra words <<< "$line"
+    [[ $line =~ [[:space:]]$ ]] && words+=("")
+    # bash replaces only the part after the last = or :
+    local cur=${words[${#words[@]}-1]}
+    local prefix=${cur%"${cur##*[=:]}"}
+    COMPREPLY=()
+    while IFS= read -r candidate; do
+        [[ -n $candidate ]] && COMPREPLY+=("${candidate#"$prefix"}")
+    done < <(PROGRAM COMPLETE "${words[@]:1}" 2>/dev/null)
+}
+complete -o default -F _PROGRAM PROGRAM
+`
+
+const zshScript = `#compdef PROGRAM
+# zsh completion for PROGRAM
+_PROGRAM() {
+    local -a candidates
+    candidates=("${(@f)$(PROGRAM COMPLETE "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+    if [[ -n ${candidates[1]} ]]; then
+        compadd -a candidates
+    else
+        _files
+    fi
+}
+if [[ $funcstack[1] == _PROGRAM ]]; then
+    _PROGRAM "$@"
+else
+    compdef _PROGRAM PROGRAM
+fi
+`
+
+const fishScript = `# fish completion for PROGRAM
+function __PROGRAM_complete
+    set -l tokens (commandline -opc)
+    set -e tokens[1]
+    set -l candidates (PROGRAM COMPLETE $tokens (commandline -ct) 2>/dev/null)
+    if test (count $candidates) -gt 0
+        printf '%s\n' $candidates
+    else
+        __fish_complete_path (commandline -ct)
+    end
+end
+complete -c PROGRAM -f -a '(__PROGRAM_complete)'
+`
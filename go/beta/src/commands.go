@@ -0,0 +1,139 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"main/src/cli"
+	"main/src/dns"
+)
+
+// completeCommand is run by the completion scripts to get candidates.
+const completeCommand = "__complete"
+
+// commandTree returns the commands of svpn. Their help and completions
+// are generated from it.
+func commandTree() *cli.Command {
+	root := &cli.Command{
+		Name:    "svpn",
+		Args:    "[args]",
+		Summary: "Connect to OpenVPN profiles in the background.",
+		Description: `Examples:
+  svpn init --sha256 <digest>  Fetch the VPN config and check its SHA-256 digest
+  svpn start                   Start the VPN of the default profile in the background
+  svpn start work              Start the VPN of the profile 'work'
+  svpn status                  Show whether the VPN is running
+  svpn stop                    Stop the VPN`,
+		Setup: func(fs *flag.FlagSet) func(args []string) (any, error) {
+			new(globalOptions).define(fs)
+			return nil
+		},
+		Values: map[string]func() []string{
+			"output": staticValues(outputText, outputJSON),
+		},
+	}
+
+	root.Commands = []*cli.Command{
+		{Name: "init", Summary: "Fetch and verify the VPN configuration", Setup: initVPN,
+			Description: "Every config must be verified: pass --sha256 with its digest, or --signature\nwith a detached OpenPGP signature and --key with the signing key.",
+			Values:      map[string]func() []string{"profile": profileNames}},
+		{Name: "start", Args: "[profile]", Summary: "Start the VPN connection", Setup: main_vpn,
+			Complete: completeProfile,
+			Values: map[string]func() []string{
+				"dns":      staticValues(dns.MethodAuto, dns.MethodResolved, dns.MethodResolvConf, dns.MethodOff),
+				"auth-via": staticValues("management", "file"),
+				"notify":   staticValues("all", "none", "connected", "disconnected", "reconnecting", "auth-failed"),
+			}},
+		{Name: "stop", Args: "[profile]", Summary: "Stop the VPN connection", Setup: killVPN,
+			Complete: completeProfile},
+		{Name: "status", Args: "[profile]", Summary: "Show the VPN connection status", Setup: statusVPN,
+			Complete: completeProfile},
+		{Name: "check", Args: "[profile]", Summary: "Test the running VPN for IP, DNS and IPv6 leaks", Setup: checkCommand,
+			Complete: completeProfile},
+		{Name: "servers", Args: "[profile]", Summary: "Measure the latency of the profile's servers", Setup: serversCommand,
+			Complete: completeProfile},
+		{Name: "reconnect", Args: "[profile]", Summary: "Restart the VPN connection", Setup: reconnectVPN,
+			Complete: completeProfile},
+		{Name: "logs", Args: "[profile]", Summary: "Show the VPN logs", Setup: logsCommand,
+			Complete: completeProfile,
+			Values: map[string]func() []string{
+				"level": staticValues("debug", "info", "warning", "error", "fatal"),
+			}},
+		{Name: "dns", Summary: "Show or restore the DNS settings svpn changed", Commands: []*cli.Command{
+			{Name: "status", Args: "[profile]", Summary: "Show the DNS settings applied for the VPN", Setup: dnsStatus,
+				Complete: completeProfile},
+			{Name: "restore", Args: "[profile]", Summary: "Restore the DNS settings from before the VPN", Setup: dnsRestore,
+				Complete: completeProfile},
+			{Name: "apply", Args: "[profile]", Summary: "Apply DNS settings as root", Setup: dnsApply, Hidden: true},
+		}},
+		{Name: "killswitch", Summary: "Remove or inspect the kill switch", Commands: []*cli.Command{
+			{Name: "off", Summary: "Remove the kill switch", Run: killswitchOff},
+			{Name: "status", Summary: "Show whether the kill switch is on", Run: killswitchStatus},
+		}},
+		{Name: "update", Args: "[profile]", Summary: "Fetch the profile's config again and show what changed", Setup: updateCommand,
+			Complete: completeProfile},
+		{Name: "rollback", Args: "[profile]", Summary: "Restore the config replaced by the last update", Setup: rollbackCommand,
+			Complete: completeProfile},
+		{Name: "profile", Summary: "Manage profiles", Commands: []*cli.Command{
+			{Name: "add", Args: "<name> <file.ovpn>", Summary: "Add a profile from an .ovpn file", Setup: profileAdd},
+			{Name: "list", Summary: "List the profiles", Run: withProfileStore(profileList)},
+			{Name: "show", Args: "<name>", Summary: "Show a profile", Run: withProfileStore(profileShow),
+				Complete: completeProfile},
+			{Name: "remove", Args: "<name>", Summary: "Remove a profile", Run: withProfileStore(profileRemove),
+				Complete: completeProfile},
+			{Name: "default", Args: "<name>", Summary: "Make a profile the default", Run: withProfileStore(profileDefault),
+				Complete: completeProfile},
+			{Name: "encrypt", Args: "<name>", Summary: "Encrypt the config of a profile", Run: withProfileStore(profileEncrypt),
+				Complete: completeProfile},
+		}},
+		{Name: "config", Summary: "Show or check the config files", Commands: []*cli.Command{
+			{Name: "show", Summary: "Show the settings in effect", Run: configShow},
+			{Name: "validate", Args: "[file]", Summary: "Check the config files for mistakes", Run: configValidate},
+		}},
+		{Name: "completion", Args: "<bash|zsh|fish>", Summary: "Print the shell completion script",
+			Run: func(args []string) (any, error) { return completionCommand(root, args) },
+			Complete: func(args []string) []string {
+				if len(args) > 0 {
+					return nil
+				}
+				return cli.Shells
+			}},
+		{Name: "svpnd", Summary: "Run the privileged helper", Setup: runHelper, Hidden: true},
+	}
+	return root
+}
+
+// completionCommand prints the completion script of a shell.
+func completionCommand(root *cli.Command, args []string) (any, error) {
+	if len(args) != 1 {
+		return nil, failf(exitUsage, "usage: svpn completion <bash|zsh|fish>")
+	}
+	script, err := root.Script(args[0], completeCommand)
+	if err != nil {
+		return nil, fail(exitUsage, err)
+	}
+	fmt.Print(script)
+	return nil, nil
+}
+
+// completeProfile completes a single profile name argument.
+func completeProfile(args []string) []string {
+	if len(args) > 0 {
+		return nil
+	}
+	return profileNames()
+}
+
+// profileNames lists the profiles of the user and the system ones.
+func profileNames() []string {
+	store, err := userProfileStore()
+	if err != nil {
+		return nil
+	}
+	names, _ := store.List()
+	return names
+}
+
+func staticValues(values ...string) func() []string {
+	return func() []string { return values }
+}
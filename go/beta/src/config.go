@@ -0,0 +1,285 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"main/src/config"
+	"main/src/credentials"
+	"main/src/dns"
+	"main/src/fetch"
+	"main/src/health"
+	"main/src/hooks"
+	"main/src/notify"
+	"main/src/paths"
+)
+
+// defaultOpenVPN is the openvpn binary used unless the config names
+// another one.
+const defaultOpenVPN = "/usr/sbin/openvpn"
+
+// loadConfig reads the system and the user's config file and the
+// environment.
+func loadConfig(dirs *paths.Dirs) (*config.Config, error) {
+	return config.Load(os.Getenv, paths.SystemConfigFile, dirs.ConfigFile)
+}
+
+// flagSet reports whether a flag was given on the command line.
+func flagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) { set = set || f.Name == name })
+	return set
+}
+
+// settingFlags returns the flags of `svpn start` that the config files
+// and the environment set for a profile. The credentials are left to
+// Profile.CredentialsSpec.
+func settingFlags(settings *config.Config, s config.Settings) map[string]string {
+	flags := map[string]string{}
+	if settings.OpenVPN != "" {
+		flags["openvpn"] = settings.OpenVPN
+	}
+	if s.AuthVia != "" {
+		flags["auth-via"] = s.AuthVia
+	}
+	if s.DNS != "" {
+		flags["dns"] = s.DNS
+	}
+	if s.Killswitch != nil {
+		flags["killswitch"] = strconv.FormatBool(*s.Killswitch)
+	}
+	if s.Supervise != nil {
+		flags["supervise"] = strconv.FormatBool(*s.Supervise)
+	}
+	if s.Health.Interval != nil {
+		flags["health-interval"] = s.Health.Interval.String()
+	}
+	if s.Health.Failures != nil {
+		flags["health-failures"] = strconv.Itoa(*s.Health.Failures)
+	}
+	if s.Health.Probe != "" {
+		flags["health-probe"] = s.Health.Probe
+	}
+	if events, set := notifyEvents(s.Notify); set {
+		flags["notify"] = events
+	}
+	return flags
+}
+
+// applySettings sets the flags the command line left out to the values
+// in flags, and returns them as arguments for a later stage.
+func applySettings(fs *flag.FlagSet, flags map[string]string) ([]string, error) {
+	names := make([]string, 0, len(flags))
+	for name := range flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var args []string
+	for _, name := range names {
+		if fs.Lookup(name) == nil || flagSet(fs, name) {
+			continue
+		}
+		if err := fs.Set(name, flags[name]); err != nil {
+			return nil, fmt.Errorf("invalid %s %q in the config: %v", name, flags[name], err)
+		}
+		// The = form also works for boolean flags
+		args = append(args, "--"+name+"="+flags[name])
+	}
+	return args, nil
+}
+
+// notifyEvents returns the --notify value for the events the config
+// leaves on, and false when it sets none.
+func notifyEvents(n config.Notify) (string, bool) {
+	var events []string
+	set := false
+	for _, event := range notify.Events {
+		on := n.Event(string(event))
+		set = set || on != nil
+		if on == nil || *on {
+			events = append(events, string(event))
+		}
+	}
+	if len(events) == 0 {
+		return "none", set
+	}
+	return strings.Join(events, ","), set
+}
+
+// configuredCredentials returns the credentials backend the config files
+// or the environment set for all profiles, "" if there is none.
+func configuredCredentials(settings *config.Config) string {
+	if settings.Env.Credentials != "" {
+		return settings.Env.Credentials
+	}
+	return settings.Credentials
+}
+
+// checkSettings returns the problems with settings, named by the keys
+// they are set with, prefixed by prefix.
+func checkSettings(prefix string, s config.Settings) []string {
+	var problems []string
+	if s.Credentials != "" {
+		if _, err := credentials.New(s.Credentials); err != nil {
+			problems = append(problems, fmt.Sprintf("%scredentials: %v", prefix, err))
+		}
+	}
+	if s.AuthVia != "" && s.AuthVia != "management" && s.AuthVia != "file" {
+		problems = append(problems, fmt.Sprintf("%sauth_via: unknown value %q (expected management or file)", prefix, s.AuthVia))
+	}
+	if s.DNS != "" && !dns.ValidMethod(s.DNS) {
+		problems = append(problems, fmt.Sprintf("%sdns: unknown value %q (expected auto, resolved, resolvconf or off)", prefix, s.DNS))
+	}
+	if s.Health.Interval != nil && *s.Health.Interval < 0 {
+		problems = append(problems, fmt.Sprintf("%shealth.interval: must not be negative", prefix))
+	}
+	if s.Health.Failures != nil && *s.Health.Failures < 1 {
+		problems = append(problems, fmt.Sprintf("%shealth.failures: must be at least 1", prefix))
+	}
+	if s.Health.Probe != "" {
+		if _, err := health.Parse(s.Health.Probe, nil); err != nil {
+			problems = append(problems, fmt.Sprintf("%shealth.probe: %v", prefix, err))
+		}
+	}
+	if s.Hooks.Timeout != nil && *s.Hooks.Timeout <= 0 {
+		problems = append(problems, fmt.Sprintf("%shooks.timeout: must be positive", prefix))
+	}
+	for _, event := range hooks.Events {
+		key := strings.ReplaceAll(string(event), "-", "_")
+		for i, command := range s.Hooks.Event(string(event)) {
+			if strings.TrimSpace(command) == "" {
+				problems = append(problems, fmt.Sprintf("%shooks.%s[%d]: empty command", prefix, key, i))
+			}
+		}
+	}
+	return problems
+}
+
+// checkConfig returns the problems with a config: unknown keys, invalid
+// values and files that do not exist.
+func checkConfig(settings *config.Config) []string {
+	var problems []string
+	for _, key := range settings.Unknown {
+		problems = append(problems, "unknown key "+key)
+	}
+
+	if settings.OpenVPN != "" {
+		info, err := os.Stat(settings.OpenVPN)
+		switch {
+		case !filepath.IsAbs(settings.OpenVPN):
+			problems = append(problems, fmt.Sprintf("openvpn: %q is not an absolute path", settings.OpenVPN))
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("openvpn: %v", err))
+		case info.IsDir() || info.Mode()&0111 == 0:
+			problems = append(problems, fmt.Sprintf("openvpn: %s is not executable", settings.OpenVPN))
+		}
+	}
+	if settings.DefaultProfile != "" {
+		if err := validateProfileName(settings.DefaultProfile); err != nil {
+			problems = append(problems, "default_profile: "+err.Error())
+		}
+	}
+	if url := settings.ConfigURL; url != "" && !fetch.IsURL(url) && !filepath.IsAbs(url) {
+		problems = append(problems, fmt.Sprintf("config_url: %q is neither a URL nor an absolute path", url))
+	}
+
+	problems = append(problems, checkSettings("", settings.Settings)...)
+	problems = append(problems, checkSettings("environment: ", settings.Env)...)
+	for _, name := range settings.ProfileNames() {
+		prefix := "profiles." + name + "."
+		if err := validateProfileName(name); err != nil {
+			problems = append(problems, "profiles."+name+": "+err.Error())
+		}
+		profile := settings.Profiles[name]
+		if profile.Config == "" {
+			problems = append(problems, prefix+"config: missing")
+		} else if _, err := os.Stat(profile.Config); err != nil {
+			problems = append(problems, fmt.Sprintf("%sconfig: %v", prefix, err))
+		}
+		problems = append(problems, checkSettings(prefix, profile.Settings)...)
+	}
+	return problems
+}
+
+// configShow prints the settings in effect.
+func configShow(args []string) (any, error) {
+	if len(args) != 0 {
+		return nil, failf(exitUsage, "usage: svpn config show")
+	}
+	settings, err := loadUserConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	effective := settings.Effective()
+	if jsonOutput() {
+		return effective, nil
+	}
+	if len(settings.Files) == 0 {
+		fmt.Println("# No config file found")
+	}
+	for _, file := range settings.Files {
+		fmt.Println("# Read from", file)
+	}
+	fmt.Println("# Settings left out have the defaults of 'svpn start --help'")
+	if err := effective.Encode(os.Stdout); err != nil {
+		return nil, fail(exitFailure, err)
+	}
+	return effective, nil
+}
+
+// configValidate checks the config files, or the given file alone.
+func configValidate(args []string) (any, error) {
+	var settings *config.Config
+	var err error
+	switch len(args) {
+	case 0:
+		if settings, err = loadUserConfig(); err != nil {
+			return nil, err
+		}
+	case 1:
+		if _, err = os.Stat(args[0]); err == nil {
+			settings, err = config.Load(nil, args[0])
+		}
+		if err != nil {
+			return nil, fail(exitConfig, err)
+		}
+	default:
+		return nil, failf(exitUsage, "usage: svpn config validate [file]")
+	}
+
+	problems := checkConfig(settings)
+	result := map[string][]string{"files": settings.Files, "problems": problems}
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Println(" ", problem)
+		}
+		return result, failf(exitConfig, "found %d mistakes in the config", len(problems))
+	}
+	if len(settings.Files) == 0 {
+		fmt.Println("No config file found, svpn uses its defaults")
+	}
+	for _, file := range settings.Files {
+		fmt.Printf("%s is valid\n", file)
+	}
+	return result, nil
+}
+
+// loadUserConfig loads the config of the user svpn acts for.
+func loadUserConfig() (*config.Config, error) {
+	dirs, err := paths.Current()
+	if err != nil {
+		return nil, fail(exitFailure, err)
+	}
+	settings, err := loadConfig(dirs)
+	if err != nil {
+		return nil, fail(exitConfig, err)
+	}
+	return settings, nil
+}
@@ -0,0 +1,406 @@
+// Package config reads the settings svpn takes from TOML files instead
+// of the command line. The system file /etc/svpn/config.toml is read
+// first, the user's file overrides it key by key and SVPN_* environment
+// variables override both; flags, which the caller applies, override
+// everything. A profile section overrides the global settings of its own
+// file only, so the user's global settings win over a system profile's.
+//
+//	openvpn = "/usr/sbin/openvpn"
+//	default_profile = "work"
+//	dns = "resolved"
+//
+//	[health]
+//	interval = "1m"
+//
+//	[notify]
+//	connected = false
+//
+//	[profiles.work]
+//	config = "work.ovpn"
+//	credentials = "pass:vpn/work"
+//	killswitch = true
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Settings are the options of a tunnel that can be set for all profiles
+// and per profile. Unset fields are empty or nil.
+type Settings struct {
+	Credentials string `toml:"credentials,omitempty" json:"credentials,omitempty"`
+	AuthVia     string `toml:"auth_via,omitempty" json:"auth_via,omitempty"`
+	DNS         string `toml:"dns,omitempty" json:"dns,omitempty"`
+	Killswitch  *bool  `toml:"killswitch,omitempty" json:"killswitch,omitempty"`
+	Supervise   *bool  `toml:"supervise,omitempty" json:"supervise,omitempty"`
+	Health      Health `toml:"health,omitempty" json:"health,omitempty"`
+	Hooks       Hooks  `toml:"hooks,omitempty" json:"hooks,omitempty"`
+	Notify      Notify `toml:"notify,omitempty" json:"notify,omitempty"`
+}
+
+// Health are the settings of the tunnel's health checks.
+type Health struct {
+	Interval *time.Duration `toml:"interval,omitempty" json:"interval,omitempty"`
+	Failures *int           `toml:"failures,omitempty" json:"failures,omitempty"`
+	Probe    string         `toml:"probe,omitempty" json:"probe,omitempty"`
+}
+
+// Hooks are shell commands run on events of the tunnel, see package
+// hooks.
+type Hooks struct {
+	PreUp     []string       `toml:"pre_up,omitempty" json:"pre_up,omitempty"`
+	Up        []string       `toml:"up,omitempty" json:"up,omitempty"`
+	Down      []string       `toml:"down,omitempty" json:"down,omitempty"`
+	Reconnect []string       `toml:"reconnect,omitempty" json:"reconnect,omitempty"`
+	Timeout   *time.Duration `toml:"timeout,omitempty" json:"timeout,omitempty"`
+}
+
+// Event returns the commands of an event such as "pre-up".
+func (h Hooks) Event(event string) []string {
+	switch event {
+	case "pre-up":
+		return h.PreUp
+	case "up":
+		return h.Up
+	case "down":
+		return h.Down
+	case "reconnect":
+		return h.Reconnect
+	}
+	return nil
+}
+
+// Notify turns the desktop notifications of events on or off, see
+// package notify. Unset events are notified about.
+type Notify struct {
+	Connected    *bool `toml:"connected,omitempty" json:"connected,omitempty"`
+	Disconnected *bool `toml:"disconnected,omitempty" json:"disconnected,omitempty"`
+	Reconnecting *bool `toml:"reconnecting,omitempty" json:"reconnecting,omitempty"`
+	AuthFailed   *bool `toml:"auth_failed,omitempty" json:"auth_failed,omitempty"`
+}
+
+// Event returns the setting of an event such as "auth-failed".
+func (n Notify) Event(event string) *bool {
+	switch event {
+	case "connected":
+		return n.Connected
+	case "disconnected":
+		return n.Disconnected
+	case "reconnecting":
+		return n.Reconnecting
+	case "auth-failed":
+		return n.AuthFailed
+	}
+	return nil
+}
+
+// Merge overrides the settings with those set in o. Hooks add up
+// instead: those of o run after the ones already there.
+func (s *Settings) Merge(o Settings) {
+	if o.Credentials != "" {
+		s.Credentials = o.Credentials
+	}
+	if o.AuthVia != "" {
+		s.AuthVia = o.AuthVia
+	}
+	if o.DNS != "" {
+		s.DNS = o.DNS
+	}
+	if o.Killswitch != nil {
+		s.Killswitch = o.Killswitch
+	}
+	if o.Supervise != nil {
+		s.Supervise = o.Supervise
+	}
+	if o.Health.Interval != nil {
+		s.Health.Interval = o.Health.Interval
+	}
+	if o.Health.Failures != nil {
+		s.Health.Failures = o.Health.Failures
+	}
+	if o.Health.Probe != "" {
+		s.Health.Probe = o.Health.Probe
+	}
+	s.Hooks.PreUp = slices.Concat(s.Hooks.PreUp, o.Hooks.PreUp)
+	s.Hooks.Up = slices.Concat(s.Hooks.Up, o.Hooks.Up)
+	s.Hooks.Down = slices.Concat(s.Hooks.Down, o.Hooks.Down)
+	s.Hooks.Reconnect = slices.Concat(s.Hooks.Reconnect, o.Hooks.Reconnect)
+	if o.Hooks.Timeout != nil {
+		s.Hooks.Timeout = o.Hooks.Timeout
+	}
+	if o.Notify.Connected != nil {
+		s.Notify.Connected = o.Notify.Connected
+	}
+	if o.Notify.Disconnected != nil {
+		s.Notify.Disconnected = o.Notify.Disconnected
+	}
+	if o.Notify.Reconnecting != nil {
+		s.Notify.Reconnecting = o.Notify.Reconnecting
+	}
+	if o.Notify.AuthFailed != nil {
+		s.Notify.AuthFailed = o.Notify.AuthFailed
+	}
+}
+
+// Profile is a profile declared in a config file rather than added with
+// `svpn profile add`. Its settings override the global ones.
+type Profile struct {
+	// Config is the .ovpn file, relative to the config file's directory
+	// unless it is absolute.
+	Config string `toml:"config" json:"config"`
+	Settings
+
+	// File is the config file declaring the profile.
+	File string `toml:"-" json:"file"`
+}
+
+// Config is the content of the config files.
+type Config struct {
+	// OpenVPN is the openvpn binary.
+	OpenVPN string `toml:"openvpn,omitempty" json:"openvpn,omitempty"`
+	// DefaultProfile is used when a command names no profile.
+	DefaultProfile string `toml:"default_profile,omitempty" json:"default_profile,omitempty"`
+	// ConfigURL is where `svpn init` fetches the config from.
+	ConfigURL string `toml:"config_url,omitempty" json:"config_url,omitempty"`
+	Settings
+	Profiles map[string]*Profile `toml:"profiles,omitempty" json:"profiles,omitempty"`
+
+	// Files are the files that were read, in order.
+	Files []string `toml:"-" json:"files,omitempty"`
+	// Unknown are keys no setting uses, as "<key> in <file>".
+	Unknown []string `toml:"-" json:"unknown,omitempty"`
+	// Env are the settings taken from the environment. They override
+	// those of every profile.
+	Env Settings `toml:"-" json:"-"`
+	// EnvDefault is the default profile set in the environment.
+	EnvDefault string `toml:"-" json:"-"`
+
+	// layers are the global settings of each file read, in order.
+	layers []layer
+}
+
+// layer is the global settings of one config file.
+type layer struct {
+	file     string
+	settings Settings
+}
+
+// Load reads the config files that exist, later ones overriding earlier
+// ones, and then the environment through getenv, which may be nil.
+// A profile declared again replaces the earlier declaration.
+func Load(getenv func(string) string, files ...string) (*Config, error) {
+	c := &Config{}
+	for _, file := range files {
+		var f Config
+		md, err := toml.DecodeFile(file, &f)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", file, err)
+		}
+		c.Files = append(c.Files, file)
+		for _, key := range md.Undecoded() {
+			c.Unknown = append(c.Unknown, fmt.Sprintf("%s in %s", key, file))
+		}
+		c.merge(&f, file)
+	}
+
+	if getenv != nil {
+		if err := c.loadEnv(getenv); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *Config) merge(f *Config, file string) {
+	if f.OpenVPN != "" {
+		c.OpenVPN = f.OpenVPN
+	}
+	if f.DefaultProfile != "" {
+		c.DefaultProfile = f.DefaultProfile
+	}
+	if f.ConfigURL != "" {
+		c.ConfigURL = f.ConfigURL
+	}
+	c.Settings.Merge(f.Settings)
+	c.layers = append(c.layers, layer{file: file, settings: f.Settings})
+
+	for name, profile := range f.Profiles {
+		if profile == nil {
+			continue
+		}
+		if profile.Config != "" && !filepath.IsAbs(profile.Config) {
+			profile.Config = filepath.Join(filepath.Dir(file), profile.Config)
+		}
+		profile.File = file
+		if c.Profiles == nil {
+			c.Profiles = map[string]*Profile{}
+		}
+		c.Profiles[name] = profile
+	}
+}
+
+// Environment variables and the settings they override.
+const (
+	EnvOpenVPN        = "SVPN_OPENVPN"
+	EnvProfile        = "SVPN_PROFILE"
+	EnvConfigURL      = "SVPN_CONFIG_URL"
+	EnvCredentials    = "SVPN_CREDENTIALS"
+	EnvAuthVia        = "SVPN_AUTH_VIA"
+	EnvDNS            = "SVPN_DNS"
+	EnvKillswitch     = "SVPN_KILLSWITCH"
+	EnvSupervise      = "SVPN_SUPERVISE"
+	EnvHealthInterval = "SVPN_HEALTH_INTERVAL"
+	EnvHealthFailures = "SVPN_HEALTH_FAILURES"
+	EnvHealthProbe    = "SVPN_HEALTH_PROBE"
+)
+
+func (c *Config) loadEnv(getenv func(string) string) error {
+	for name, field := range map[string]*string{
+		EnvOpenVPN:     &c.OpenVPN,
+		EnvProfile:     &c.EnvDefault,
+		EnvConfigURL:   &c.ConfigURL,
+		EnvCredentials: &c.Env.Credentials,
+		EnvAuthVia:     &c.Env.AuthVia,
+		EnvDNS:         &c.Env.DNS,
+		EnvHealthProbe: &c.Env.Health.Probe,
+	} {
+		if value := getenv(name); value != "" {
+			*field = value
+		}
+	}
+
+	for name, field := range map[string]**bool{
+		EnvKillswitch: &c.Env.Killswitch,
+		EnvSupervise:  &c.Env.Supervise,
+	} {
+		if value := getenv(name); value != "" {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q: expected true or false", name, value)
+			}
+			*field = &b
+		}
+	}
+
+	if value := getenv(EnvHealthInterval); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: expected a duration such as 30s", EnvHealthInterval, value)
+		}
+		c.Env.Health.Interval = &d
+	}
+	if value := getenv(EnvHealthFailures); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: expected a number", EnvHealthFailures, value)
+		}
+		c.Env.Health.Failures = &n
+	}
+	return nil
+}
+
+// For returns the settings of a profile. Each file's global settings
+// are overridden by the profile's own if that file declares it, and
+// later files override earlier ones; the environment overrides all.
+func (c *Config) For(name string) Settings {
+	layers := c.layers
+	// A Config built in code rather than loaded has no layers
+	if layers == nil {
+		layers = []layer{{settings: c.Settings}}
+	}
+
+	profile := c.Profiles[name]
+	var s Settings
+	applied := false
+	for _, l := range layers {
+		s.Merge(l.settings)
+		if profile != nil && profile.File == l.file {
+			s.Merge(profile.Settings)
+			applied = true
+		}
+	}
+	if profile != nil && !applied {
+		s.Merge(profile.Settings)
+	}
+	s.Merge(c.Env)
+	return s
+}
+
+// ProfileNames returns the names of the declared profiles, sorted.
+func (c *Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Effective returns the config with the environment applied. The
+// environment overrides the global settings and, as those then apply,
+// removes the same settings from the profiles.
+func (c *Config) Effective() *Config {
+	e := *c
+	if c.EnvDefault != "" {
+		e.DefaultProfile = c.EnvDefault
+	}
+	e.Settings.Merge(c.Env)
+	e.Profiles = map[string]*Profile{}
+	for name, profile := range c.Profiles {
+		p := *profile
+		p.Settings.unset(c.Env)
+		e.Profiles[name] = &p
+	}
+	return &e
+}
+
+// unset clears the settings that are set in o.
+func (s *Settings) unset(o Settings) {
+	if o.Credentials != "" {
+		s.Credentials = ""
+	}
+	if o.AuthVia != "" {
+		s.AuthVia = ""
+	}
+	if o.DNS != "" {
+		s.DNS = ""
+	}
+	if o.Killswitch != nil {
+		s.Killswitch = nil
+	}
+	if o.Supervise != nil {
+		s.Supervise = nil
+	}
+	if o.Health.Interval != nil {
+		s.Health.Interval = nil
+	}
+	if o.Health.Failures != nil {
+		s.Health.Failures = nil
+	}
+	if o.Health.Probe != "" {
+		s.Health.Probe = ""
+	}
+	if o.Hooks.Timeout != nil {
+		s.Hooks.Timeout = nil
+	}
+}
+
+// Encode writes the config as TOML.
+func (c *Config) Encode(w io.Writer) error {
+	enc := toml.NewEncoder(w)
+	enc.Indent = ""
+	return enc.Encode(c)
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestForPrecedence(t *testing.T) {
+	tests := []struct {
+		name   string
+		system string
+		user   string
+		env    map[string]string
+		want   Settings
+	}{
+		{
+			name:   "user global over system global",
+			system: `dns = "system"`,
+			user:   `dns = "user"`,
+			want:   Settings{DNS: "user"},
+		},
+		{
+			name:   "system profile over system global",
+			system: "dns = \"system\"\n[profiles.work]\ndns = \"system-work\"",
+			want:   Settings{DNS: "system-work"},
+		},
+		{
+			name:   "user global over system profile",
+			system: "[profiles.work]\ndns = \"system-work\"\nauth_via = \"management\"",
+			user:   `dns = "user"`,
+			want:   Settings{DNS: "user", AuthVia: "management"},
+		},
+		{
+			name: "user profile over user global",
+			user: "dns = \"user\"\n[profiles.work]\ndns = \"user-work\"",
+			want: Settings{DNS: "user-work"},
+		},
+		{
+			name:   "user profile replaces system profile",
+			system: "[profiles.work]\ndns = \"system-work\"\nauth_via = \"management\"",
+			user:   "[profiles.work]\ndns = \"user-work\"",
+			want:   Settings{DNS: "user-work"},
+		},
+		{
+			name:   "other profiles do not apply",
+			system: "dns = \"system\"\n[profiles.home]\ndns = \"system-home\"",
+			want:   Settings{DNS: "system"},
+		},
+		{
+			name:   "environment over everything",
+			system: "[profiles.work]\ndns = \"system-work\"",
+			user:   "[profiles.work]\ndns = \"user-work\"",
+			env:    map[string]string{EnvDNS: "env"},
+			want:   Settings{DNS: "env"},
+		},
+		{
+			name:   "hooks add up in file order",
+			system: "[hooks]\nup = [\"system\"]\n[profiles.work.hooks]\nup = [\"system-work\"]",
+			user:   "[hooks]\nup = [\"user\"]",
+			want:   Settings{Hooks: Hooks{Up: []string{"system", "system-work", "user"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			system := writeFile(t, dir, "system.toml", tt.system)
+			user := writeFile(t, dir, "user.toml", tt.user)
+			c, err := Load(func(key string) string { return tt.env[key] }, system, user)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.For("work"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("For() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForWithoutFiles(t *testing.T) {
+	c := &Config{
+		Settings: Settings{DNS: "global", AuthVia: "management"},
+		Profiles: map[string]*Profile{"work": {Settings: Settings{DNS: "work"}}},
+	}
+	want := Settings{DNS: "work", AuthVia: "management"}
+	if got := c.For("work"); !reflect.DeepEqual(got, want) {
+		t.Errorf("For() = %+v, want %+v", got, want)
+	}
+}
+
+// writeFile writes a config file, or returns a path that does not exist
+// for empty content.
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if content == "" {
+		return path
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,75 @@
+// Package credentials provides pluggable backends that supply the
+// username and password OpenVPN needs for auth-user-pass.
+package credentials
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DefaultSpec is the backend used when none has been configured.
+const DefaultSpec = "prompt"
+
+// ErrNotFound is returned when a backend has no credentials stored.
+var ErrNotFound = errors.New("credentials not found")
+
+// Credentials is a username/password pair.
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// Provider is a source of VPN credentials.
+type Provider interface {
+	// Name returns the backend spec, suitable for storing and passing
+	// back to New.
+	Name() string
+	// Get returns the credentials, prompting or unlocking as needed.
+	Get() (*Credentials, error)
+}
+
+// New returns the provider for a backend spec. Supported specs are:
+//
+//	prompt                  ask on the terminal
+//	env                     read SVPN_USERNAME and SVPN_PASSWORD
+//	file:<path>             read a two-line file with mode 0600 or stricter
+//	secret-service[:<key>]  look up an item in the freedesktop Secret Service
+//	pass:<entry>            read an entry from the pass password store
+func New(spec string) (Provider, error) {
+	backend, arg, _ := strings.Cut(spec, ":")
+
+	switch backend {
+	case "prompt":
+		return NewPromptProvider(), nil
+	case "env":
+		return NewEnvProvider(), nil
+	case "file":
+		if arg == "" {
+			return nil, fmt.Errorf("credentials backend %q needs a path, e.g. file:/path/to/auth.txt", backend)
+		}
+		return &FileProvider{Path: arg}, nil
+	case "secret-service":
+		if arg == "" {
+			arg = DefaultSecretServiceKey
+		}
+		return &SecretServiceProvider{Key: arg}, nil
+	case "pass":
+		if arg == "" {
+			return nil, fmt.Errorf("credentials backend %q needs an entry name, e.g. pass:vpn/work", backend)
+		}
+		return &PassProvider{Entry: arg}, nil
+	default:
+		return nil, fmt.Errorf("unknown credentials backend %q", backend)
+	}
+}
+
+// Parse parses the OpenVPN auth-user-pass format: the username on the
+// first line and the password on the second.
+func Parse(data string) (*Credentials, error) {
+	lines := strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n")
+	if len(lines) < 2 || lines[0] == "" || lines[1] == "" {
+		return nil, errors.New("expected the username on the first line and the password on the second")
+	}
+	return &Credentials{Username: lines[0], Password: lines[1]}, nil
+}
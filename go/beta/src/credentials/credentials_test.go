@@ -0,0 +1,143 @@
+package credentials
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		spec string
+		name string // Name() of the provider, "" for an error
+	}{
+		{"prompt", "prompt"},
+		{"env", "env"},
+		{"file:/etc/svpn/auth.txt", "file:/etc/svpn/auth.txt"},
+		{"file:", ""},
+		{"secret-service", "secret-service:" + DefaultSecretServiceKey},
+		{"secret-service:work", "secret-service:work"},
+		{"pass:vpn/work", "pass:vpn/work"},
+		{"pass", ""},
+		{"keychain", ""},
+	}
+	for _, tt := range tests {
+		p, err := New(tt.spec)
+		switch {
+		case tt.name == "" && err == nil:
+			t.Errorf("New(%q) = %s, want an error", tt.spec, p.Name())
+		case tt.name != "" && err != nil:
+			t.Errorf("New(%q) = %v", tt.spec, err)
+		case tt.name != "" && p.Name() != tt.name:
+			t.Errorf("New(%q).Name() = %q, want %q", tt.spec, p.Name(), tt.name)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		data string
+		want *Credentials
+	}{
+		{"alice\ns3cret\n", &Credentials{"alice", "s3cret"}},
+		{"alice\r\ns3 cret\r\n", &Credentials{"alice", "s3 cret"}},
+		{"alice\ns3cret", &Credentials{"alice", "s3cret"}},
+		{"alice\n", nil},
+		{"\ns3cret\n", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.data)
+		switch {
+		case tt.want == nil && err == nil:
+			t.Errorf("Parse(%q) = %+v, want an error", tt.data, got)
+		case tt.want != nil && (err != nil || *got != *tt.want):
+			t.Errorf("Parse(%q) = %+v, %v, want %+v", tt.data, got, err, tt.want)
+		}
+	}
+}
+
+func TestEnvProvider(t *testing.T) {
+	p := NewEnvProvider()
+	t.Setenv(UsernameEnv, "alice")
+	t.Setenv(PasswordEnv, "")
+	if creds, err := p.Get(); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() without a password = %v, %v, want %v", creds, err, ErrNotFound)
+	}
+	t.Setenv(PasswordEnv, "s3cret")
+	if creds, err := p.Get(); err != nil || *creds != (Credentials{"alice", "s3cret"}) {
+		t.Errorf("Get() = %v, %v, want alice's credentials", creds, err)
+	}
+}
+
+func TestPromptProvider(t *testing.T) {
+	var out strings.Builder
+	p := &PromptProvider{In: strings.NewReader("alice\r\ns3cret\n"), Out: &out}
+	creds, err := p.Get()
+	if err != nil || *creds != (Credentials{"alice", "s3cret"}) {
+		t.Errorf("Get() = %v, %v, want alice's credentials", creds, err)
+	}
+	if !strings.Contains(out.String(), "VPN username: ") || !strings.Contains(out.String(), "VPN password: ") {
+		t.Errorf("prompts = %q", out.String())
+	}
+
+	p = &PromptProvider{In: strings.NewReader("alice\n\n"), Out: &out}
+	if creds, err := p.Get(); err == nil {
+		t.Errorf("Get() = %v with an empty password, want an error", creds)
+	}
+}
+
+func TestPassProvider(t *testing.T) {
+	// A pass that prints the entry named after its arguments
+	dir := t.TempDir()
+	script := "#!/bin/sh\n[ \"$1\" = show ] || exit 2\ncase \"$2\" in\n" +
+		"vpn/work) printf 's3cret\\nurl: https://vpn.example.com\\nlogin: alice\\n' ;;\n" +
+		"vpn/bob) printf 'hunter2\\n' ;;\n" +
+		"*) echo \"Error: $2 is not in the password store.\" >&2; exit 1 ;;\nesac\n"
+	if err := os.WriteFile(filepath.Join(dir, "pass"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	tests := []struct {
+		entry string
+		want  *Credentials
+	}{
+		{"vpn/work", &Credentials{"alice", "s3cret"}},
+		{"vpn/bob", &Credentials{"bob", "hunter2"}},
+		{"vpn/missing", nil},
+	}
+	for _, tt := range tests {
+		got, err := (&PassProvider{Entry: tt.entry}).Get()
+		switch {
+		case tt.want == nil && err == nil:
+			t.Errorf("Get(%q) = %+v, want an error", tt.entry, got)
+		case tt.want != nil && (err != nil || *got != *tt.want):
+			t.Errorf("Get(%q) = %+v, %v, want %+v", tt.entry, got, err, tt.want)
+		}
+	}
+}
+
+func TestParsePassEntry(t *testing.T) {
+	tests := []struct {
+		data string
+		want *Credentials
+	}{
+		{"s3cret\n", &Credentials{"work", "s3cret"}},
+		{"s3cret\nUsername: alice\n", &Credentials{"alice", "s3cret"}},
+		{"s3cret\nuser:alice\r\n", &Credentials{"alice", "s3cret"}},
+		{"s3cret\nnote: login: ignored\n", &Credentials{"work", "s3cret"}},
+		{"\nlogin: alice\n", nil},
+	}
+	for _, tt := range tests {
+		got, err := parsePassEntry("vpn/work", tt.data)
+		switch {
+		case tt.want == nil && !errors.Is(err, ErrNotFound):
+			t.Errorf("parsePassEntry(%q) = %+v, %v, want %v", tt.data, got, err, ErrNotFound)
+		case tt.want != nil && (err != nil || *got != *tt.want):
+			t.Errorf("parsePassEntry(%q) = %+v, %v, want %+v", tt.data, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package credentials
+
+import (
+	"fmt"
+	"os"
+)
+
+// Environment variables read by EnvProvider.
+const (
+	UsernameEnv = "SVPN_USERNAME"
+	PasswordEnv = "SVPN_PASSWORD"
+)
+
+// EnvProvider reads the credentials from environment variables.
+type EnvProvider struct {
+	UsernameVar string
+	PasswordVar string
+}
+
+// NewEnvProvider returns a provider reading SVPN_USERNAME and SVPN_PASSWORD.
+func NewEnvProvider() *EnvProvider {
+	return &EnvProvider{UsernameVar: UsernameEnv, PasswordVar: PasswordEnv}
+}
+
+func (p *EnvProvider) Name() string { return "env" }
+
+func (p *EnvProvider) Get() (*Credentials, error) {
+	username := os.Getenv(p.UsernameVar)
+	password := os.Getenv(p.PasswordVar)
+	if username == "" || password == "" {
+		return nil, fmt.Errorf("%w: set %s and %s", ErrNotFound, p.UsernameVar, p.PasswordVar)
+	}
+	return &Credentials{Username: username, Password: password}, nil
+}
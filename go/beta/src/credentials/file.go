@@ -0,0 +1,58 @@
+package credentials
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// FileProvider reads the credentials from a file in OpenVPN's
+// auth-user-pass format. The file must be a regular file owned by the
+// current user or root and must not be accessible by group or others.
+type FileProvider struct {
+	Path string
+}
+
+func (p *FileProvider) Name() string { return "file:" + p.Path }
+
+func (p *FileProvider) Get() (*Credentials, error) {
+	if err := CheckPrivateFile(p.Path); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(p.Path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading credentials file: %v", err)
+	}
+
+	creds, err := Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid credentials file %s: %v", p.Path, err)
+	}
+	return creds, nil
+}
+
+// CheckPrivateFile verifies that path is a regular file owned by the
+// current user or root with no group or other permissions.
+func CheckPrivateFile(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s does not exist", ErrNotFound, path)
+	}
+	if err != nil {
+		return fmt.Errorf("error checking credentials file: %v", err)
+	}
+
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("credentials file %s is not a regular file", path)
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return fmt.Errorf("credentials file %s has mode %04o, it must not be accessible by group or others (chmod 600)", path, perm)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		if int(stat.Uid) != os.Geteuid() && stat.Uid != 0 {
+			return fmt.Errorf("credentials file %s is owned by uid %d, expected %d or root", path, stat.Uid, os.Geteuid())
+		}
+	}
+	return nil
+}
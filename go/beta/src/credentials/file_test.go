@@ -0,0 +1,125 @@
+package credentials
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckPrivateFile(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, path string)
+		err   string // substring of the error, "" for none
+	}{
+		{
+			name:  "private",
+			setup: func(t *testing.T, path string) { writeCredentials(t, path, 0600) },
+		},
+		{
+			name:  "read-only",
+			setup: func(t *testing.T, path string) { writeCredentials(t, path, 0400) },
+		},
+		{
+			name:  "group readable",
+			setup: func(t *testing.T, path string) { writeCredentials(t, path, 0640) },
+			err:   "has mode 0640",
+		},
+		{
+			name:  "world readable",
+			setup: func(t *testing.T, path string) { writeCredentials(t, path, 0604) },
+			err:   "has mode 0604",
+		},
+		{
+			name:  "group writable",
+			setup: func(t *testing.T, path string) { writeCredentials(t, path, 0620) },
+			err:   "has mode 0620",
+		},
+		{
+			name: "symlink to a private file",
+			setup: func(t *testing.T, path string) {
+				target := path + ".target"
+				writeCredentials(t, target, 0600)
+				if err := os.Symlink(target, path); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err: "not a regular file",
+		},
+		{
+			name: "directory",
+			setup: func(t *testing.T, path string) {
+				if err := os.Mkdir(path, 0700); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err: "not a regular file",
+		},
+		{
+			name: "owned by someone else",
+			setup: func(t *testing.T, path string) {
+				if os.Geteuid() != 0 {
+					t.Skip("needs root to give a file away")
+				}
+				writeCredentials(t, path, 0600)
+				if err := os.Chown(path, 4321, 4321); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err: "owned by uid 4321",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "auth.txt")
+			tt.setup(t, path)
+
+			err := CheckPrivateFile(path)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("CheckPrivateFile() = %v, want nil", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("CheckPrivateFile() = %v, want an error containing %q", err, tt.err)
+			}
+
+			// The file backend reads only what passes the check
+			creds, err := (&FileProvider{Path: path}).Get()
+			switch {
+			case tt.err == "" && (err != nil || *creds != Credentials{Username: "alice", Password: "s3cret"}):
+				t.Errorf("FileProvider.Get() = %v, %v, want alice's credentials", creds, err)
+			case tt.err != "" && err == nil:
+				t.Errorf("FileProvider.Get() = %v, want an error", creds)
+			}
+		})
+	}
+}
+
+func TestCheckPrivateFileMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.txt")
+	if err := CheckPrivateFile(path); !errors.Is(err, ErrNotFound) {
+		t.Errorf("CheckPrivateFile() = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestFileProviderInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.txt")
+	if err := os.WriteFile(path, []byte("alice\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if creds, err := (&FileProvider{Path: path}).Get(); err == nil {
+		t.Errorf("FileProvider.Get() = %v for a file without a password", creds)
+	}
+}
+
+func writeCredentials(t *testing.T, path string, mode os.FileMode) {
+	if err := os.WriteFile(path, []byte("alice\ns3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// WriteFile's mode is subject to the umask
+	if err := os.Chmod(path, mode); err != nil {
+		t.Fatal(err)
+	}
+}
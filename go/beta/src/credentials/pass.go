@@ -0,0 +1,52 @@
+package credentials
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// PassProvider reads the credentials from the pass password store. The
+// first line of the entry is the password; the username is taken from a
+// "username:", "user:" or "login:" line, or else from the entry name.
+type PassProvider struct {
+	Entry string
+}
+
+func (p *PassProvider) Name() string { return "pass:" + p.Entry }
+
+func (p *PassProvider) Get() (*Credentials, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("pass", "show", p.Entry)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("error running pass show %s: %v: %s", p.Entry, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return parsePassEntry(p.Entry, stdout.String())
+}
+
+func parsePassEntry(entry, data string) (*Credentials, error) {
+	lines := strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n")
+	if len(lines) == 0 || lines[0] == "" {
+		return nil, fmt.Errorf("%w: pass entry %s has no password", ErrNotFound, entry)
+	}
+
+	creds := &Credentials{Username: path.Base(entry), Password: lines[0]}
+	for _, line := range lines[1:] {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "username", "user", "login":
+			creds.Username = strings.TrimSpace(value)
+		}
+	}
+	return creds, nil
+}
@@ -0,0 +1,88 @@
+package credentials
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// PromptProvider asks for the credentials on the terminal.
+type PromptProvider struct {
+	In  io.Reader
+	Out io.Writer
+}
+
+// NewPromptProvider returns a provider that reads stdin and prompts on
+// stderr, keeping stdout for the command's output.
+func NewPromptProvider() *PromptProvider {
+	return &PromptProvider{In: os.Stdin, Out: os.Stderr}
+}
+
+func (p *PromptProvider) Name() string { return "prompt" }
+
+func (p *PromptProvider) Get() (*Credentials, error) {
+	reader := bufio.NewReader(p.In)
+
+	fmt.Fprint(p.Out, "VPN username: ")
+	username, err := readLine(reader)
+	if err != nil {
+		return nil, fmt.Errorf("error reading username: %v", err)
+	}
+
+	fmt.Fprint(p.Out, "VPN password: ")
+	restore := disableEcho(p.In)
+	password, err := readLine(reader)
+	restore()
+	fmt.Fprintln(p.Out)
+	if err != nil {
+		return nil, fmt.Errorf("error reading password: %v", err)
+	}
+
+	if username == "" || password == "" {
+		return nil, errors.New("username and password must not be empty")
+	}
+	return &Credentials{Username: username, Password: password}, nil
+}
+
+// PromptSecret asks for a secret such as a passphrase on the terminal
+// without echoing it. The prompt goes to stderr.
+func PromptSecret(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	restore := disableEcho(os.Stdin)
+	secret, err := readLine(bufio.NewReader(os.Stdin))
+	restore()
+	fmt.Fprintln(os.Stderr)
+	return secret, err
+}
+
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// disableEcho turns off terminal echo when in is a terminal and returns a
+// function that turns it back on.
+func disableEcho(in io.Reader) func() {
+	file, ok := in.(*os.File)
+	if !ok {
+		return func() {}
+	}
+
+	stty := func(arg string) error {
+		cmd := exec.Command("stty", arg)
+		cmd.Stdin = file
+		return cmd.Run()
+	}
+
+	if err := stty("-echo"); err != nil {
+		return func() {}
+	}
+	return func() { stty("echo") }
+}
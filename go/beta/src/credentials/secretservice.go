@@ -0,0 +1,151 @@
+package credentials
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// DefaultSecretServiceKey is the account looked up when the
+// secret-service backend is used without a key.
+const DefaultSecretServiceKey = "default"
+
+const (
+	secretsBusName   = "org.freedesktop.secrets"
+	secretsPath      = dbus.ObjectPath("/org/freedesktop/secrets")
+	secretsInterface = "org.freedesktop.Secret.Service"
+	itemInterface    = "org.freedesktop.Secret.Item"
+	sessionInterface = "org.freedesktop.Secret.Session"
+	promptInterface  = "org.freedesktop.Secret.Prompt"
+
+	// unlockTimeout bounds how long we wait for the user to answer an
+	// unlock prompt from the keyring.
+	unlockTimeout = 2 * time.Minute
+)
+
+// SecretServiceProvider looks up the credentials in the freedesktop
+// Secret Service (GNOME Keyring, KWallet, KeePassXC) over the session
+// bus. Items are matched on the attributes service=svpn and
+// account=<Key>; the username is stored in the item's "username"
+// attribute and the password is the secret itself, e.g.:
+//
+//	secret-tool store --label=svpn service svpn account default username alice
+type SecretServiceProvider struct {
+	Key string
+}
+
+// secret mirrors the Secret Service (oayays) secret struct.
+type secret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+func (p *SecretServiceProvider) Name() string { return "secret-service:" + p.Key }
+
+func (p *SecretServiceProvider) Get() (*Credentials, error) {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to the session bus: %v", err)
+	}
+
+	service := conn.Object(secretsBusName, secretsPath)
+
+	// The plain algorithm is fine here: the secret only travels over
+	// the local session bus
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	err = service.Call(secretsInterface+".OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&output, &session)
+	if err != nil {
+		return nil, fmt.Errorf("error opening secret service session: %v", err)
+	}
+	defer conn.Object(secretsBusName, session).Call(sessionInterface+".Close", 0)
+
+	attributes := map[string]string{"service": "svpn", "account": p.Key}
+	var unlocked, locked []dbus.ObjectPath
+	err = service.Call(secretsInterface+".SearchItems", 0, attributes).Store(&unlocked, &locked)
+	if err != nil {
+		return nil, fmt.Errorf("error searching secret service: %v", err)
+	}
+
+	items := unlocked
+	if len(items) == 0 && len(locked) > 0 {
+		if items, err = unlockItems(conn, service, locked); err != nil {
+			return nil, err
+		}
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("%w: no secret service item with service=svpn account=%s", ErrNotFound, p.Key)
+	}
+
+	item := conn.Object(secretsBusName, items[0])
+
+	var s secret
+	if err := item.Call(itemInterface+".GetSecret", 0, session).Store(&s); err != nil {
+		return nil, fmt.Errorf("error reading secret: %v", err)
+	}
+
+	prop, err := item.GetProperty(itemInterface + ".Attributes")
+	if err != nil {
+		return nil, fmt.Errorf("error reading item attributes: %v", err)
+	}
+	itemAttributes, _ := prop.Value().(map[string]string)
+
+	username := itemAttributes["username"]
+	if username == "" {
+		return nil, fmt.Errorf("secret service item for account %s has no username attribute", p.Key)
+	}
+	return &Credentials{Username: username, Password: string(s.Value)}, nil
+}
+
+// unlockItems unlocks the given items, showing the keyring's unlock
+// prompt if one is needed.
+func unlockItems(conn *dbus.Conn, service dbus.BusObject, paths []dbus.ObjectPath) ([]dbus.ObjectPath, error) {
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	if err := service.Call(secretsInterface+".Unlock", 0, paths).Store(&unlocked, &prompt); err != nil {
+		return nil, fmt.Errorf("error unlocking secret: %v", err)
+	}
+	if prompt == "/" {
+		return unlocked, nil
+	}
+
+	signals := make(chan *dbus.Signal, 4)
+	conn.Signal(signals)
+	defer conn.RemoveSignal(signals)
+
+	match := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(prompt),
+		dbus.WithMatchInterface(promptInterface),
+		dbus.WithMatchMember("Completed"),
+	}
+	if err := conn.AddMatchSignal(match...); err != nil {
+		return nil, fmt.Errorf("error watching unlock prompt: %v", err)
+	}
+	defer conn.RemoveMatchSignal(match...)
+
+	if err := conn.Object(secretsBusName, prompt).Call(promptInterface+".Prompt", 0, "").Err; err != nil {
+		return nil, fmt.Errorf("error showing unlock prompt: %v", err)
+	}
+
+	timeout := time.After(unlockTimeout)
+	for {
+		select {
+		case sig := <-signals:
+			if sig.Path != prompt || sig.Name != promptInterface+".Completed" || len(sig.Body) < 2 {
+				continue
+			}
+			if dismissed, _ := sig.Body[0].(bool); dismissed {
+				return nil, errors.New("unlock prompt was dismissed")
+			}
+			result, _ := sig.Body[1].(dbus.Variant)
+			paths, _ := result.Value().([]dbus.ObjectPath)
+			return paths, nil
+		case <-timeout:
+			return nil, errors.New("timed out waiting for the keyring to be unlocked")
+		}
+	}
+}
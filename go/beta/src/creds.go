@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"main/src/credentials"
+	"main/src/management"
+)
+
+// authTimeout bounds how long start waits for OpenVPN to authenticate.
+const authTimeout = 30 * time.Second
+
+// errAuthFailed is returned when the server rejects the credentials.
+// Retrying would only risk locking the account, so the supervisor gives
+// up instead of restarting.
+var errAuthFailed = errors.New("authentication failed")
+
+// credentialsSpecFile returns the file in which versions of svpn before
+// named profiles recorded the credentials backend. It holds a backend
+// spec, never a secret.
+func credentialsSpecFile(configPath string) string {
+	return filepath.Join(configPath, "credentials")
+}
+
+// loadCredentialsSpec returns the recorded credentials backend spec, or
+// "" if there is none.
+func loadCredentialsSpec(configPath string) string {
+	data, err := os.ReadFile(credentialsSpecFile(configPath))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// writeAuthFile writes creds to a new 0600 file in dir for OpenVPN's
+// --auth-user-pass. The caller must remove it once OpenVPN has read it.
+func writeAuthFile(dir string, creds *credentials.Credentials) (string, error) {
+	file, err := os.CreateTemp(dir, "auth-*.txt")
+	if err != nil {
+		return "", fmt.Errorf("error creating auth file: %v", err)
+	}
+	defer file.Close()
+
+	if _, err := fmt.Fprintf(file, "%s\n%s\n", creds.Username, creds.Password); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("error writing auth file: %v", err)
+	}
+	return file.Name(), nil
+}
+
+// waitForManagement connects to the management socket, retrying while
+// OpenVPN is still starting up.
+func waitForManagement(socketPath string, timeout time.Duration) (*management.Client, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		client, err := management.Dial(socketPath, managementDialTimeout)
+		if err == nil {
+			return client, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, err
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// authenticate releases OpenVPN from its management hold, answers the
+// username/password prompt when creds is set, and waits until OpenVPN
+// has passed the authentication stage.
+func authenticate(client *management.Client, creds *credentials.Credentials) error {
+	if err := client.StateNotifications(true); err != nil {
+		return err
+	}
+	if err := client.HoldRelease(); err != nil {
+		return err
+	}
+
+	timeout := time.After(authTimeout)
+	for {
+		select {
+		case event, ok := <-client.Events():
+			if !ok {
+				return fmt.Errorf("OpenVPN exited during authentication")
+			}
+			switch event.Type {
+			case "PASSWORD":
+				req, err := management.ParsePasswordRequest(event.Data)
+				if err != nil {
+					continue
+				}
+				if req.Failed {
+					return errAuthFailed
+				}
+				if creds == nil {
+					return fmt.Errorf("OpenVPN asked for %q credentials but none were configured", req.AuthType)
+				}
+				if req.UsernameNeeded {
+					if err := client.Username(req.AuthType, creds.Username); err != nil {
+						return err
+					}
+				}
+				if err := client.Password(req.AuthType, creds.Password); err != nil {
+					return err
+				}
+			case "STATE":
+				state, err := management.ParseState(event.Data)
+				if err != nil {
+					continue
+				}
+				switch state.Name {
+				case "GET_CONFIG", "ASSIGN_IP", "ADD_ROUTES", "CONNECTED":
+					return nil
+				case "EXITING":
+					return fmt.Errorf("OpenVPN is exiting: %s", state.Description)
+				}
+			case "FATAL":
+				return fmt.Errorf("OpenVPN reported a fatal error: %s", event.Data)
+			}
+		case <-timeout:
+			return fmt.Errorf("timed out waiting for OpenVPN to authenticate")
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"main/src/credentials"
+	"main/src/management"
+	"main/src/management/managementtest"
+)
+
+// fakeOpenVPN answers like OpenVPN waiting on --management-hold: once
+// released it asks for credentials with prompt, and once it has the
+// password it reports result, e.g. a >STATE or >PASSWORD notification.
+func fakeOpenVPN(prompt, result string) func(s *managementtest.Server, cmd string) {
+	return func(s *managementtest.Server, cmd string) {
+		s.Send("SUCCESS:")
+		switch managementtest.Verb(cmd) {
+		case "hold":
+			s.Send(">PASSWORD:" + prompt)
+		case "password":
+			s.Send(result)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	creds := &credentials.Credentials{Username: "alice", Password: `s3"cret`}
+	tests := []struct {
+		name           string
+		prompt, result string
+		creds          *credentials.Credentials
+		wantErr        error
+		wantCommands   []string
+	}{{
+		name:   "username and password",
+		prompt: "Need 'Auth' username/password",
+		result: ">STATE:1700000000,GET_CONFIG,,,,,,",
+		creds:  creds,
+		wantCommands: []string{
+			"state on", "hold release",
+			`username "Auth" "alice"`, `password "Auth" "s3\"cret"`,
+		},
+	}, {
+		name:         "password only",
+		prompt:       "Need 'Auth' password",
+		result:       ">STATE:1700000000,CONNECTED,SUCCESS,10.8.0.2,,,,",
+		creds:        creds,
+		wantCommands: []string{"state on", "hold release", `password "Auth" "s3\"cret"`},
+	}, {
+		name:         "rejected",
+		prompt:       "Need 'Auth' username/password",
+		result:       ">PASSWORD:Verification Failed: 'Auth'",
+		creds:        creds,
+		wantErr:      errAuthFailed,
+		wantCommands: []string{"state on", "hold release", `username "Auth" "alice"`, `password "Auth" "s3\"cret"`},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, conn := managementtest.New(fakeOpenVPN(tt.prompt, tt.result))
+			client := management.NewClient(conn)
+			defer client.Close()
+
+			err := authenticate(client, tt.creds)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("authenticate() = %v, want %v", err, tt.wantErr)
+			}
+			if got := server.Commands(); !reflect.DeepEqual(got, tt.wantCommands) {
+				t.Errorf("commands = %q, want %q", got, tt.wantCommands)
+			}
+		})
+	}
+}
+
+func TestAuthenticateWithoutCredentials(t *testing.T) {
+	_, conn := managementtest.New(fakeOpenVPN("Need 'Auth' username/password", ""))
+	client := management.NewClient(conn)
+	defer client.Close()
+
+	err := authenticate(client, nil)
+	if err == nil || !strings.Contains(err.Error(), "none were configured") {
+		t.Errorf("authenticate() = %v, want an error about missing credentials", err)
+	}
+}
+
+func TestAuthenticateOpenVPNExits(t *testing.T) {
+	server, conn := managementtest.New(func(s *managementtest.Server, cmd string) {
+		s.Send("SUCCESS:")
+		if cmd == "hold release" {
+			s.Send(">FATAL:Cannot open TUN/TAP dev /dev/net/tun")
+			s.Close()
+		}
+	})
+	client := management.NewClient(conn)
+	defer client.Close()
+
+	err := authenticate(client, nil)
+	if err == nil || !strings.Contains(err.Error(), "Cannot open TUN/TAP") {
+		t.Errorf("authenticate() = %v, want the fatal error", err)
+	}
+	server.Close()
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"main/src/credentials"
+	"main/src/svpnlog"
+)
+
+// File descriptors the background process receives from its launcher.
+const (
+	daemonCredentialsFD = 3
+	daemonReadyFD       = 4
+)
+
+// A background start goes through three stages so that sudo can still
+// ask for a password on the terminal:
+//
+//	0. svpn start (user): reads the credentials and runs stage 1 via sudo
+//	1. sudo svpn start --daemon-stage 1 (root): spawns stage 2 in a new
+//	   session with setsid and reports whether OpenVPN came up
+//	2. svpn start --daemon-stage 2 (root, detached): runs and supervises
+//	   OpenVPN, writing all output to the profile's rotating log
+//
+// The credentials and the passphrase of an encrypted profile travel
+// through pipes and never touch the disk.
+
+// startBackground runs stage 1 through sudo and waits for its report.
+func startBackground(args []string, creds *credentials.Credentials, passphrase string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("error locating the svpn binary: %v", err)
+	}
+
+	cmd := exec.Command("sudo", append([]string{exe, "start"}, append(args, "--daemon-stage", "1")...)...)
+	cmd.Stdin = strings.NewReader(handoff(creds, passphrase))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("background start failed: %w", err)
+	}
+	return nil
+}
+
+// startExitCode is the exit code of a failed start: exitAuth when the
+// server rejected the credentials, which the background stages report
+// in their error text and pass on as their exit status.
+func startExitCode(err error) int {
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(err, errAuthFailed), strings.HasSuffix(err.Error(), errAuthFailed.Error()):
+		return exitAuth
+	case errors.As(err, &exitErr) && exitErr.ExitCode() > 0:
+		return exitErr.ExitCode()
+	}
+	return exitFailure
+}
+
+// spawnDaemon is stage 1: it detaches stage 2 from the terminal, hands
+// over the credentials and relays whether the first start succeeded.
+func spawnDaemon(args []string, creds *credentials.Credentials, passphrase string) error {
+	cmd, err := launchDaemon(append([]string{"start"}, args...), nil, creds, passphrase)
+	if err != nil {
+		return fail(startExitCode(err), err)
+	}
+	pid := cmd.Process.Pid
+	cmd.Process.Release()
+	fmt.Printf("OpenVPN authenticated and is running in the background (supervisor PID: %d)\n", pid)
+	return nil
+}
+
+// launchDaemon starts stage 2 in a new session with the given extra
+// environment, hands it the credentials and waits until OpenVPN is up or
+// has failed to start. The caller must wait for or release the process.
+func launchDaemon(args, env []string, creds *credentials.Credentials, passphrase string) (*exec.Cmd, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("error locating the svpn binary: %v", err)
+	}
+
+	credsR, credsW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer credsW.Close()
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		credsR.Close()
+		return nil, err
+	}
+	defer readyR.Close()
+
+	// The last --daemon-stage wins when the flags are parsed
+	cmd := exec.Command(exe, append(args, "--daemon-stage", "2")...)
+	cmd.Dir = "/"
+	cmd.Env = append(os.Environ(), env...)
+	cmd.ExtraFiles = []*os.File{credsR, readyW}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	credsR.Close()
+	readyW.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error starting the background process: %v", err)
+	}
+
+	io.WriteString(credsW, handoff(creds, passphrase))
+	credsW.Close()
+
+	// Stage 2 writes "ok" or "error: <reason>" once OpenVPN is up or failed
+	reply, _ := bufio.NewReader(readyR).ReadString('\n')
+	reply = strings.TrimSpace(reply)
+
+	switch {
+	case reply == "ok":
+		return cmd, nil
+	case reply == "":
+		cmd.Wait()
+		return nil, fmt.Errorf("the background process exited before OpenVPN started")
+	default:
+		cmd.Wait()
+		return nil, errors.New(strings.TrimPrefix(reply, "error: "))
+	}
+}
+
+// runDaemon is stage 2: it sends its own output and OpenVPN's to the
+// profile log and supervises OpenVPN until it is stopped. Its errors end
+// up in the log.
+func runDaemon(launch *vpnLaunch, policy supervisorPolicy) error {
+	ready := os.NewFile(daemonReadyFD, "ready")
+	signal.Ignore(syscall.SIGHUP)
+
+	err := ensureRunDir(launch.stateDir)
+	if err != nil {
+		fmt.Fprintf(ready, "error: %v\n", err)
+		return fail(exitFailure, err)
+	}
+	logFile, err := openProfileLog(launch.stateDir)
+	if err != nil {
+		fmt.Fprintf(ready, "error: %v\n", err)
+		return fail(exitFailure, err)
+	}
+	defer logFile.Close()
+
+	// Route everything svpn prints into the log
+	logR, logW, err := os.Pipe()
+	if err != nil {
+		fmt.Fprintf(ready, "error: %v\n", err)
+		return fail(exitFailure, err)
+	}
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(svpnlog.NewLineWriter(logFile, "svpn"), logR)
+		close(copied)
+	}()
+	os.Stdout = logW
+	os.Stderr = logW
+
+	launch.output = svpnlog.NewOpenVPNWriter(logFile)
+	launch.log = logFile
+	launch.ready = func(err error) {
+		if err != nil {
+			fmt.Fprintf(ready, "error: %v\n", err)
+		} else {
+			fmt.Fprintln(ready, "ok")
+		}
+		ready.Close()
+	}
+
+	err = launch.supervise(policy)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+	}
+
+	logW.Close()
+	<-copied
+	if err != nil {
+		return exitCode(exitFailure)
+	}
+	return nil
+}
+
+// handoff formats the credentials and the passphrase of an encrypted
+// profile for the next start stage.
+func handoff(creds *credentials.Credentials, passphrase string) string {
+	return creds.Username + "\n" + creds.Password + "\n" + passphrase + "\n"
+}
+
+// readHandoff reads what the previous start stage wrote with handoff.
+func readHandoff(r io.Reader) (*credentials.Credentials, string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("error receiving credentials: %v", err)
+	}
+	creds, err := credentials.Parse(string(data))
+	if err != nil {
+		return nil, "", err
+	}
+	lines := strings.Split(string(data), "\n")
+	passphrase := ""
+	if len(lines) > 2 {
+		passphrase = lines[2]
+	}
+	return creds, passphrase, nil
+}
+
+// profileLogPath returns the log file of a profile.
+func profileLogPath(stateDir string) string {
+	return filepath.Join(stateDir, "openvpn.log")
+}
+
+// openProfileLog opens the rotating log of a profile.
+func openProfileLog(stateDir string) (*svpnlog.RotatingFile, error) {
+	return svpnlog.OpenRotating(profileLogPath(stateDir), svpnlog.DefaultMaxSize, svpnlog.DefaultKeep, chownToInvoker)
+}
+
+// chownToInvoker hands a file opened by root under sudo to the user who
+// ran sudo, so that the unprivileged CLI can read it later. It changes
+// the open file, never a path that could be swapped for a symlink.
+func chownToInvoker(file *os.File) {
+	if os.Geteuid() != 0 {
+		return
+	}
+	uid, err1 := strconv.Atoi(os.Getenv("SUDO_UID"))
+	gid, err2 := strconv.Atoi(os.Getenv("SUDO_GID"))
+	if err1 != nil || err2 != nil {
+		return
+	}
+	if err := file.Chown(uid, gid); err != nil {
+		warnf("Could not change ownership of %s: %v", file.Name(), err)
+	}
+}
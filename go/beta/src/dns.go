@@ -0,0 +1,266 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"main/src/dns"
+	"main/src/helper"
+	"main/src/hooks"
+	"main/src/management"
+	"main/src/notify"
+	"main/src/state"
+)
+
+// dnsJournalPath returns the journal of the DNS change made for a
+// profile. It lives in the profile's private directory: root restores
+// resolv.conf from it, so the user must not be able to write it.
+func dnsJournalPath(privateDir string) string {
+	return filepath.Join(privateDir, "dns.json")
+}
+
+// handleUpDown records the tun device and applies the pushed DNS
+// settings when the tunnel comes up, and restores the previous ones when
+// it goes down. The hooks of the event run afterwards.
+func (l *vpnLaunch) handleUpDown(event *management.UpDown) {
+	switch event.Event {
+	case "UP":
+		if dev := event.Env["dev"]; dev != "" {
+			err := l.updateState(func(info *state.Info) { info.Interface = dev })
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error saving PID:", err)
+			}
+		}
+		cfg := dns.FromEnv(event.Env)
+		switch {
+		case l.dnsMethod == dns.MethodOff:
+		case cfg.Empty():
+			fmt.Println("The server pushed no DNS servers, leaving DNS unchanged")
+		default:
+			if err := l.applyDNS(cfg); err != nil {
+				fmt.Fprintln(os.Stderr, "Error applying DNS:", err)
+			}
+		}
+
+		reason, summary := "connect", "VPN connected"
+		if event.Env["script_context"] == "restart" {
+			reason, summary = "reconnect", "VPN reconnected"
+		}
+		l.tunnel = event.Env
+		l.runHooks(hooks.Up, reason)
+		l.notify(notify.Connected, summary, fmt.Sprintf("Profile %s is up on %s", l.profile.Name, event.Env["dev"]))
+	case "DOWN":
+		if err := l.restoreDNS(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		reason := "disconnect"
+		if l.stopping.Load() {
+			reason = "stop"
+		}
+		l.tunnelDown(reason)
+	case reconnectingEvent:
+		l.runHooks(hooks.Reconnect, event.Env["reason"])
+		l.notify(notify.Reconnecting, "VPN reconnecting", fmt.Sprintf("Profile %s is reconnecting (%s)", l.profile.Name, event.Env["reason"]))
+	}
+}
+
+// applyDNS applies DNS settings for the profile, through svpnd or sudo
+// unless svpn already is root.
+func (l *vpnLaunch) applyDNS(cfg dns.Config) error {
+	if client := helperClient(); client != nil {
+		params := helper.DNSParams{Action: "apply", Profile: l.profile.Name, Method: l.dnsMethod, Interface: cfg.Interface, Domains: cfg.Domains}
+		for _, addr := range cfg.Servers {
+			params.Servers = append(params.Servers, addr.String())
+		}
+		if err := client.Call(helper.MethodDNS, params, nil); err != nil {
+			return err
+		}
+		fmt.Printf("DNS set to %s on %s by svpnd\n", strings.Join(params.Servers, ", "), cfg.Interface)
+		return nil
+	}
+	if os.Geteuid() != 0 {
+		args := []string{"dns", "apply", l.profile.Name, "--method", l.dnsMethod, "--interface", cfg.Interface}
+		for _, addr := range cfg.Servers {
+			args = append(args, "--server", addr.String())
+		}
+		for _, d := range cfg.Domains {
+			args = append(args, "--domain", d)
+		}
+		return runSelfAsRoot(args...)
+	}
+
+	if err := ensurePrivateDir(l.privateDir); err != nil {
+		return err
+	}
+	method, err := dns.Apply(cfg, l.dnsMethod, dnsJournalPath(l.privateDir))
+	if err != nil {
+		return err
+	}
+
+	var servers []string
+	for _, addr := range cfg.Servers {
+		servers = append(servers, addr.String())
+	}
+	fmt.Printf("DNS set to %s on %s via %s", strings.Join(servers, ", "), cfg.Interface, method)
+	if len(cfg.Domains) > 0 {
+		fmt.Printf(" (search %s)", strings.Join(cfg.Domains, " "))
+	}
+	fmt.Println()
+	return nil
+}
+
+// restoreDNS undoes the DNS change recorded for the profile, if any.
+func (l *vpnLaunch) restoreDNS() error {
+	return restoreProfileDNS(l.profile.Name, l.privateDir)
+}
+
+// restoreProfileDNS restores DNS from the journal of a profile, through
+// svpnd or sudo unless svpn already is root.
+func restoreProfileDNS(profileName, privateDir string) error {
+	journal := dnsJournalPath(privateDir)
+	if _, err := os.Stat(journal); os.IsNotExist(err) {
+		return nil
+	}
+	if client := helperClient(); client != nil {
+		if err := client.Call(helper.MethodDNS, helper.DNSParams{Action: "restore", Profile: profileName}, nil); err != nil {
+			return err
+		}
+		fmt.Println("DNS settings restored by svpnd")
+		return nil
+	}
+	if os.Geteuid() != 0 {
+		return runSelfAsRoot("dns", "restore", profileName)
+	}
+	if err := dns.Restore(journal); err != nil {
+		return err
+	}
+	fmt.Println("DNS settings restored")
+	return nil
+}
+
+// runSelfAsRoot runs an svpn command as root and prints its output.
+func runSelfAsRoot(args ...string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("error locating the svpn binary: %v", err)
+	}
+	cmd := rootCommand(exe, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("svpn %s failed: %v", args[0]+" "+args[1], err)
+	}
+	return nil
+}
+
+// dnsProfileDir resolves the profile argument of the dns commands to
+// the profile and its private directory. They also run as root under
+// sudo.
+func dnsProfileDir(fs *flag.FlagSet, args []string) (string, string, error) {
+	profileName, err := profileArg(fs, args)
+	if err != nil {
+		return "", "", err
+	}
+	store, err := userProfileStore()
+	if err != nil {
+		return "", "", fail(exitFailure, err)
+	}
+	profile, err := store.ResolveActive(profileName)
+	if err != nil {
+		return "", "", fail(exitConfig, err)
+	}
+	return profile.Name, store.PrivateDir(profile.Name), nil
+}
+
+func dnsStatus(fs *flag.FlagSet) func(args []string) (any, error) {
+	return func(args []string) (any, error) {
+		name, privateDir, err := dnsProfileDir(fs, args)
+		if err != nil {
+			return nil, err
+		}
+
+		j, err := dns.LoadJournal(dnsJournalPath(privateDir))
+		if err != nil {
+			return nil, fail(exitUnknown, err)
+		}
+		if j == nil {
+			fmt.Printf("DNS: not changed by profile %q\n", name)
+			return nil, exitCode(exitNotRunning)
+		}
+
+		fmt.Printf("DNS:        %s via %s since %s\n", strings.Join(j.Servers, ", "), j.Method, j.Time.Format("2006-01-02 15:04:05"))
+		fmt.Printf("Interface:  %s\n", j.Interface)
+		if len(j.Domains) > 0 {
+			fmt.Printf("Search:     %s\n", strings.Join(j.Domains, " "))
+		}
+		if j.Method == dns.MethodResolvConf {
+			fmt.Printf("Replaced:   %s\n", j.ResolvConf)
+		}
+		return j, nil
+	}
+}
+
+func dnsRestore(fs *flag.FlagSet) func(args []string) (any, error) {
+	return func(args []string) (any, error) {
+		name, privateDir, err := dnsProfileDir(fs, args)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := os.Stat(dnsJournalPath(privateDir)); os.IsNotExist(err) {
+			fmt.Printf("Nothing to restore for profile %q\n", name)
+			return nil, nil
+		}
+		if err := restoreProfileDNS(name, privateDir); err != nil {
+			return nil, fail(exitFailure, err)
+		}
+		return nil, nil
+	}
+}
+
+func dnsApply(fs *flag.FlagSet) func(args []string) (any, error) {
+	method := fs.String("method", dns.MethodAuto, "auto, resolved or resolvconf")
+	iface := fs.String("interface", "", "Tunnel interface")
+	var servers, domains stringList
+	fs.Var(&servers, "server", "DNS server (repeatable)")
+	fs.Var(&domains, "domain", "Search domain (repeatable)")
+	return func(args []string) (any, error) {
+		name, privateDir, err := dnsProfileDir(fs, args)
+		if err != nil {
+			return nil, err
+		}
+
+		if os.Geteuid() != 0 {
+			return nil, failf(exitPermission, "svpn dns apply must run as root")
+		}
+
+		cfg := dns.Config{Interface: *iface, Domains: domains}
+		for _, s := range servers {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, failf(exitFailure, "invalid DNS server %q", s)
+			}
+			cfg.Servers = append(cfg.Servers, addr)
+		}
+
+		launch := &vpnLaunch{profile: &Profile{Name: name}, privateDir: privateDir, dnsMethod: *method}
+		if err := launch.applyDNS(cfg); err != nil {
+			return nil, fail(exitFailure, err)
+		}
+		return nil, nil
+	}
+}
+
+// stringList is a flag that can be given several times.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
@@ -0,0 +1,135 @@
+// Package dns applies the DNS servers and search domains pushed by the
+// VPN server to the system, and restores the previous settings from a
+// journal afterwards.
+package dns
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Config is the DNS setup a VPN connection asks for.
+type Config struct {
+	Interface string
+	Servers   []netip.Addr
+	Domains   []string // search domains
+}
+
+// Empty reports whether the server pushed no DNS servers.
+func (c Config) Empty() bool {
+	return len(c.Servers) == 0
+}
+
+// Validate checks that the search domains can be written to a resolver
+// config as they are: a domain with whitespace or control characters
+// could add lines of its own to /etc/resolv.conf.
+func (c Config) Validate() error {
+	for _, d := range c.Domains {
+		if d == "" || strings.IndexFunc(d, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+			return fmt.Errorf("invalid search domain %q", d)
+		}
+	}
+	return nil
+}
+
+// FromEnv reads the pushed DNS settings from an OpenVPN up/down script
+// environment: "dhcp-option DNS|DNS6|DOMAIN|DOMAIN-SEARCH" values in
+// foreign_option_<n>, and the dns_server_<n>_address_<m> and
+// dns_search_domain_<n> variables of OpenVPN 2.6's --dns option.
+func FromEnv(env map[string]string) Config {
+	cfg := Config{Interface: env["dev"]}
+
+	for _, name := range sortedKeys(env, "foreign_option_") {
+		fields := strings.Fields(env[name])
+		if len(fields) < 3 || fields[0] != "dhcp-option" {
+			continue
+		}
+		switch strings.ToUpper(fields[1]) {
+		case "DNS", "DNS6":
+			cfg.addServer(fields[2])
+		case "DOMAIN", "DOMAIN-SEARCH", "ADAPTER_DOMAIN_SUFFIX":
+			cfg.addDomain(fields[2])
+		}
+	}
+
+	for _, name := range sortedKeys(env, "dns_server_") {
+		if strings.Contains(name, "_address_") {
+			cfg.addServer(env[name])
+		}
+	}
+	for _, name := range sortedKeys(env, "dns_search_domain_") {
+		cfg.addDomain(env[name])
+	}
+
+	return cfg
+}
+
+func (c *Config) addServer(s string) {
+	// Servers may carry a port as in "10.8.0.1:53" or "[fd00::1]:53"
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		ap, err := netip.ParseAddrPort(s)
+		if err != nil {
+			return
+		}
+		addr = ap.Addr()
+	}
+	for _, existing := range c.Servers {
+		if existing == addr {
+			return
+		}
+	}
+	c.Servers = append(c.Servers, addr.Unmap())
+}
+
+func (c *Config) addDomain(d string) {
+	d = strings.TrimSuffix(d, ".")
+	if d == "" {
+		return
+	}
+	for _, existing := range c.Domains {
+		if existing == d {
+			return
+		}
+	}
+	c.Domains = append(c.Domains, d)
+}
+
+// sortedKeys returns the variables with the given prefix in numeric
+// order, so that foreign_option_10 comes after foreign_option_9.
+func sortedKeys(env map[string]string, prefix string) []string {
+	var keys []string
+	for name := range env {
+		if strings.HasPrefix(name, prefix) {
+			keys = append(keys, name)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := numbers(keys[i][len(prefix):]), numbers(keys[j][len(prefix):])
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// numbers extracts the numbers of a suffix such as "1_address_2".
+func numbers(s string) []int {
+	var out []int
+	for _, part := range strings.Split(s, "_") {
+		if n, err := strconv.Atoi(part); err == nil {
+			out = append(out, n)
+		}
+	}
+	return out
+}
@@ -0,0 +1,23 @@
+package dns
+
+import "testing"
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		domain  string
+		wantErr bool
+	}{
+		{"corp.example.com", false},
+		{"", true},
+		{"corp.example.com\nnameserver 203.0.113.1", true},
+		{"corp example.com", true},
+		{"corp.example.com\t", true},
+		{"corp\x00.example.com", true},
+	}
+	for _, tt := range tests {
+		err := Config{Domains: []string{tt.domain}}.Validate()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Validate() with domain %q = %v, want error %v", tt.domain, err, tt.wantErr)
+		}
+	}
+}
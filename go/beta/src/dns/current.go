@@ -0,0 +1,106 @@
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// stubResolvers are the addresses of systemd-resolved's stub resolver.
+var stubResolvers = map[netip.Addr]bool{
+	netip.MustParseAddr("127.0.0.53"): true,
+	netip.MustParseAddr("127.0.0.54"): true,
+}
+
+// Resolvers returns the DNS servers the system sends queries to that no
+// search or routing domain claims: the nameservers of resolv.conf, or,
+// behind systemd-resolved's stub resolver, the servers of the links that
+// have the "~." routing domain, or of every link if none has.
+func Resolvers() ([]netip.Addr, error) {
+	data, err := os.ReadFile(ResolvConfPath)
+	if err != nil {
+		return nil, err
+	}
+	servers := nameservers(data)
+	if len(servers) == 0 || !stubResolvers[servers[0]] {
+		return servers, nil
+	}
+	return resolvedResolvers()
+}
+
+// nameservers returns the nameserver addresses of a resolv.conf.
+func nameservers(data []byte) []netip.Addr {
+	var servers []netip.Addr
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		// Link-local servers may carry a zone, e.g. fe80::1%eth0
+		if addr, err := netip.ParseAddr(fields[1]); err == nil {
+			servers = append(servers, addr.WithZone(""))
+		}
+	}
+	return servers
+}
+
+// resolvedResolvers reads the servers systemd-resolved uses from its
+// DNS and Domains properties.
+func resolvedResolvers() ([]netip.Addr, error) {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to the system bus: %v", err)
+	}
+	resolved := conn.Object(resolvedBusName, resolvedPath)
+
+	var servers []struct {
+		Index   int32
+		Family  int32
+		Address []byte
+	}
+	if err := getProperty(resolved, "DNS", &servers); err != nil {
+		return nil, err
+	}
+	var domains []struct {
+		Index       int32
+		Domain      string
+		RoutingOnly bool
+	}
+	if err := getProperty(resolved, "Domains", &domains); err != nil {
+		return nil, err
+	}
+
+	defaultLinks := map[int32]bool{}
+	for _, d := range domains {
+		if d.Domain == "." && d.RoutingOnly {
+			defaultLinks[d.Index] = true
+		}
+	}
+	var addrs []netip.Addr
+	for _, s := range servers {
+		if len(defaultLinks) > 0 && !defaultLinks[s.Index] {
+			continue
+		}
+		if addr, ok := netip.AddrFromSlice(s.Address); ok {
+			addrs = append(addrs, addr.Unmap())
+		}
+	}
+	return addrs, nil
+}
+
+func getProperty(obj dbus.BusObject, name string, value any) error {
+	variant, err := obj.GetProperty(resolvedInterface + "." + name)
+	if err != nil {
+		return fmt.Errorf("systemd-resolved %s: %v", name, err)
+	}
+	if err := variant.Store(value); err != nil {
+		return fmt.Errorf("systemd-resolved %s: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"main/src/state"
+)
+
+// Methods of applying DNS settings.
+const (
+	MethodAuto       = "auto"
+	MethodResolved   = "resolved"
+	MethodResolvConf = "resolvconf"
+	MethodOff        = "off"
+)
+
+// ValidMethod reports whether m is a method Apply accepts.
+func ValidMethod(m string) bool {
+	switch m {
+	case MethodAuto, MethodResolved, MethodResolvConf, MethodOff:
+		return true
+	}
+	return false
+}
+
+// Apply configures the system to use the DNS settings in cfg through
+// systemd-resolved or by rewriting /etc/resolv.conf, and returns the
+// method used. Anything a previous journal at journalPath records is
+// restored first. The journal is written before the change is made.
+func Apply(cfg Config, method, journalPath string) (string, error) {
+	if err := cfg.Validate(); err != nil {
+		return "", err
+	}
+	if err := Restore(journalPath); err != nil {
+		return "", err
+	}
+	if method == MethodOff || cfg.Empty() {
+		return MethodOff, nil
+	}
+
+	if method == MethodAuto {
+		method = MethodResolvConf
+		if resolvedAvailable() {
+			method = MethodResolved
+		}
+	}
+
+	j := &Journal{
+		Method:    method,
+		Time:      time.Now(),
+		Interface: cfg.Interface,
+		Servers:   addrStrings(cfg.Servers),
+		Domains:   cfg.Domains,
+	}
+
+	switch method {
+	case MethodResolved:
+		iface, err := net.InterfaceByName(cfg.Interface)
+		if err != nil {
+			return "", fmt.Errorf("error looking up interface %q: %v", cfg.Interface, err)
+		}
+		j.Index = iface.Index
+		if err := j.save(journalPath); err != nil {
+			return "", err
+		}
+		if err := resolvedApply(iface.Index, cfg); err != nil {
+			resolvedRevert(iface.Index)
+			os.Remove(journalPath)
+			return "", err
+		}
+
+	case MethodResolvConf:
+		if err := backupResolvConf(j, resolvConfPath); err != nil {
+			return "", fmt.Errorf("error backing up %s: %v", resolvConfPath, err)
+		}
+		j.Written = resolvConfContent(cfg)
+		if err := j.save(journalPath); err != nil {
+			return "", err
+		}
+		if err := state.WriteFile(resolvConfPath, j.Written, 0644); err != nil {
+			os.Remove(journalPath)
+			return "", fmt.Errorf("error writing %s: %v", resolvConfPath, err)
+		}
+
+	default:
+		return "", fmt.Errorf("unknown DNS method %q", method)
+	}
+
+	return method, nil
+}
+
+// Restore undoes the change recorded in the journal at journalPath and
+// removes the journal. It does nothing when there is no journal.
+func Restore(journalPath string) error {
+	j, err := LoadJournal(journalPath)
+	if err != nil || j == nil {
+		return err
+	}
+
+	switch j.Method {
+	case MethodResolved:
+		// After a reboot the index may belong to another link
+		if iface, lookupErr := net.InterfaceByIndex(j.Index); lookupErr == nil && iface.Name == j.Interface {
+			err = resolvedRevert(j.Index)
+		}
+	case MethodResolvConf:
+		err = restoreResolvConf(j)
+	default:
+		err = fmt.Errorf("unknown DNS method %q in journal", j.Method)
+	}
+	if err != nil {
+		return fmt.Errorf("error restoring DNS: %v", err)
+	}
+	return os.Remove(journalPath)
+}
@@ -0,0 +1,176 @@
+package dns
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeResolvConf points Apply and Restore at a resolv.conf in a
+// temporary directory and returns its path and a journal path.
+func fakeResolvConf(t *testing.T) (path, journal string) {
+	dir := t.TempDir()
+	old := resolvConfPath
+	resolvConfPath = filepath.Join(dir, "resolv.conf")
+	t.Cleanup(func() { resolvConfPath = old })
+	return resolvConfPath, filepath.Join(dir, "dns.json")
+}
+
+var testConfig = Config{
+	Interface: "tun0",
+	Servers:   []netip.Addr{netip.MustParseAddr("10.8.0.1")},
+	Domains:   []string{"corp.example.com"},
+}
+
+func TestApplyAndRestoreResolvConf(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(path string) error
+		check func(t *testing.T, path string)
+	}{
+		{
+			name:  "regular file",
+			setup: func(path string) error { return os.WriteFile(path, []byte("nameserver 192.0.2.1\n"), 0640) },
+			check: func(t *testing.T, path string) {
+				info, err := os.Lstat(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, _ := os.ReadFile(path)
+				if string(data) != "nameserver 192.0.2.1\n" || info.Mode() != 0640 {
+					t.Errorf("restored %q with mode %v, want the original with mode 0640", data, info.Mode())
+				}
+			},
+		},
+		{
+			name:  "symlink",
+			setup: func(path string) error { return os.Symlink("../run/systemd/resolve/stub-resolv.conf", path) },
+			check: func(t *testing.T, path string) {
+				if target, err := os.Readlink(path); err != nil || target != "../run/systemd/resolve/stub-resolv.conf" {
+					t.Errorf("restored symlink to %q, %v, want the stub file", target, err)
+				}
+			},
+		},
+		{
+			name:  "no file",
+			setup: func(path string) error { return nil },
+			check: func(t *testing.T, path string) {
+				if _, err := os.Lstat(path); !os.IsNotExist(err) {
+					t.Errorf("restored %s, want it removed: %v", path, err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, journal := fakeResolvConf(t)
+			if err := tt.setup(path); err != nil {
+				t.Fatal(err)
+			}
+
+			method, err := Apply(testConfig, MethodResolvConf, journal)
+			if err != nil || method != MethodResolvConf {
+				t.Fatalf("Apply() = %q, %v", method, err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil || string(data) != string(resolvConfContent(testConfig)) {
+				t.Fatalf("resolv.conf = %q, %v, want svpn's", data, err)
+			}
+
+			// svpn dies here; the next run only has the journal
+			j, err := LoadJournal(journal)
+			if err != nil || j == nil {
+				t.Fatalf("LoadJournal() = %v, %v", j, err)
+			}
+			if j.Method != MethodResolvConf || j.Interface != "tun0" || len(j.Servers) != 1 || j.Servers[0] != "10.8.0.1" {
+				t.Errorf("LoadJournal() = %+v", j)
+			}
+
+			if err := Restore(journal); err != nil {
+				t.Fatalf("Restore() = %v", err)
+			}
+			tt.check(t, path)
+			if _, err := os.Stat(journal); !os.IsNotExist(err) {
+				t.Errorf("journal left behind: %v", err)
+			}
+		})
+	}
+}
+
+func TestApplyRestoresJournalFirst(t *testing.T) {
+	path, journal := fakeResolvConf(t)
+	if err := os.WriteFile(path, []byte("nameserver 192.0.2.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A tunnel that crashed leaves its journal; the next one must not
+	// record svpn's own file as the original
+	if _, err := Apply(testConfig, MethodResolvConf, journal); err != nil {
+		t.Fatal(err)
+	}
+	second := Config{Interface: "tun1", Servers: []netip.Addr{netip.MustParseAddr("10.9.0.1")}}
+	if _, err := Apply(second, MethodResolvConf, journal); err != nil {
+		t.Fatal(err)
+	}
+	if err := Restore(journal); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "nameserver 192.0.2.1\n" {
+		t.Errorf("resolv.conf = %q, want the original", data)
+	}
+}
+
+func TestRestoreAfterCrashBeforeWrite(t *testing.T) {
+	path, journal := fakeResolvConf(t)
+	if err := os.WriteFile(path, []byte("nameserver 192.0.2.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The journal is saved, but svpn died before writing resolv.conf
+	j := &Journal{Method: MethodResolvConf, Interface: "tun0"}
+	if err := backupResolvConf(j, path); err != nil {
+		t.Fatal(err)
+	}
+	j.Written = resolvConfContent(testConfig)
+	if err := j.save(journal); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Restore(journal); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "nameserver 192.0.2.1\n" {
+		t.Errorf("resolv.conf = %q, want the original", data)
+	}
+	if _, err := os.Stat(journal); !os.IsNotExist(err) {
+		t.Errorf("journal left behind: %v", err)
+	}
+}
+
+func TestRestoreLeavesOtherChangesAlone(t *testing.T) {
+	path, journal := fakeResolvConf(t)
+	if err := os.WriteFile(path, []byte("nameserver 192.0.2.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Apply(testConfig, MethodResolvConf, journal); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("nameserver 198.51.100.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Restore(journal); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "nameserver 198.51.100.1\n" {
+		t.Errorf("resolv.conf = %q, want the other program's", data)
+	}
+}
+
+func TestRestoreWithoutJournal(t *testing.T) {
+	_, journal := fakeResolvConf(t)
+	if err := Restore(journal); err != nil {
+		t.Errorf("Restore() = %v, want nil without a journal", err)
+	}
+}
@@ -0,0 +1,62 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"main/src/state"
+)
+
+// Journal records a DNS change before it is made, so that it can be
+// undone even when svpn or OpenVPN die before restoring it.
+type Journal struct {
+	Method    string    `json:"method"` // MethodResolved or MethodResolvConf
+	Time      time.Time `json:"time"`
+	Interface string    `json:"interface"`
+	Servers   []string  `json:"servers"`
+	Domains   []string  `json:"domains,omitempty"`
+
+	// Link index configured in systemd-resolved
+	Index int `json:"index,omitempty"`
+
+	// The resolv.conf svpn replaced: its path, a symlink target or the
+	// original content, and what svpn wrote instead
+	ResolvConf string `json:"resolv_conf,omitempty"`
+	Symlink    string `json:"symlink,omitempty"`
+	Original   []byte `json:"original,omitempty"`
+	Mode       uint32 `json:"mode,omitempty"`
+	Written    []byte `json:"written,omitempty"`
+}
+
+// LoadJournal reads a journal. It returns nil and no error when there is
+// nothing to restore.
+func LoadJournal(path string) (*Journal, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading DNS journal: %v", err)
+	}
+	var j Journal
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, fmt.Errorf("error parsing DNS journal %s: %v", path, err)
+	}
+	return &j, nil
+}
+
+// save writes the journal atomically and syncs it to disk before the
+// change it describes is made.
+func (j *Journal) save(path string) error {
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Users read it for `svpn dns status`, but only root writes it
+	if err := state.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("error writing DNS journal: %v", err)
+	}
+	return nil
+}
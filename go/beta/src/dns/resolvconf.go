@@ -0,0 +1,88 @@
+package dns
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"main/src/state"
+)
+
+// ResolvConfPath is the file rewritten when systemd-resolved is not
+// available.
+const ResolvConfPath = "/etc/resolv.conf"
+
+// resolvConfPath is the ResolvConfPath Apply and Restore use; tests point
+// it elsewhere.
+var resolvConfPath = ResolvConfPath
+
+// resolvConfContent renders the resolv.conf svpn installs.
+func resolvConfContent(cfg Config) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Generated by svpn for %s, the previous file is restored on disconnect\n", cfg.Interface)
+	for _, addr := range cfg.Servers {
+		fmt.Fprintf(&b, "nameserver %s\n", addr)
+	}
+	if len(cfg.Domains) > 0 {
+		fmt.Fprintf(&b, "search")
+		for _, d := range cfg.Domains {
+			fmt.Fprintf(&b, " %s", d)
+		}
+		fmt.Fprintf(&b, "\n")
+	}
+	return b.Bytes()
+}
+
+// backupResolvConf records the current resolv.conf in the journal.
+func backupResolvConf(j *Journal, path string) error {
+	j.ResolvConf = path
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Keep symlinks such as the one to systemd-resolved's stub file
+	if info.Mode()&os.ModeSymlink != 0 {
+		j.Symlink, err = os.Readlink(path)
+		return err
+	}
+
+	j.Mode = uint32(info.Mode().Perm())
+	j.Original, err = os.ReadFile(path)
+	return err
+}
+
+// restoreResolvConf puts back the resolv.conf recorded in the journal,
+// unless something else has replaced svpn's file in the meantime. Only
+// resolvConfPath is ever restored, whatever the journal names.
+func restoreResolvConf(j *Journal) error {
+	if j.ResolvConf != resolvConfPath {
+		return fmt.Errorf("the DNS journal names %q instead of %s, not restoring it", j.ResolvConf, resolvConfPath)
+	}
+	current, err := os.ReadFile(j.ResolvConf)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil && !bytes.Equal(current, j.Written) {
+		fmt.Printf("Warning: %s was changed by another program, leaving it alone\n", j.ResolvConf)
+		return nil
+	}
+
+	switch {
+	case j.Symlink != "":
+		tmp := j.ResolvConf + ".svpn-restore"
+		os.Remove(tmp)
+		if err := os.Symlink(j.Symlink, tmp); err != nil {
+			return err
+		}
+		return os.Rename(tmp, j.ResolvConf)
+	case j.Original != nil:
+		return state.WriteFile(j.ResolvConf, j.Original, os.FileMode(j.Mode))
+	default:
+		// There was no resolv.conf before
+		return os.Remove(j.ResolvConf)
+	}
+}
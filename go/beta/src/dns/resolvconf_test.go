@@ -0,0 +1,28 @@
+package dns
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRestoreResolvConfOnlyRestoresResolvConfPath(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "shadow")
+	if err := os.WriteFile(target, []byte("root:x:0:0\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	j := &Journal{ResolvConf: target, Original: []byte("owned\n"), Mode: 0644, Written: []byte("root:x:0:0\n")}
+	if err := restoreResolvConf(j); err == nil {
+		t.Error("restoreResolvConf restored a file other than ResolvConfPath")
+	}
+	j.Original, j.Symlink = nil, "/tmp/evil"
+	if err := restoreResolvConf(j); err == nil {
+		t.Error("restoreResolvConf replaced a file other than ResolvConfPath with a symlink")
+	}
+
+	data, err := os.ReadFile(target)
+	if err != nil || string(data) != "root:x:0:0\n" {
+		t.Errorf("%s = %q, %v, want it untouched", target, data, err)
+	}
+}
@@ -0,0 +1,109 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	resolvedBusName   = "org.freedesktop.resolve1"
+	resolvedPath      = dbus.ObjectPath("/org/freedesktop/resolve1")
+	resolvedInterface = "org.freedesktop.resolve1.Manager"
+)
+
+// resolvedAddress mirrors the (iay) address struct of SetLinkDNS.
+type resolvedAddress struct {
+	Family  int32
+	Address []byte
+}
+
+// resolvedDomain mirrors the (sb) domain struct of SetLinkDomains.
+type resolvedDomain struct {
+	Domain      string
+	RoutingOnly bool
+}
+
+// resolvedAvailable reports whether systemd-resolved runs on the
+// system bus.
+func resolvedAvailable() bool {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return false
+	}
+	var hasOwner bool
+	err = conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, resolvedBusName).Store(&hasOwner)
+	return err == nil && hasOwner
+}
+
+// resolvedApply sets the DNS servers and domains of a link. The "~."
+// routing domain sends every query that no other link claims to the
+// VPN servers.
+func resolvedApply(index int, cfg Config) error {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return fmt.Errorf("error connecting to the system bus: %v", err)
+	}
+	resolved := conn.Object(resolvedBusName, resolvedPath)
+
+	var addresses []resolvedAddress
+	for _, addr := range cfg.Servers {
+		family := int32(2) // AF_INET
+		if addr.Is6() {
+			family = 10 // AF_INET6
+		}
+		addresses = append(addresses, resolvedAddress{Family: family, Address: addr.AsSlice()})
+	}
+	if err := resolved.Call(resolvedInterface+".SetLinkDNS", 0, int32(index), addresses).Err; err != nil {
+		return fmt.Errorf("systemd-resolved SetLinkDNS: %v", err)
+	}
+
+	domains := []resolvedDomain{{Domain: ".", RoutingOnly: true}}
+	for _, d := range cfg.Domains {
+		domains = append(domains, resolvedDomain{Domain: d})
+	}
+	if err := resolved.Call(resolvedInterface+".SetLinkDomains", 0, int32(index), domains).Err; err != nil {
+		return fmt.Errorf("systemd-resolved SetLinkDomains: %v", err)
+	}
+
+	if err := resolved.Call(resolvedInterface+".SetLinkDefaultRoute", 0, int32(index), true).Err; err != nil {
+		// Older systemd versions lack SetLinkDefaultRoute; "~." suffices there
+		if dbusErrorName(err) != "org.freedesktop.DBus.Error.UnknownMethod" {
+			return fmt.Errorf("systemd-resolved SetLinkDefaultRoute: %v", err)
+		}
+	}
+	return nil
+}
+
+// resolvedRevert drops everything svpn set on a link. A link that is
+// already gone has nothing left to revert.
+func resolvedRevert(index int) error {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return fmt.Errorf("error connecting to the system bus: %v", err)
+	}
+	err = conn.Object(resolvedBusName, resolvedPath).Call(resolvedInterface+".RevertLink", 0, int32(index)).Err
+	if err != nil && dbusErrorName(err) != "org.freedesktop.resolve1.NoSuchLink" {
+		return fmt.Errorf("systemd-resolved RevertLink: %v", err)
+	}
+	return nil
+}
+
+func addrStrings(addrs []netip.Addr) []string {
+	out := make([]string, len(addrs))
+	for i, addr := range addrs {
+		out[i] = addr.String()
+	}
+	return out
+}
+
+// dbusErrorName returns the D-Bus name of an error returned by a call.
+func dbusErrorName(err error) string {
+	var dbusErr dbus.Error
+	if errors.As(err, &dbusErr) {
+		return dbusErr.Name
+	}
+	return ""
+}
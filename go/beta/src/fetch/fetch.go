@@ -0,0 +1,145 @@
+// Package fetch downloads profile configs over HTTP or reads them from
+// local files, and verifies them before they are used.
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// MaxSize bounds the size of a downloaded file.
+const MaxSize = 10 << 20
+
+// Cache holds the validators of an earlier download of URL, sent back as
+// If-None-Match and If-Modified-Since.
+type Cache struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Fetched      time.Time `json:"fetched"`
+}
+
+// Result is the outcome of Get.
+type Result struct {
+	// Data is nil when NotModified is set.
+	Data []byte
+	// NotModified is set when the server confirmed that the cached copy
+	// is still current.
+	NotModified bool
+	// Cache describes this download, for the next call to Get.
+	Cache Cache
+}
+
+// Fetcher reads sources. The zero value is not usable, use New.
+type Fetcher struct {
+	Client    *http.Client
+	UserAgent string
+}
+
+// New returns a Fetcher that honours HTTP_PROXY, HTTPS_PROXY and NO_PROXY.
+func New() *Fetcher {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	return &Fetcher{
+		Client:    &http.Client{Transport: transport, Timeout: time.Minute},
+		UserAgent: "svpn",
+	}
+}
+
+// IsURL reports whether src is downloaded rather than read from disk.
+func IsURL(src string) bool {
+	return strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://")
+}
+
+// Get returns the contents of src, a URL or a local path. For URLs a
+// cache of an earlier download of the same URL makes the request
+// conditional.
+func (f *Fetcher) Get(ctx context.Context, src string, cache *Cache) (*Result, error) {
+	if !IsURL(src) {
+		data, err := readFile(src)
+		if err != nil {
+			return nil, err
+		}
+		return &Result{Data: data, Cache: Cache{URL: src, Fetched: time.Now()}}, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %q: %v", src, err)
+	}
+	if f.UserAgent != "" {
+		req.Header.Set("User-Agent", f.UserAgent)
+	}
+	if cache != nil && cache.URL == src {
+		if cache.ETag != "" {
+			req.Header.Set("If-None-Match", cache.ETag)
+		}
+		if cache.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cache.LastModified)
+		}
+	}
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading %s: %v", src, err)
+	}
+	defer resp.Body.Close()
+
+	result := &Result{Cache: Cache{
+		URL:          src,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Fetched:      time.Now(),
+	}}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cache != nil:
+		result.NotModified = true
+		// Servers may leave the validators out of a 304
+		if result.Cache.ETag == "" {
+			result.Cache.ETag = cache.ETag
+		}
+		if result.Cache.LastModified == "" {
+			result.Cache.LastModified = cache.LastModified
+		}
+		return result, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("error downloading %s: %s", src, resp.Status)
+	}
+
+	if result.Data, err = readLimited(resp.Body); err != nil {
+		return nil, fmt.Errorf("error downloading %s: %v", src, err)
+	}
+	return result, nil
+}
+
+func readFile(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	defer file.Close()
+
+	data, err := readLimited(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	return data, nil
+}
+
+func readLimited(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxSize {
+		return nil, errors.New("file is larger than 10 MiB")
+	}
+	return data, nil
+}
@@ -0,0 +1,97 @@
+package fetch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGetConditional(t *testing.T) {
+	const body = "client\nremote vpn.example.com 1194\n"
+	var requests []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Header.Clone())
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			// Validators may be left out of a 304
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	f := New()
+	first, err := f.Get(context.Background(), server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(first.Data) != body || first.NotModified || first.Cache.ETag != `"v1"` {
+		t.Errorf("first Get() = %+v, want the body and its ETag", first)
+	}
+	if ua := requests[0].Get("User-Agent"); ua != "svpn" {
+		t.Errorf("User-Agent = %q, want svpn", ua)
+	}
+
+	second, err := f.Get(context.Background(), server.URL, &first.Cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.NotModified || second.Data != nil {
+		t.Errorf("second Get() = %+v, want NotModified without data", second)
+	}
+	if second.Cache.ETag != `"v1"` || second.Cache.LastModified != first.Cache.LastModified {
+		t.Errorf("second Get() cache = %+v, want the validators of the first", second.Cache)
+	}
+	if got := requests[1].Get("If-Modified-Since"); got != first.Cache.LastModified {
+		t.Errorf("If-Modified-Since = %q, want %q", got, first.Cache.LastModified)
+	}
+
+	// A cache of another URL is not sent
+	other := Cache{URL: server.URL + "/other", ETag: `"v1"`}
+	third, err := f.Get(context.Background(), server.URL, &other)
+	if err != nil || third.NotModified {
+		t.Errorf("Get() with the cache of another URL = %+v, %v, want a full download", third, err)
+	}
+}
+
+func TestGetErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/huge":
+			w.Write([]byte(strings.Repeat("x", MaxSize+1)))
+		case "/limit":
+			w.Write([]byte(strings.Repeat("x", MaxSize)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	f := New()
+	if _, err := f.Get(context.Background(), server.URL+"/huge", nil); err == nil || !strings.Contains(err.Error(), "larger than 10 MiB") {
+		t.Errorf("Get() of a file over MaxSize = %v, want a size error", err)
+	}
+	if result, err := f.Get(context.Background(), server.URL+"/limit", nil); err != nil || len(result.Data) != MaxSize {
+		t.Errorf("Get() of a file of MaxSize failed: %v", err)
+	}
+	if _, err := f.Get(context.Background(), server.URL+"/missing", nil); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Get() of a missing file = %v, want the status", err)
+	}
+}
+
+func TestGetFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "work.ovpn")
+	if err := os.WriteFile(path, []byte("client\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	result, err := New().Get(context.Background(), path, nil)
+	if err != nil || string(result.Data) != "client\n" || result.Cache.URL != path {
+		t.Errorf("Get() of a file = %+v, %v", result, err)
+	}
+}
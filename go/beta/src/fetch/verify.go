@@ -0,0 +1,104 @@
+package fetch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+// ErrVerification is wrapped by every error of a Verifier that rejected
+// a file.
+var ErrVerification = errors.New("verification failed")
+
+// Verifier checks the integrity of a downloaded file.
+type Verifier interface {
+	Verify(data []byte) error
+	String() string
+}
+
+// SHA256 is a hex encoded SHA-256 digest the file must match.
+type SHA256 string
+
+// ParseSHA256 accepts a hex digest, optionally prefixed by "sha256:".
+func ParseSHA256(s string) (SHA256, error) {
+	s = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(s), "sha256:"))
+	if b, err := hex.DecodeString(s); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("invalid SHA-256 digest %q", s)
+	}
+	return SHA256(s), nil
+}
+
+func (d SHA256) Verify(data []byte) error {
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != string(d) {
+		return fmt.Errorf("%w: SHA-256 is %s, expected %s", ErrVerification, got, string(d))
+	}
+	return nil
+}
+
+func (d SHA256) String() string {
+	return "SHA-256 " + string(d)
+}
+
+// Signature verifies a detached OpenPGP signature, armored or binary,
+// made by one of the keys in Keyring.
+type Signature struct {
+	Keyring   openpgp.EntityList
+	Signature []byte
+}
+
+func (s *Signature) Verify(data []byte) error {
+	check := openpgp.CheckDetachedSignature
+	if bytes.HasPrefix(bytes.TrimSpace(s.Signature), []byte("-----BEGIN PGP SIGNATURE-----")) {
+		check = openpgp.CheckArmoredDetachedSignature
+	}
+	signer, err := check(s.Keyring, bytes.NewReader(data), bytes.NewReader(s.Signature), nil)
+	if err != nil {
+		return fmt.Errorf("%w: bad signature: %v", ErrVerification, err)
+	}
+	if signer == nil || signer.PrimaryKey == nil {
+		return fmt.Errorf("%w: signature made by an unknown key", ErrVerification)
+	}
+	return nil
+}
+
+func (s *Signature) String() string {
+	return "signature by " + Fingerprint(s.Keyring)
+}
+
+// ReadKeyring parses OpenPGP public keys, armored or binary.
+func ReadKeyring(data []byte) (openpgp.EntityList, error) {
+	var keyring openpgp.EntityList
+	var err error
+	if block, armorErr := armor.Decode(bytes.NewReader(data)); armorErr == nil {
+		if block.Type != openpgp.PublicKeyType {
+			return nil, fmt.Errorf("expected a public key, found %q", block.Type)
+		}
+		keyring, err = openpgp.ReadKeyRing(block.Body)
+	} else {
+		keyring, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading public key: %v", err)
+	}
+	if len(keyring) == 0 {
+		return nil, errors.New("no public key found")
+	}
+	return keyring, nil
+}
+
+// Fingerprint returns the fingerprints of the primary keys in keyring,
+// separated by commas.
+func Fingerprint(keyring openpgp.EntityList) string {
+	var prints []string
+	for _, entity := range keyring {
+		prints = append(prints, strings.ToUpper(hex.EncodeToString(entity.PrimaryKey.Fingerprint[:])))
+	}
+	return strings.Join(prints, ",")
+}
@@ -0,0 +1,117 @@
+package fetch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+var data = []byte("client\nremote vpn.example.com 1194\n")
+
+func TestSHA256(t *testing.T) {
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+
+	for _, s := range []string{digest, "sha256:" + digest, " " + strings.ToUpper(digest) + "\n"} {
+		d, err := ParseSHA256(s)
+		if err != nil {
+			t.Fatalf("ParseSHA256(%q) = %v", s, err)
+		}
+		if err := d.Verify(data); err != nil {
+			t.Errorf("Verify() = %v for the matching digest", err)
+		}
+	}
+	for _, s := range []string{"", "abc", digest[:62], "sha1:" + digest} {
+		if _, err := ParseSHA256(s); err == nil {
+			t.Errorf("ParseSHA256(%q) succeeded", s)
+		}
+	}
+
+	d, _ := ParseSHA256(digest)
+	if err := d.Verify(append(data, '\n')); !errors.Is(err, ErrVerification) {
+		t.Errorf("Verify() of changed data = %v, want ErrVerification", err)
+	}
+}
+
+// newKey returns a signing key and its public key, armored if asked.
+func newKey(t *testing.T, armored bool) (*openpgp.Entity, []byte) {
+	entity, err := openpgp.NewEntity("svpn test", "", "test@example.com", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if !armored {
+		if err := entity.Serialize(&buf); err != nil {
+			t.Fatal(err)
+		}
+		return entity, buf.Bytes()
+	}
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	return entity, buf.Bytes()
+}
+
+func TestSignature(t *testing.T) {
+	signer, public := newKey(t, true)
+	keyring, err := ReadKeyring(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := Fingerprint(keyring), strings.ToUpper(hex.EncodeToString(signer.PrimaryKey.Fingerprint)); got != want {
+		t.Errorf("Fingerprint() = %s, want %s", got, want)
+	}
+
+	var binary, armored bytes.Buffer
+	if err := openpgp.DetachSign(&binary, signer, bytes.NewReader(data), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := openpgp.ArmoredDetachSign(&armored, signer, bytes.NewReader(data), nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, sig := range [][]byte{binary.Bytes(), armored.Bytes()} {
+		s := &Signature{Keyring: keyring, Signature: sig}
+		if err := s.Verify(data); err != nil {
+			t.Errorf("Verify() = %v for a good signature", err)
+		}
+		if err := s.Verify(append(data, '\n')); !errors.Is(err, ErrVerification) {
+			t.Errorf("Verify() of changed data = %v, want ErrVerification", err)
+		}
+	}
+
+	// Signed, but not by a pinned key
+	_, otherPublic := newKey(t, false)
+	other, err := ReadKeyring(otherPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Signature{Keyring: other, Signature: binary.Bytes()}
+	if err := s.Verify(data); !errors.Is(err, ErrVerification) {
+		t.Errorf("Verify() with another key = %v, want ErrVerification", err)
+	}
+}
+
+func TestReadKeyringRejects(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := armor.Encode(&buf, "PGP MESSAGE", nil)
+	w.Write([]byte("not a key"))
+	w.Close()
+	if _, err := ReadKeyring(buf.Bytes()); err == nil {
+		t.Error("ReadKeyring() accepted an armored message")
+	}
+	if _, err := ReadKeyring([]byte("garbage")); err == nil {
+		t.Error("ReadKeyring() accepted garbage")
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"main/src/health"
+	"main/src/management"
+	"main/src/state"
+)
+
+// healthPolicy controls the health checks of a running tunnel.
+type healthPolicy struct {
+	// Interval is the time between checks, 0 turns them off
+	Interval time.Duration
+	// Failures failed checks in a row make OpenVPN reconnect
+	Failures int
+	// Probe is an extra check through the tunnel, see health.Parse
+	Probe string
+}
+
+func defaultHealthPolicy() healthPolicy {
+	return healthPolicy{Interval: 30 * time.Second, Failures: 3}
+}
+
+func (p healthPolicy) validate() error {
+	if p.Interval < 0 {
+		return errors.New("--health-interval must not be negative")
+	}
+	if p.Failures < 1 {
+		return errors.New("--health-failures must be at least 1")
+	}
+	if p.Probe != "" {
+		if _, err := health.Parse(p.Probe, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// device returns the tun device of the running tunnel.
+func (l *vpnLaunch) device() string {
+	l.infoMu.Lock()
+	defer l.infoMu.Unlock()
+	return l.info.Interface
+}
+
+// checkHealth checks the tunnel of a started OpenVPN until the returned
+// function is called. The tun device must have an address, OpenVPN must
+// keep receiving data and the extra probe, if any, must pass; when that
+// fails too often in a row OpenVPN is asked to reconnect.
+func (l *vpnLaunch) checkHealth(client *management.Client, tracker *eventTracker) func() {
+	if l.health.Interval <= 0 {
+		return func() {}
+	}
+
+	dev := health.Device(l.device)
+	probes := []health.Probe{
+		&health.Interface{Device: dev},
+		&health.Traffic{Received: tracker.received},
+	}
+	if l.health.Probe != "" {
+		probe, err := health.Parse(l.health.Probe, dev)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		} else {
+			probes = append(probes, probe)
+		}
+	}
+
+	// Byte counts arrive twice per check so that every check sees a new one
+	interval := int(l.health.Interval / time.Second / 2)
+	if err := client.ByteCount(max(interval, 1)); err != nil {
+		fmt.Fprintln(os.Stderr, "Error enabling byte counts:", err)
+	}
+
+	checker := &health.Checker{
+		Probes:    probes,
+		Interval:  l.health.Interval,
+		Timeout:   min(l.health.Interval, health.DefaultTimeout),
+		Threshold: l.health.Failures,
+		Ready:     tracker.connected,
+		OnReport: func(report *health.Report) {
+			for _, result := range report.Results {
+				if result.Error != "" {
+					fmt.Printf("Health check %s failed (%d/%d): %s\n",
+						result.Probe, report.Failures, l.health.Failures, result.Error)
+				}
+			}
+			if err := l.updateState(func(info *state.Info) { info.Health = report }); err != nil {
+				fmt.Fprintln(os.Stderr, "Error saving PID:", err)
+			}
+		},
+		OnUnhealthy: func(report *health.Report) {
+			fmt.Printf("Health checks failed %d times in a row, reconnecting\n", report.Failures)
+			if err := client.Signal("SIGUSR1"); err != nil {
+				fmt.Fprintln(os.Stderr, "Error requesting reconnect:", err)
+			}
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		checker.Run(ctx)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
@@ -0,0 +1,130 @@
+// Package health checks whether a tunnel works. A running OpenVPN
+// process does not mean a working tunnel, so a Checker runs a set of
+// probes on a schedule and reports when they keep failing.
+package health
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultTimeout bounds a probe when the Checker sets no timeout.
+const DefaultTimeout = 10 * time.Second
+
+// Probe is one check of a tunnel.
+type Probe interface {
+	// Name identifies the probe in reports, e.g. "tcp 1.1.1.1:443".
+	Name() string
+	Check(ctx context.Context) error
+}
+
+// Func is a Probe made from a function.
+type Func struct {
+	Label string
+	Fn    func(ctx context.Context) error
+}
+
+func (f Func) Name() string                    { return f.Label }
+func (f Func) Check(ctx context.Context) error { return f.Fn(ctx) }
+
+// Result is the outcome of one probe.
+type Result struct {
+	Probe string `json:"probe"`
+	Error string `json:"error,omitempty"`
+}
+
+// Report is the outcome of one round of probes.
+type Report struct {
+	Time    time.Time `json:"time"`
+	Results []Result  `json:"results"`
+	// Failures counts the failed rounds in a row, this one included
+	Failures int `json:"failures,omitempty"`
+}
+
+// Healthy reports whether every probe passed.
+func (r *Report) Healthy() bool {
+	for _, result := range r.Results {
+		if result.Error != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Checker runs probes every Interval. A round fails when any probe
+// fails, and Threshold failed rounds in a row make the tunnel unhealthy.
+type Checker struct {
+	Probes    []Probe
+	Interval  time.Duration
+	Timeout   time.Duration
+	Threshold int
+
+	// Ready, if set, skips rounds while it returns false, e.g. while
+	// OpenVPN is reconnecting.
+	Ready func() bool
+	// OnReport is called after every round.
+	OnReport func(*Report)
+	// OnUnhealthy is called once Threshold rounds in a row failed; the
+	// count then starts over.
+	OnUnhealthy func(*Report)
+
+	failures int
+}
+
+// Check runs one round of probes, one after the other.
+func (c *Checker) Check(ctx context.Context) *Report {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	report := &Report{Time: time.Now()}
+	for _, probe := range c.Probes {
+		probeCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := probe.Check(probeCtx)
+		cancel()
+		result := Result{Probe: probe.Name()}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		report.Results = append(report.Results, result)
+	}
+
+	if report.Healthy() {
+		c.failures = 0
+	} else {
+		c.failures++
+	}
+	report.Failures = c.failures
+	return report
+}
+
+// Run checks the tunnel every Interval until ctx is done.
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if c.Ready != nil && !c.Ready() {
+			continue
+		}
+
+		report := c.Check(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if c.OnReport != nil {
+			c.OnReport(report)
+		}
+		if c.Threshold > 0 && report.Failures >= c.Threshold {
+			c.failures = 0
+			if c.OnUnhealthy != nil {
+				c.OnUnhealthy(report)
+			}
+		}
+	}
+}
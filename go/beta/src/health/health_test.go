@@ -0,0 +1,124 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	c := &Checker{Timeout: 10 * time.Millisecond, Probes: []Probe{
+		Func{Label: "ok", Fn: func(ctx context.Context) error { return nil }},
+		Func{Label: "hangs", Fn: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+	}}
+	report := c.Check(context.Background())
+	if report.Healthy() || report.Failures != 1 || len(report.Results) != 2 {
+		t.Fatalf("Check() = %+v, want one failed round with two results", report)
+	}
+	if r := report.Results[0]; r.Probe != "ok" || r.Error != "" {
+		t.Errorf("result 0 = %+v, want ok to pass", r)
+	}
+	if r := report.Results[1]; r.Probe != "hangs" || r.Error != context.DeadlineExceeded.Error() {
+		t.Errorf("result 1 = %+v, want hangs to time out", r)
+	}
+}
+
+// runChecker runs c until it has reported n rounds, and returns them.
+func runChecker(t *testing.T, c *Checker, n int) []*Report {
+	reports := make(chan *Report, n)
+	c.Interval = time.Millisecond
+	c.OnReport = func(r *Report) { reports <- r }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+
+	var got []*Report
+	for len(got) < n {
+		select {
+		case r := <-reports:
+			got = append(got, r)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d rounds reported", len(got), n)
+		}
+	}
+	// Rounds after the n-th may still be reported before Run returns
+	go func() {
+		for range reports {
+		}
+	}()
+	cancel()
+	<-done
+	close(reports)
+	return got
+}
+
+func TestRunThreshold(t *testing.T) {
+	results := []bool{false, true, false, false, false, false}
+	var round atomic.Int32
+	var mu sync.Mutex
+	var unhealthy []int
+	c := &Checker{
+		Threshold: 2,
+		Probes: []Probe{Func{Label: "scripted", Fn: func(ctx context.Context) error {
+			i := int(round.Add(1)) - 1
+			if i < len(results) && results[i] {
+				return nil
+			}
+			return errors.New("no route to host")
+		}}},
+		OnUnhealthy: func(r *Report) {
+			mu.Lock()
+			defer mu.Unlock()
+			unhealthy = append(unhealthy, r.Failures)
+		},
+	}
+
+	reports := runChecker(t, c, len(results))
+	// The count starts over after every OnUnhealthy
+	want := []int{1, 0, 1, 2, 1, 2}
+	for i, r := range reports {
+		if r.Failures != want[i] {
+			t.Errorf("round %d: Failures = %d, want %d", i, r.Failures, want[i])
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(unhealthy) < 2 || unhealthy[0] != 2 || unhealthy[1] != 2 {
+		t.Errorf("OnUnhealthy saw failures %v, want 2 twice", unhealthy)
+	}
+}
+
+func TestRunSkipsWhileNotReady(t *testing.T) {
+	var readyCalls, probeCalls atomic.Int32
+	c := &Checker{
+		Threshold: 1,
+		// Not ready for the first three ticks, e.g. while reconnecting
+		Ready: func() bool { return readyCalls.Add(1) > 3 },
+		Probes: []Probe{Func{Label: "count", Fn: func(ctx context.Context) error {
+			if readyCalls.Load() <= 3 {
+				t.Error("probe ran while not ready")
+			}
+			probeCalls.Add(1)
+			return nil
+		}}},
+		OnUnhealthy: func(r *Report) { t.Errorf("OnUnhealthy(%+v) with passing probes", r) },
+	}
+
+	runChecker(t, c, 2)
+	if n := readyCalls.Load(); n < 5 {
+		t.Errorf("Ready called %d times, want at least 5", n)
+	}
+	if n := probeCalls.Load(); n < 2 {
+		t.Errorf("probe ran %d times, want at least 2", n)
+	}
+}
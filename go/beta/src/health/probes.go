@@ -0,0 +1,193 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Device returns the name of the tunnel's tun device, or "" while it is
+// not known yet.
+type Device func() string
+
+func (d Device) name() string {
+	if d == nil {
+		return ""
+	}
+	return d()
+}
+
+// dialer returns a dialer whose sockets are bound to the tun device, so
+// that probes test the tunnel even when the route would bypass it.
+func (d Device) dialer() *net.Dialer {
+	return &net.Dialer{Control: func(network, address string, c syscall.RawConn) error {
+		dev := d.name()
+		if dev == "" {
+			return nil
+		}
+		var err error
+		if ctrlErr := c.Control(func(fd uintptr) {
+			err = unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, dev)
+		}); ctrlErr != nil {
+			return ctrlErr
+		}
+		return err
+	}}
+}
+
+// Interface checks that the tun device exists and has an address.
+type Interface struct {
+	Device Device
+	// Addrs returns the addresses of an interface; it defaults to
+	// looking the interface up in the kernel.
+	Addrs func(name string) ([]net.Addr, error)
+}
+
+func (p *Interface) Name() string { return "interface" }
+
+func (p *Interface) Check(ctx context.Context) error {
+	dev := p.Device.name()
+	if dev == "" {
+		return errors.New("tun device not known yet")
+	}
+	addrs := p.Addrs
+	if addrs == nil {
+		addrs = interfaceAddrs
+	}
+	list, err := addrs(dev)
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		return fmt.Errorf("%s has no address", dev)
+	}
+	return nil
+}
+
+func interfaceAddrs(name string) ([]net.Addr, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	return iface.Addrs()
+}
+
+// TCP connects to Addr through the tunnel.
+type TCP struct {
+	Addr   string
+	Device Device
+}
+
+func (p *TCP) Name() string { return "tcp " + p.Addr }
+
+func (p *TCP) Check(ctx context.Context) error {
+	conn, err := p.Device.dialer().DialContext(ctx, "tcp", p.Addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// DNS looks Host up with the system resolver, which svpn points at the
+// DNS servers the tunnel pushed.
+type DNS struct {
+	Host     string
+	Resolver *net.Resolver
+}
+
+func (p *DNS) Name() string { return "dns " + p.Host }
+
+func (p *DNS) Check(ctx context.Context) error {
+	resolver := p.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	addrs, err := resolver.LookupHost(ctx, p.Host)
+	if err != nil {
+		return err
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("no address for %s", p.Host)
+	}
+	return nil
+}
+
+// HTTP fetches URL through the tunnel and expects a status below 400.
+type HTTP struct {
+	URL    string
+	Device Device
+}
+
+func (p *HTTP) Name() string { return "http " + p.URL }
+
+func (p *HTTP) Check(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+	if err != nil {
+		return err
+	}
+	transport := &http.Transport{DialContext: p.Device.dialer().DialContext}
+	defer transport.CloseIdleConnections()
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// Traffic checks that OpenVPN received data since the previous check.
+// Received returns the bytes received so far as reported by the
+// management interface, and false while nothing was reported.
+type Traffic struct {
+	Received func() (int64, bool)
+
+	last int64
+	seen bool
+}
+
+func (p *Traffic) Name() string { return "traffic" }
+
+func (p *Traffic) Check(ctx context.Context) error {
+	received, ok := p.Received()
+	if !ok {
+		return nil
+	}
+	// The count starts over when OpenVPN reconnects
+	stalled := p.seen && received == p.last
+	p.last, p.seen = received, true
+	if stalled {
+		return errors.New("nothing received since the last check")
+	}
+	return nil
+}
+
+// Parse builds the probe described by spec: "tcp:<host>:<port>",
+// "dns:<name>" or an http:// or https:// URL.
+func Parse(spec string, dev Device) (Probe, error) {
+	switch {
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return &HTTP{URL: spec, Device: dev}, nil
+	case strings.HasPrefix(spec, "tcp:"):
+		addr := strings.TrimPrefix(spec, "tcp:")
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("invalid probe %q: %v", spec, err)
+		}
+		return &TCP{Addr: addr, Device: dev}, nil
+	case strings.HasPrefix(spec, "dns:"):
+		host := strings.TrimPrefix(spec, "dns:")
+		if host == "" {
+			return nil, fmt.Errorf("invalid probe %q: missing name", spec)
+		}
+		return &DNS{Host: host}, nil
+	}
+	return nil, fmt.Errorf("invalid probe %q (expected tcp:<host>:<port>, dns:<name> or an http(s) URL)", spec)
+}
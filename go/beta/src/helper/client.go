@@ -0,0 +1,81 @@
+package helper
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// callTimeout bounds a whole call. Starting a profile waits for OpenVPN
+// to authenticate, so it is generous.
+const callTimeout = 2 * time.Minute
+
+// ErrUnavailable is returned by Dial when svpnd is not running.
+var ErrUnavailable = errors.New("svpnd is not running")
+
+// Client calls svpnd.
+type Client struct {
+	socket string
+	// Timeout bounds a call instead of callTimeout when it is longer,
+	// for calls that wait on something the caller chose to wait for.
+	Timeout time.Duration
+}
+
+// Dial checks that svpnd listens on socket and returns a client for it.
+func Dial(socket string) (*Client, error) {
+	conn, err := net.DialTimeout("unix", socket, time.Second)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, ErrUnavailable
+		}
+		return nil, fmt.Errorf("error connecting to svpnd: %v", err)
+	}
+	conn.Close()
+	return &Client{socket: socket}, nil
+}
+
+// Call sends a request and decodes the result into result, which may be
+// nil.
+func (c *Client) Call(method string, params, result any) error {
+	conn, err := net.DialTimeout("unix", c.socket, time.Second)
+	if err != nil {
+		return fmt.Errorf("error connecting to svpnd: %v", err)
+	}
+	defer conn.Close()
+	timeout := callTimeout
+	if c.Timeout > timeout {
+		timeout = c.Timeout
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	req := Request{Method: method}
+	if params != nil {
+		if req.Params, err = json.Marshal(params); err != nil {
+			return err
+		}
+	}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return fmt.Errorf("error sending request to svpnd: %v", err)
+	}
+
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("error reading reply from svpnd: %v", err)
+	}
+	var resp Response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return fmt.Errorf("malformed reply from svpnd: %v", err)
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	if result != nil && resp.Result != nil {
+		return json.Unmarshal(resp.Result, result)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+// Package helper implements the protocol between the unprivileged svpn
+// CLI and svpnd, the root helper that starts and stops OpenVPN and
+// changes the firewall and DNS on its behalf.
+//
+// Every call is one connection to svpnd's unix socket carrying one JSON
+// request line answered by one JSON response line. svpnd identifies the
+// caller with SO_PEERCRED and acts on that user's profiles.
+package helper
+
+import (
+	"encoding/json"
+	"time"
+
+	"main/src/credentials"
+)
+
+// DefaultSocket is where svpnd listens.
+const DefaultSocket = "/run/svpn/svpnd.sock"
+
+// Methods svpnd answers.
+const (
+	MethodStart      = "start"
+	MethodStop       = "stop"
+	MethodStatus     = "status"
+	MethodKillswitch = "killswitch"
+	MethodDNS        = "dns"
+)
+
+// Request is a call to svpnd.
+type Request struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// Response is svpnd's answer. Error is set when the call failed.
+type Response struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// StartParams starts a profile in the background.
+type StartParams struct {
+	Profile     string                   `json:"profile"`
+	Credentials *credentials.Credentials `json:"credentials"`
+	// Passphrase decrypts an encrypted profile
+	Passphrase string `json:"passphrase,omitempty"`
+	AuthVia    string `json:"auth_via,omitempty"`
+	DNS        string `json:"dns,omitempty"`
+	Killswitch bool   `json:"killswitch,omitempty"`
+
+	// Supervise restarts OpenVPN when it exits, within these limits
+	Supervise     bool          `json:"supervise,omitempty"`
+	MaxRestarts   int           `json:"max_restarts,omitempty"`
+	RestartWindow time.Duration `json:"restart_window,omitempty"`
+	Backoff       time.Duration `json:"backoff,omitempty"`
+	MaxBackoff    time.Duration `json:"max_backoff,omitempty"`
+
+	// HealthInterval is the time between health checks, 0 turns them off
+	HealthInterval time.Duration `json:"health_interval"`
+	HealthFailures int           `json:"health_failures,omitempty"`
+	HealthProbe    string        `json:"health_probe,omitempty"`
+
+	// Remote is the only server to connect to, <host>:<port>[/<proto>]
+	Remote string `json:"remote,omitempty"`
+	// MetricsAddr is where the supervisor serves Prometheus metrics
+	MetricsAddr string `json:"metrics_addr,omitempty"`
+	// Notify are the events the supervisor shows desktop notifications
+	// for, as for `svpn start --notify`
+	Notify string `json:"notify,omitempty"`
+}
+
+// StartResult describes a started profile.
+type StartResult struct {
+	Profile    string `json:"profile"`
+	Supervisor int    `json:"supervisor_pid"`
+	Log        string `json:"log"`
+}
+
+// ProfileParams names the profile a call acts on; empty means the only
+// running profile or the default one.
+type ProfileParams struct {
+	Profile string `json:"profile,omitempty"`
+}
+
+// StopParams stops a profile.
+type StopParams struct {
+	Profile string `json:"profile,omitempty"`
+	// Timeout is how long OpenVPN gets to exit before it is killed
+	Timeout time.Duration `json:"timeout,omitempty"`
+}
+
+// KillswitchParams turns the kill switch off or asks whether it is on.
+type KillswitchParams struct {
+	Action string `json:"action"` // "off" or "status"
+}
+
+// KillswitchResult reports whether the kill switch is installed.
+type KillswitchResult struct {
+	Active bool `json:"active"`
+}
+
+// DNSParams applies DNS settings for a running profile or restores the
+// previous ones.
+type DNSParams struct {
+	Action    string   `json:"action"` // "apply" or "restore"
+	Profile   string   `json:"profile"`
+	Method    string   `json:"method,omitempty"`
+	Interface string   `json:"interface,omitempty"`
+	Servers   []string `json:"servers,omitempty"`
+	Domains   []string `json:"domains,omitempty"`
+}
@@ -0,0 +1,136 @@
+package helper
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// requestTimeout bounds how long a client may take to send its request.
+const requestTimeout = 10 * time.Second
+
+// Peer is the process on the other end of a connection, as reported by
+// the kernel.
+type Peer struct {
+	PID int
+	UID int
+	GID int
+}
+
+// HandlerFunc answers one method. params holds the raw request params.
+type HandlerFunc func(peer Peer, params json.RawMessage) (any, error)
+
+// Server dispatches requests to handlers after authorizing the caller.
+type Server struct {
+	Handlers  map[string]HandlerFunc
+	Authorize func(peer Peer) error
+	// Log reports every call and its outcome
+	Log func(format string, args ...any)
+}
+
+// Serve accepts connections until the listener is closed.
+func (s *Server) Serve(listener *net.UnixListener) error {
+	for {
+		conn, err := listener.AcceptUnix()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn *net.UnixConn) {
+	defer conn.Close()
+
+	reply := func(result any, err error) {
+		var resp Response
+		if err != nil {
+			resp.Error = err.Error()
+		} else if result != nil {
+			data, marshalErr := json.Marshal(result)
+			if marshalErr != nil {
+				resp.Error = marshalErr.Error()
+			}
+			resp.Result = data
+		}
+		json.NewEncoder(conn).Encode(resp)
+	}
+
+	peer, err := peerCredentials(conn)
+	if err != nil {
+		reply(nil, err)
+		return
+	}
+	if err := s.Authorize(peer); err != nil {
+		s.logf("denied uid %d (pid %d): %v", peer.UID, peer.PID, err)
+		reply(nil, err)
+		return
+	}
+
+	conn.SetReadDeadline(time.Now().Add(requestTimeout))
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	var req Request
+	if err := json.Unmarshal(line, &req); err != nil {
+		reply(nil, fmt.Errorf("malformed request: %v", err))
+		return
+	}
+	handler, ok := s.Handlers[req.Method]
+	if !ok {
+		reply(nil, fmt.Errorf("unknown method %q", req.Method))
+		return
+	}
+
+	result, err := handler(peer, req.Params)
+	if err != nil {
+		s.logf("%s from uid %d failed: %v", req.Method, peer.UID, err)
+	} else {
+		s.logf("%s from uid %d succeeded", req.Method, peer.UID)
+	}
+	reply(result, err)
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if s.Log != nil {
+		s.Log(format, args...)
+	}
+}
+
+// peerCredentials asks the kernel who is connected through SO_PEERCRED,
+// which cannot be forged by the client.
+func peerCredentials(conn *net.UnixConn) (Peer, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return Peer{}, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err == nil {
+		err = credErr
+	}
+	if err != nil {
+		return Peer{}, fmt.Errorf("error reading peer credentials: %v", err)
+	}
+	return Peer{PID: int(cred.Pid), UID: int(cred.Uid), GID: int(cred.Gid)}, nil
+}
+
+// Decode unmarshals request params, treating missing params as empty.
+func Decode(params json.RawMessage, v any) error {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return fmt.Errorf("invalid params: %v", err)
+	}
+	return nil
+}
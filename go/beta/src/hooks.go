@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"main/src/config"
+	"main/src/dns"
+	"main/src/hooks"
+	"main/src/notify"
+	"main/src/paths"
+	"main/src/svpnlog"
+)
+
+// loadHooks collects the hooks of the profile from the hook directories
+// and the config files, logging their output to the launch's log.
+// Hooks from /etc/svpn run as svpn does, as root for a tunnel; the
+// user's own run as the user, so that nobody gets root through svpnd
+// with them.
+func (l *vpnLaunch) loadHooks(dirs *paths.Dirs) error {
+	runAs, env, err := hookUser(dirs.User)
+	if err != nil {
+		return err
+	}
+
+	sources := []struct {
+		dir, file string
+		user      *syscall.Credential
+		env       []string
+	}{
+		{filepath.Join(paths.SystemDir, "hooks"), paths.SystemConfigFile, nil, nil},
+		{filepath.Join(filepath.Dir(dirs.ConfigFile), "hooks"), dirs.ConfigFile, runAs, env},
+	}
+
+	runner := &hooks.Runner{Hooks: map[hooks.Event][]hooks.Hook{}, Output: l.hookOutput}
+	if timeout := l.profile.Settings.Hooks.Timeout; timeout != nil {
+		runner.Timeout = *timeout
+	}
+	for _, source := range sources {
+		settings, err := config.Load(nil, source.file)
+		if err != nil {
+			return err
+		}
+		commands := settings.For(l.profile.Name).Hooks
+
+		for _, event := range hooks.Events {
+			found, err := hooks.Dir(source.dir, event)
+			if err != nil {
+				return err
+			}
+			key := "hooks." + strings.ReplaceAll(string(event), "-", "_")
+			found = append(found, hooks.Commands(source.file+": "+key, commands.Event(string(event)))...)
+			for _, hook := range found {
+				hook.User, hook.Env = source.user, source.env
+				runner.Hooks[event] = append(runner.Hooks[event], hook)
+			}
+		}
+	}
+	l.hooks = runner
+	return nil
+}
+
+// hookUser returns the credentials and environment for running a hook as
+// u when svpn runs as root for u, and nil when svpn already runs as u.
+func hookUser(u *user.User) (*syscall.Credential, []string, error) {
+	if os.Geteuid() != 0 || u.Uid == "0" {
+		return nil, nil, nil
+	}
+	uid, err1 := strconv.ParseUint(u.Uid, 10, 32)
+	gid, err2 := strconv.ParseUint(u.Gid, 10, 32)
+	if err1 != nil || err2 != nil {
+		return nil, nil, fmt.Errorf("invalid ID of user %s", u.Username)
+	}
+	cred := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	if groups, err := u.GroupIds(); err == nil {
+		for _, group := range groups {
+			if id, err := strconv.ParseUint(group, 10, 32); err == nil {
+				cred.Groups = append(cred.Groups, uint32(id))
+			}
+		}
+	}
+	env := []string{"HOME=" + u.HomeDir, "USER=" + u.Username, "LOGNAME=" + u.Username}
+	return cred, env, nil
+}
+
+// hookOutput returns the writer logging the output of a hook to the
+// launch's log, which is known by the time hooks run.
+func (l *vpnLaunch) hookOutput(hook hooks.Hook) io.Writer {
+	out := l.log
+	if out == nil {
+		out = os.Stdout
+	}
+	return &svpnlog.LineWriter{Out: out, Parse: func(line string, now time.Time) svpnlog.Entry {
+		return svpnlog.Entry{Time: now, Level: svpnlog.GuessLevel(line), Source: "hook", Message: hook.Name + ": " + line}
+	}}
+}
+
+// hookEnv returns the environment of the hooks of an event: see the
+// README for the variables. Where the tunnel is comes from the last up
+// event OpenVPN reported.
+func (l *vpnLaunch) hookEnv(event hooks.Event, reason string) []string {
+	env := []string{
+		"SVPN_EVENT=" + string(event),
+		"SVPN_REASON=" + reason,
+		"SVPN_PROFILE=" + l.profile.Name,
+		"SVPN_CONFIG=" + l.profile.Config,
+	}
+	if l.tunnel == nil {
+		return env
+	}
+
+	remote := l.tunnel["route_vpn_gateway"]
+	if remote == "" {
+		remote = l.tunnel["ifconfig_remote"]
+	}
+	cfg := dns.FromEnv(l.tunnel)
+	var servers []string
+	for _, addr := range cfg.Servers {
+		servers = append(servers, addr.String())
+	}
+	return append(env,
+		"SVPN_DEVICE="+l.tunnel["dev"],
+		"SVPN_LOCAL_IP="+l.tunnel["ifconfig_local"],
+		"SVPN_LOCAL_IPV6="+l.tunnel["ifconfig_ipv6_local"],
+		"SVPN_REMOTE_IP="+remote,
+		"SVPN_SERVER="+l.tunnel["trusted_ip"],
+		"SVPN_DNS="+strings.Join(servers, " "),
+		"SVPN_DNS_DOMAINS="+strings.Join(cfg.Domains, " "),
+	)
+}
+
+// runHooks runs the hooks of an event, printing every failure, and
+// returns the first.
+func (l *vpnLaunch) runHooks(event hooks.Event, reason string) error {
+	if !l.hooks.Has(event) {
+		return nil
+	}
+	fmt.Printf("Running %s hooks (%s)\n", event, reason)
+	errs := l.hooks.Run(event, l.hookEnv(event, reason))
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+	}
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// tunnelDown runs the down hooks if the up hooks ran for the tunnel and
+// no down event followed, e.g. because OpenVPN died, and tells the user
+// unless they stopped it.
+func (l *vpnLaunch) tunnelDown(reason string) {
+	if l.tunnel == nil {
+		return
+	}
+	l.runHooks(hooks.Down, reason)
+	l.tunnel = nil
+	if reason != "stop" {
+		l.notify(notify.Disconnected, "VPN disconnected", fmt.Sprintf("Profile %s went down (%s)", l.profile.Name, reason))
+	}
+}
@@ -0,0 +1,147 @@
+// Package hooks runs the commands users attach to events of a tunnel:
+// before OpenVPN starts, when the tunnel is up, when it goes down and
+// when it reconnects. Hooks are executables in a <event>.d directory or
+// shell commands from the config, and run one after another with a
+// timeout.
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Event is when a hook runs.
+type Event string
+
+const (
+	PreUp     Event = "pre-up"
+	Up        Event = "up"
+	Down      Event = "down"
+	Reconnect Event = "reconnect"
+)
+
+// Events are all events in the order they happen.
+var Events = []Event{PreUp, Up, Down, Reconnect}
+
+// DefaultTimeout bounds a hook when no timeout is configured.
+const DefaultTimeout = 30 * time.Second
+
+// Hook is one command to run on an event.
+type Hook struct {
+	// Name identifies the hook in the log: its path, or where the
+	// command is configured.
+	Name string
+	// Path is an executable to run, or empty to run Command with sh.
+	Path    string
+	Command string
+	// User runs the hook as another user, nil to run it as svpn runs.
+	User *syscall.Credential
+	// Env is added to the environment of the hook, e.g. HOME.
+	Env []string
+}
+
+// Dir returns the hooks of an event in dir: the executable files in
+// <dir>/<event>.d, in lexical order. A missing directory has none.
+func Dir(dir string, event Event) ([]Hook, error) {
+	eventDir := filepath.Join(dir, string(event)+".d")
+	entries, err := os.ReadDir(eventDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading hooks: %v", err)
+	}
+
+	var hooks []Hook
+	for _, entry := range entries {
+		info, err := entry.Info()
+		// chmod -x disables a hook
+		if err != nil || !info.Mode().IsRegular() || info.Mode()&0111 == 0 {
+			continue
+		}
+		path := filepath.Join(eventDir, entry.Name())
+		hooks = append(hooks, Hook{Name: path, Path: path})
+	}
+	return hooks, nil
+}
+
+// Commands returns shell commands as hooks named <source>[<index>].
+func Commands(source string, commands []string) []Hook {
+	hooks := make([]Hook, len(commands))
+	for i, command := range commands {
+		hooks[i] = Hook{Name: fmt.Sprintf("%s[%d]", source, i), Command: command}
+	}
+	return hooks
+}
+
+// Runner runs the hooks of a tunnel.
+type Runner struct {
+	Hooks   map[Event][]Hook
+	Timeout time.Duration
+	// Output returns where the output of a hook goes. A writer with a
+	// Flush method is flushed when the hook exits.
+	Output func(hook Hook) io.Writer
+}
+
+// Has reports whether there are hooks for an event.
+func (r *Runner) Has(event Event) bool {
+	return r != nil && len(r.Hooks[event]) > 0
+}
+
+// Run runs the hooks of an event in order with env added to their
+// environment, and returns the errors of those that failed. A nil
+// Runner has no hooks.
+func (r *Runner) Run(event Event, env []string) []error {
+	if r == nil {
+		return nil
+	}
+	var errs []error
+	for _, hook := range r.Hooks[event] {
+		if err := r.run(hook, env); err != nil {
+			errs = append(errs, fmt.Errorf("%s hook %s: %v", event, hook.Name, err))
+		}
+	}
+	return errs
+}
+
+func (r *Runner) run(hook Hook, env []string) error {
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, hook.Path)
+	if hook.Path == "" {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", hook.Command)
+	}
+	cmd.Env = append(append(os.Environ(), hook.Env...), env...)
+	cmd.Dir = "/"
+	// The hook and anything it starts are killed together on timeout
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: hook.User}
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+	cmd.WaitDelay = time.Second
+	if r.Output != nil {
+		out := r.Output(hook)
+		cmd.Stdout = out
+		cmd.Stderr = out
+		// A last line without a newline still ends up in the log
+		if f, ok := out.(interface{ Flush() error }); ok {
+			defer f.Flush()
+		}
+	}
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	return err
+}
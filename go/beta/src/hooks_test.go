@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"main/src/hooks"
+	"main/src/paths"
+	"main/src/svpnlog"
+)
+
+func TestHookOutputReachesProfileLog(t *testing.T) {
+	dir := t.TempDir()
+	u, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs := &paths.Dirs{User: u, ConfigFile: filepath.Join(dir, "svpn", "config.toml")}
+
+	upDir := filepath.Join(dir, "svpn", "hooks", "up.d")
+	if err := os.MkdirAll(upDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(upDir, "10-mount")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho \"mounting for $SVPN_PROFILE on $SVPN_DEVICE\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	config := "[hooks]\nup = [\"echo $SVPN_REASON >&2; printf 'no newline'\"]\n"
+	if err := os.WriteFile(dirs.ConfigFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	logFile, err := openProfileLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+
+	l := &vpnLaunch{profile: &Profile{Name: "work"}, log: logFile}
+	if err := l.loadHooks(dirs); err != nil {
+		t.Fatal(err)
+	}
+	l.tunnel = map[string]string{"dev": "tun0"}
+	if err := l.runHooks(hooks.Up, "connect"); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []svpnlog.Entry
+	err = svpnlog.ReadLines([]string{profileLogPath(dir)}, func(line string) {
+		entry, err := svpnlog.ParseEntry(line)
+		if err != nil {
+			t.Errorf("unparsable log line %q: %v", line, err)
+		}
+		got = append(got, entry)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		script + ": mounting for work on tun0",
+		dirs.ConfigFile + ": hooks.up[0]: connect",
+		dirs.ConfigFile + ": hooks.up[0]: no newline",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("log has %d entries, want %d: %v", len(got), len(want), got)
+	}
+	for i, entry := range got {
+		if entry.Source != "hook" || entry.Message != want[i] {
+			t.Errorf("entry %d is %s %q, want hook %q", i, entry.Source, entry.Message, want[i])
+		}
+	}
+}
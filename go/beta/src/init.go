@@ -1,160 +1,153 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
-	"os/exec"
 	"os/user"
 	"path/filepath"
 	"strconv"
+
+	"main/src/credentials"
+	"main/src/fetch"
+	"main/src/paths"
 )
 
-func initVPN() {
-	// Path to the auth file
-	authFilePath := "/etc/openvpn/auth.txt"
-	
-	// Content to write to the auth file
-	authContent := "zwsXIilK7SxU0s9Z\ngotM4g5OZe17ixa6UEWi8oTi3Oee7Vk8"
-	
-	// GitHub repository URL for the config file
-	configFileURL := "https://github.com/cazzano/open_vpn/raw/main/config.ovpn.gpg"
-	
-	// Get the original user (before sudo)
-	originalUser, homeDir := getOriginalUserAndHome()
-	
-	// Check if running with sudo
-	if os.Geteuid() != 0 {
-		fmt.Println("This program needs sudo privileges to write to /etc/openvpn/auth.txt")
-		fmt.Println("Please run with sudo")
-		
-		// Attempt to re-run with sudo
-		cmd := exec.Command("sudo", os.Args[0], "init")
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		
-		err := cmd.Run()
+func initVPN(fs *flag.FlagSet) func(args []string) (any, error) {
+	credSpec := fs.String("credentials", credentials.DefaultSpec,
+		"Credentials backend: prompt, env, file:<path>, secret-service[:<key>] or pass:<entry>")
+	profileName := fs.String("profile", defaultProfile, "Name of the profile to initialize")
+	from := fs.String("from", defaultConfigURL, "URL or file to fetch the config from")
+	sha := fs.String("sha256", "", "Expected SHA-256 digest of the config")
+	signature := fs.String("signature", "", "URL or file of a detached OpenPGP signature of the config")
+	key := fs.String("key", "", "OpenPGP public key to pin for --signature")
+	return func(args []string) (any, error) {
+		if err := parseFlags(fs, args); err != nil {
+			return nil, err
+		}
+
+		if err := validateProfileName(*profileName); err != nil {
+			return nil, fail(exitUsage, err)
+		}
+
+		// Get the original user (before sudo)
+		dirs, err := paths.Current()
 		if err != nil {
-			fmt.Printf("Failed to execute with sudo: %v\n", err)
+			return nil, fail(exitFailure, err)
+		}
+		settings, err := loadConfig(dirs)
+		if err != nil {
+			return nil, fail(exitConfig, err)
 		}
-		
-		return
-	}
-	
-	// Create the directory if it doesn't exist
-	os.MkdirAll("/etc/openvpn", 0755)
-	
-	// Write the content to the file
-	err := os.WriteFile(authFilePath, []byte(authContent), 0600)
-	if err != nil {
-		fmt.Printf("Error writing to %s: %v\n", authFilePath, err)
-		return
-	}
-	
-	fmt.Printf("Successfully created %s with the required credentials\n", authFilePath)
-	
-	// Create ~/.open_vpn directory
-	openVpnDir := filepath.Join(homeDir, ".open_vpn")
-	err = os.MkdirAll(openVpnDir, 0755)
-	if err != nil {
-		fmt.Printf("Error creating directory %s: %v\n", openVpnDir, err)
-		return
-	}
-	
-	// Download the config file using wget
-	configFilePath := filepath.Join(openVpnDir, "config.ovpn.gpg")
-	fmt.Printf("Downloading config file to %s...\n", configFilePath)
-	
-	// Use wget to download the file
-	cmd := exec.Command("wget", "-O", configFilePath, configFileURL)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	
-	err = cmd.Run()
-	if err != nil {
-		fmt.Printf("Error downloading config file: %v\n", err)
-		return
-	}
-	
-	// Fix ownership of the .open_vpn directory and its contents if we're running as root
-	if originalUser != "" {
-		fixOwnership(openVpnDir, originalUser)
-	}
-	
-	fmt.Printf("Successfully downloaded config file to %s\n", configFilePath)
-	
-	// Run GPG on the downloaded file
-	fmt.Println("Running GPG on the config file...")
-	
-	var gpgCmd *exec.Cmd
-	if originalUser != "" && originalUser != "root" {
-		// Run the GPG command as the original user
-		gpgCmd = exec.Command("sudo", "-u", originalUser, "gpg", configFilePath)
-	} else {
-		// If we couldn't determine the original user, just run GPG normally
-		gpgCmd = exec.Command("gpg", configFilePath)
-	}
-	
-	gpgCmd.Stdout = os.Stdout
-	gpgCmd.Stderr = os.Stderr
-	gpgCmd.Stdin = os.Stdin
-	
-	err = gpgCmd.Run()
-	if err != nil {
-		fmt.Printf("Error running GPG: %v\n", err)
-	}
-	
-	fmt.Println("Setup completed successfully!")
-}
 
-// getOriginalUserAndHome retrieves the original user (before sudo) and their home directory
-func getOriginalUserAndHome() (string, string) {
-	// First check if SUDO_USER environment variable is set
-	sudoUser := os.Getenv("SUDO_USER")
-	if sudoUser != "" {
-		usr, err := user.Lookup(sudoUser)
-		if err == nil {
-			return sudoUser, usr.HomeDir
+		// The config files may choose the backend and the download
+		if spec := configuredCredentials(settings); spec != "" && !flagSet(fs, "credentials") {
+			*credSpec = spec
 		}
+		if settings.ConfigURL != "" && !flagSet(fs, "from") {
+			*from = settings.ConfigURL
+		}
+
+		// Validate the credentials backend before touching anything
+		provider, err := credentials.New(*credSpec)
+		if err != nil {
+			return nil, fail(exitUsage, err)
+		}
+
+		// Local paths are recorded absolute so the profile can be fetched again
+		opts := sourceOptions{From: *from, SHA256: *sha, Signature: *signature, Key: *key}
+		for _, path := range []*string{&opts.From, &opts.Signature} {
+			if *path != "" && !fetch.IsURL(*path) {
+				*path, _ = filepath.Abs(*path)
+			}
+		}
+
+		// Re-running init keeps the cache of the previous download
+		store := newProfileStore(dirs, settings)
+		profile := &Profile{Name: *profileName}
+		if store.Exists(profile.Name) {
+			if profile, err = store.Load(profile.Name); err != nil {
+				return nil, fail(exitConfig, err)
+			}
+		}
+		// svpn never stores the username or password itself. An existing
+		// profile keeps its backend unless --credentials is given.
+		if flagSet(fs, "credentials") || profile.Credentials == "" {
+			profile.Credentials = provider.Name()
+		}
+
+		profileDir := store.Dir(profile.Name)
+		if err := ensureConfigDir(profileDir); err != nil {
+			return nil, failf(exitFailure, "error creating directory %s: %v", profileDir, err)
+		}
+		if os.Geteuid() == 0 {
+			defer fixOwnership(store.dir, dirs.User.Username)
+		}
+
+		dl, err := downloadConfig(profile, profileDir, opts)
+		if err != nil {
+			return nil, fail(exitConfig, err)
+		}
+
+		// Encrypted configs stay encrypted on disk and are decrypted in
+		// memory whenever the VPN starts. Decrypting it now checks the
+		// passphrase and the config itself.
+		if dl.Unchanged {
+			fmt.Println("The config is up to date")
+		} else {
+			fmt.Println("Checking the downloaded profile...")
+			if _, _, err := unlockConfigData(opts.From, dl.Data); err != nil {
+				return nil, fail(exitConfig, err)
+			}
+		}
+
+		if err := installConfig(store, profile, dl); err != nil {
+			return nil, fail(exitFailure, err)
+		}
+		fmt.Printf("Saved config to %s\n", profile.Config)
+		fmt.Printf("Profile %q will read its credentials from %s when the VPN starts\n", profile.Name, profile.CredentialsSpec())
+
+		// Older versions left the decrypted config in ~/.open_vpn
+		plainConfig := filepath.Join(dirs.Home, ".open_vpn", "config.ovpn")
+		if _, err := os.Stat(plainConfig); err == nil {
+			if err := os.Remove(plainConfig); err != nil {
+				warnf("Could not remove the decrypted config %s: %v", plainConfig, err)
+			} else {
+				fmt.Printf("Removed the decrypted config %s, it is no longer needed\n", plainConfig)
+			}
+		}
+
+		fmt.Println("Setup completed successfully!")
+		return profile, nil
 	}
-	
-	// If running without sudo or SUDO_USER not available, get current user
-	currentUser, err := user.Current()
-	if err != nil {
-		fmt.Printf("Warning: Could not determine current user: %v\n", err)
-		homeDir, _ := os.UserHomeDir() // Fallback
-		return "", homeDir
-	}
-	
-	return currentUser.Username, currentUser.HomeDir
 }
 
 // fixOwnership changes the ownership of a file or directory to the specified user
 func fixOwnership(path, username string) {
 	usr, err := user.Lookup(username)
 	if err != nil {
-		fmt.Printf("Warning: Could not look up user %s: %v\n", username, err)
+		warnf("Could not look up user %s: %v", username, err)
 		return
 	}
-	
+
 	uid, err := strconv.Atoi(usr.Uid)
 	if err != nil {
-		fmt.Printf("Warning: Could not parse UID for user %s: %v\n", username, err)
+		warnf("Could not parse UID for user %s: %v", username, err)
 		return
 	}
-	
+
 	gid, err := strconv.Atoi(usr.Gid)
 	if err != nil {
-		fmt.Printf("Warning: Could not parse GID for user %s: %v\n", username, err)
+		warnf("Could not parse GID for user %s: %v", username, err)
 		return
 	}
-	
+
 	// Change ownership of the directory
 	err = os.Chown(path, uid, gid)
 	if err != nil {
-		fmt.Printf("Warning: Could not change ownership of %s: %v\n", path, err)
+		warnf("Could not change ownership of %s: %v", path, err)
 	}
-	
+
 	// Change ownership of any files inside the directory
 	filepath.Walk(path, func(filePath string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -163,7 +156,7 @@ func fixOwnership(path, username string) {
 		if filePath != path {
 			err = os.Chown(filePath, uid, gid)
 			if err != nil {
-				fmt.Printf("Warning: Could not change ownership of %s: %v\n", filePath, err)
+				warnf("Could not change ownership of %s: %v", filePath, err)
 			}
 		}
 		return nil
@@ -1,112 +1,324 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"syscall"
 	"time"
+
+	"main/src/helper"
+	"main/src/ovpn"
+	"main/src/state"
 )
 
-func readPIDInfo(configPath string) (*PIDInfo, error) {
-	pidFile := filepath.Join(configPath, "pid.json")
-	data, err := os.ReadFile(pidFile)
-	if err != nil {
-		return nil, fmt.Errorf("error reading PID file: %v", err)
+// Exit codes for `svpn stop`.
+const (
+	stopDone       = exitOK         // VPN stopped in time and everything was reverted
+	stopFailed     = exitFailure    // VPN could not be stopped
+	stopNotRunning = exitNotRunning // no VPN was running
+	stopKilled     = exitKilled     // VPN had to be killed after the timeout
+	stopLeftovers  = exitLeftovers  // VPN stopped, but its tun device, kill switch or DNS changes remain
+)
+
+// killGrace is how long a killed process may take to disappear.
+const killGrace = 5 * time.Second
+
+// StopReport describes how `svpn stop` went.
+type StopReport struct {
+	Profile string `json:"profile"`
+	// Running is set when a VPN process was found running
+	Running bool `json:"running"`
+	// Killed is set when a process had to be sent SIGKILL
+	Killed bool `json:"killed,omitempty"`
+	// Leftovers lists what is still in place after the stop
+	Leftovers []string `json:"leftovers,omitempty"`
+}
+
+// exitCode returns the exit code of `svpn stop` for the report.
+func (r *StopReport) exitCode() int {
+	switch {
+	case len(r.Leftovers) > 0:
+		return stopLeftovers
+	case !r.Running:
+		return stopNotRunning
+	case r.Killed:
+		return stopKilled
 	}
+	return stopDone
+}
 
-	var pidInfo PIDInfo
-	if err := json.Unmarshal(data, &data); err != nil {
-		return nil, fmt.Errorf("error parsing PID file: %v", err)
+// stopViaManagement sends SIGTERM to OpenVPN over its management socket.
+func stopViaManagement(pidInfo *state.Info) error {
+	client, err := dialManagement(pidInfo)
+	if err != nil {
+		return err
 	}
+	defer client.Close()
 
-	return &pidInfo, nil
+	return client.Signal("SIGTERM")
 }
 
-func killVPN() {
-	// Check sudo permissions first
-	fmt.Println("Checking sudo permissions...")
-	if err := checkSudo(); err != nil {
-		fmt.Println("Error: This program requires sudo privileges")
-		fmt.Println("Please run with sudo or enter your password when prompted")
-		os.Exit(1)
-	}
+// stopSupervisor asks a `svpn start --supervise` process to stop OpenVPN
+// and waits for it to exit, killing both after timeout. It reports
+// whether they had to be killed.
+func stopSupervisor(info *state.Info, timeout time.Duration) (bool, error) {
+	pid := info.Supervisor
+	fmt.Printf("Stopping the VPN supervisor (PID: %d)...\n", pid)
 
-	// Get config directory path
-	homeDir, err := os.UserHomeDir()
+	process, err := os.FindProcess(pid)
 	if err != nil {
-		fmt.Println("Error getting home directory:", err)
-		os.Exit(1)
+		return false, fmt.Errorf("supervisor process %d not found: %v", pid, err)
+	}
+	// A background supervisor runs as root and needs sudo to be signaled
+	if err := process.Signal(syscall.SIGTERM); err == syscall.EPERM {
+		err = signalProcess(pid, "TERM")
+		if err != nil {
+			return false, fmt.Errorf("error signaling supervisor: %v", err)
+		}
+	} else if err != nil {
+		return false, fmt.Errorf("error signaling supervisor: %v", err)
+	}
+	if waitForExit(info.SupervisorAlive, timeout) {
+		return false, nil
 	}
 
-	configPath := filepath.Join(homeDir, ".config", "secret_vpn")
-	pidFile := filepath.Join(configPath, "pid.json")
+	// Killing OpenVPN lets the supervisor clean up after it
+	fmt.Printf("The VPN did not stop within %s, sending SIGKILL\n", timeout)
+	if info.OpenVPNAlive() {
+		signalProcess(info.PID, "KILL")
+	}
+	if waitForExit(info.SupervisorAlive, killGrace) {
+		return true, nil
+	}
+	if err := signalProcess(pid, "KILL"); err != nil {
+		return true, fmt.Errorf("failed to kill supervisor: %v", err)
+	}
+	if !waitForExit(info.SupervisorAlive, killGrace) {
+		return true, fmt.Errorf("supervisor %d is still running after SIGKILL", pid)
+	}
+	return true, nil
+}
 
-	// Check if PID file exists
-	if _, err := os.Stat(pidFile); os.IsNotExist(err) {
-		fmt.Println("No VPN process found (PID file does not exist)")
-		os.Exit(1)
+// waitForExit polls alive until it turns false or timeout passes, and
+// reports whether the process exited.
+func waitForExit(alive func() bool, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for alive() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(200 * time.Millisecond)
 	}
+	return true
+}
 
-	// Read the PID file
-	data, err := os.ReadFile(pidFile)
-	if err != nil {
-		fmt.Println("Error reading PID file:", err)
-		fmt.Println("Is the VPN running?")
-		os.Exit(1)
+func killVPN(fs *flag.FlagSet) func(args []string) (any, error) {
+	timeout := fs.Duration("timeout", stopTimeout, "How long OpenVPN gets to exit before it is killed")
+	return func(args []string) (any, error) {
+		profileName, err := profileArg(fs, args)
+		if err != nil {
+			return nil, err
+		}
+		if *timeout <= 0 {
+			return nil, failf(stopFailed, "--timeout must be positive")
+		}
+
+		// Find the profile whose tunnel should be stopped
+		store, err := userProfileStore()
+		if err != nil {
+			return nil, fail(stopFailed, err)
+		}
+		profile, err := store.ResolveActive(profileName)
+		if err != nil {
+			return nil, fail(stopFailed, err)
+		}
+
+		// svpnd stops the VPN without sudo when it is running
+		file := state.Open(store.TunnelDir(profile.Name))
+		if client := helperClient(); client != nil {
+			fmt.Printf("Asking svpnd to stop profile %q...\n", profile.Name)
+			client.Timeout = *timeout + 2*killGrace + time.Minute
+			var report StopReport
+			params := helper.StopParams{Profile: profile.Name, Timeout: *timeout}
+			if err := client.Call(helper.MethodStop, params, &report); err != nil {
+				return nil, fail(stopFailed, err)
+			}
+			// svpnd only stops tunnels started as root; the supervisor
+			// of a foreground one is the user's own process
+			if info, err := file.Read(); report.Running || err != nil || !info.SupervisorAlive() {
+				return &report, exitCode(printStopReport(&report))
+			}
+		} else {
+			// Check sudo permissions first
+			fmt.Println("Checking sudo permissions...")
+			if err := checkSudo(); err != nil {
+				return nil, failf(stopFailed, "this program requires sudo privileges, please run with sudo or enter your password when prompted")
+			}
+		}
+
+		report, err := stopVPN(profile.Name, file, store.PrivateDir(profile.Name), *timeout)
+		if err != nil {
+			return nil, fail(stopFailed, err)
+		}
+		return report, exitCode(printStopReport(report))
 	}
+}
 
-	var pidInfo PIDInfo
-	if err := json.Unmarshal(data, &pidInfo); err != nil {
-		fmt.Println("Error parsing PID file:", err)
-		// If PID file is corrupted, remove it
-		os.Remove(pidFile)
-		os.Exit(1)
+// printStopReport sums up a stop and returns its exit code.
+func printStopReport(report *StopReport) int {
+	for _, leftover := range report.Leftovers {
+		warnf("%s", leftover)
 	}
+	code := report.exitCode()
+	switch code {
+	case stopNotRunning:
+		fmt.Printf("No VPN is running for profile %q\n", report.Profile)
+	case stopKilled:
+		fmt.Println("VPN shutdown complete (killed after the timeout)")
+	case stopLeftovers:
+		fmt.Println("VPN stopped, but not everything was reverted")
+	default:
+		fmt.Println("VPN shutdown complete")
+	}
+	return code
+}
 
-	// Check if the process exists
-	process, err := os.FindProcess(pidInfo.PID)
+// stopVPN stops the tunnel recorded in file, waiting up to timeout for
+// it to exit before killing it, undoes its DNS and firewall changes and
+// checks that they are gone. privateDir is the profile's private
+// directory.
+func stopVPN(profileName string, file *state.File, privateDir string, timeout time.Duration) (*StopReport, error) {
+	report := &StopReport{Profile: profileName}
+	pidInfo, err := file.Read()
+	if errors.Is(err, state.ErrNoState) {
+		return report, nil
+	}
+	if errors.Is(err, state.ErrCorrupt) {
+		// If PID file is corrupted, remove it
+		file.Remove()
+	}
 	if err != nil {
-		fmt.Printf("Process with PID %d not found\n", pidInfo.PID)
-		// Clean up the PID file
-		os.Remove(pidFile)
-		os.Exit(1)
-	}
-
-	// Try to kill the process
-	fmt.Printf("Attempting to kill OpenVPN process (PID: %d)...\n", pidInfo.PID)
-	
-	// First try SIGTERM for graceful shutdown
-	cmd := exec.Command("sudo", "kill", "-TERM", fmt.Sprintf("%d", pidInfo.PID))
-	if err := cmd.Run(); err != nil {
-		fmt.Printf("Warning: SIGTERM failed, attempting force kill: %v\n", err)
-		// If SIGTERM fails, try SIGKILL
-		cmd = exec.Command("sudo", "kill", "-9", fmt.Sprintf("%d", pidInfo.PID))
-		if err := cmd.Run(); err != nil {
-			fmt.Printf("Error: Failed to kill process: %v\n", err)
-			os.Exit(1)
-		}
-	}
-
-	// Wait a moment to ensure the process is killed
-	time.Sleep(2 * time.Second)
-
-	// Verify the process is killed by sending signal 0
-	if err := process.Signal(syscall.Signal(0)); err == nil {
-		fmt.Println("Warning: Process might still be running")
-		fmt.Println("Please check the process status manually")
-	} else {
-		fmt.Println("OpenVPN process successfully terminated")
+		return nil, err
+	}
+
+	// A supervisor would restart OpenVPN, so ask it to shut down instead
+	if pidInfo.SupervisorAlive() {
+		report.Running = true
+		if report.Killed, err = stopSupervisor(pidInfo, timeout); err != nil {
+			return nil, err
+		}
+		// A killed supervisor leaves its state behind
+		file.Remove()
+		report.Leftovers = cleanupAfterStop(profileName, privateDir, pidInfo)
+		return report, nil
+	}
+
+	// OpenVPN may have died on its own, leaving the kill switch behind
+	if !pidInfo.OpenVPNAlive() {
+		fmt.Printf("OpenVPN process (PID: %d) is no longer running\n", pidInfo.PID)
+		removeManagementSocket(pidInfo.Management)
+		file.Remove()
+		report.Leftovers = cleanupAfterStop(profileName, privateDir, pidInfo)
+		return report, nil
+	}
+	report.Running = true
+
+	// Ask OpenVPN to shut down through the management interface first
+	fmt.Printf("Stopping OpenVPN process (PID: %d)...\n", pidInfo.PID)
+	if err := stopViaManagement(pidInfo); err != nil {
+		fmt.Printf("Management interface unavailable (%v), sending SIGTERM\n", err)
+		if err := signalProcess(pidInfo.PID, "TERM"); err != nil {
+			warnf("SIGTERM failed: %v", err)
+		}
 	}
+	if !waitForExit(pidInfo.OpenVPNAlive, timeout) {
+		fmt.Printf("OpenVPN did not exit within %s, sending SIGKILL\n", timeout)
+		report.Killed = true
+		if err := signalProcess(pidInfo.PID, "KILL"); err != nil {
+			return nil, fmt.Errorf("failed to kill process: %v", err)
+		}
+		if !waitForExit(pidInfo.OpenVPNAlive, killGrace) {
+			return nil, fmt.Errorf("OpenVPN process %d is still running after SIGKILL", pidInfo.PID)
+		}
+	}
+	fmt.Println("OpenVPN process successfully terminated")
+
+	// OpenVPN removes its management socket on exit, but not after SIGKILL
+	removeManagementSocket(pidInfo.Management)
 
 	// Remove the PID file
-	if err := os.Remove(pidFile); err != nil {
-		fmt.Printf("Warning: Could not remove PID file: %v\n", err)
+	if err := file.Remove(); err != nil {
+		warnf("Could not remove PID file: %v", err)
 	} else {
 		fmt.Println("PID file removed successfully")
 	}
 
-	fmt.Println("VPN shutdown complete")
+	report.Leftovers = cleanupAfterStop(profileName, privateDir, pidInfo)
+	return report, nil
+}
+
+// removeManagementSocket removes the management socket OpenVPN leaves
+// behind when it is killed. Nothing but a socket is removed, as this
+// runs as root in a directory of the user.
+func removeManagementSocket(path string) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+}
+
+// cleanupAfterStop restores DNS and removes the kill switch once
+// OpenVPN is gone, then returns what is still in place. The supervisor
+// normally restores DNS itself; the journal is only left when it could
+// not.
+func cleanupAfterStop(profileName, privateDir string, pidInfo *state.Info) []string {
+	var leftovers []string
+	if err := restoreProfileDNS(profileName, privateDir); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+	}
+	if _, err := os.Stat(dnsJournalPath(privateDir)); err == nil {
+		leftovers = append(leftovers, fmt.Sprintf("DNS settings were not restored, run 'svpn dns restore %s'", profileName))
+	}
+
+	if pidInfo.Killswitch {
+		// Another tunnel may have taken the shared table over since
+		if err := removeOwnKillswitch(privateDir); err != nil {
+			fmt.Fprintln(os.Stderr, "Error removing the kill switch:", err)
+		}
+		if active, err := killswitchOwnedBy(privateDir); err != nil || active {
+			leftovers = append(leftovers, "the kill switch is still on, run 'svpn killswitch off' to restore normal traffic")
+		} else {
+			fmt.Println("Kill switch removed")
+		}
+	}
+
+	// The kernel removes a tun device as soon as OpenVPN closes it
+	if dev := tunnelInterface(pidInfo); dev != "" {
+		exists := func() bool {
+			_, err := os.Stat(filepath.Join("/sys/class/net", dev))
+			return err == nil
+		}
+		if !waitForExit(exists, 2*time.Second) {
+			leftovers = append(leftovers, fmt.Sprintf("tun device %s still exists", dev))
+		}
+	}
+	return leftovers
+}
+
+// tunnelInterface returns the tun device of a tunnel: the one
+// it recorded, or a fixed device name from its config.
+func tunnelInterface(pidInfo *state.Info) string {
+	if pidInfo.Interface != "" {
+		return pidInfo.Interface
+	}
+	cfg, err := ovpn.ParseFile(pidInfo.Config)
+	if err != nil {
+		return ""
+	}
+	if d := cfg.Get("dev"); d != nil && d.Arg(0) != "tun" && d.Arg(0) != "tap" {
+		return d.Arg(0)
+	}
+	return ""
 }
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+
+	"main/src/state"
+)
+
+// TestHelperOpenVPN is not a test but the process stopVPN stops, run by
+// startOpenVPNHelper. It reports every SIGTERM and dies of it only in
+// mode "exit".
+func TestHelperOpenVPN(t *testing.T) {
+	mode := os.Getenv("SVPN_TEST_OPENVPN")
+	if mode == "" {
+		return
+	}
+	terms := make(chan os.Signal, 1)
+	signal.Notify(terms, syscall.SIGTERM)
+	fmt.Println("ready")
+	for range terms {
+		fmt.Println("SIGTERM")
+		if mode == "exit" {
+			// Die of the signal, without the race detector's delay on exit
+			signal.Reset(syscall.SIGTERM)
+			syscall.Kill(os.Getpid(), syscall.SIGTERM)
+		}
+	}
+}
+
+// openvpnHelper is a running TestHelperOpenVPN.
+type openvpnHelper struct {
+	cmd   *exec.Cmd
+	lines []string
+	done  chan error
+}
+
+// startOpenVPNHelper runs the test binary as a process whose command line
+// passes for OpenVPN's and waits until it handles SIGTERM.
+func startOpenVPNHelper(t *testing.T, mode string) *openvpnHelper {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperOpenVPN$", "--", "openvpn")
+	cmd.Env = append(os.Environ(), "SVPN_TEST_OPENVPN="+mode)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	p := &openvpnHelper{cmd: cmd, done: make(chan error, 1)}
+	t.Cleanup(func() { cmd.Process.Kill() })
+
+	scanner := bufio.NewScanner(stdout)
+	if !scanner.Scan() || scanner.Text() != "ready" {
+		t.Fatalf("fake OpenVPN did not start: %q", scanner.Text())
+	}
+	go func() {
+		for scanner.Scan() {
+			p.lines = append(p.lines, scanner.Text())
+		}
+		p.done <- cmd.Wait()
+	}()
+	return p
+}
+
+// wait returns the output after "ready" and how the process exited.
+func (p *openvpnHelper) wait(t *testing.T) ([]string, error) {
+	select {
+	case err := <-p.done:
+		return p.lines, err
+	case <-time.After(10 * time.Second):
+		t.Fatal("fake OpenVPN is still running")
+		return nil, nil
+	}
+}
+
+func TestStopVPNEscalates(t *testing.T) {
+	tests := []struct {
+		mode   string
+		killed bool
+		code   int
+	}{
+		{mode: "exit", killed: false, code: stopDone},
+		{mode: "ignore", killed: true, code: stopKilled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			p := startOpenVPNHelper(t, tt.mode)
+			pid := p.cmd.Process.Pid
+			start, err := state.StartTime(pid)
+			if err != nil {
+				t.Skipf("no /proc: %v", err)
+			}
+
+			dir := t.TempDir()
+			file := state.Open(dir)
+			// Without a management socket stopVPN falls back to signals
+			if err := file.Write(&state.Info{PID: pid, PIDStart: start, Management: filepath.Join(dir, "management.sock")}); err != nil {
+				t.Fatal(err)
+			}
+
+			timeout := 500 * time.Millisecond
+			began := time.Now()
+			report, err := stopVPN("work", file, dir, timeout)
+			if err != nil {
+				t.Fatalf("stopVPN() = %v", err)
+			}
+			if !report.Running || report.Killed != tt.killed || report.exitCode() != tt.code {
+				t.Errorf("stopVPN() = %+v with exit code %d, want killed %v and exit code %d",
+					report, report.exitCode(), tt.killed, tt.code)
+			}
+			if tt.killed && time.Since(began) < timeout {
+				t.Errorf("stopVPN() killed OpenVPN after %s, before the %s timeout", time.Since(began), timeout)
+			}
+
+			lines, waitErr := p.wait(t)
+			if !slices.Contains(lines, "SIGTERM") {
+				t.Errorf("OpenVPN got no SIGTERM before being stopped, output %q", lines)
+			}
+			var exitErr *exec.ExitError
+			killed := errors.As(waitErr, &exitErr) && exitErr.Sys().(syscall.WaitStatus).Signal() == syscall.SIGKILL
+			if killed != tt.killed {
+				t.Errorf("OpenVPN exited with %v, want SIGKILL %v", waitErr, tt.killed)
+			}
+			if _, err := file.Read(); !errors.Is(err, state.ErrNoState) {
+				t.Errorf("state after stop: %v, want %v", err, state.ErrNoState)
+			}
+		})
+	}
+}
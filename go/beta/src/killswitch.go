@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"main/src/helper"
+	"main/src/killswitch"
+	"main/src/ovpn"
+	"main/src/state"
+)
+
+// killswitchResolveTimeout bounds the lookup of the remote hostnames.
+const killswitchResolveTimeout = 10 * time.Second
+
+// killswitchState is what the kill switch of a profile was built from.
+// It is kept so that a restart can reuse it when DNS is already blocked
+// by a kill switch left in place.
+type killswitchState struct {
+	Rules killswitch.Config `json:"rules"`
+	// Remotes replace the remote directives of the profile's config
+	Remotes []killswitchRemote `json:"remotes"`
+}
+
+// killswitchRemote is a remote directive with a resolved address.
+type killswitchRemote struct {
+	Addr  string `json:"addr"`
+	Port  string `json:"port"`
+	Proto string `json:"proto"`
+}
+
+func killswitchStateFile(dir string) string {
+	return filepath.Join(dir, "killswitch.json")
+}
+
+// prepareKillswitch resolves the remotes of a config into the addresses
+// the kill switch lets through.
+func prepareKillswitch(cfg *ovpn.Config) (*killswitchState, error) {
+	if cfg.Inline("connection") != nil {
+		return nil, fmt.Errorf("the kill switch does not support <connection> blocks")
+	}
+
+	rules := killswitch.Config{Interface: "tun*"}
+	if d := cfg.Get("dev"); d != nil && d.Arg(0) != "" {
+		rules.Interface = d.Arg(0)
+		if rules.Interface == "tun" || rules.Interface == "tap" {
+			rules.Interface += "*"
+		}
+	}
+
+	remotes := cfg.Remotes()
+	if len(remotes) == 0 {
+		return nil, fmt.Errorf("%s has no remote to allow through the kill switch", cfg.Name)
+	}
+
+	state := &killswitchState{Rules: rules}
+	for _, r := range remotes {
+		proto, err := killswitch.Proto(r.Proto)
+		if err != nil {
+			return nil, fmt.Errorf("remote %s: %v", r, err)
+		}
+		port, err := strconv.Atoi(r.Port)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("remote %s: invalid port %q", r, r.Port)
+		}
+		addrs, err := resolveRemote(r.Host, r.Proto)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			state.Rules.Endpoints = append(state.Rules.Endpoints, killswitch.Endpoint{Addr: addr, Port: port, Proto: proto})
+			state.Remotes = append(state.Remotes, killswitchRemote{Addr: addr.String(), Port: r.Port, Proto: r.Proto})
+		}
+	}
+	return state, nil
+}
+
+// rewriteRemotes replaces every remote of cfg with the resolved ones, so
+// OpenVPN never needs DNS while the kill switch blocks it.
+func rewriteRemotes(cfg *ovpn.Config, state *killswitchState) {
+	cfg.Remove("remote")
+	cfg.Remove("remote-random-hostname")
+	for _, r := range state.Remotes {
+		cfg.Add("remote", r.Addr, r.Port, r.Proto)
+	}
+}
+
+// resolveRemote returns the addresses of a remote host that match the
+// address family its protocol allows.
+func resolveRemote(host, proto string) ([]netip.Addr, error) {
+	network := "ip"
+	switch {
+	case strings.HasSuffix(proto, "4") || strings.Contains(proto, "4-"):
+		network = "ip4"
+	case strings.HasSuffix(proto, "6") || strings.Contains(proto, "6-"):
+		network = "ip6"
+	}
+
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr.Unmap()}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), killswitchResolveTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, network, host)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving remote %s: %v", host, err)
+	}
+	for i := range addrs {
+		addrs[i] = addrs[i].Unmap()
+	}
+	return addrs, nil
+}
+
+// loadKillswitchState reads the state saved by the last enableKillswitch.
+func loadKillswitchState(dir string) (*killswitchState, error) {
+	data, err := os.ReadFile(killswitchStateFile(dir))
+	if err != nil {
+		return nil, err
+	}
+	var state killswitchState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", killswitchStateFile(dir), err)
+	}
+	if len(state.Remotes) == 0 {
+		return nil, fmt.Errorf("%s lists no remotes", killswitchStateFile(dir))
+	}
+	return &state, nil
+}
+
+// saveKillswitchState replaces the saved state of the kill switch.
+func saveKillswitchState(dir string, ks *killswitchState) error {
+	data, err := json.MarshalIndent(ks, "", "  ")
+	if err != nil {
+		return err
+	}
+	return state.WriteFile(killswitchStateFile(dir), data, 0600)
+}
+
+// enableKillswitch installs the kill switch for the launch and makes
+// OpenVPN use the config with resolved remotes.
+func (l *vpnLaunch) enableKillswitch() error {
+	cfg, err := l.parseConfig()
+	if err != nil {
+		return err
+	}
+
+	// The rules are built from the saved state as root, so root keeps
+	// it where the user cannot change it
+	dir := l.stateDir
+	if l.rootState() {
+		dir = l.privateDir
+		if err := ensurePrivateDir(dir); err != nil {
+			return err
+		}
+	}
+
+	state, err := prepareKillswitch(cfg)
+	if err != nil {
+		// DNS fails while an earlier kill switch is still in place
+		previous, loadErr := loadKillswitchState(dir)
+		if loadErr != nil {
+			return err
+		}
+		warnf("%v, reusing the previously resolved remotes", err)
+		state = previous
+	}
+
+	// The table is shared by all tunnels, see killswitch.Table
+	state.Rules.Owner = l.privateDir
+	listing, err := killswitchTable()
+	if err != nil {
+		return err
+	}
+	if owner, _ := killswitch.Owner(listing); listing != "" && owner != state.Rules.Owner {
+		if owner == "" {
+			owner = "unknown"
+		}
+		return fmt.Errorf("the kill switch of another tunnel (%s) is on, stop that tunnel or run 'svpn killswitch off' first", owner)
+	}
+
+	if err := applyKillswitch(state.Rules); err != nil {
+		return err
+	}
+
+	if err := saveKillswitchState(dir, state); err != nil {
+		warnf("Could not save the kill switch state: %v", err)
+	}
+
+	rewriteRemotes(cfg, state)
+	l.runConfig = cfg.Bytes()
+	l.info.Killswitch = true
+
+	fmt.Printf("Kill switch enabled: only loopback, %s and %d server address(es) are allowed\n",
+		state.Rules.Interface, len(state.Rules.Endpoints))
+	for _, e := range state.Rules.Endpoints {
+		fmt.Printf("  allowing %s\n", e)
+	}
+	return nil
+}
+
+// applyKillswitch installs or replaces the svpn nftables table.
+func applyKillswitch(rules killswitch.Config) error {
+	return runNFT(killswitch.Ruleset(rules))
+}
+
+// removeKillswitch deletes the svpn nftables table if it exists.
+func removeKillswitch() error {
+	return runNFT(killswitch.DeleteScript())
+}
+
+// removeOwnKillswitch deletes the svpn nftables table if it belongs to
+// owner, leaving the kill switch of another tunnel alone.
+func removeOwnKillswitch(owner string) error {
+	own, err := killswitchOwnedBy(owner)
+	if err != nil || !own {
+		return err
+	}
+	return removeKillswitch()
+}
+
+// killswitchOwnedBy reports whether the svpn nftables table exists and
+// belongs to owner.
+func killswitchOwnedBy(owner string) (bool, error) {
+	listing, err := killswitchTable()
+	if err != nil || listing == "" {
+		return false, err
+	}
+	current, _ := killswitch.Owner(listing)
+	return current == owner, nil
+}
+
+// killswitchActive reports whether the svpn nftables table exists.
+func killswitchActive() (bool, error) {
+	listing, err := killswitchTable()
+	return listing != "", err
+}
+
+// killswitchTable returns the svpn nftables table as nft lists it, or
+// "" if there is none.
+func killswitchTable() (string, error) {
+	out, err := rootCommand("nft", "list", "table", killswitch.Family, killswitch.Table).CombinedOutput()
+	if err == nil {
+		return string(out), nil
+	}
+	if bytes.Contains(out, []byte("No such file or directory")) {
+		return "", nil
+	}
+	return "", nftError(out, err)
+}
+
+// runNFT feeds a script to nft as one atomic transaction.
+func runNFT(script string) error {
+	cmd := rootCommand("nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(script)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nftError(out, err)
+	}
+	return nil
+}
+
+func nftError(out []byte, err error) error {
+	var execErr *exec.Error
+	if errors.As(err, &execErr) {
+		return fmt.Errorf("nft not found, install nftables to use the kill switch")
+	}
+	if msg := strings.TrimSpace(string(out)); msg != "" {
+		return fmt.Errorf("nft failed: %s", msg)
+	}
+	return fmt.Errorf("nft failed: %v", err)
+}
+
+// killswitchOff handles `svpn killswitch off`.
+func killswitchOff(args []string) (any, error) {
+	if client := helperClient(); client != nil {
+		err := client.Call(helper.MethodKillswitch, helper.KillswitchParams{Action: "off"}, nil)
+		if err != nil {
+			return nil, fail(exitFailure, err)
+		}
+	} else if err := removeKillswitch(); err != nil {
+		return nil, fail(exitFailure, err)
+	}
+	fmt.Println("Kill switch removed, traffic is no longer restricted to the VPN")
+	return nil, nil
+}
+
+// killswitchStatus handles `svpn killswitch status`.
+func killswitchStatus(args []string) (any, error) {
+	var active bool
+	var err error
+	if client := helperClient(); client != nil {
+		var result helper.KillswitchResult
+		err = client.Call(helper.MethodKillswitch, helper.KillswitchParams{Action: "status"}, &result)
+		active = result.Active
+	} else {
+		active, err = killswitchActive()
+	}
+	if err != nil {
+		return nil, fail(exitFailure, err)
+	}
+	result := map[string]bool{"active": active}
+	if !active {
+		fmt.Println("Kill switch: off")
+		return result, exitCode(exitNotRunning)
+	}
+	fmt.Println("Kill switch: on")
+	return result, nil
+}
@@ -0,0 +1,151 @@
+// Package killswitch generates the nftables ruleset that blocks all
+// traffic outside the VPN tunnel.
+package killswitch
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// Family and Table name the nftables table svpn owns. There is a single
+// table for all profiles: a drop policy in one table would drop packets
+// that another table accepts. It therefore belongs to one tunnel at a
+// time, named in a rule comment, see Owner.
+const (
+	Family = "inet"
+	Table  = "svpn"
+)
+
+// Endpoint is a VPN server address OpenVPN may connect to.
+type Endpoint struct {
+	Addr  netip.Addr `json:"addr"`
+	Port  int        `json:"port"`
+	Proto string     `json:"proto"` // "udp" or "tcp"
+}
+
+func (e Endpoint) String() string {
+	return fmt.Sprintf("%s (%s)", netip.AddrPortFrom(e.Addr, uint16(e.Port)), e.Proto)
+}
+
+// Config describes what the kill switch lets through.
+type Config struct {
+	// Interface is the tunnel device; a trailing '*' matches any
+	// device with that prefix, e.g. "tun*".
+	Interface string     `json:"interface"`
+	Endpoints []Endpoint `json:"endpoints"`
+	// Owner identifies the tunnel the kill switch belongs to
+	Owner string `json:"owner,omitempty"`
+}
+
+// Ruleset returns an nft script that atomically replaces the svpn table
+// with one that only allows loopback traffic, traffic through the tunnel
+// and traffic to the VPN endpoints. Neighbor discovery and DHCP stay
+// allowed so the physical link keeps working while the tunnel is down.
+func Ruleset(cfg Config) string {
+	endpoints := normalize(cfg.Endpoints)
+	iface := quote(cfg.Interface)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Generated by svpn, removed by 'svpn stop' or 'svpn killswitch off'\n")
+	// Creating the table first makes the delete succeed when it is missing
+	fmt.Fprintf(&b, "table %s %s\n", Family, Table)
+	fmt.Fprintf(&b, "delete table %s %s\n", Family, Table)
+	fmt.Fprintf(&b, "table %s %s {\n", Family, Table)
+
+	fmt.Fprintf(&b, "\tchain input {\n")
+	fmt.Fprintf(&b, "\t\ttype filter hook input priority filter; policy drop;\n")
+	fmt.Fprintf(&b, "\t\tiifname \"lo\" accept\n")
+	fmt.Fprintf(&b, "\t\tiifname %s accept\n", iface)
+	fmt.Fprintf(&b, "\t\tct state established,related accept\n")
+	fmt.Fprintf(&b, "\t\ticmpv6 type { nd-neighbor-solicit, nd-neighbor-advert, nd-router-advert } accept\n")
+	fmt.Fprintf(&b, "\t\tudp sport 67 udp dport 68 accept\n")
+	fmt.Fprintf(&b, "\t}\n")
+
+	fmt.Fprintf(&b, "\tchain output {\n")
+	fmt.Fprintf(&b, "\t\ttype filter hook output priority filter; policy drop;\n")
+	fmt.Fprintf(&b, "\t\toifname \"lo\" accept comment %s\n", quote(ownerPrefix+cfg.Owner))
+	fmt.Fprintf(&b, "\t\toifname %s accept\n", iface)
+	for _, e := range endpoints {
+		ip := "ip"
+		if e.Addr.Is6() {
+			ip = "ip6"
+		}
+		fmt.Fprintf(&b, "\t\t%s daddr %s %s dport %d accept\n", ip, e.Addr, e.Proto, e.Port)
+	}
+	fmt.Fprintf(&b, "\t\ticmpv6 type { nd-neighbor-solicit, nd-neighbor-advert, nd-router-solicit } accept\n")
+	fmt.Fprintf(&b, "\t\tudp sport 68 udp dport 67 accept\n")
+	fmt.Fprintf(&b, "\t}\n")
+
+	fmt.Fprintf(&b, "\tchain forward {\n")
+	fmt.Fprintf(&b, "\t\ttype filter hook forward priority filter; policy drop;\n")
+	fmt.Fprintf(&b, "\t\toifname %s accept\n", iface)
+	fmt.Fprintf(&b, "\t\tiifname %s ct state established,related accept\n", iface)
+	fmt.Fprintf(&b, "\t}\n")
+
+	fmt.Fprintf(&b, "}\n")
+	return b.String()
+}
+
+// ownerPrefix starts the comment that records the owner of the table.
+const ownerPrefix = "svpn owner "
+
+// Owner returns the owner recorded in the output of
+// `nft list table inet svpn`, and false if none is recorded.
+func Owner(listing string) (string, bool) {
+	_, rest, ok := strings.Cut(listing, `comment "`+ownerPrefix)
+	if !ok {
+		return "", false
+	}
+	owner, _, ok := strings.Cut(rest, `"`)
+	return owner, ok
+}
+
+// DeleteScript returns an nft script that removes the svpn table, and
+// succeeds when there is none.
+func DeleteScript() string {
+	return fmt.Sprintf("table %s %s\ndelete table %s %s\n", Family, Table, Family, Table)
+}
+
+// Proto maps an OpenVPN proto such as "udp4" or "tcp-client" to the
+// transport protocol nftables matches on.
+func Proto(proto string) (string, error) {
+	switch strings.ToLower(proto) {
+	case "udp", "udp4", "udp6":
+		return "udp", nil
+	case "tcp", "tcp4", "tcp6", "tcp-client", "tcp4-client", "tcp6-client":
+		return "tcp", nil
+	}
+	return "", fmt.Errorf("unsupported protocol %q", proto)
+}
+
+// normalize sorts the endpoints and drops duplicates so the ruleset is
+// stable for the same input.
+func normalize(endpoints []Endpoint) []Endpoint {
+	sorted := append([]Endpoint(nil), endpoints...)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if c := a.Addr.Compare(b.Addr); c != 0 {
+			return c < 0
+		}
+		if a.Proto != b.Proto {
+			return a.Proto < b.Proto
+		}
+		return a.Port < b.Port
+	})
+
+	var out []Endpoint
+	for i, e := range sorted {
+		if i > 0 && e == sorted[i-1] {
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// quote renders an interface name or comment as an nft string.
+func quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, "") + `"`
+}
@@ -0,0 +1,77 @@
+package killswitch
+
+import (
+	"flag"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func TestRuleset(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+	}{{
+		name: "ipv4",
+		cfg: Config{
+			Interface: "tun0",
+			Owner:     "/var/lib/svpn/1000/profiles/work",
+			Endpoints: []Endpoint{{Addr: netip.MustParseAddr("198.51.100.7"), Port: 1194, Proto: "udp"}},
+		},
+	}, {
+		// Endpoints come out sorted by address, protocol and port, once
+		name: "ipv6",
+		cfg: Config{
+			Interface: "tun1",
+			Owner:     "/var/lib/svpn/1000/profiles/home",
+			Endpoints: []Endpoint{
+				{Addr: netip.MustParseAddr("2001:db8::2"), Port: 443, Proto: "tcp"},
+				{Addr: netip.MustParseAddr("203.0.113.9"), Port: 1194, Proto: "udp"},
+				{Addr: netip.MustParseAddr("2001:db8::2"), Port: 1194, Proto: "udp"},
+				{Addr: netip.MustParseAddr("203.0.113.9"), Port: 443, Proto: "tcp"},
+				{Addr: netip.MustParseAddr("203.0.113.9"), Port: 1194, Proto: "udp"},
+				{Addr: netip.MustParseAddr("2001:db8::1"), Port: 1194, Proto: "udp"},
+			},
+		},
+	}, {
+		name: "wildcard",
+		cfg: Config{
+			Interface: "tun*",
+			Owner:     "/var/lib/svpn/0/profiles/default",
+			Endpoints: []Endpoint{{Addr: netip.MustParseAddr("192.0.2.1"), Port: 1194, Proto: "udp"}},
+		},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Ruleset(tt.cfg)
+			golden := filepath.Join("testdata", tt.name+".nft")
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("Ruleset() differs from %s (run go test -update to rewrite it):\n%s", golden, got)
+			}
+		})
+	}
+}
+
+func TestOwner(t *testing.T) {
+	// As `nft list table inet svpn` prints it
+	listing := "table inet svpn {\n\tchain output {\n\t\ttype filter hook output priority filter; policy drop;\n" +
+		"\t\toifname \"lo\" accept comment \"svpn owner /var/lib/svpn/1000/profiles/work\"\n\t}\n}\n"
+	if owner, ok := Owner(listing); !ok || owner != "/var/lib/svpn/1000/profiles/work" {
+		t.Errorf("Owner() = %q, %v, want the work profile", owner, ok)
+	}
+	if owner, ok := Owner("table inet svpn {\n}\n"); ok {
+		t.Errorf("Owner() = %q for a table without owner", owner)
+	}
+}
@@ -0,0 +1,204 @@
+package latency
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"main/src/ovpn"
+)
+
+// hardResetClientV2 is the opcode of the packet a client opens a
+// session with, shifted into the upper five bits; key ID 0.
+const hardResetClientV2 = 7 << 3
+
+// staticKeySize is the size of an OpenVPN static key: two directions
+// of a 64 byte cipher key followed by a 64 byte HMAC key.
+const staticKeySize = 256
+
+// Handshake builds the P_CONTROL_HARD_RESET_CLIENT_V2 packet that opens
+// an OpenVPN session over UDP. Servers answer it right away, before any
+// certificate or credential is checked, as long as it carries the
+// tls-auth HMAC or tls-crypt wrapping they expect. A nil Handshake
+// builds the plain packet.
+type Handshake struct {
+	// tls-auth
+	authKey    []byte
+	authDigest func() hash.Hash
+	// tls-crypt
+	cryptKey []byte
+	cryptMAC []byte
+}
+
+// NewHandshake returns the handshake for the control channel settings
+// of cfg. Key files are read relative to the config's directory.
+func NewHandshake(cfg *ovpn.Config) (*Handshake, error) {
+	// An inline block alone stands for the directive with "[inline]"
+	directive := func(tag string) *ovpn.Directive {
+		if d := cfg.Get(tag); d != nil {
+			return d
+		}
+		if cfg.Inline(tag) != nil {
+			return &ovpn.Directive{Name: tag, Args: []string{"[inline]"}}
+		}
+		return nil
+	}
+	if directive("tls-crypt-v2") != nil {
+		return nil, errors.New("tls-crypt-v2 is not supported")
+	}
+	if d := directive("tls-crypt"); d != nil {
+		key, err := staticKey(cfg, "tls-crypt", d)
+		if err != nil {
+			return nil, err
+		}
+		// Clients send with the second half of the key
+		return &Handshake{cryptKey: key[128:160], cryptMAC: key[192:224]}, nil
+	}
+	if d := directive("tls-auth"); d != nil {
+		key, err := staticKey(cfg, "tls-auth", d)
+		if err != nil {
+			return nil, err
+		}
+		digest, size, err := authDigest(cfg)
+		if err != nil {
+			return nil, err
+		}
+		direction := d.Arg(1)
+		if kd := cfg.Get("key-direction"); kd != nil {
+			direction = kd.Arg(0)
+		}
+		// Without a direction both sides use the first HMAC key
+		offset := 64
+		if direction == "1" {
+			offset = 192
+		}
+		return &Handshake{authKey: key[offset : offset+size], authDigest: digest}, nil
+	}
+	return nil, nil
+}
+
+// Packet returns a new handshake packet.
+func (h *Handshake) Packet() ([]byte, error) {
+	session := make([]byte, 8)
+	if _, err := rand.Read(session); err != nil {
+		return nil, err
+	}
+	return h.packet(session, time.Now())
+}
+
+// packet builds the handshake packet of session at time now.
+func (h *Handshake) packet(session []byte, now time.Time) ([]byte, error) {
+	// No acknowledgements, message packet ID 0
+	rest := []byte{0, 0, 0, 0, 0}
+	head := append([]byte{hardResetClientV2}, session...)
+	if h == nil || (h.authKey == nil && h.cryptKey == nil) {
+		return append(head, rest...), nil
+	}
+
+	// Replay protection: packet ID 1 and the current time
+	replay := make([]byte, 8)
+	binary.BigEndian.PutUint32(replay[0:4], 1)
+	binary.BigEndian.PutUint32(replay[4:8], uint32(now.Unix()))
+
+	if h.cryptKey != nil {
+		header := append(head, replay...)
+		mac := hmac.New(sha256.New, h.cryptMAC)
+		mac.Write(header)
+		mac.Write(rest)
+		tag := mac.Sum(nil)
+		block, err := aes.NewCipher(h.cryptKey)
+		if err != nil {
+			return nil, err
+		}
+		encrypted := make([]byte, len(rest))
+		cipher.NewCTR(block, tag[:aes.BlockSize]).XORKeyStream(encrypted, rest)
+		return append(append(header, tag...), encrypted...), nil
+	}
+
+	// The HMAC covers the replay fields first, then the opcode and session
+	mac := hmac.New(h.authDigest, h.authKey)
+	mac.Write(replay)
+	mac.Write(head)
+	mac.Write(rest)
+	packet := append(head, mac.Sum(nil)...)
+	packet = append(packet, replay...)
+	return append(packet, rest...), nil
+}
+
+// staticKey reads the key of a tls-auth or tls-crypt directive, inline
+// or from the file it names.
+func staticKey(cfg *ovpn.Config, tag string, d *ovpn.Directive) ([]byte, error) {
+	var data []byte
+	if block := cfg.Inline(tag); block != nil {
+		data = []byte(block.Content)
+	} else {
+		path := d.Arg(0)
+		if path == "" || path == "[inline]" {
+			return nil, fmt.Errorf("%s names no key", tag)
+		}
+		if !filepath.IsAbs(path) && cfg.Name != "" {
+			path = filepath.Join(filepath.Dir(cfg.Name), path)
+		}
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("error reading the %s key: %v", tag, err)
+		}
+	}
+	key, err := parseStaticKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s key: %v", tag, err)
+	}
+	return key, nil
+}
+
+// parseStaticKey decodes an "OpenVPN Static key V1" file.
+func parseStaticKey(data []byte) ([]byte, error) {
+	const begin, end = "-----BEGIN OpenVPN Static key V1-----", "-----END OpenVPN Static key V1-----"
+	start := bytes.Index(data, []byte(begin))
+	stop := bytes.Index(data, []byte(end))
+	if start < 0 || stop < start {
+		return nil, errors.New("not an OpenVPN static key")
+	}
+	var hexKey strings.Builder
+	for _, line := range strings.Split(string(data[start+len(begin):stop]), "\n") {
+		hexKey.WriteString(strings.TrimSpace(line))
+	}
+	key, err := hex.DecodeString(hexKey.String())
+	if err != nil || len(key) != staticKeySize {
+		return nil, errors.New("malformed OpenVPN static key")
+	}
+	return key, nil
+}
+
+// authDigest returns the HMAC digest chosen by the auth directive.
+func authDigest(cfg *ovpn.Config) (func() hash.Hash, int, error) {
+	name := "SHA1"
+	if d := cfg.Get("auth"); d != nil && d.Arg(0) != "" {
+		name = strings.ToUpper(strings.ReplaceAll(d.Arg(0), "-", ""))
+	}
+	switch name {
+	case "SHA1":
+		return sha1.New, sha1.Size, nil
+	case "SHA256":
+		return sha256.New, sha256.Size, nil
+	case "SHA384":
+		return sha512.New384, sha512.Size384, nil
+	case "SHA512":
+		return sha512.New, sha512.Size, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported tls-auth digest %q", name)
+}
@@ -0,0 +1,180 @@
+package latency
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"main/src/ovpn"
+)
+
+// testKey is the static key 00 01 02 ... ff.
+func testKey() []byte {
+	key := make([]byte, staticKeySize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	return key
+}
+
+// keyBlock formats testKey as an OpenVPN static key file.
+func keyBlock() string {
+	encoded := hex.EncodeToString(testKey())
+	var b strings.Builder
+	b.WriteString("-----BEGIN OpenVPN Static key V1-----\n")
+	for i := 0; i < len(encoded); i += 32 {
+		b.WriteString(encoded[i:i+32] + "\n")
+	}
+	b.WriteString("-----END OpenVPN Static key V1-----\n")
+	return b.String()
+}
+
+func parseHandshake(t *testing.T, directives, tag string) *Handshake {
+	t.Helper()
+	config := "client\nremote vpn.example.com 1194 udp\n" + directives
+	if tag != "" {
+		config += "<" + tag + ">\n" + keyBlock() + "</" + tag + ">\n"
+	}
+	cfg, err := ovpn.Parse(strings.NewReader(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewHandshake(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+var (
+	testSession = []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	testTime    = time.Unix(1700000000, 0)
+)
+
+func TestNewHandshakeKeyOffsets(t *testing.T) {
+	key := testKey()
+	tests := []struct {
+		name       string
+		directives string
+		tag        string
+		authKey    []byte
+		cryptKey   []byte
+		cryptMAC   []byte
+	}{
+		{"no direction", "tls-auth [inline]\n", "tls-auth", key[64:84], nil, nil},
+		{"direction 0", "tls-auth [inline] 0\n", "tls-auth", key[64:84], nil, nil},
+		{"direction 1", "tls-auth [inline] 1\n", "tls-auth", key[192:212], nil, nil},
+		{"key-direction 1", "key-direction 1\n", "tls-auth", key[192:212], nil, nil},
+		{"key-direction over argument", "tls-auth [inline] 0\nkey-direction 1\n", "tls-auth", key[192:212], nil, nil},
+		{"SHA256", "key-direction 1\nauth SHA256\n", "tls-auth", key[192:224], nil, nil},
+		{"SHA512", "key-direction 1\nauth SHA512\n", "tls-auth", key[192:256], nil, nil},
+		{"tls-crypt", "", "tls-crypt", nil, key[128:160], key[192:224]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := parseHandshake(t, tt.directives, tt.tag)
+			if !bytes.Equal(h.authKey, tt.authKey) {
+				t.Errorf("authKey = %x, want %x", h.authKey, tt.authKey)
+			}
+			if !bytes.Equal(h.cryptKey, tt.cryptKey) {
+				t.Errorf("cryptKey = %x, want %x", h.cryptKey, tt.cryptKey)
+			}
+			if !bytes.Equal(h.cryptMAC, tt.cryptMAC) {
+				t.Errorf("cryptMAC = %x, want %x", h.cryptMAC, tt.cryptMAC)
+			}
+		})
+	}
+}
+
+func TestHandshakePacket(t *testing.T) {
+	tests := []struct {
+		name       string
+		directives string
+		tag        string
+		want       string
+	}{
+		{"plain", "", "", "380102030405060708" + "0000000000"},
+		{"tls-auth", "key-direction 1\n", "tls-auth",
+			"380102030405060708" + "3647902577839423b58b17ca6af3b0971e4f40a1" + "000000016553f100" + "0000000000"},
+		{"tls-auth SHA256", "key-direction 1\nauth SHA256\n", "tls-auth",
+			"380102030405060708" + "36e2743ae2126dc34c9572c48b7858d8d8dcf58e82b4b59ba48c2eaa8bdf5d3a" + "000000016553f100" + "0000000000"},
+		{"tls-crypt", "", "tls-crypt",
+			"380102030405060708" + "000000016553f100" + "e2fcfd945f4c4d4a65fe3f138b0c4bafa2f10f7a4e77594fca76f3a4cad2f5e6" + "0bc4ff7777"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := parseHandshake(t, tt.directives, tt.tag)
+			packet, err := h.packet(testSession, testTime)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(packet); got != tt.want {
+				t.Errorf("packet = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandshakePacketLayout(t *testing.T) {
+	key := testKey()
+	head := append([]byte{0x38}, testSession...)
+	replay := []byte{0, 0, 0, 1, 0x65, 0x53, 0xf1, 0x00}
+	rest := []byte{0, 0, 0, 0, 0}
+
+	t.Run("tls-auth", func(t *testing.T) {
+		packet, err := parseHandshake(t, "key-direction 1\n", "tls-auth").packet(testSession, testTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// opcode, session, HMAC, packet ID and time, then the message
+		if len(packet) != 9+sha1.Size+8+5 {
+			t.Fatalf("len(packet) = %d, want %d", len(packet), 9+sha1.Size+8+5)
+		}
+		mac := hmac.New(sha1.New, key[192:212])
+		mac.Write(replay)
+		mac.Write(head)
+		mac.Write(rest)
+		want := append(append(append(append([]byte{}, head...), mac.Sum(nil)...), replay...), rest...)
+		if !bytes.Equal(packet, want) {
+			t.Errorf("packet = %x, want %x", packet, want)
+		}
+	})
+
+	t.Run("tls-crypt", func(t *testing.T) {
+		packet, err := parseHandshake(t, "", "tls-crypt").packet(testSession, testTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// opcode, session, packet ID and time, tag, then the encrypted message
+		if len(packet) != 9+8+sha256.Size+5 {
+			t.Fatalf("len(packet) = %d, want %d", len(packet), 9+8+sha256.Size+5)
+		}
+		header := append(append([]byte{}, head...), replay...)
+		if !bytes.Equal(packet[:17], header) {
+			t.Errorf("header = %x, want %x", packet[:17], header)
+		}
+		tag, encrypted := packet[17:17+sha256.Size], packet[17+sha256.Size:]
+		block, err := aes.NewCipher(key[128:160])
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain := make([]byte, len(encrypted))
+		cipher.NewCTR(block, tag[:aes.BlockSize]).XORKeyStream(plain, encrypted)
+		if !bytes.Equal(plain, rest) {
+			t.Errorf("decrypted message = %x, want %x", plain, rest)
+		}
+		mac := hmac.New(sha256.New, key[192:224])
+		mac.Write(header)
+		mac.Write(rest)
+		if !hmac.Equal(tag, mac.Sum(nil)) {
+			t.Errorf("tag = %x, want %x", tag, mac.Sum(nil))
+		}
+	})
+}
@@ -0,0 +1,187 @@
+// Package latency measures how fast the servers of a config answer, so
+// that the fastest one can be picked instead of trying the remotes in
+// the order they are listed.
+package latency
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"main/src/ovpn"
+)
+
+// DefaultTimeout bounds a probe when the Prober sets no timeout.
+const DefaultTimeout = 3 * time.Second
+
+// Result is the latency of one remote.
+type Result struct {
+	Remote ovpn.Remote `json:"remote"`
+	// Addr is the address that was probed
+	Addr  netip.Addr    `json:"addr"`
+	RTT   time.Duration `json:"rtt,omitempty"`
+	Error string        `json:"error,omitempty"`
+}
+
+// OK reports whether the remote answered.
+func (r Result) OK() bool {
+	return r.Error == ""
+}
+
+// ProbeFunc measures one remote.
+type ProbeFunc func(ctx context.Context, remote ovpn.Remote) Result
+
+// Measure probes every remote, at most workers at a time, and returns
+// the results in the order of remotes.
+func Measure(ctx context.Context, remotes []ovpn.Remote, workers int, probe ProbeFunc) []Result {
+	if workers < 1 {
+		workers = 1
+	}
+	results := make([]Result, len(remotes))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(workers, len(remotes)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = probe(ctx, remotes[i])
+			}
+		}()
+	}
+	for i := range remotes {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// Sorted returns the results from fastest to slowest, followed by the
+// remotes that did not answer in their original order.
+func Sorted(results []Result) []Result {
+	sorted := append([]Result(nil), results...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].OK() != sorted[j].OK() {
+			return sorted[i].OK()
+		}
+		return sorted[i].OK() && sorted[i].RTT < sorted[j].RTT
+	})
+	return sorted
+}
+
+// Fastest returns the fastest remote that answered.
+func Fastest(results []Result) (Result, bool) {
+	sorted := Sorted(results)
+	if len(sorted) == 0 || !sorted[0].OK() {
+		return Result{}, false
+	}
+	return sorted[0], true
+}
+
+// Prober measures remotes the way OpenVPN reaches them: the time to
+// connect for TCP, and the time until the server answers the opening
+// packet of a session for UDP.
+type Prober struct {
+	// Handshake builds the UDP packet; see NewHandshake
+	Handshake *Handshake
+	Timeout   time.Duration
+	Resolver  *net.Resolver
+}
+
+// Probe measures one remote. It matches ProbeFunc.
+func (p *Prober) Probe(ctx context.Context, remote ovpn.Remote) Result {
+	result := Result{Remote: remote}
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	addr, err := p.resolve(ctx, remote)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Addr = addr
+	target := net.JoinHostPort(addr.String(), remote.Port)
+
+	if strings.HasPrefix(remote.Proto, "tcp") {
+		result.RTT, err = probeTCP(ctx, target)
+	} else {
+		result.RTT, err = probeUDP(ctx, target, p.Handshake)
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// resolve returns the first address of a remote host in the address
+// family its protocol allows.
+func (p *Prober) resolve(ctx context.Context, remote ovpn.Remote) (netip.Addr, error) {
+	if addr, err := netip.ParseAddr(remote.Host); err == nil {
+		return addr.Unmap(), nil
+	}
+	network := "ip"
+	switch {
+	case strings.HasSuffix(remote.Proto, "4") || strings.Contains(remote.Proto, "4-"):
+		network = "ip4"
+	case strings.HasSuffix(remote.Proto, "6") || strings.Contains(remote.Proto, "6-"):
+		network = "ip6"
+	}
+	resolver := p.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	addrs, err := resolver.LookupNetIP(ctx, network, remote.Host)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("error resolving %s: %v", remote.Host, err)
+	}
+	return addrs[0].Unmap(), nil
+}
+
+func probeTCP(ctx context.Context, target string) (time.Duration, error) {
+	start := time.Now()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", target)
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(start)
+	conn.Close()
+	return rtt, nil
+}
+
+func probeUDP(ctx context.Context, target string, handshake *Handshake) (time.Duration, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "udp", target)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	packet, err := handshake.Packet()
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	if _, err := conn.Write(packet); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 2048)
+	if _, err := conn.Read(buf); err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return 0, fmt.Errorf("no answer to the handshake")
+		}
+		return 0, err
+	}
+	return time.Since(start), nil
+}
@@ -0,0 +1,282 @@
+// Package leak checks that traffic and DNS queries leave through the
+// tunnel rather than around it.
+package leak
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// DefaultEchoURL answers a GET with the address the request came from,
+// over IPv4 and IPv6.
+const DefaultEchoURL = "https://api64.ipify.org"
+
+// Status is the outcome of one check.
+type Status string
+
+const (
+	Pass Status = "pass"
+	Fail Status = "fail"
+)
+
+// Result is the outcome of one check, with what it found.
+type Result struct {
+	Name   string `json:"name"`
+	Status Status `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// Report is the outcome of all checks.
+type Report struct {
+	Results []Result `json:"results"`
+}
+
+// Passed reports whether no check failed.
+func (r *Report) Passed() bool {
+	for _, result := range r.Results {
+		if result.Status == Fail {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Report) add(name string, status Status, format string, args ...any) {
+	r.Results = append(r.Results, Result{Name: name, Status: status, Detail: fmt.Sprintf(format, args...)})
+}
+
+// Tunnel describes the tunnel under test.
+type Tunnel struct {
+	Interface string
+	// Addrs are the addresses of the tun device
+	Addrs []netip.Addr
+	// DNS are the DNS servers applied for the tunnel
+	DNS []netip.Addr
+}
+
+// has reports whether addr belongs to the tunnel.
+func (t *Tunnel) has(addr netip.Addr) bool {
+	return slices.Contains(t.Addrs, addr.Unmap().WithZone(""))
+}
+
+// hasIPv6 reports whether the tunnel carries IPv6.
+func (t *Tunnel) hasIPv6() bool {
+	for _, addr := range t.Addrs {
+		if addr.Is6() && !addr.Is4In6() && !addr.IsLinkLocalUnicast() {
+			return true
+		}
+	}
+	return false
+}
+
+// Checker runs the checks.
+type Checker struct {
+	// EchoURL answers a GET with the client's address as plain text
+	EchoURL string
+	Tunnel  Tunnel
+	// Uplink is the interface traffic leaves through outside the
+	// tunnel. When it is set, the egress address is also looked up
+	// bound to it for comparison.
+	Uplink string
+	// Resolvers returns the DNS servers the system sends queries to
+	Resolvers func() ([]netip.Addr, error)
+}
+
+// Run runs every check.
+func (c *Checker) Run(ctx context.Context) *Report {
+	report := &Report{}
+	c.checkEgress(ctx, report)
+	c.checkDNS(report)
+	c.checkIPv6(ctx, report)
+	return report
+}
+
+// checkEgress checks that IPv4 traffic leaves through the tunnel with
+// another address than without it.
+func (c *Checker) checkEgress(ctx context.Context, report *Report) {
+	const name = "egress IP"
+	via, ip, err := c.echo(ctx, "tcp4", "")
+	if err != nil {
+		report.add(name, Fail, "%v", err)
+		return
+	}
+	if !c.Tunnel.has(via) {
+		report.add(name, Fail, "request to %s left from %s, outside the tunnel", c.EchoURL, via)
+		return
+	}
+	if c.Uplink == "" {
+		report.add(name, Pass, "%s through the tunnel", ip)
+		return
+	}
+
+	_, direct, err := c.echo(ctx, "tcp4", c.Uplink)
+	switch {
+	case errors.Is(err, errBind):
+		report.add(name, Pass, "%s through the tunnel (%v)", ip, err)
+	case err != nil:
+		report.add(name, Pass, "%s through the tunnel, blocked on %s (%v)", ip, c.Uplink, err)
+	case direct == ip:
+		report.add(name, Fail, "%s is the same as without the tunnel", ip)
+	default:
+		report.add(name, Pass, "%s through the tunnel, %s without it", ip, direct)
+	}
+}
+
+// checkDNS checks that the system sends queries only to the tunnel's
+// DNS servers, and reaches those through the tunnel.
+func (c *Checker) checkDNS(report *Report) {
+	const name = "DNS"
+	resolvers, err := c.Resolvers()
+	if err != nil {
+		report.add(name, Fail, "cannot determine the DNS servers in use: %v", err)
+		return
+	}
+	if len(c.Tunnel.DNS) == 0 {
+		report.add(name, Fail, "no DNS servers were applied for the tunnel, queries go to %s", joinAddrs(resolvers))
+		return
+	}
+
+	var outside []netip.Addr
+	for _, addr := range resolvers {
+		if !slices.Contains(c.Tunnel.DNS, addr) {
+			outside = append(outside, addr)
+		}
+	}
+	if len(outside) > 0 {
+		report.add(name, Fail, "queries also go to %s, not only to %s", joinAddrs(outside), joinAddrs(c.Tunnel.DNS))
+		return
+	}
+
+	for _, addr := range c.Tunnel.DNS {
+		via, err := routeSource(addr)
+		if err != nil {
+			report.add(name, Fail, "no route to DNS server %s: %v", addr, err)
+			return
+		}
+		if !c.Tunnel.has(via) {
+			report.add(name, Fail, "DNS server %s is reached from %s, outside the tunnel", addr, via)
+			return
+		}
+	}
+	report.add(name, Pass, "queries go to %s through the tunnel", joinAddrs(c.Tunnel.DNS))
+}
+
+// checkIPv6 checks that IPv6 traffic leaves through the tunnel if it
+// carries IPv6, and not at all otherwise.
+func (c *Checker) checkIPv6(ctx context.Context, report *Report) {
+	const name = "IPv6"
+	via, ip, err := c.echo(ctx, "tcp6", "")
+	if !c.Tunnel.hasIPv6() {
+		if err != nil {
+			report.add(name, Pass, "the tunnel is IPv4-only and no IPv6 traffic gets out")
+		} else {
+			report.add(name, Fail, "the tunnel is IPv4-only but IPv6 traffic leaves from %s as %s", via, ip)
+		}
+		return
+	}
+	switch {
+	case err != nil:
+		report.add(name, Fail, "%v", err)
+	case !c.Tunnel.has(via):
+		report.add(name, Fail, "IPv6 request left from %s, outside the tunnel", via)
+	default:
+		report.add(name, Pass, "%s through the tunnel", ip)
+	}
+}
+
+// echo asks the echo endpoint for the address a request over network
+// ("tcp4" or "tcp6") comes from, optionally bound to an interface. It
+// returns the local address the request left from and the echoed one.
+func (c *Checker) echo(ctx context.Context, network, device string) (netip.Addr, netip.Addr, error) {
+	var local netip.Addr
+	dialer := &net.Dialer{Control: bindToDevice(device)}
+	transport := &http.Transport{
+		// Proxies would hide where the request leaves from
+		Proxy: nil,
+		DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err == nil {
+				local = conn.LocalAddr().(*net.TCPAddr).AddrPort().Addr().Unmap()
+			}
+			return conn, err
+		},
+	}
+	defer transport.CloseIdleConnections()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.EchoURL, nil)
+	if err != nil {
+		return local, netip.Addr{}, err
+	}
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return local, netip.Addr{}, fmt.Errorf("request to %s over %s failed: %w", c.EchoURL, network, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return local, netip.Addr{}, fmt.Errorf("error reading the answer of %s: %v", c.EchoURL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return local, netip.Addr{}, fmt.Errorf("%s answered %s", c.EchoURL, resp.Status)
+	}
+	ip, err := netip.ParseAddr(strings.TrimSpace(string(body)))
+	if err != nil {
+		return local, netip.Addr{}, fmt.Errorf("%s answered %q, not an IP address", c.EchoURL, strings.TrimSpace(string(body)))
+	}
+	return local, ip.Unmap(), nil
+}
+
+// routeSource returns the local address the kernel picks to reach a DNS
+// server. Connecting a UDP socket sends nothing.
+func routeSource(addr netip.Addr) (netip.Addr, error) {
+	conn, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(netip.AddrPortFrom(addr, 53)))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).AddrPort().Addr().Unmap(), nil
+}
+
+// errBind is wrapped by errors of sockets that could not be bound to an
+// interface, which kernels before 5.7 only allow root.
+var errBind = errors.New("cannot bind to interface")
+
+// bindToDevice makes a dialer's sockets use one interface only.
+func bindToDevice(device string) func(network, address string, c syscall.RawConn) error {
+	if device == "" {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		if ctrlErr := c.Control(func(fd uintptr) {
+			err = unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, device)
+		}); ctrlErr != nil {
+			return ctrlErr
+		}
+		if err != nil {
+			return fmt.Errorf("%w %s: %v", errBind, device, err)
+		}
+		return nil
+	}
+}
+
+func joinAddrs(addrs []netip.Addr) string {
+	if len(addrs) == 0 {
+		return "none"
+	}
+	s := make([]string, len(addrs))
+	for i, addr := range addrs {
+		s[i] = addr.String()
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,125 @@
+package leak
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+var (
+	loopback = netip.MustParseAddr("127.0.0.1")
+	// stubDNS is reached from loopback, like a server inside the tunnel
+	stubDNS = netip.MustParseAddr("127.0.0.53")
+)
+
+// echoServer answers like --echo-url: with the address of the client.
+func echoServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		w.Write([]byte(host + "\n"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func resolvers(addrs ...netip.Addr) func() ([]netip.Addr, error) {
+	return func() ([]netip.Addr, error) { return addrs, nil }
+}
+
+func statuses(report *Report) map[string]Status {
+	got := map[string]Status{}
+	for _, r := range report.Results {
+		got[r.Name] = r.Status
+	}
+	return got
+}
+
+func TestRun(t *testing.T) {
+	server := echoServer(t)
+	tests := []struct {
+		name    string
+		checker Checker
+		want    map[string]Status
+		detail  string
+	}{{
+		// Loopback plays the tunnel: requests leave from its address
+		name: "no leak",
+		checker: Checker{
+			Tunnel:    Tunnel{Interface: "lo", Addrs: []netip.Addr{loopback}, DNS: []netip.Addr{stubDNS}},
+			Resolvers: resolvers(stubDNS),
+		},
+		want: map[string]Status{"egress IP": Pass, "DNS": Pass, "IPv6": Pass},
+	}, {
+		name: "traffic outside the tunnel",
+		checker: Checker{
+			Tunnel:    Tunnel{Interface: "tun0", Addrs: []netip.Addr{netip.MustParseAddr("10.8.0.2")}, DNS: []netip.Addr{stubDNS}},
+			Resolvers: resolvers(stubDNS),
+		},
+		want:   map[string]Status{"egress IP": Fail, "DNS": Fail, "IPv6": Pass},
+		detail: "outside the tunnel",
+	}, {
+		name: "DNS outside the tunnel",
+		checker: Checker{
+			Tunnel:    Tunnel{Interface: "lo", Addrs: []netip.Addr{loopback}, DNS: []netip.Addr{stubDNS}},
+			Resolvers: resolvers(stubDNS, netip.MustParseAddr("192.0.2.53")),
+		},
+		want:   map[string]Status{"egress IP": Pass, "DNS": Fail, "IPv6": Pass},
+		detail: "queries also go to 192.0.2.53",
+	}, {
+		name: "no DNS for the tunnel",
+		checker: Checker{
+			Tunnel:    Tunnel{Interface: "lo", Addrs: []netip.Addr{loopback}},
+			Resolvers: resolvers(stubDNS),
+		},
+		want:   map[string]Status{"egress IP": Pass, "DNS": Fail, "IPv6": Pass},
+		detail: "no DNS servers were applied",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.checker.EchoURL = server.URL
+			report := tt.checker.Run(context.Background())
+			got := statuses(report)
+			for name, want := range tt.want {
+				if got[name] != want {
+					t.Errorf("%s = %s, want %s: %+v", name, got[name], want, report.Results)
+				}
+			}
+			if report.Passed() != (tt.detail == "") {
+				t.Errorf("Passed() = %v: %+v", report.Passed(), report.Results)
+			}
+			if tt.detail != "" && !strings.Contains(resultDetails(report), tt.detail) {
+				t.Errorf("details %q do not mention %q", resultDetails(report), tt.detail)
+			}
+		})
+	}
+}
+
+func resultDetails(report *Report) string {
+	var details []string
+	for _, r := range report.Results {
+		details = append(details, r.Detail)
+	}
+	return strings.Join(details, "; ")
+}
+
+func TestEchoAnswers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("<html>hello</html>"))
+	}))
+	defer server.Close()
+
+	for path, want := range map[string]string{"/": "not an IP address", "/down": "503"} {
+		c := &Checker{EchoURL: server.URL + path}
+		if _, _, err := c.echo(context.Background(), "tcp4", ""); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("echo() of %s = %v, want an error about %q", path, err, want)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package leak
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// Uplink returns the interface of the IPv4 default route other than
+// the tunnel's, or "" if there is none. OpenVPN's redirect-gateway def1
+// keeps the original default route and overrides it with two /1 routes.
+func Uplink(tunnel string) string {
+	file, err := os.Open("/proc/net/route")
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 || fields[0] == tunnel {
+			continue
+		}
+		if fields[1] == "00000000" && fields[7] == "00000000" {
+			return fields[0]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"main/src/svpnlog"
+)
+
+func logsCommand(fs *flag.FlagSet) func(args []string) (any, error) {
+	follow := fs.Bool("f", false, "Keep printing new log lines as they are written")
+	since := fs.String("since", "", "Only show lines newer than a duration (e.g. 30m) or an RFC 3339 time")
+	level := fs.String("level", "", "Only show lines at this level or above: debug, info, warning, error or fatal")
+	lines := fs.Int("n", 100, "Number of lines to show, 0 for all")
+	return func(args []string) (any, error) {
+		profileName, err := profileArg(fs, args)
+		if err != nil {
+			return nil, err
+		}
+		if *follow && jsonOutput() {
+			return nil, failf(exitUsage, "-f cannot be used with --output json")
+		}
+
+		var minLevel svpnlog.Level = svpnlog.LevelDebug
+		if *level != "" {
+			var err error
+			if minLevel, err = svpnlog.ParseLevel(*level); err != nil {
+				return nil, fail(exitUsage, err)
+			}
+		}
+
+		var sinceTime time.Time
+		if *since != "" {
+			if d, err := time.ParseDuration(*since); err == nil {
+				sinceTime = time.Now().Add(-d)
+			} else if t, err := time.Parse(time.RFC3339, *since); err == nil {
+				sinceTime = t
+			} else {
+				return nil, failf(exitUsage, "invalid --since value %q, use a duration like 30m or an RFC 3339 time", *since)
+			}
+		}
+
+		store, err := userProfileStore()
+		if err != nil {
+			return nil, fail(exitFailure, err)
+		}
+		profile, err := store.ResolveActive(profileName)
+		if err != nil {
+			return nil, fail(exitConfig, err)
+		}
+		logPath := profileLogPath(store.TunnelDir(profile.Name))
+
+		// match filters a line; lines svpn did not write are always shown
+		match := func(line string) bool {
+			entry, err := svpnlog.ParseEntry(line)
+			if err != nil {
+				return true
+			}
+			return entry.Level.AtLeast(minLevel) && !entry.Time.Before(sinceTime)
+		}
+
+		files := svpnlog.Files(logPath, svpnlog.DefaultKeep)
+		if len(files) == 0 && !*follow {
+			return nil, failf(exitFailure, "no logs found for profile %q (%s)", profile.Name, logPath)
+		}
+
+		// Keep only the last n matching lines
+		tail := []string{}
+		err = svpnlog.ReadLines(files, func(line string) {
+			if !match(line) {
+				return
+			}
+			tail = append(tail, line)
+			if *lines > 0 && len(tail) > *lines {
+				tail = tail[1:]
+			}
+		})
+		if err != nil {
+			return nil, failf(exitFailure, "error reading logs: %v", err)
+		}
+		if jsonOutput() {
+			return tail, nil
+		}
+		for _, line := range tail {
+			fmt.Println(line)
+		}
+
+		if !*follow {
+			return nil, nil
+		}
+
+		stop := make(chan struct{})
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			<-signals
+			close(stop)
+		}()
+
+		svpnlog.Follow(logPath, stop, func(line string) {
+			if match(line) {
+				fmt.Println(line)
+			}
+		})
+		return nil, nil
+	}
+}
@@ -1,32 +1,51 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 )
 
 func main() {
-	if len(os.Args) < 2 {
-		PrintUsage()
-		os.Exit(1)
+	// svpnd is the same binary installed under a second name
+	if filepath.Base(os.Args[0]) == "svpnd" {
+		os.Exit(finishCommand(runHelper(flag.NewFlagSet("svpnd", flag.ExitOnError))(os.Args[1:])))
 	}
 
-	command := os.Args[1]
+	root := commandTree()
 
-	switch command {
-	case "init":
-		initVPN()
-	case "start":
-		main_vpn()
-	case "stop":
-		killVPN()
-	case "--h":
-		PrintUsage()
-	case "--v":
+	// The completion scripts pass the words typed so far as they are
+	if len(os.Args) > 1 && os.Args[1] == completeCommand {
+		for _, candidate := range root.Completions(os.Args[2:]) {
+			fmt.Println(candidate)
+		}
+		return
+	}
+
+	opts, args, err := parseGlobalFlags(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitUsage)
+	}
+	output.Format, output.Quiet = opts.Format, opts.Quiet
+	if opts.Version {
 		DisplayVersion()
-	default:
-		fmt.Printf("Unknown command: %s\n", command)
-		PrintUsage()
-		os.Exit(1)
+		return
+	}
+	if len(args) < 1 {
+		root.PrintHelp(os.Stdout)
+		os.Exit(exitUsage)
+	}
+	// Older versions used --h for help
+	if args[0] == "--h" {
+		args[0] = "--help"
+	}
+
+	output.Command = args[0]
+	if err := beginOutput(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitFailure)
 	}
+	os.Exit(finishCommand(root.Execute(args)))
 }
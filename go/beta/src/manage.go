@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"main/src/management"
+	"main/src/state"
+)
+
+// managementDialTimeout bounds how long we wait for the management socket.
+const managementDialTimeout = 2 * time.Second
+
+// managementSocketPath returns the unix socket OpenVPN's management
+// interface listens on.
+func managementSocketPath(runtimeDir string) string {
+	return filepath.Join(runtimeDir, "management.sock")
+}
+
+// managementArgs returns the openvpn arguments that enable the management
+// interface on socketPath for the given user.
+func managementArgs(socketPath, username string) []string {
+	return []string{
+		"--management", socketPath, "unix",
+		"--management-client-user", username,
+	}
+}
+
+// dialManagement connects to the management interface of the running VPN.
+func dialManagement(pidInfo *state.Info) (*management.Client, error) {
+	if pidInfo.Management == "" {
+		return nil, fmt.Errorf("VPN was started without a management interface")
+	}
+	return management.Dial(pidInfo.Management, managementDialTimeout)
+}
+
+// reconnectVPN asks the running OpenVPN to restart its connection.
+func reconnectVPN(fs *flag.FlagSet) func(args []string) (any, error) {
+	return func(args []string) (any, error) {
+		profileName, err := profileArg(fs, args)
+		if err != nil {
+			return nil, err
+		}
+
+		store, err := userProfileStore()
+		if err != nil {
+			return nil, fail(exitFailure, err)
+		}
+		profile, err := store.ResolveActive(profileName)
+		if err != nil {
+			return nil, fail(exitConfig, err)
+		}
+
+		pidInfo, err := state.Open(store.TunnelDir(profile.Name)).Read()
+		if err != nil {
+			return nil, failf(exitNotRunning, "no VPN process found: %v", err)
+		}
+
+		client, err := dialManagement(pidInfo)
+		if err != nil {
+			return nil, fail(exitFailure, err)
+		}
+		defer client.Close()
+
+		// SIGUSR1 restarts the connection without re-reading the config
+		if err := client.Signal("SIGUSR1"); err != nil {
+			return nil, failf(exitFailure, "error requesting reconnect: %v", err)
+		}
+
+		fmt.Printf("Reconnect requested for profile %q\n", profile.Name)
+		return map[string]string{"profile": profile.Name}, nil
+	}
+}
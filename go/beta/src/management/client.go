@@ -0,0 +1,276 @@
+// Package management implements a client for the OpenVPN management
+// interface protocol as described in OpenVPN's management-notes.txt.
+package management
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned by commands issued after the connection is gone.
+var ErrClosed = errors.New("management connection closed")
+
+// Event is a real-time notification sent by OpenVPN. Type is the
+// notification name without the leading '>' (e.g. "STATE", "BYTECOUNT",
+// "HOLD", "PASSWORD", "LOG", "INFO", "FATAL") and Data is everything
+// after the first colon.
+type Event struct {
+	Type string
+	Data string
+}
+
+// Client is a connection to an OpenVPN management interface. Commands are
+// serialized; notifications are delivered on the Events channel.
+type Client struct {
+	conn    net.Conn
+	mu      sync.Mutex
+	replies chan string
+	events  chan Event
+	done    chan struct{}
+}
+
+// Dial connects to a management interface listening on a unix socket.
+func Dial(socketPath string, timeout time.Duration) (*Client, error) {
+	conn, err := net.DialTimeout("unix", socketPath, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to management socket: %v", err)
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient wraps an established management connection.
+func NewClient(conn net.Conn) *Client {
+	c := &Client{
+		conn:    conn,
+		replies: make(chan string, 16),
+		events:  make(chan Event, 64),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+	return c
+}
+
+// Events returns the channel on which real-time notifications arrive. The
+// channel is closed when the connection ends. Notifications are dropped
+// if the channel is not drained.
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+// Done is closed once the connection to OpenVPN has ended.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Close closes the management connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) readLoop() {
+	defer close(c.done)
+	defer close(c.events)
+
+	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.HasPrefix(line, ">") {
+			typ, data, _ := strings.Cut(line[1:], ":")
+			select {
+			case c.events <- Event{Type: typ, Data: data}:
+			default:
+			}
+			continue
+		}
+		c.replies <- line
+	}
+}
+
+// readLine waits for the next non-notification line from OpenVPN.
+func (c *Client) readLine() (string, error) {
+	select {
+	case line := <-c.replies:
+		return line, nil
+	case <-c.done:
+		// Drain anything that arrived before the connection closed
+		select {
+		case line := <-c.replies:
+			return line, nil
+		default:
+			return "", ErrClosed
+		}
+	}
+}
+
+func (c *Client) send(cmd string) error {
+	_, err := fmt.Fprintf(c.conn, "%s\n", cmd)
+	if err != nil {
+		return fmt.Errorf("error sending %q: %v", cmd, err)
+	}
+	return nil
+}
+
+// Command sends a command that is answered by a single SUCCESS or ERROR
+// line and returns the text after "SUCCESS: ".
+func (c *Client) Command(cmd string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.send(cmd); err != nil {
+		return "", err
+	}
+	line, err := c.readLine()
+	if err != nil {
+		return "", err
+	}
+	return parseResult(line)
+}
+
+// MultiLineCommand sends a command whose answer is a list of lines ended
+// by "END" and returns those lines.
+func (c *Client) MultiLineCommand(cmd string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.send(cmd); err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "END" {
+			return lines, nil
+		}
+		if len(lines) == 0 && strings.HasPrefix(line, "ERROR:") {
+			_, err := parseResult(line)
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+}
+
+func parseResult(line string) (string, error) {
+	switch {
+	case strings.HasPrefix(line, "SUCCESS:"):
+		return strings.TrimSpace(strings.TrimPrefix(line, "SUCCESS:")), nil
+	case strings.HasPrefix(line, "ERROR:"):
+		return "", fmt.Errorf("openvpn: %s", strings.TrimSpace(strings.TrimPrefix(line, "ERROR:")))
+	default:
+		return "", fmt.Errorf("unexpected management reply: %q", line)
+	}
+}
+
+// State returns the current connection state.
+func (c *Client) State() (*State, error) {
+	lines, err := c.MultiLineCommand("state")
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, errors.New("empty state reply")
+	}
+	return ParseState(lines[len(lines)-1])
+}
+
+// StateNotifications turns real-time >STATE notifications on or off.
+func (c *Client) StateNotifications(on bool) error {
+	_, err := c.Command("state " + onOff(on))
+	return err
+}
+
+// ByteCount asks OpenVPN to send a >BYTECOUNT notification every interval
+// seconds. An interval of 0 turns the notifications off.
+func (c *Client) ByteCount(interval int) error {
+	_, err := c.Command(fmt.Sprintf("bytecount %d", interval))
+	return err
+}
+
+// Signal sends a signal (SIGHUP, SIGTERM, SIGUSR1 or SIGUSR2) to OpenVPN.
+func (c *Client) Signal(sig string) error {
+	_, err := c.Command("signal " + sig)
+	return err
+}
+
+// HoldRelease lets an OpenVPN started with --management-hold continue.
+func (c *Client) HoldRelease() error {
+	_, err := c.Command("hold release")
+	return err
+}
+
+// LogNotifications turns real-time >LOG notifications on or off.
+func (c *Client) LogNotifications(on bool) error {
+	_, err := c.Command("log " + onOff(on))
+	return err
+}
+
+// LogHistory returns up to n lines of the OpenVPN log history, or the
+// whole history when n is 0.
+func (c *Client) LogHistory(n int) ([]LogLine, error) {
+	arg := "all"
+	if n > 0 {
+		arg = fmt.Sprint(n)
+	}
+	lines, err := c.MultiLineCommand("log " + arg)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]LogLine, 0, len(lines))
+	for _, line := range lines {
+		entry, err := ParseLogLine(line)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, *entry)
+	}
+	return entries, nil
+}
+
+// Username answers a >PASSWORD prompt for the given auth type, e.g. "Auth".
+func (c *Client) Username(authType, username string) error {
+	_, err := c.Command(fmt.Sprintf("username %s %s", quote(authType), quote(username)))
+	return err
+}
+
+// Password answers a >PASSWORD prompt for the given auth type, e.g. "Auth".
+func (c *Client) Password(authType, password string) error {
+	_, err := c.Command(fmt.Sprintf("password %s %s", quote(authType), quote(password)))
+	return err
+}
+
+// PID returns the process ID of the OpenVPN daemon.
+func (c *Client) PID() (int, error) {
+	reply, err := c.Command("pid")
+	if err != nil {
+		return 0, err
+	}
+	var pid int
+	if _, err := fmt.Sscanf(reply, "pid=%d", &pid); err != nil {
+		return 0, fmt.Errorf("error parsing pid reply %q: %v", reply, err)
+	}
+	return pid, nil
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+// quote escapes a parameter the way the management interface expects.
+func quote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
@@ -0,0 +1,138 @@
+package management_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"main/src/management"
+	"main/src/management/managementtest"
+)
+
+func TestCommand(t *testing.T) {
+	server, conn := managementtest.New(func(s *managementtest.Server, cmd string) {
+		switch cmd {
+		case "pid":
+			// Notifications may arrive before the reply
+			s.Send(">BYTECOUNT:10,20", "SUCCESS: pid=4242")
+		case "signal SIGHUP":
+			s.Send("ERROR: signal not allowed")
+		default:
+			s.Send("garbage")
+		}
+	})
+	client := management.NewClient(conn)
+	defer client.Close()
+
+	pid, err := client.PID()
+	if err != nil || pid != 4242 {
+		t.Errorf("PID() = %d, %v, want 4242", pid, err)
+	}
+	select {
+	case event := <-client.Events():
+		if event.Type != "BYTECOUNT" || event.Data != "10,20" {
+			t.Errorf("event = %+v, want BYTECOUNT 10,20", event)
+		}
+	case <-time.After(time.Second):
+		t.Error("no BYTECOUNT event")
+	}
+
+	if err := client.Signal("SIGHUP"); err == nil || err.Error() != "openvpn: signal not allowed" {
+		t.Errorf("Signal() = %v, want the ERROR text", err)
+	}
+	if _, err := client.Command("hold"); err == nil {
+		t.Error("Command() accepted a reply that is neither SUCCESS nor ERROR")
+	}
+
+	want := []string{"pid", "signal SIGHUP", "hold"}
+	if got := server.Commands(); !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+}
+
+func TestCommandQuotes(t *testing.T) {
+	server, conn := managementtest.New(nil)
+	client := management.NewClient(conn)
+	defer client.Close()
+
+	if err := client.Password("Auth", `pa"ss\word`); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`password "Auth" "pa\"ss\\word"`}
+	if got := server.Commands(); !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+}
+
+func TestMultiLineCommand(t *testing.T) {
+	_, conn := managementtest.New(func(s *managementtest.Server, cmd string) {
+		switch cmd {
+		case "log 2":
+			s.Send("1700000000,I,first", ">HOLD:Waiting for hold release", "1700000001,W,second", "END")
+		default:
+			s.Send("ERROR: unknown command", "END")
+		}
+	})
+	client := management.NewClient(conn)
+	defer client.Close()
+
+	lines, err := client.MultiLineCommand("log 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"1700000000,I,first", "1700000001,W,second"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines = %q, want %q", lines, want)
+	}
+
+	if _, err := client.MultiLineCommand("nonsense"); err == nil || err.Error() != "openvpn: unknown command" {
+		t.Errorf("MultiLineCommand() = %v, want the ERROR text", err)
+	}
+}
+
+func TestState(t *testing.T) {
+	_, conn := managementtest.New(func(s *managementtest.Server, cmd string) {
+		s.Send("1700000000,CONNECTED,SUCCESS,10.8.0.2,203.0.113.1,1194,,,fd00::2", "END")
+	})
+	client := management.NewClient(conn)
+	defer client.Close()
+
+	state, err := client.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &management.State{
+		Time:        time.Unix(1700000000, 0),
+		Name:        "CONNECTED",
+		Description: "SUCCESS",
+		LocalIP:     "10.8.0.2",
+		RemoteIP:    "203.0.113.1",
+		RemotePort:  "1194",
+		LocalIPv6:   "fd00::2",
+	}
+	if !reflect.DeepEqual(state, want) {
+		t.Errorf("State() = %+v, want %+v", state, want)
+	}
+}
+
+func TestClosedConnection(t *testing.T) {
+	server, conn := managementtest.New(func(s *managementtest.Server, cmd string) {
+		s.Close()
+	})
+	client := management.NewClient(conn)
+	defer client.Close()
+
+	if _, err := client.Command("pid"); !errors.Is(err, management.ErrClosed) {
+		t.Errorf("Command() = %v, want ErrClosed", err)
+	}
+	select {
+	case <-client.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Done not closed after OpenVPN went away")
+	}
+	if _, ok := <-client.Events(); ok {
+		t.Error("Events not closed after OpenVPN went away")
+	}
+	server.Close()
+}
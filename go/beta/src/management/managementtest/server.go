@@ -0,0 +1,71 @@
+// Package managementtest provides a fake OpenVPN management interface
+// for tests of code that talks to OpenVPN.
+package managementtest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Server is the OpenVPN end of a management connection. It records the
+// commands it receives and answers them with Reply.
+type Server struct {
+	conn  net.Conn
+	reply func(s *Server, cmd string)
+
+	mu       sync.Mutex
+	commands []string
+}
+
+// New starts a server and returns it with the client end of the
+// connection. reply is called for every command and answers it with
+// Send; a nil reply answers every command with "SUCCESS:".
+func New(reply func(s *Server, cmd string)) (*Server, net.Conn) {
+	if reply == nil {
+		reply = func(s *Server, cmd string) { s.Send("SUCCESS:") }
+	}
+	server, client := net.Pipe()
+	s := &Server{conn: server, reply: reply}
+	go s.serve()
+	return s, client
+}
+
+func (s *Server) serve() {
+	scanner := bufio.NewScanner(s.conn)
+	for scanner.Scan() {
+		cmd := scanner.Text()
+		s.mu.Lock()
+		s.commands = append(s.commands, cmd)
+		s.mu.Unlock()
+		s.reply(s, cmd)
+	}
+}
+
+// Send writes lines to the client, e.g. "SUCCESS: pid=1" or a
+// notification such as ">STATE:1700000000,CONNECTED,SUCCESS".
+func (s *Server) Send(lines ...string) {
+	for _, line := range lines {
+		fmt.Fprintf(s.conn, "%s\r\n", line)
+	}
+}
+
+// Commands returns the commands received so far.
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// Close ends the connection, as OpenVPN does when it exits.
+func (s *Server) Close() error {
+	return s.conn.Close()
+}
+
+// Verb returns the first word of a command, e.g. "password".
+func Verb(cmd string) string {
+	verb, _, _ := strings.Cut(cmd, " ")
+	return verb
+}
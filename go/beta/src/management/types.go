@@ -0,0 +1,154 @@
+package management
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// State is a connection state as reported by the "state" command and
+// >STATE notifications.
+type State struct {
+	Time        time.Time
+	Name        string // e.g. CONNECTING, WAIT, AUTH, GET_CONFIG, CONNECTED, RECONNECTING, EXITING
+	Description string
+	LocalIP     string
+	RemoteIP    string
+	RemotePort  string
+	LocalAddr   string
+	LocalPort   string
+	LocalIPv6   string
+}
+
+// ParseState parses a state line such as
+// "1700000000,CONNECTED,SUCCESS,10.8.0.2,203.0.113.1,1194,,".
+func ParseState(line string) (*State, error) {
+	fields := strings.Split(line, ",")
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("malformed state line: %q", line)
+	}
+
+	ts, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("malformed state time %q: %v", fields[0], err)
+	}
+
+	field := func(i int) string {
+		if i < len(fields) {
+			return fields[i]
+		}
+		return ""
+	}
+
+	return &State{
+		Time:        time.Unix(ts, 0),
+		Name:        fields[1],
+		Description: field(2),
+		LocalIP:     field(3),
+		RemoteIP:    field(4),
+		RemotePort:  field(5),
+		LocalAddr:   field(6),
+		LocalPort:   field(7),
+		LocalIPv6:   field(8),
+	}, nil
+}
+
+// ParseByteCount parses the data of a >BYTECOUNT notification ("in,out").
+func ParseByteCount(data string) (in, out int64, err error) {
+	a, b, ok := strings.Cut(data, ",")
+	if !ok {
+		return 0, 0, fmt.Errorf("malformed bytecount: %q", data)
+	}
+	if in, err = strconv.ParseInt(a, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("malformed bytecount: %q", data)
+	}
+	if out, err = strconv.ParseInt(b, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("malformed bytecount: %q", data)
+	}
+	return in, out, nil
+}
+
+// LogLine is a single entry of the OpenVPN log.
+type LogLine struct {
+	Time    time.Time
+	Flags   string // I (info), F (fatal), N (non-fatal), W (warning), D (debug)
+	Message string
+}
+
+// ParseLogLine parses a log line such as "1700000000,I,Initialization Sequence Completed".
+func ParseLogLine(line string) (*LogLine, error) {
+	fields := strings.SplitN(line, ",", 3)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("malformed log line: %q", line)
+	}
+	ts, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("malformed log time %q: %v", fields[0], err)
+	}
+	return &LogLine{Time: time.Unix(ts, 0), Flags: fields[1], Message: fields[2]}, nil
+}
+
+// PasswordRequest is the parsed data of a >PASSWORD notification.
+type PasswordRequest struct {
+	AuthType string // e.g. "Auth" or "Private Key"
+	// Failed is set for "Verification Failed" notifications, which
+	// OpenVPN sends after the server rejected the credentials.
+	Failed bool
+	// UsernameNeeded is set when OpenVPN asks for a username as well
+	// as a password.
+	UsernameNeeded bool
+}
+
+// ParsePasswordRequest parses the data of a >PASSWORD notification, e.g.
+// "Need 'Auth' username/password" or "Verification Failed: 'Auth'".
+func ParsePasswordRequest(data string) (*PasswordRequest, error) {
+	start := strings.Index(data, "'")
+	end := strings.LastIndex(data, "'")
+	if start < 0 || end <= start {
+		return nil, fmt.Errorf("malformed password request: %q", data)
+	}
+
+	return &PasswordRequest{
+		AuthType:       data[start+1 : end],
+		Failed:         strings.HasPrefix(data, "Verification Failed"),
+		UsernameNeeded: strings.Contains(data[end:], "username/password"),
+	}, nil
+}
+
+// UpDown is a tunnel up or down event reported with
+// --management-up-down, together with the environment OpenVPN would
+// pass to an up or down script.
+type UpDown struct {
+	Event string // "UP" or "DOWN"
+	Env   map[string]string
+}
+
+// UpDownReader assembles the >UPDOWN notifications of one event, which
+// arrive as ">UPDOWN:UP", a series of ">UPDOWN:ENV,name=value" and a
+// final ">UPDOWN:ENV,END".
+type UpDownReader struct {
+	current *UpDown
+}
+
+// Add feeds the data of an >UPDOWN notification and returns the event
+// once it is complete.
+func (r *UpDownReader) Add(data string) *UpDown {
+	env, isEnv := strings.CutPrefix(data, "ENV,")
+	if !isEnv {
+		r.current = &UpDown{Event: data, Env: map[string]string{}}
+		return nil
+	}
+	if r.current == nil {
+		return nil
+	}
+	if env == "END" {
+		event := r.current
+		r.current = nil
+		return event
+	}
+	if name, value, ok := strings.Cut(env, "="); ok {
+		r.current.Env[name] = value
+	}
+	return nil
+}
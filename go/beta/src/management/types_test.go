@@ -0,0 +1,70 @@
+package management
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseByteCount(t *testing.T) {
+	in, out, err := ParseByteCount("1048576,2048")
+	if err != nil || in != 1048576 || out != 2048 {
+		t.Errorf("ParseByteCount() = %d, %d, %v, want 1048576, 2048", in, out, err)
+	}
+	for _, data := range []string{"", "12", "a,1", "1,b", "1,2,3"} {
+		if _, _, err := ParseByteCount(data); err == nil {
+			t.Errorf("ParseByteCount(%q) succeeded", data)
+		}
+	}
+}
+
+func TestParsePasswordRequest(t *testing.T) {
+	tests := []struct {
+		data string
+		want PasswordRequest
+	}{
+		{"Need 'Auth' username/password", PasswordRequest{AuthType: "Auth", UsernameNeeded: true}},
+		{"Need 'Private Key' password", PasswordRequest{AuthType: "Private Key"}},
+		{"Verification Failed: 'Auth'", PasswordRequest{AuthType: "Auth", Failed: true}},
+	}
+	for _, tt := range tests {
+		got, err := ParsePasswordRequest(tt.data)
+		if err != nil || *got != tt.want {
+			t.Errorf("ParsePasswordRequest(%q) = %+v, %v, want %+v", tt.data, got, err, tt.want)
+		}
+	}
+	if _, err := ParsePasswordRequest("Need password"); err == nil {
+		t.Error("ParsePasswordRequest accepted a request without an auth type")
+	}
+}
+
+func TestUpDownReader(t *testing.T) {
+	var r UpDownReader
+
+	// Stray environment lines before an event are ignored
+	for _, data := range []string{"ENV,dev=tun9", "ENV,END"} {
+		if event := r.Add(data); event != nil {
+			t.Fatalf("Add(%q) = %+v before any event", data, event)
+		}
+	}
+
+	lines := []string{"UP", "ENV,dev=tun0", "ENV,ifconfig_local=10.8.0.2", "ENV,foreign_option_1=dhcp-option DNS 10.8.0.1", "ENV,broken"}
+	for _, data := range lines {
+		if event := r.Add(data); event != nil {
+			t.Fatalf("Add(%q) = %+v before END", data, event)
+		}
+	}
+	event := r.Add("ENV,END")
+	want := &UpDown{Event: "UP", Env: map[string]string{
+		"dev":              "tun0",
+		"ifconfig_local":   "10.8.0.2",
+		"foreign_option_1": "dhcp-option DNS 10.8.0.1",
+	}}
+	if !reflect.DeepEqual(event, want) {
+		t.Errorf("event = %+v, want %+v", event, want)
+	}
+
+	r.Add("DOWN")
+	if event := r.Add("ENV,END"); event == nil || event.Event != "DOWN" || len(event.Env) != 0 {
+		t.Errorf("event = %+v, want an empty DOWN", event)
+	}
+}
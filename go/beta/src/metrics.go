@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"main/src/metrics"
+)
+
+// metricsByteCountInterval is how often OpenVPN reports its byte counts
+// for the metrics when the health checks do not ask for them.
+const metricsByteCountInterval = 5
+
+// tunnelMetrics follows the tunnel for the --metrics-addr endpoint. The
+// methods do nothing on a nil *tunnelMetrics.
+type tunnelMetrics struct {
+	mu    sync.Mutex
+	state string
+	// connectedAt is when the tunnel came up, zero while it is down
+	connectedAt time.Time
+	// handshake is when the tunnel last came up
+	handshake  time.Time
+	bytesIn    int64
+	bytesOut   int64
+	reconnects int
+}
+
+// started records an OpenVPN process that has just connected.
+func (m *tunnelMetrics) started() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.state, m.connectedAt, m.handshake = "CONNECTED", now, now
+	m.bytesIn, m.bytesOut = 0, 0
+}
+
+// exited records that OpenVPN is no longer running.
+func (m *tunnelMetrics) exited() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state, m.connectedAt = "EXITED", time.Time{}
+}
+
+// stateChanged records a state OpenVPN reported at time at.
+func (m *tunnelMetrics) stateChanged(name string, at time.Time) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case name == "CONNECTED":
+		m.connectedAt, m.handshake = at, at
+	case name == "RECONNECTING" && m.state != "RECONNECTING":
+		m.reconnects++
+		m.connectedAt = time.Time{}
+	case name != m.state:
+		m.connectedAt = time.Time{}
+	}
+	m.state = name
+}
+
+// counted records the bytes OpenVPN reported.
+func (m *tunnelMetrics) counted(in, out int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bytesIn, m.bytesOut = in, out
+}
+
+// serveMetrics starts the --metrics-addr endpoint, or returns nil if
+// there is none.
+func (l *vpnLaunch) serveMetrics() (*metrics.Server, error) {
+	if l.metricsAddr == "" {
+		return nil, nil
+	}
+	l.metrics = &tunnelMetrics{}
+	server, err := metrics.Listen(l.metricsAddr, l.collectMetrics)
+	if err != nil {
+		return nil, fmt.Errorf("error serving metrics: %v", err)
+	}
+	fmt.Printf("Serving metrics at http://%s/metrics\n", server.Addr())
+	return server, nil
+}
+
+// collectMetrics returns the current metrics of the tunnel.
+func (l *vpnLaunch) collectMetrics() []*metrics.Family {
+	profile := metrics.Labels{"profile": l.profile.Name}
+	up := &metrics.Family{Name: "svpn_up", Type: metrics.Gauge,
+		Help: "Whether the tunnel is connected."}
+	state := &metrics.Family{Name: "svpn_state", Type: metrics.Gauge,
+		Help: "The state OpenVPN last reported, as a label with value 1."}
+	uptime := &metrics.Family{Name: "svpn_uptime_seconds", Type: metrics.Gauge,
+		Help: "How long the tunnel has been connected, 0 while it is down."}
+	handshake := &metrics.Family{Name: "svpn_last_handshake_timestamp_seconds", Type: metrics.Gauge,
+		Help: "When the tunnel last connected, in seconds since the epoch."}
+	received := &metrics.Family{Name: "svpn_received_bytes_total", Type: metrics.Counter,
+		Help: "Bytes received by OpenVPN, reset when OpenVPN restarts."}
+	sent := &metrics.Family{Name: "svpn_sent_bytes_total", Type: metrics.Counter,
+		Help: "Bytes sent by OpenVPN, reset when OpenVPN restarts."}
+	reconnects := &metrics.Family{Name: "svpn_reconnects_total", Type: metrics.Counter,
+		Help: "Reconnects of a running OpenVPN, e.g. after failed health checks."}
+	restarts := &metrics.Family{Name: "svpn_restarts_total", Type: metrics.Counter,
+		Help: "Restarts of OpenVPN by the supervisor."}
+	healthUp := &metrics.Family{Name: "svpn_health_check_up", Type: metrics.Gauge,
+		Help: "Whether the health check passed in the last round."}
+	healthFailures := &metrics.Family{Name: "svpn_health_check_failures", Type: metrics.Gauge,
+		Help: "Failed rounds of health checks in a row."}
+	healthTime := &metrics.Family{Name: "svpn_health_check_timestamp_seconds", Type: metrics.Gauge,
+		Help: "When the health checks last ran, in seconds since the epoch."}
+
+	m := l.metrics
+	m.mu.Lock()
+	connected := 0.0
+	if !m.connectedAt.IsZero() {
+		connected = 1
+		uptime.Add(time.Since(m.connectedAt).Seconds(), profile)
+	} else {
+		uptime.Add(0, profile)
+	}
+	up.Add(connected, profile)
+	if m.state != "" {
+		state.Add(1, metrics.Labels{"profile": l.profile.Name, "state": m.state})
+	}
+	if !m.handshake.IsZero() {
+		handshake.Add(metrics.Timestamp(m.handshake), profile)
+	}
+	received.Add(float64(m.bytesIn), profile)
+	sent.Add(float64(m.bytesOut), profile)
+	reconnects.Add(float64(m.reconnects), profile)
+	m.mu.Unlock()
+
+	l.infoMu.Lock()
+	restarts.Add(float64(l.info.Restarts), profile)
+	if report := l.info.Health; report != nil {
+		for _, result := range report.Results {
+			passed := 0.0
+			if result.Error == "" {
+				passed = 1
+			}
+			healthUp.Add(passed, metrics.Labels{"profile": l.profile.Name, "probe": result.Probe})
+		}
+		healthFailures.Add(float64(report.Failures), profile)
+		healthTime.Add(metrics.Timestamp(report.Time), profile)
+	}
+	l.infoMu.Unlock()
+
+	return []*metrics.Family{up, state, uptime, handshake, received, sent, reconnects, restarts,
+		healthUp, healthFailures, healthTime}
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"bytes"
+	"flag"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// families covers every kind of value and the escaping of help texts and
+// label values.
+func families() []*Family {
+	up := &Family{Name: "svpn_up", Help: "Whether the tunnel is up.", Type: Gauge}
+	up.Add(1, Labels{"profile": "work", "interface": "tun0"})
+	up.Add(0, Labels{"profile": `back\slash "quoted"` + "\nnewline"})
+
+	traffic := &Family{Name: "svpn_bytes_total", Help: `Bytes through the tunnel, "in" and "out" \ per` + "\ndirection.", Type: Counter}
+	traffic.Add(1234567890123, Labels{"direction": "in"})
+	traffic.Add(0.5, Labels{"direction": "out"})
+
+	special := &Family{Name: "svpn_special", Help: "Values without a number.", Type: Gauge}
+	special.Add(math.NaN(), nil)
+	special.Add(math.Inf(1), Labels{})
+	special.Add(math.Inf(-1), Labels{"sign": "-"})
+
+	empty := &Family{Name: "svpn_empty", Help: "Left out.", Type: Gauge}
+	return []*Family{up, traffic, special, empty}
+}
+
+func TestWrite(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, families()); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	golden := filepath.Join("testdata", "metrics.txt")
+	if *update {
+		if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("Write() differs from %s (run go test -update to rewrite it):\n%s", golden, got)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	handler := Handler(families)
+	tests := []struct {
+		method string
+		code   int
+	}{
+		{http.MethodGet, http.StatusOK},
+		{http.MethodHead, http.StatusOK},
+		{http.MethodPost, http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(tt.method, "/metrics", nil))
+		if rec.Code != tt.code {
+			t.Errorf("%s /metrics = %d, want %d", tt.method, rec.Code, tt.code)
+		}
+		if tt.code == http.StatusOK && rec.Header().Get("Content-Type") != ContentType {
+			t.Errorf("%s /metrics Content-Type = %q, want %q", tt.method, rec.Header().Get("Content-Type"), ContentType)
+		}
+	}
+}
@@ -13,8 +13,14 @@ func main() {
 	// Path to the auth file
 	authFilePath := "/etc/openvpn/auth.txt"
 	
-	// Content to write to the auth file
-	authContent := "zwsXIilK7SxU0s9Z\ngotM4g5OZe17ixa6UEWi8oTi3Oee7Vk8"
+	// Content to write to the auth file, taken from the environment so
+	// that no credentials are compiled into the binary
+	username, password := os.Getenv("SVPN_USERNAME"), os.Getenv("SVPN_PASSWORD")
+	if username == "" || password == "" {
+		fmt.Println("Please set SVPN_USERNAME and SVPN_PASSWORD")
+		return
+	}
+	authContent := username + "\n" + password
 	
 	// GitHub repository URL for the config file
 	configFileURL := "https://github.com/cazzano/open_vpn/raw/main/config.ovpn.gpg"
@@ -28,7 +34,7 @@ func main() {
 		fmt.Println("Please run with sudo")
 		
 		// Attempt to re-run with sudo
-		cmd := exec.Command("sudo", os.Args[0])
+		cmd := exec.Command("sudo", "--preserve-env=SVPN_USERNAME,SVPN_PASSWORD", os.Args[0])
 		cmd.Stdin = os.Stdin
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
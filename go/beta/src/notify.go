@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+
+	"main/src/notify"
+)
+
+// desktopNotifier returns the notifier for the desktop of u. A root
+// supervisor finds the session bus where systemd puts it, as sudo and
+// svpnd leave out the user's environment.
+func desktopNotifier(u *user.User) (*notify.Desktop, error) {
+	runAs, _, err := hookUser(u)
+	if err != nil || runAs == nil {
+		return &notify.Desktop{}, err
+	}
+	return &notify.Desktop{Address: "unix:path=/run/user/" + u.Uid + "/bus", User: runAs}, nil
+}
+
+// notify tells the user about an event if they want to hear about it.
+func (l *vpnLaunch) notify(event notify.Event, summary, body string) {
+	if l.notifier == nil || !l.notifyOn[event] {
+		return
+	}
+	err := l.notifier.Notify(notify.Notification{Event: event, Summary: summary, Body: body})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+	}
+}
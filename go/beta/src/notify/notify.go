@@ -0,0 +1,228 @@
+// Package notify tells the user about changes of the tunnel with desktop
+// notifications, sent to org.freedesktop.Notifications on the session
+// bus. The supervisor talks to a Notifier, so that another one, e.g. a
+// Recorder, can stand in for the desktop.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Event is what a notification is about.
+type Event string
+
+const (
+	Connected    Event = "connected"
+	Disconnected Event = "disconnected"
+	Reconnecting Event = "reconnecting"
+	AuthFailed   Event = "auth-failed"
+)
+
+// Events are all events.
+var Events = []Event{Connected, Disconnected, Reconnecting, AuthFailed}
+
+// ParseEvents parses a comma-separated list of events, or "all" or
+// "none", into the set of events to notify about.
+func ParseEvents(s string) (map[Event]bool, error) {
+	set := map[Event]bool{}
+	switch s {
+	case "all":
+		for _, event := range Events {
+			set[event] = true
+		}
+		return set, nil
+	case "none", "":
+		return set, nil
+	}
+	for _, name := range strings.Split(s, ",") {
+		event := Event(strings.TrimSpace(name))
+		if !valid(event) {
+			return nil, fmt.Errorf("unknown event %q (expected connected, disconnected, reconnecting or auth-failed)", name)
+		}
+		set[event] = true
+	}
+	return set, nil
+}
+
+func valid(event Event) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Notification is one message to the user.
+type Notification struct {
+	Event   Event
+	Summary string
+	Body    string
+}
+
+// Notifier shows notifications.
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+const (
+	notificationsBusName   = "org.freedesktop.Notifications"
+	notificationsPath      = dbus.ObjectPath("/org/freedesktop/Notifications")
+	notificationsInterface = "org.freedesktop.Notifications"
+
+	// callTimeout bounds a call, so that a hanging notification daemon
+	// does not hold up the supervisor.
+	callTimeout = 5 * time.Second
+)
+
+// Desktop sends notifications over D-Bus. Each notification replaces the
+// previous one, so that a flapping tunnel does not pile them up.
+type Desktop struct {
+	// Address is the session bus, empty for the one of the environment.
+	Address string
+	// User connects to the bus as another user, nil to connect as svpn
+	// runs. Session buses only admit the user they belong to.
+	User *syscall.Credential
+
+	mu   sync.Mutex
+	conn *dbus.Conn
+	id   uint32
+}
+
+func (d *Desktop) Notify(n Notification) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// The bus goes away when the user logs out; try a new connection
+	// once before giving up
+	err := d.send(n)
+	if err != nil && d.conn != nil {
+		d.close()
+		err = d.send(n)
+	}
+	return err
+}
+
+func (d *Desktop) send(n Notification) error {
+	if d.conn == nil {
+		conn, err := d.connect()
+		if err != nil {
+			return fmt.Errorf("error connecting to the session bus: %v", err)
+		}
+		d.conn = conn
+	}
+
+	// Urgency: 1 is normal, 2 critical, which stays until dismissed
+	urgency := byte(1)
+	if n.Event == AuthFailed {
+		urgency = 2
+	}
+	hints := map[string]dbus.Variant{
+		"urgency":  dbus.MakeVariant(urgency),
+		"category": dbus.MakeVariant("network"),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+	obj := d.conn.Object(notificationsBusName, notificationsPath)
+	call := obj.CallWithContext(ctx, notificationsInterface+".Notify", 0,
+		"svpn", d.id, "network-vpn", n.Summary, n.Body, []string{}, hints, int32(-1))
+	if err := call.Store(&d.id); err != nil {
+		return fmt.Errorf("error sending notification: %v", err)
+	}
+	return nil
+}
+
+// connect opens a private connection to the session bus. As another
+// user it connects from a thread of its own that takes the user's IDs,
+// which only change that thread, and ends with it.
+func (d *Desktop) connect() (*dbus.Conn, error) {
+	if d.User == nil {
+		return d.dial()
+	}
+
+	type result struct {
+		conn *dbus.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		// Never unlocked: the runtime ends the thread with the goroutine
+		runtime.LockOSThread()
+		if err := setThreadUser(d.User); err != nil {
+			done <- result{err: err}
+			return
+		}
+		conn, err := d.dial()
+		done <- result{conn, err}
+	}()
+	r := <-done
+	return r.conn, r.err
+}
+
+func (d *Desktop) dial() (*dbus.Conn, error) {
+	if d.Address == "" {
+		return dbus.ConnectSessionBus()
+	}
+	return dbus.Connect(d.Address)
+}
+
+// setThreadUser switches the calling thread to the user. Unlike
+// syscall.Setuid, the raw system calls leave the other threads alone.
+func setThreadUser(user *syscall.Credential) error {
+	uid, gid := uintptr(user.Uid), uintptr(user.Gid)
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_SETGROUPS, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("error dropping groups: %v", errno)
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_SETRESGID, gid, gid, gid); errno != 0 {
+		return fmt.Errorf("error switching to group %d: %v", user.Gid, errno)
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_SETRESUID, uid, uid, uid); errno != 0 {
+		return fmt.Errorf("error switching to user %d: %v", user.Uid, errno)
+	}
+	return nil
+}
+
+// Close closes the connection to the bus, if there is one.
+func (d *Desktop) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.close()
+}
+
+func (d *Desktop) close() error {
+	if d.conn == nil {
+		return nil
+	}
+	err := d.conn.Close()
+	d.conn = nil
+	return err
+}
+
+// Recorder keeps the notifications it gets instead of showing them.
+type Recorder struct {
+	mu            sync.Mutex
+	notifications []Notification
+}
+
+func (r *Recorder) Notify(n Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notifications = append(r.notifications, n)
+	return nil
+}
+
+// Notifications returns the notifications received so far.
+func (r *Recorder) Notifications() []Notification {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Notification(nil), r.notifications...)
+}
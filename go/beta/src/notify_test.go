@@ -0,0 +1,124 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"main/src/dns"
+	"main/src/management"
+	"main/src/management/managementtest"
+	"main/src/notify"
+)
+
+// supervise feeds OpenVPN's notifications to the event handlers of l,
+// like the supervisor does, and returns once all were handled.
+func supervise(l *vpnLaunch, lines ...string) {
+	server, conn := managementtest.New(nil)
+	client := management.NewClient(conn)
+	tracker := trackEvents(client, l.handleUpDown, nil)
+	server.Send(lines...)
+	server.Close()
+	tracker.wait()
+	client.Close()
+}
+
+func recordingLaunch(t *testing.T, events string) (*vpnLaunch, *notify.Recorder) {
+	notifyOn, err := notify.ParseEvents(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := &notify.Recorder{}
+	l := &vpnLaunch{
+		profile:   &Profile{Name: "work"},
+		stateDir:  t.TempDir(),
+		dnsMethod: dns.MethodOff,
+		notifier:  recorder,
+		notifyOn:  notifyOn,
+	}
+	return l, recorder
+}
+
+var (
+	up        = []string{">UPDOWN:UP", ">UPDOWN:ENV,dev=tun0", ">UPDOWN:ENV,END"}
+	restarted = []string{">UPDOWN:UP", ">UPDOWN:ENV,dev=tun0", ">UPDOWN:ENV,script_context=restart", ">UPDOWN:ENV,END"}
+	down      = []string{">UPDOWN:DOWN", ">UPDOWN:ENV,END"}
+	pingLost  = ">STATE:1700000000,RECONNECTING,ping-restart,,,,,"
+)
+
+func lines(groups ...[]string) []string {
+	var all []string
+	for _, g := range groups {
+		all = append(all, g...)
+	}
+	return all
+}
+
+func TestSupervisorNotifications(t *testing.T) {
+	tests := []struct {
+		name     string
+		events   string
+		stopping bool
+		lines    []string
+		want     []notify.Notification
+	}{{
+		name:   "connect, reconnect and disconnect",
+		events: "all",
+		lines:  lines(up, []string{pingLost}, restarted, down),
+		want: []notify.Notification{
+			{Event: notify.Connected, Summary: "VPN connected", Body: "Profile work is up on tun0"},
+			{Event: notify.Reconnecting, Summary: "VPN reconnecting", Body: "Profile work is reconnecting (ping-restart)"},
+			{Event: notify.Connected, Summary: "VPN reconnected", Body: "Profile work is up on tun0"},
+			{Event: notify.Disconnected, Summary: "VPN disconnected", Body: "Profile work went down (disconnect)"},
+		},
+	}, {
+		name:   "only the events asked for",
+		events: "disconnected,reconnecting",
+		lines:  lines(up, []string{pingLost}, down),
+		want: []notify.Notification{
+			{Event: notify.Reconnecting, Summary: "VPN reconnecting", Body: "Profile work is reconnecting (ping-restart)"},
+			{Event: notify.Disconnected, Summary: "VPN disconnected", Body: "Profile work went down (disconnect)"},
+		},
+	}, {
+		name:   "none",
+		events: "none",
+		lines:  lines(up, down),
+	}, {
+		// The user stopped the tunnel and needs no telling
+		name:     "stopped",
+		events:   "all",
+		stopping: true,
+		lines:    lines(up, down),
+		want: []notify.Notification{
+			{Event: notify.Connected, Summary: "VPN connected", Body: "Profile work is up on tun0"},
+		},
+	}, {
+		name:   "down without up",
+		events: "all",
+		lines:  down,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, recorder := recordingLaunch(t, tt.events)
+			l.stopping.Store(tt.stopping)
+			supervise(l, tt.lines...)
+			if got := recorder.Notifications(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("notifications = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTunnelDownAfterExit(t *testing.T) {
+	l, recorder := recordingLaunch(t, "disconnected")
+	supervise(l, up...)
+
+	// OpenVPN died without a DOWN event; the supervisor reports it once
+	l.tunnelDown("exit")
+	l.tunnelDown("exit")
+	want := []notify.Notification{
+		{Event: notify.Disconnected, Summary: "VPN disconnected", Body: "Profile work went down (exit)"},
+	}
+	if got := recorder.Notifications(); !reflect.DeepEqual(got, want) {
+		t.Errorf("notifications = %+v, want %+v", got, want)
+	}
+}
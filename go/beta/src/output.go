@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"main/src/cli"
+)
+
+// Exit codes of all commands. Every meaning has its own code, also
+// those only status and stop use.
+const (
+	exitOK         = 0
+	exitFailure    = 1
+	exitUsage      = 2
+	exitNotRunning = 3
+	exitStale      = 4 // the state is left over from a tunnel that is gone, or restarting
+	exitUnknown    = 5 // the state could not be determined
+	exitConfig     = 6
+	exitAuth       = 7
+	exitRunning    = 8
+	exitPermission = 9
+	exitKilled     = 10 // the VPN had to be killed after the timeout
+	exitLeftovers  = 11 // the VPN stopped, but not everything was reverted
+)
+
+// Output formats of the global --output flag.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// output is the output mode chosen with the global flags.
+var output struct {
+	Format string
+	Quiet  bool
+	// Command is the command being run, for the JSON document
+	Command string
+
+	// stdout is where the JSON document goes, while the messages of the
+	// command go to stderr
+	stdout   *os.File
+	warnings []string
+}
+
+// jsonOutput reports whether the command's output is one JSON document.
+func jsonOutput() bool {
+	return output.Format == outputJSON
+}
+
+// commandError is a failure of a command with its exit code. Err may be
+// nil when the command explained itself, e.g. status in its report.
+type commandError struct {
+	Code int
+	Err  error
+}
+
+func (e *commandError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("exit code %d", e.Code)
+	}
+	return e.Err.Error()
+}
+
+func (e *commandError) Unwrap() error { return e.Err }
+
+// fail returns err as the error of a command that exits with code.
+func fail(code int, err error) error {
+	return &commandError{Code: code, Err: err}
+}
+
+// failf is fail with an error formatted like fmt.Errorf.
+func failf(code int, format string, args ...any) error {
+	return fail(code, fmt.Errorf(format, args...))
+}
+
+// exitCode ends a command with code without an error message.
+func exitCode(code int) error {
+	if code == exitOK {
+		return nil
+	}
+	return &commandError{Code: code}
+}
+
+// warnf prints a warning to stderr, and the JSON document lists it as
+// well.
+func warnf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if jsonOutput() {
+		output.warnings = append(output.warnings, msg)
+	}
+	fmt.Fprintln(os.Stderr, "Warning:", msg)
+}
+
+// globalOptions are the flags every command accepts.
+type globalOptions struct {
+	Format  string
+	Quiet   bool
+	Version bool
+}
+
+// define defines the global flags on fs, with their one-letter aliases.
+func (o *globalOptions) define(fs *flag.FlagSet) {
+	fs.StringVar(&o.Format, "output", outputText, "Output format: text, or json for one JSON object")
+	fs.StringVar(&o.Format, "o", outputText, "")
+	fs.Var(&jsonFlag{&o.Format}, "json", "Same as --output json")
+	fs.BoolVar(&o.Quiet, "quiet", false, "Print only errors and warnings, to stderr")
+	fs.BoolVar(&o.Quiet, "q", false, "")
+	fs.BoolVar(&o.Version, "version", false, "Print the version and exit")
+	fs.BoolVar(&o.Version, "v", false, "")
+}
+
+// jsonFlag is --json, a shorthand for --output json.
+type jsonFlag struct {
+	format *string
+}
+
+func (f *jsonFlag) String() string   { return "false" }
+func (f *jsonFlag) IsBoolFlag() bool { return true }
+
+func (f *jsonFlag) Set(value string) error {
+	on, err := strconv.ParseBool(value)
+	if on {
+		*f.format = outputJSON
+	}
+	return err
+}
+
+// parseGlobalFlags removes the global flags from args, wherever they
+// appear, and returns the rest.
+func parseGlobalFlags(args []string) (*globalOptions, []string, error) {
+	opts := &globalOptions{}
+	fs := flag.NewFlagSet("svpn", flag.ContinueOnError)
+	opts.define(fs)
+
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		f := fs.Lookup(name)
+		if !strings.HasPrefix(arg, "-") || f == nil {
+			rest = append(rest, arg)
+			continue
+		}
+		if !hasValue {
+			if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+				value = "true"
+			} else if i+1 < len(args) {
+				i++
+				value = args[i]
+			} else {
+				return nil, nil, fmt.Errorf("flag needs an argument: %s", arg)
+			}
+		}
+		if err := fs.Set(name, value); err != nil {
+			return nil, nil, fmt.Errorf("invalid value %q for %s: %v", value, arg, err)
+		}
+	}
+	if opts.Format != outputText && opts.Format != outputJSON {
+		return nil, nil, fmt.Errorf("unknown --output value %q (expected text or json)", opts.Format)
+	}
+	return opts, rest, nil
+}
+
+// beginOutput sends the messages of the command, which go to stdout,
+// to stderr with --output json and nowhere with --quiet. Prompts go to
+// stderr and are not affected.
+func beginOutput() error {
+	switch {
+	case jsonOutput():
+		output.stdout, os.Stdout = os.Stdout, os.Stderr
+	case output.Quiet:
+		devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		os.Stdout = devNull
+	}
+	return nil
+}
+
+// outputDocument is what a command prints with --output json.
+type outputDocument struct {
+	Command  string   `json:"command"`
+	OK       bool     `json:"ok"`
+	ExitCode int      `json:"exit_code"`
+	Result   any      `json:"result,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// finishCommand reports what a command returned and returns the code
+// svpn exits with: the JSON document with --output json, otherwise the
+// error and a usage hint on stderr.
+func finishCommand(result any, err error) int {
+	code := exitOK
+	var cmdErr *commandError
+	var usageErr *cli.UsageError
+	switch {
+	case err == nil || errors.Is(err, flag.ErrHelp):
+		err = nil
+	case errors.As(err, &cmdErr):
+		code, err = cmdErr.Code, cmdErr.Err
+	case errors.As(err, &usageErr):
+		code = exitUsage
+	default:
+		code = exitFailure
+	}
+
+	if !jsonOutput() {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		if usageErr != nil {
+			fmt.Fprintf(os.Stderr, "Run '%s --help' for usage\n", usageErr.Command.Path())
+		}
+		return code
+	}
+
+	doc := outputDocument{Command: output.Command, OK: code == exitOK, ExitCode: code, Result: result, Warnings: output.warnings}
+	if err != nil {
+		doc.Error = err.Error()
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error encoding JSON:", err)
+		return exitFailure
+	}
+	fmt.Fprintln(output.stdout, string(data))
+	return code
+}
+
+// parseFlags parses the flags of a command. Errors exit with exitUsage;
+// -h and --help return flag.ErrHelp, which exits with exitOK. The flag
+// package prints errors itself unless the output is JSON.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	fs.Init(fs.Name(), flag.ContinueOnError)
+	if jsonOutput() {
+		fs.SetOutput(io.Discard)
+	}
+	err := fs.Parse(args)
+	switch {
+	case err == nil || err == flag.ErrHelp:
+		return err
+	case jsonOutput():
+		return fail(exitUsage, err)
+	}
+	return exitCode(exitUsage)
+}
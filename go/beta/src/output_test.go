@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"main/src/cli"
+)
+
+// finishJSON runs finishCommand with --output json and returns the exit
+// code and the document it printed.
+func finishJSON(t *testing.T, result any, err error, warnings ...string) (int, map[string]any) {
+	t.Helper()
+	f, ferr := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if ferr != nil {
+		t.Fatal(ferr)
+	}
+	defer f.Close()
+	saved := output
+	t.Cleanup(func() { output = saved })
+	output.Format, output.Command, output.stdout, output.warnings = outputJSON, "test", f, warnings
+
+	code := finishCommand(result, err)
+	data, ferr := os.ReadFile(f.Name())
+	if ferr != nil {
+		t.Fatal(ferr)
+	}
+	var doc map[string]any
+	if ferr := json.Unmarshal(data, &doc); ferr != nil {
+		t.Fatalf("not one JSON document: %v\n%s", ferr, data)
+	}
+	return code, doc
+}
+
+func TestFinishCommand(t *testing.T) {
+	tests := []struct {
+		name   string
+		result any
+		err    error
+		want   int
+		error  string
+	}{
+		{name: "success", result: map[string]bool{"active": true}, want: exitOK},
+		{name: "help", err: flag.ErrHelp, want: exitOK},
+		{name: "failure", err: fail(exitConfig, errors.New("no profile")), want: exitConfig, error: "no profile"},
+		{name: "code only", result: map[string]bool{"active": false}, err: exitCode(exitNotRunning), want: exitNotRunning},
+		{name: "usage", err: &cli.UsageError{Command: &cli.Command{Name: "svpn"}, Err: errors.New("unknown command")}, want: exitUsage, error: "unknown command"},
+		{name: "plain error", err: errors.New("broken"), want: exitFailure, error: "broken"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, doc := finishJSON(t, tt.result, tt.err)
+			if code != tt.want {
+				t.Errorf("code = %d, want %d", code, tt.want)
+			}
+			if doc["ok"] != (tt.want == exitOK) || doc["exit_code"] != float64(tt.want) {
+				t.Errorf("document = %v, want exit code %d", doc, tt.want)
+			}
+			if got, _ := doc["error"].(string); got != tt.error {
+				t.Errorf("error = %q, want %q", got, tt.error)
+			}
+			if _, ok := doc["result"]; ok != (tt.result != nil) {
+				t.Errorf("result = %v, want %v", doc["result"], tt.result)
+			}
+		})
+	}
+}
+
+func TestFinishCommandWarnings(t *testing.T) {
+	_, doc := finishJSON(t, nil, nil, "Could not cache the results")
+	want := []any{"Could not cache the results"}
+	if !reflect.DeepEqual(doc["warnings"], want) {
+		t.Errorf("warnings = %v, want %v", doc["warnings"], want)
+	}
+}
+
+func TestParseFlagsUsage(t *testing.T) {
+	saved := output
+	t.Cleanup(func() { output = saved })
+	output.Format = outputJSON
+
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	var cmdErr *commandError
+	if err := parseFlags(fs, []string{"--bogus"}); !errors.As(err, &cmdErr) || cmdErr.Code != exitUsage {
+		t.Errorf("parseFlags() = %v, want an error with exit code %d", err, exitUsage)
+	}
+}
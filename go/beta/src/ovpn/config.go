@@ -0,0 +1,398 @@
+// Package ovpn parses OpenVPN configuration files into a typed model,
+// validates them and writes them back without losing any content.
+package ovpn
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Directive is a single option line such as "remote vpn.example.com 1194 udp".
+type Directive struct {
+	Name string
+	Args []string
+	Line int // 1-based line number, 0 for directives added programmatically
+}
+
+// Arg returns the i-th argument or "" if there is none.
+func (d *Directive) Arg(i int) string {
+	if i < len(d.Args) {
+		return d.Args[i]
+	}
+	return ""
+}
+
+// String renders the directive in config file syntax.
+func (d *Directive) String() string {
+	parts := []string{d.Name}
+	for _, arg := range d.Args {
+		parts = append(parts, quoteArg(arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Block is an inline file such as <ca>...</ca>.
+type Block struct {
+	Tag     string
+	Content string // lines between the tags, each ending in a newline
+	Line    int    // line of the opening tag
+	EndLine int    // line of the closing tag, 0 if unterminated
+}
+
+// node is one element of a config file in source order. Raw holds the
+// exact source lines so unchanged content is written back verbatim.
+type node struct {
+	raw       []string
+	directive *Directive
+	block     *Block
+}
+
+// Config is a parsed OpenVPN configuration file.
+type Config struct {
+	// Name is the file name used in issue messages.
+	Name string
+
+	nodes        []*node
+	finalNewline bool
+	parseIssues  []Issue
+}
+
+// ParseFile reads and parses the config file at path.
+func ParseFile(path string) (*Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	cfg, err := Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	cfg.Name = path
+	return cfg, nil
+}
+
+// Parse parses an OpenVPN config. Syntax problems such as unterminated
+// blocks do not make Parse fail; they are reported by Validate.
+func Parse(r io.Reader) (*Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{Name: "config", finalNewline: bytes.HasSuffix(data, []byte("\n"))}
+	text := strings.TrimSuffix(string(data), "\n")
+	if text == "" && !cfg.finalNewline {
+		return cfg, nil
+	}
+
+	var block *node
+	for i, raw := range strings.Split(text, "\n") {
+		lineNum := i + 1
+		line := strings.TrimSpace(strings.TrimSuffix(raw, "\r"))
+
+		if block != nil {
+			block.raw = append(block.raw, raw)
+			if line == "</"+block.block.Tag+">" {
+				block.block.EndLine = lineNum
+				block = nil
+				continue
+			}
+			block.block.Content += strings.TrimSuffix(raw, "\r") + "\n"
+			continue
+		}
+
+		n := &node{raw: []string{raw}}
+		cfg.nodes = append(cfg.nodes, n)
+
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+			// blank line or comment
+		case strings.HasPrefix(line, "</") && strings.HasSuffix(line, ">"):
+			cfg.parseIssues = append(cfg.parseIssues, Issue{
+				Line: lineNum, Severity: SeverityError,
+				Message: fmt.Sprintf("closing tag %s without matching opening tag", line),
+			})
+		case line[0] == '<' && strings.HasSuffix(line, ">"):
+			n.block = &Block{Tag: line[1 : len(line)-1], Line: lineNum}
+			block = n
+		default:
+			fields, err := splitLine(line)
+			if err != nil {
+				cfg.parseIssues = append(cfg.parseIssues, Issue{Line: lineNum, Severity: SeverityError, Message: err.Error()})
+			}
+			if len(fields) == 0 {
+				continue
+			}
+			n.directive = &Directive{
+				Name: strings.TrimPrefix(fields[0], "--"),
+				Args: fields[1:],
+				Line: lineNum,
+			}
+		}
+	}
+
+	if block != nil {
+		cfg.parseIssues = append(cfg.parseIssues, Issue{
+			Line: block.block.Line, Severity: SeverityError,
+			Message: fmt.Sprintf("<%s> block is never closed", block.block.Tag),
+		})
+	}
+	return cfg, nil
+}
+
+// splitLine splits a directive line into fields following OpenVPN's
+// rules: whitespace separates fields, double and single quotes group
+// them, backslash escapes outside single quotes, and an unquoted field
+// starting with '#' or ';' begins a comment.
+func splitLine(line string) ([]string, error) {
+	var fields []string
+	var current strings.Builder
+	inField := false
+	var quote rune
+
+	for i := 0; i < len(line); i++ {
+		c := rune(line[i])
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' && i+1 < len(line) {
+				i++
+				current.WriteByte(line[i])
+			} else {
+				current.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			inField = true
+		case c == '\\' && i+1 < len(line):
+			i++
+			current.WriteByte(line[i])
+			inField = true
+		case c == ' ' || c == '\t':
+			if inField {
+				fields = append(fields, current.String())
+				current.Reset()
+				inField = false
+			}
+		case (c == '#' || c == ';') && !inField:
+			return fields, nil
+		default:
+			current.WriteRune(c)
+			inField = true
+		}
+	}
+
+	if quote != 0 {
+		return fields, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inField {
+		fields = append(fields, current.String())
+	}
+	return fields, nil
+}
+
+// quoteArg quotes an argument if it would not survive splitLine as is.
+func quoteArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\#;") {
+		return arg
+	}
+	arg = strings.ReplaceAll(arg, `\`, `\\`)
+	arg = strings.ReplaceAll(arg, `"`, `\"`)
+	return `"` + arg + `"`
+}
+
+// WriteTo writes the config back in file form. Unchanged lines are
+// written exactly as they were read.
+func (c *Config) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	var written int64
+
+	for i, n := range c.nodes {
+		lines := n.raw
+		if lines == nil {
+			lines = n.render()
+		}
+		for j, line := range lines {
+			nn, _ := bw.WriteString(line)
+			written += int64(nn)
+			last := i == len(c.nodes)-1 && j == len(lines)-1
+			if !last || c.finalNewline {
+				bw.WriteByte('\n')
+				written++
+			}
+		}
+	}
+	return written, bw.Flush()
+}
+
+// Bytes returns the config in file form.
+func (c *Config) Bytes() []byte {
+	var buf bytes.Buffer
+	c.WriteTo(&buf)
+	return buf.Bytes()
+}
+
+func (n *node) render() []string {
+	switch {
+	case n.directive != nil:
+		return []string{n.directive.String()}
+	case n.block != nil:
+		lines := []string{"<" + n.block.Tag + ">"}
+		lines = append(lines, strings.Split(strings.TrimSuffix(n.block.Content, "\n"), "\n")...)
+		return append(lines, "</"+n.block.Tag+">")
+	}
+	return []string{""}
+}
+
+// Directives returns all directives in file order.
+func (c *Config) Directives() []*Directive {
+	var directives []*Directive
+	for _, n := range c.nodes {
+		if n.directive != nil {
+			directives = append(directives, n.directive)
+		}
+	}
+	return directives
+}
+
+// Blocks returns all inline blocks in file order.
+func (c *Config) Blocks() []*Block {
+	var blocks []*Block
+	for _, n := range c.nodes {
+		if n.block != nil {
+			blocks = append(blocks, n.block)
+		}
+	}
+	return blocks
+}
+
+// Get returns the last occurrence of a directive, which is the one
+// OpenVPN honors, or nil.
+func (c *Config) Get(name string) *Directive {
+	var found *Directive
+	for _, d := range c.Directives() {
+		if d.Name == name {
+			found = d
+		}
+	}
+	return found
+}
+
+// All returns every occurrence of a directive.
+func (c *Config) All(name string) []*Directive {
+	var found []*Directive
+	for _, d := range c.Directives() {
+		if d.Name == name {
+			found = append(found, d)
+		}
+	}
+	return found
+}
+
+// Inline returns the inline block with the given tag, e.g. "ca", or nil.
+func (c *Config) Inline(tag string) *Block {
+	for _, b := range c.Blocks() {
+		if b.Tag == tag {
+			return b
+		}
+	}
+	return nil
+}
+
+// Remove deletes every occurrence of a directive.
+func (c *Config) Remove(name string) {
+	nodes := c.nodes[:0]
+	for _, n := range c.nodes {
+		if n.directive == nil || n.directive.Name != name {
+			nodes = append(nodes, n)
+		}
+	}
+	c.nodes = nodes
+}
+
+// Add inserts a directive before the first inline block, or at the end
+// if there is none.
+func (c *Config) Add(name string, args ...string) *Directive {
+	d := &Directive{Name: name, Args: args}
+	n := &node{directive: d}
+
+	for i, existing := range c.nodes {
+		if existing.block != nil {
+			c.nodes = append(c.nodes[:i], append([]*node{n}, c.nodes[i:]...)...)
+			return d
+		}
+	}
+	c.nodes = append(c.nodes, n)
+	c.finalNewline = true
+	return d
+}
+
+// Remote is a server endpoint from a remote directive, with the port and
+// protocol defaults of the config applied.
+type Remote struct {
+	Host  string `json:"host"`
+	Port  string `json:"port"`
+	Proto string `json:"proto,omitempty"`
+	Line  int    `json:"line,omitempty"`
+}
+
+// Address returns host:port.
+func (r Remote) Address() string {
+	if strings.Contains(r.Host, ":") {
+		return "[" + r.Host + "]:" + r.Port
+	}
+	return r.Host + ":" + r.Port
+}
+
+// String formats the remote as host:port (proto).
+func (r Remote) String() string {
+	return fmt.Sprintf("%s (%s)", r.Address(), r.Proto)
+}
+
+// Remotes returns the server endpoints in the order OpenVPN tries them.
+func (c *Config) Remotes() []Remote {
+	port := "1194"
+	if d := c.Get("port"); d != nil && d.Arg(0) != "" {
+		port = d.Arg(0)
+	}
+	if d := c.Get("rport"); d != nil && d.Arg(0) != "" {
+		port = d.Arg(0)
+	}
+	proto := "udp"
+	if d := c.Get("proto"); d != nil && d.Arg(0) != "" {
+		proto = d.Arg(0)
+	}
+
+	var remotes []Remote
+	for _, d := range c.All("remote") {
+		if d.Arg(0) == "" {
+			continue
+		}
+		r := Remote{Host: d.Arg(0), Port: port, Proto: proto, Line: d.Line}
+		if d.Arg(1) != "" {
+			r.Port = d.Arg(1)
+		}
+		if d.Arg(2) != "" {
+			r.Proto = d.Arg(2)
+		}
+		remotes = append(remotes, r)
+	}
+	return remotes
+}
+
+// AuthUserPass reports whether the config uses username/password
+// authentication and returns the credentials file it names, if any.
+func (c *Config) AuthUserPass() (file string, ok bool) {
+	d := c.Get("auth-user-pass")
+	if d == nil {
+		return "", false
+	}
+	return d.Arg(0), true
+}
@@ -0,0 +1,155 @@
+package ovpn
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"empty", ""},
+		{"final newline", "client\nremote vpn.example.com 1194 udp\n"},
+		{"no final newline", "client\nremote vpn.example.com 1194 udp"},
+		{"CRLF", "client\r\nremote vpn.example.com 1194 udp\r\n<ca>\r\nCERT\r\n</ca>\r\n"},
+		{"quotes", "auth-user-pass \"my file.txt\"\nsetenv NAME 'a b' \"c\\\"d\"\n"},
+		{"comments and blank lines", "# comment\n; other\n\n   \nclient # trailing\n"},
+		{"inline blocks", "client\n<ca>\nline 1\n\nline 3\n</ca>\n<tls-auth>\nKEY\n</tls-auth>\nkey-direction 1\n"},
+		{"unterminated block", "client\n<ca>\nCERT\n"},
+		{"odd spacing", "  remote\tvpn.example.com   1194  \n--verb 3\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := Parse(strings.NewReader(tt.config))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(cfg.Bytes()); got != tt.config {
+				t.Errorf("Bytes() = %q, want %q", got, tt.config)
+			}
+		})
+	}
+}
+
+func TestParseDirectives(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"remote vpn.example.com 1194 udp", []string{"remote", "vpn.example.com", "1194", "udp"}},
+		{"remote vpn.example.com 1194\r", []string{"remote", "vpn.example.com", "1194"}},
+		{"--verb 3", []string{"verb", "3"}},
+		{`auth-user-pass "my file.txt"`, []string{"auth-user-pass", "my file.txt"}},
+		{`setenv A 'b c' "d\"e"`, []string{"setenv", "A", "b c", `d"e`}},
+		{`setenv A 'b\c'`, []string{"setenv", "A", `b\c`}},
+		{`setenv A b\ c`, []string{"setenv", "A", "b c"}},
+		{"verb 3 # comment", []string{"verb", "3"}},
+		{"verb 3;comment", []string{"verb", "3;comment"}},
+		{`setenv A "#not a comment"`, []string{"setenv", "A", "#not a comment"}},
+	}
+	for _, tt := range tests {
+		cfg, err := Parse(strings.NewReader(tt.line + "\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		directives := cfg.Directives()
+		if len(directives) != 1 {
+			t.Errorf("Parse(%q) has %d directives, want 1", tt.line, len(directives))
+			continue
+		}
+		got := append([]string{directives[0].Name}, directives[0].Args...)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestInlineBlock(t *testing.T) {
+	cfg, err := Parse(strings.NewReader("client\r\n<ca>\r\nline 1\r\nline 2\r\n</ca>\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := cfg.Inline("ca")
+	if block == nil {
+		t.Fatal("Inline(\"ca\") = nil")
+	}
+	if block.Content != "line 1\nline 2\n" || block.Line != 2 || block.EndLine != 5 {
+		t.Errorf("Inline(\"ca\") = %+v, want the two lines from line 2 to 5", block)
+	}
+}
+
+func TestChangedDirectiveIsQuoted(t *testing.T) {
+	cfg, err := Parse(strings.NewReader("client\n<ca>\nCERT\n</ca>\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Add("auth-user-pass", `my "file"`)
+	want := "client\nauth-user-pass \"my \\\"file\\\"\"\n<ca>\nCERT\n</ca>\n"
+	if got := string(cfg.Bytes()); got != want {
+		t.Fatalf("Bytes() = %q, want %q", got, want)
+	}
+
+	again, err := Parse(strings.NewReader(want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := again.Get("auth-user-pass").Arg(0); got != `my "file"` {
+		t.Errorf("reparsed argument = %q, want %q", got, `my "file"`)
+	}
+}
+
+func TestValidateErrors(t *testing.T) {
+	const base = "client\nremote vpn.example.com 1194 udp\n"
+	tests := []struct {
+		name   string
+		config string
+		line   int
+		want   string
+	}{
+		{"unterminated quote", base + "auth-user-pass \"file\n", 3, "unterminated \" quote"},
+		{"unclosed block", base + "<ca>\nCERT\n", 3, "<ca> block is never closed"},
+		{"stray closing tag", base + "</ca>\n", 3, "closing tag </ca> without matching opening tag"},
+		{"unknown block", base + "<foo>\n</foo>\n", 3, "unknown inline block <foo>"},
+		{"repeated block", base + "<ca>\nA\n</ca>\n<ca>\nB\n</ca>\n", 6, "<ca> block repeats the one on line 3"},
+		{"file and block", base + "ca ca.crt\n<ca>\nA\n</ca>\n", 3, "ca is given both as a file and as an inline <ca> block"},
+		{"no remote", "client\n", 1, "no remote directive"},
+		{"invalid port", "client\nremote vpn.example.com 99999\n", 2, `remote vpn.example.com has invalid port "99999"`},
+		{"invalid proto", "client\nremote vpn.example.com 1194 sctp\n", 2, `remote vpn.example.com has invalid protocol "sctp"`},
+		{"script-security 3", base + "script-security 3\n", 3, "script-security 3 allows passwords"},
+		{"bad script-security", base + "script-security x\n", 3, `script-security level must be 0-3, got "x"`},
+		{"script", base + "script-security 2\nup /tmp/up.sh\n", 4, `up runs "/tmp/up.sh" as root`},
+		{"plugin", base + "plugin /tmp/evil.so\n", 3, `plugin loads "/tmp/evil.so"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := Parse(strings.NewReader(tt.config))
+			if err != nil {
+				t.Fatal(err)
+			}
+			issues := cfg.Validate()
+			if !issues.HasErrors() {
+				t.Fatalf("Validate() found no errors, want %q", tt.want)
+			}
+			for _, issue := range issues.Items {
+				if issue.Severity == SeverityError && issue.Line == tt.line && strings.Contains(issue.Message, tt.want) {
+					return
+				}
+			}
+			t.Errorf("Validate() = %q, want an error on line %d containing %q", issues.Strings(), tt.line, tt.want)
+		})
+	}
+}
+
+func TestValidateValid(t *testing.T) {
+	config := "client\ndev tun\nremote vpn.example.com 1194 udp\nremote vpn2.example.com 443 tcp\n<ca>\nCERT\n</ca>\nscript-security 1\nup /tmp/ignored.sh\n"
+	cfg, err := Parse(strings.NewReader(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issues := cfg.Validate(); issues.HasErrors() {
+		t.Errorf("Validate() = %q, want no errors", issues.Strings())
+	}
+}
@@ -0,0 +1,235 @@
+package ovpn
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ChangeKind says whether something was added, removed or changed.
+type ChangeKind int
+
+const (
+	Added ChangeKind = iota
+	Removed
+	Changed
+)
+
+// Symbol returns "+", "-" or "~".
+func (k ChangeKind) Symbol() string {
+	switch k {
+	case Added:
+		return "+"
+	case Removed:
+		return "-"
+	}
+	return "~"
+}
+
+// Change is one difference between two configs. Old and New describe
+// the value before and after; the one that does not exist is empty.
+type Change struct {
+	Kind ChangeKind
+	Name string
+	Old  string
+	New  string
+}
+
+// String formats the change for display, e.g. "~ verb: 3 -> 4".
+func (c Change) String() string {
+	switch {
+	case c.Kind == Added && c.New != "":
+		return fmt.Sprintf("+ %s: %s", c.Name, c.New)
+	case c.Kind == Removed && c.Old != "":
+		return fmt.Sprintf("- %s: %s", c.Name, c.Old)
+	case c.Kind == Changed && (c.Old != "" || c.New != ""):
+		return fmt.Sprintf("~ %s: %s -> %s", c.Name, c.Old, c.New)
+	}
+	return c.Kind.Symbol() + " " + c.Name
+}
+
+// Diff is the semantic difference between two configs: what a user
+// cares about when a provider updates a profile, rather than the lines
+// that changed.
+type Diff struct {
+	Remotes      []Change
+	Certificates []Change
+	Directives   []Change
+}
+
+// Empty reports whether the configs behave the same.
+func (d *Diff) Empty() bool {
+	return len(d.Remotes) == 0 && len(d.Certificates) == 0 && len(d.Directives) == 0
+}
+
+// certificateTags are the inline blocks holding certificates, which are
+// compared certificate by certificate.
+var certificateTags = map[string]bool{"ca": true, "cert": true, "extra-certs": true}
+
+// Compare returns the changes that turn old into new.
+func Compare(old, new *Config) *Diff {
+	diff := &Diff{}
+
+	oldRemotes, newRemotes := remoteSet(old), remoteSet(new)
+	for _, r := range sortedKeys(newRemotes) {
+		if !oldRemotes[r] {
+			diff.Remotes = append(diff.Remotes, Change{Kind: Added, Name: r})
+		}
+	}
+	for _, r := range sortedKeys(oldRemotes) {
+		if !newRemotes[r] {
+			diff.Remotes = append(diff.Remotes, Change{Kind: Removed, Name: r})
+		}
+	}
+
+	oldBlocks, newBlocks := blockMap(old), blockMap(new)
+	for _, tag := range unionKeys(oldBlocks, newBlocks) {
+		before, after := oldBlocks[tag], newBlocks[tag]
+		if before == after {
+			continue
+		}
+		if certificateTags[tag] {
+			diff.Certificates = append(diff.Certificates, compareCertificates(tag, before, after)...)
+			continue
+		}
+		// Keys are secret, only say that they changed
+		diff.Certificates = append(diff.Certificates, Change{Kind: kind(before, after), Name: "<" + tag + ">"})
+	}
+
+	oldDirectives, newDirectives := directiveMap(old), directiveMap(new)
+	for _, name := range unionKeys(oldDirectives, newDirectives) {
+		before, after := oldDirectives[name], newDirectives[name]
+		if before != after {
+			diff.Directives = append(diff.Directives, Change{Kind: kind(before, after), Name: name, Old: before, New: after})
+		}
+	}
+	return diff
+}
+
+func kind(before, after string) ChangeKind {
+	switch {
+	case before == "":
+		return Added
+	case after == "":
+		return Removed
+	}
+	return Changed
+}
+
+func remoteSet(c *Config) map[string]bool {
+	set := map[string]bool{}
+	for _, r := range c.Remotes() {
+		set[r.String()] = true
+	}
+	return set
+}
+
+// blockMap returns the content of each inline block with blank lines
+// and surrounding whitespace removed.
+func blockMap(c *Config) map[string]string {
+	blocks := map[string]string{}
+	for _, b := range c.Blocks() {
+		for _, line := range strings.Split(b.Content, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				blocks[b.Tag] += line + "\n"
+			}
+		}
+	}
+	return blocks
+}
+
+// directiveMap returns the arguments of every directive other than
+// remote, with repeated directives joined by "; ".
+func directiveMap(c *Config) map[string]string {
+	directives := map[string]string{}
+	for _, d := range c.Directives() {
+		if d.Name == "remote" {
+			continue
+		}
+		value := strings.TrimSpace(strings.TrimPrefix(d.String(), d.Name))
+		if value == "" {
+			value = "(set)"
+		}
+		if existing, ok := directives[d.Name]; ok {
+			value = existing + "; " + value
+		}
+		directives[d.Name] = value
+	}
+	return directives
+}
+
+// compareCertificates lists the certificates that were added to or
+// removed from an inline block.
+func compareCertificates(tag, before, after string) []Change {
+	oldCerts, newCerts := certificateSet(before), certificateSet(after)
+	if oldCerts == nil || newCerts == nil {
+		return []Change{{Kind: kind(before, after), Name: "<" + tag + ">"}}
+	}
+
+	var changes []Change
+	for _, fp := range sortedKeys(newCerts) {
+		if _, ok := oldCerts[fp]; !ok {
+			changes = append(changes, Change{Kind: Added, Name: "<" + tag + ">", New: newCerts[fp]})
+		}
+	}
+	for _, fp := range sortedKeys(oldCerts) {
+		if _, ok := newCerts[fp]; !ok {
+			changes = append(changes, Change{Kind: Removed, Name: "<" + tag + ">", Old: oldCerts[fp]})
+		}
+	}
+	return changes
+}
+
+// certificateSet describes the PEM certificates in a block by their
+// SHA-256 fingerprint. It returns nil if the block holds anything it
+// cannot parse, and an empty set for an empty block.
+func certificateSet(content string) map[string]string {
+	certs := map[string]string{}
+	rest := []byte(content)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil
+		}
+		sum := sha256.Sum256(block.Bytes)
+		fp := hex.EncodeToString(sum[:])
+		certs[fp] = fmt.Sprintf("%s, expires %s, SHA-256 %s",
+			cert.Subject.String(), cert.NotAfter.Format("2006-01-02"), fp[:16])
+	}
+	if len(certs) == 0 && strings.TrimSpace(content) != "" {
+		return nil
+	}
+	return certs
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func unionKeys(a, b map[string]string) []string {
+	union := map[string]bool{}
+	for k := range a {
+		union[k] = true
+	}
+	for k := range b {
+		union[k] = true
+	}
+	return sortedKeys(union)
+}
@@ -0,0 +1,102 @@
+package ovpn
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	const base = "client\nremote a.example.com 1194 udp\nremote b.example.com 443 tcp\nverb 3\ncipher AES-256-GCM\n<tls-auth>\nKEY 1\n</tls-auth>\n"
+	tests := []struct {
+		name string
+		new  string
+		want Diff
+	}{
+		{"unchanged", base, Diff{}},
+		{
+			"reordered",
+			"cipher AES-256-GCM\nremote b.example.com 443 tcp\nverb 3\n<tls-auth>\nKEY 1\n</tls-auth>\nclient\nremote a.example.com 1194 udp\n",
+			Diff{},
+		},
+		{"comments and blank lines", "# new\n" + strings.Replace(base, "KEY 1\n", "\nKEY 1\n\n", 1), Diff{}},
+		{
+			"directive added",
+			base + "auth-nocache\nmssfix 1400\n",
+			Diff{Directives: []Change{
+				{Kind: Added, Name: "auth-nocache", New: "(set)"},
+				{Kind: Added, Name: "mssfix", New: "1400"},
+			}},
+		},
+		{
+			"directive removed",
+			strings.Replace(base, "verb 3\n", "", 1),
+			Diff{Directives: []Change{{Kind: Removed, Name: "verb", Old: "3"}}},
+		},
+		{
+			"directive changed",
+			strings.Replace(base, "cipher AES-256-GCM", "cipher AES-128-GCM", 1),
+			Diff{Directives: []Change{{Kind: Changed, Name: "cipher", Old: "AES-256-GCM", New: "AES-128-GCM"}}},
+		},
+		{
+			"remotes",
+			strings.Replace(base, "remote b.example.com 443 tcp", "remote c.example.com 443 tcp", 1),
+			Diff{Remotes: []Change{
+				{Kind: Added, Name: "c.example.com:443 (tcp)"},
+				{Kind: Removed, Name: "b.example.com:443 (tcp)"},
+			}},
+		},
+		{
+			"inline key changed",
+			strings.Replace(base, "KEY 1", "KEY 2", 1),
+			Diff{Certificates: []Change{{Kind: Changed, Name: "<tls-auth>"}}},
+		},
+		{
+			"inline block added",
+			base + "<tls-crypt>\nKEY\n</tls-crypt>\n",
+			Diff{Certificates: []Change{{Kind: Added, Name: "<tls-crypt>"}}},
+		},
+		{
+			"inline block removed",
+			strings.Replace(base, "<tls-auth>\nKEY 1\n</tls-auth>\n", "", 1),
+			Diff{Certificates: []Change{{Kind: Removed, Name: "<tls-auth>"}}},
+		},
+	}
+
+	old, err := Parse(strings.NewReader(base))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			new, err := Parse(strings.NewReader(tt.new))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := Compare(old, new)
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("Compare() = %+v, want %+v", *got, tt.want)
+			}
+			if got.Empty() != reflect.DeepEqual(tt.want, Diff{}) {
+				t.Errorf("Empty() = %v for %+v", got.Empty(), *got)
+			}
+		})
+	}
+}
+
+func TestChangeString(t *testing.T) {
+	tests := []struct {
+		change Change
+		want   string
+	}{
+		{Change{Kind: Added, Name: "mssfix", New: "1400"}, "+ mssfix: 1400"},
+		{Change{Kind: Removed, Name: "verb", Old: "3"}, "- verb: 3"},
+		{Change{Kind: Changed, Name: "verb", Old: "3", New: "4"}, "~ verb: 3 -> 4"},
+		{Change{Kind: Changed, Name: "<tls-auth>"}, "~ <tls-auth>"},
+	}
+	for _, tt := range tests {
+		if got := tt.change.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
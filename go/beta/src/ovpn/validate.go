@@ -0,0 +1,279 @@
+package ovpn
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Severity classifies a validation issue.
+type Severity int
+
+const (
+	SeverityWarning Severity = iota
+	SeverityError
+)
+
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// Issue is a problem found in a config, tied to a line.
+type Issue struct {
+	Line     int
+	Severity Severity
+	Message  string
+}
+
+// Issues is the result of validating a config.
+type Issues struct {
+	Name  string
+	Items []Issue
+}
+
+// HasErrors reports whether any issue is an error.
+func (is *Issues) HasErrors() bool {
+	for _, issue := range is.Items {
+		if issue.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Strings formats every issue as "file:line: severity: message".
+func (is *Issues) Strings() []string {
+	lines := make([]string, 0, len(is.Items))
+	for _, issue := range is.Items {
+		lines = append(lines, fmt.Sprintf("%s:%d: %s: %s", is.Name, issue.Line, issue.Severity, issue.Message))
+	}
+	return lines
+}
+
+// Error implements error so a failed validation can be returned as one.
+func (is *Issues) Error() string {
+	errors := 0
+	for _, issue := range is.Items {
+		if issue.Severity == SeverityError {
+			errors++
+		}
+	}
+	return fmt.Sprintf("%s: %d error(s) in OpenVPN config", is.Name, errors)
+}
+
+// Validate checks a config for syntax errors, unknown directives and
+// directives that would let the config run commands as root.
+func (c *Config) Validate() *Issues {
+	return c.validate(false)
+}
+
+// ValidateForRoot is Validate for configs that root runs for a user who
+// could not run them as root otherwise, as svpnd does. Only known
+// directives are allowed, and none that makes OpenVPN write files, load
+// code or read further configs. Keys, certificates and credentials must
+// be inline, so that root reads no file the user names.
+func (c *Config) ValidateForRoot() *Issues {
+	return c.validate(true)
+}
+
+func (c *Config) validate(forRoot bool) *Issues {
+	issues := &Issues{Name: c.Name}
+	add := func(line int, severity Severity, format string, args ...interface{}) {
+		issues.Items = append(issues.Items, Issue{Line: line, Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+
+	issues.Items = append(issues.Items, c.parseIssues...)
+
+	scriptSecurity := 1
+	if d := c.Get("script-security"); d != nil {
+		level, err := strconv.Atoi(d.Arg(0))
+		if err != nil || level < 0 || level > 3 {
+			add(d.Line, SeverityError, "script-security level must be 0-3, got %q", d.Arg(0))
+		} else {
+			scriptSecurity = level
+		}
+		if level >= 3 {
+			add(d.Line, SeverityError, "script-security %d allows passwords to be passed to scripts through the environment", level)
+		}
+	}
+
+	for _, d := range c.Directives() {
+		switch {
+		case forRoot && (restrictedDirectives[d.Name] || strings.HasPrefix(d.Name, "log")):
+			add(d.Line, SeverityError, "%s is not allowed in configs svpnd runs as root", d.Name)
+		case forRoot && !knownDirectives[d.Name]:
+			add(d.Line, SeverityError, "unknown directive %q is not allowed in configs svpnd runs as root", d.Name)
+		case forRoot && readsFile(d):
+			add(d.Line, SeverityError, "%s reads the file %q as root; configs svpnd runs as root must give it inline", d.Name, d.Arg(fileDirectives[d.Name]))
+		case scriptDirectives[d.Name]:
+			if scriptSecurity >= 2 {
+				add(d.Line, SeverityError, "%s runs %q as root on connection events; remove it from the config", d.Name, d.Arg(0))
+			} else {
+				add(d.Line, SeverityWarning, "%s is ignored without script-security 2", d.Name)
+			}
+		case d.Name == "plugin":
+			add(d.Line, SeverityError, "plugin loads %q into the root OpenVPN process", d.Arg(0))
+		case managedDirectives[d.Name]:
+			add(d.Line, SeverityWarning, "%s is managed by svpn and will be overridden", d.Name)
+		case conflictingDirectives[d.Name]:
+			add(d.Line, SeverityWarning, "%s interferes with how svpn tracks the OpenVPN process and its log", d.Name)
+		case !knownDirectives[d.Name]:
+			add(d.Line, SeverityWarning, "unknown directive %q", d.Name)
+		}
+	}
+
+	seen := make(map[string]int)
+	for _, b := range c.Blocks() {
+		if !inlineTags[b.Tag] {
+			add(b.Line, SeverityError, "unknown inline block <%s>", b.Tag)
+		}
+		if first, ok := seen[b.Tag]; ok && b.Tag != "connection" {
+			add(b.Line, SeverityError, "<%s> block repeats the one on line %d", b.Tag, first)
+		}
+		seen[b.Tag] = b.Line
+		if b.Tag != "connection" {
+			if d := c.Get(b.Tag); d != nil && d.Arg(0) != "[inline]" {
+				add(d.Line, SeverityError, "%s is given both as a file and as an inline <%s> block", b.Tag, b.Tag)
+			}
+		}
+	}
+
+	remotes := c.Remotes()
+	if len(remotes) == 0 && c.Inline("connection") == nil {
+		add(1, SeverityError, "no remote directive, OpenVPN would not know which server to connect to")
+	}
+	for _, r := range remotes {
+		if port, err := strconv.Atoi(r.Port); err != nil || port < 1 || port > 65535 {
+			add(r.Line, SeverityError, "remote %s has invalid port %q", r.Host, r.Port)
+		}
+		if !validProtos[r.Proto] {
+			add(r.Line, SeverityError, "remote %s has invalid protocol %q", r.Host, r.Proto)
+		}
+	}
+
+	if file, ok := c.AuthUserPass(); ok && file != "" {
+		d := c.Get("auth-user-pass")
+		if !filepath.IsAbs(file) && c.Name != "" {
+			file = filepath.Join(filepath.Dir(c.Name), file)
+		}
+		if _, err := os.Stat(file); err != nil {
+			add(d.Line, SeverityWarning, "auth-user-pass file %s is not readable, svpn will supply the credentials", d.Arg(0))
+		}
+	}
+
+	return issues
+}
+
+// scriptDirectives run external commands as root.
+var scriptDirectives = map[string]bool{
+	"up": true, "down": true, "route-up": true, "route-pre-down": true,
+	"ipchange": true, "tls-verify": true, "client-connect": true,
+	"client-disconnect": true, "learn-address": true,
+	"auth-user-pass-verify": true, "tls-export-cert": true,
+}
+
+// restrictedDirectives make OpenVPN write files, load code or read
+// other configs. Along with every directive starting with "log", they
+// are rejected by ValidateForRoot.
+var restrictedDirectives = map[string]bool{
+	"iproute": true, "status": true, "writepid": true, "engine": true,
+	"providers": true, "pkcs11-providers": true, "config": true, "cd": true,
+	"chroot": true, "replay-persist": true, "tmp-dir": true, "dev-node": true,
+}
+
+// fileDirectives name a file OpenVPN reads, at the given argument.
+var fileDirectives = map[string]int{
+	"ca": 0, "cert": 0, "key": 0, "pkcs12": 0, "tls-auth": 0, "tls-crypt": 0,
+	"tls-crypt-v2": 0, "secret": 0, "extra-certs": 0, "crl-verify": 0, "dh": 0,
+	"auth-user-pass": 0, "http-proxy": 2, "socks-proxy": 2,
+}
+
+// fileKeywords are the arguments of fileDirectives that are not files.
+var fileKeywords = map[string]bool{
+	"": true, "[inline]": true, "none": true, "stdin": true, "auto": true, "auto-nct": true,
+}
+
+// readsFile reports whether d makes OpenVPN read a file.
+func readsFile(d *Directive) bool {
+	i, ok := fileDirectives[d.Name]
+	return ok && !fileKeywords[d.Arg(i)]
+}
+
+// managedDirectives are set by svpn on the command line.
+var managedDirectives = map[string]bool{
+	"management": true, "management-hold": true, "management-query-passwords": true,
+	"management-client-user": true, "management-client-group": true,
+}
+
+// conflictingDirectives change how the OpenVPN process runs or logs.
+var conflictingDirectives = map[string]bool{
+	"daemon": true, "log": true, "log-append": true, "writepid": true,
+}
+
+var validProtos = map[string]bool{
+	"udp": true, "udp4": true, "udp6": true,
+	"tcp": true, "tcp4": true, "tcp6": true,
+	"tcp-client": true, "tcp4-client": true, "tcp6-client": true,
+}
+
+var inlineTags = map[string]bool{
+	"ca": true, "cert": true, "key": true, "tls-auth": true, "tls-crypt": true,
+	"tls-crypt-v2": true, "secret": true, "dh": true, "extra-certs": true,
+	"pkcs12": true, "crl-verify": true, "http-proxy-user-pass": true,
+	"auth-user-pass": true, "peer-fingerprint": true, "connection": true,
+}
+
+// knownDirectives lists the OpenVPN 2.x options that make sense in a
+// client config.
+var knownDirectives = map[string]bool{
+	"client": true, "dev": true, "dev-type": true, "dev-node": true, "proto": true,
+	"remote": true, "remote-random": true, "remote-random-hostname": true,
+	"resolv-retry": true, "nobind": true, "bind": true, "lport": true, "rport": true,
+	"port": true, "local": true, "float": true, "persist-key": true, "persist-tun": true,
+	"persist-remote-ip": true, "persist-local-ip": true,
+	"ca": true, "cert": true, "key": true, "pkcs12": true, "tls-auth": true,
+	"tls-crypt": true, "tls-crypt-v2": true, "key-direction": true, "secret": true,
+	"extra-certs": true, "crl-verify": true, "peer-fingerprint": true,
+	"remote-cert-tls": true, "remote-cert-ku": true, "remote-cert-eku": true,
+	"verify-x509-name": true, "ns-cert-type": true, "tls-client": true,
+	"tls-version-min": true, "tls-version-max": true, "tls-cipher": true,
+	"tls-ciphersuites": true, "tls-groups": true, "tls-timeout": true,
+	"tls-cert-profile": true, "cipher": true, "data-ciphers": true,
+	"data-ciphers-fallback": true, "ncp-ciphers": true, "ncp-disable": true, "auth": true,
+	"auth-user-pass": true, "auth-nocache": true, "auth-retry": true,
+	"auth-token": true, "auth-token-user": true, "static-challenge": true,
+	"comp-lzo": true, "compress": true, "allow-compression": true,
+	"verb": true, "mute": true, "mute-replay-warnings": true, "reneg-sec": true,
+	"reneg-bytes": true, "reneg-pkts": true, "hand-window": true, "tran-window": true,
+	"keepalive": true, "ping": true, "ping-restart": true, "ping-exit": true,
+	"ping-timer-rem": true, "inactive": true, "explicit-exit-notify": true,
+	"connect-retry": true, "connect-retry-max": true, "connect-timeout": true,
+	"server-poll-timeout": true, "redirect-gateway": true, "redirect-private": true,
+	"route": true, "route-ipv6": true, "route-gateway": true, "route-metric": true,
+	"route-delay": true, "route-nopull": true, "route-noexec": true,
+	"pull": true, "pull-filter": true, "dhcp-option": true, "block-outside-dns": true,
+	"register-dns": true, "ifconfig": true, "ifconfig-ipv6": true, "ifconfig-noexec": true,
+	"ifconfig-nowarn": true, "topology": true, "tun-mtu": true, "tun-mtu-extra": true,
+	"link-mtu": true, "mtu-disc": true, "mtu-test": true, "fragment": true,
+	"mssfix": true, "sndbuf": true, "rcvbuf": true, "txqueuelen": true,
+	"fast-io": true, "replay-window": true, "no-replay": true, "replay-persist": true,
+	"setenv": true, "setenv-safe": true, "ignore-unknown-option": true,
+	"http-proxy": true, "http-proxy-option": true, "http-proxy-retry": true,
+	"http-proxy-timeout": true, "socks-proxy": true, "socks-proxy-retry": true,
+	"user": true, "group": true, "chroot": true, "cd": true, "mlock": true,
+	"nice": true, "tun-ipv6": true, "disable-occ": true, "server-ipv6": true,
+	"machine-readable-output": true, "suppress-timestamps": true, "status": true,
+	"status-version": true, "syslog": true, "script-security": true, "push-peer-info": true,
+	"x509-username-field": true, "ecdh-curve": true, "dh": true, "mark": true,
+	"allow-pull-fqdn": true, "key-method": true, "opt-verify": true, "providers": true,
+	"windows-driver": true, "disable-dco": true, "tls-exit": true, "single-session": true,
+	"engine": true, "pkcs11-id": true, "pkcs11-providers": true, "cryptoapicert": true,
+	"management-external-key": true, "management-external-cert": true,
+	"max-routes": true, "route-ipv6-gateway": true, "ifconfig-ipv6-remote": true,
+	"block-ipv6": true, "dns": true, "iroute": true, "allow-recursive-routing": true,
+}
@@ -0,0 +1,55 @@
+package ovpn
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateForRoot(t *testing.T) {
+	const base = "client\ndev tun\nremote vpn.example.com 1194 udp\n"
+	tests := []struct {
+		extra   string
+		wantErr bool
+	}{
+		{"", false},
+		{"verb 3", false},
+		{"status /etc/cron.d/svpn", true},
+		{"log /etc/passwd", true},
+		{"log-append /etc/passwd", true},
+		{"writepid /etc/shadow", true},
+		{"iproute /tmp/ip", true},
+		{"engine dynamic", true},
+		{"providers legacy", true},
+		{"pkcs11-providers /tmp/p11.so", true},
+		{"config /tmp/other.ovpn", true},
+		{"cd /tmp", true},
+		{"chroot /tmp", true},
+		{"no-such-directive", true},
+		{"dev-node /etc/shadow", true},
+		{"ca /etc/shadow", true},
+		{"secret /root/static.key 1", true},
+		{"tls-crypt [inline]", false},
+		{"auth-user-pass", false},
+		{"auth-user-pass /etc/shadow", true},
+		{"http-proxy proxy.example.com 3128 /etc/shadow basic", true},
+		{"http-proxy proxy.example.com 3128 auto", false},
+		{"http-proxy proxy.example.com 3128", false},
+		{"socks-proxy proxy.example.com 1080 /etc/shadow", true},
+		{"dh none", false},
+	}
+	for _, tt := range tests {
+		cfg, err := Parse(strings.NewReader(base + tt.extra + "\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := cfg.ValidateForRoot().HasErrors(); got != tt.wantErr {
+			t.Errorf("ValidateForRoot() with %q has errors = %v, want %v", tt.extra, got, tt.wantErr)
+		}
+	}
+
+	// Validate only warns about them
+	cfg, _ := Parse(strings.NewReader(base + "status /tmp/status\nno-such-directive\n"))
+	if cfg.Validate().HasErrors() {
+		t.Error("Validate() rejected a config ValidateForRoot is stricter about")
+	}
+}
@@ -0,0 +1,167 @@
+// Package paths resolves where svpn keeps its files. svpn often runs as
+// root through sudo or svpnd on behalf of a user, and must then use that
+// user's directories rather than root's.
+package paths
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// appDir is the directory svpn uses inside each base directory. The
+// name predates the XDG layout and is kept so existing profiles stay
+// where they are.
+const appDir = "secret_vpn"
+
+// SystemDir holds profiles installed for every user.
+const SystemDir = "/etc/svpn"
+
+// SystemConfigFile holds the settings of every user.
+const SystemConfigFile = SystemDir + "/config.toml"
+
+// PrivateDir holds what svpn keeps as root for users, such as DNS
+// journals. Root acts on these files later, so unlike the user's own
+// directories they must be out of the user's reach.
+const PrivateDir = "/var/lib/svpn"
+
+// configFile is the user's settings file inside $XDG_CONFIG_HOME. It is
+// named after the command, like SystemDir, not after appDir.
+const configFile = "svpn/config.toml"
+
+// Dirs are the directories svpn uses for one user.
+type Dirs struct {
+	User *user.User
+	// Home is the user's home directory.
+	Home string
+	// Config holds profiles: $XDG_CONFIG_HOME/secret_vpn.
+	Config string
+	// ConfigFile holds settings: $XDG_CONFIG_HOME/svpn/config.toml.
+	ConfigFile string
+	// State holds PID files, logs and journals: $XDG_STATE_HOME/secret_vpn.
+	State string
+	// Runtime holds sockets: $XDG_RUNTIME_DIR/secret_vpn, or State when
+	// there is no runtime directory.
+	Runtime string
+	// System holds system-wide profiles.
+	System string
+	// Private holds the state root keeps for the user:
+	// /var/lib/svpn/<uid>. Only root writes there.
+	Private string
+}
+
+// Env is everything Resolve looks at, so that it can be faked.
+type Env struct {
+	Getenv      func(string) string
+	Euid        int
+	CurrentUser func() (*user.User, error)
+	LookupUser  func(name string) (*user.User, error)
+	// IsDir reports whether a directory exists.
+	IsDir func(path string) bool
+}
+
+// OSEnv returns the environment of the running process.
+func OSEnv() Env {
+	return Env{
+		Getenv:      os.Getenv,
+		Euid:        os.Geteuid(),
+		CurrentUser: user.Current,
+		LookupUser:  user.Lookup,
+		IsDir: func(path string) bool {
+			info, err := os.Stat(path)
+			return err == nil && info.IsDir()
+		},
+	}
+}
+
+// Current resolves the directories of the user svpn acts for.
+func Current() (*Dirs, error) {
+	return Resolve(OSEnv())
+}
+
+// Resolve returns the directories of the user svpn acts for: the user
+// who ran sudo when running as root through sudo (or svpnd, which sets
+// the same variables), the current user otherwise.
+//
+// The XDG base directory variables are honored when they hold absolute
+// paths. Under sudo they may come from either user, so they are only
+// used when they point into the invoking user's home directory, or to
+// /run/user/<uid> for XDG_RUNTIME_DIR.
+func Resolve(env Env) (*Dirs, error) {
+	sudoUser := env.Getenv("SUDO_USER")
+	sudo := env.Euid == 0 && sudoUser != "" && sudoUser != "root"
+
+	var u *user.User
+	var err error
+	if sudo {
+		if u, err = env.LookupUser(sudoUser); err != nil {
+			return nil, fmt.Errorf("error looking up user %s: %v", sudoUser, err)
+		}
+	} else if u, err = env.CurrentUser(); err != nil {
+		return nil, fmt.Errorf("error determining the current user: %v", err)
+	}
+
+	// $HOME is the user's own choice, unless it may be root's
+	home := u.HomeDir
+	if h := env.Getenv("HOME"); !sudo && filepath.IsAbs(h) {
+		home = h
+	}
+	if home == "" {
+		return nil, fmt.Errorf("user %s has no home directory", u.Username)
+	}
+
+	xdg := func(name, fallback string) string {
+		dir := env.Getenv(name)
+		if !filepath.IsAbs(dir) || (sudo && !within(dir, home)) {
+			return fallback
+		}
+		return dir
+	}
+
+	configHome := xdg("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	dirs := &Dirs{
+		User:       u,
+		Home:       home,
+		Config:     filepath.Join(configHome, appDir),
+		ConfigFile: filepath.Join(configHome, configFile),
+		State:      filepath.Join(xdg("XDG_STATE_HOME", filepath.Join(home, ".local", "state")), appDir),
+		System:     SystemDir,
+		Private:    filepath.Join(PrivateDir, u.Uid),
+	}
+
+	userRuntime := "/run/user/" + u.Uid
+	runtime := env.Getenv("XDG_RUNTIME_DIR")
+	if !filepath.IsAbs(runtime) || (sudo && filepath.Clean(runtime) != userRuntime) {
+		runtime = ""
+	}
+	if runtime == "" && env.IsDir(userRuntime) {
+		runtime = userRuntime
+	}
+	dirs.Runtime = dirs.State
+	if runtime != "" {
+		dirs.Runtime = filepath.Join(runtime, appDir)
+	}
+	return dirs, nil
+}
+
+// within reports whether path is dir or lies below it.
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, filepath.Clean(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// XDGVars returns the XDG base directory variables in environ, a list of
+// "name=value" entries such as /proc/<pid>/environ.
+func XDGVars(environ []string) []string {
+	var vars []string
+	for _, entry := range environ {
+		name, _, _ := strings.Cut(entry, "=")
+		switch name {
+		case "XDG_CONFIG_HOME", "XDG_STATE_HOME", "XDG_RUNTIME_DIR":
+			vars = append(vars, entry)
+		}
+	}
+	return vars
+}
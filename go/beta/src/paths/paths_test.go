@@ -0,0 +1,156 @@
+package paths
+
+import (
+	"fmt"
+	"os/user"
+	"reflect"
+	"testing"
+)
+
+var (
+	alice = &user.User{Uid: "1000", Gid: "1000", Username: "alice", HomeDir: "/home/alice"}
+	root  = &user.User{Uid: "0", Gid: "0", Username: "root", HomeDir: "/root"}
+)
+
+// fakeEnv is an Env with the given variables, euid and existing
+// directories, in which the current user is root when euid is 0.
+func fakeEnv(vars map[string]string, euid int, dirs ...string) Env {
+	users := map[string]*user.User{"alice": alice, "root": root}
+	return Env{
+		Getenv: func(name string) string { return vars[name] },
+		Euid:   euid,
+		CurrentUser: func() (*user.User, error) {
+			if euid == 0 {
+				return root, nil
+			}
+			return alice, nil
+		},
+		LookupUser: func(name string) (*user.User, error) {
+			if u, ok := users[name]; ok {
+				return u, nil
+			}
+			return nil, fmt.Errorf("no user %s", name)
+		},
+		IsDir: func(path string) bool {
+			for _, d := range dirs {
+				if d == path {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+func TestResolve(t *testing.T) {
+	aliceDirs := &Dirs{
+		User:       alice,
+		Home:       "/home/alice",
+		Config:     "/home/alice/.config/secret_vpn",
+		ConfigFile: "/home/alice/.config/svpn/config.toml",
+		State:      "/home/alice/.local/state/secret_vpn",
+		Runtime:    "/run/user/1000/secret_vpn",
+		System:     SystemDir,
+		Private:    "/var/lib/svpn/1000",
+	}
+	with := func(change func(d *Dirs)) *Dirs {
+		d := *aliceDirs
+		change(&d)
+		return &d
+	}
+
+	tests := []struct {
+		name string
+		env  Env
+		want *Dirs
+	}{{
+		name: "plain user",
+		env:  fakeEnv(map[string]string{"HOME": "/home/alice"}, 1000, "/run/user/1000"),
+		want: aliceDirs,
+	}, {
+		name: "plain user with XDG variables",
+		env: fakeEnv(map[string]string{
+			"HOME":            "/home/alice",
+			"XDG_CONFIG_HOME": "/srv/alice/config",
+			"XDG_STATE_HOME":  "/srv/alice/state",
+			"XDG_RUNTIME_DIR": "/tmp/alice-run",
+		}, 1000),
+		want: with(func(d *Dirs) {
+			d.Config = "/srv/alice/config/secret_vpn"
+			d.ConfigFile = "/srv/alice/config/svpn/config.toml"
+			d.State = "/srv/alice/state/secret_vpn"
+			d.Runtime = "/tmp/alice-run/secret_vpn"
+		}),
+	}, {
+		name: "sudo",
+		env:  fakeEnv(map[string]string{"SUDO_USER": "alice", "HOME": "/root"}, 0, "/run/user/1000"),
+		want: aliceDirs,
+	}, {
+		name: "root without SUDO_USER",
+		env:  fakeEnv(map[string]string{"HOME": "/root"}, 0),
+		want: &Dirs{
+			User:       root,
+			Home:       "/root",
+			Config:     "/root/.config/secret_vpn",
+			ConfigFile: "/root/.config/svpn/config.toml",
+			State:      "/root/.local/state/secret_vpn",
+			Runtime:    "/root/.local/state/secret_vpn",
+			System:     SystemDir,
+			Private:    "/var/lib/svpn/0",
+		},
+	}, {
+		// They may be root's, which sudo kept
+		name: "sudo with XDG variables outside the home",
+		env: fakeEnv(map[string]string{
+			"SUDO_USER":       "alice",
+			"XDG_CONFIG_HOME": "/root/.config",
+			"XDG_STATE_HOME":  "/home/alice/../../root/state",
+			"XDG_RUNTIME_DIR": "/run/user/0",
+		}, 0, "/run/user/1000"),
+		want: aliceDirs,
+	}, {
+		name: "sudo with XDG variables inside the home",
+		env: fakeEnv(map[string]string{
+			"SUDO_USER":       "alice",
+			"XDG_CONFIG_HOME": "/home/alice/cfg",
+			"XDG_RUNTIME_DIR": "/run/user/1000",
+		}, 0, "/run/user/1000"),
+		want: with(func(d *Dirs) {
+			d.Config = "/home/alice/cfg/secret_vpn"
+			d.ConfigFile = "/home/alice/cfg/svpn/config.toml"
+		}),
+	}, {
+		name: "sudo without /run/user/<uid>",
+		env:  fakeEnv(map[string]string{"SUDO_USER": "alice"}, 0),
+		want: with(func(d *Dirs) { d.Runtime = d.State }),
+	}, {
+		name: "SUDO_USER without root",
+		env:  fakeEnv(map[string]string{"SUDO_USER": "root", "HOME": "/home/alice"}, 1000, "/run/user/1000"),
+		want: aliceDirs,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Resolve(tt.env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Resolve() = %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveUnknownSudoUser(t *testing.T) {
+	if _, err := Resolve(fakeEnv(map[string]string{"SUDO_USER": "mallory"}, 0)); err == nil {
+		t.Error("Resolve() succeeded for an unknown SUDO_USER")
+	}
+}
+
+func TestXDGVars(t *testing.T) {
+	environ := []string{"PATH=/usr/bin", "XDG_STATE_HOME=/home/alice/s", "XDG_DATA_HOME=/x", "XDG_RUNTIME_DIR=/run/user/1000", ""}
+	want := []string{"XDG_STATE_HOME=/home/alice/s", "XDG_RUNTIME_DIR=/run/user/1000"}
+	if got := XDGVars(environ); !reflect.DeepEqual(got, want) {
+		t.Errorf("XDGVars() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,718 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"main/src/bundle"
+	"main/src/config"
+	"main/src/credentials"
+	"main/src/paths"
+	"main/src/state"
+)
+
+// Profile is a named OpenVPN configuration with its credentials backend.
+// Each profile keeps its runtime state (PID file, management socket) in
+// its own directory so several tunnels can be managed independently.
+type Profile struct {
+	Name        string `json:"name"`
+	Config      string `json:"config"`
+	Credentials string `json:"credentials,omitempty"`
+
+	// System is set for profiles installed in /etc/svpn, which users
+	// can use but not change.
+	System bool `json:"-"`
+	// Source is the config file declaring the profile, if it is declared
+	// in one rather than saved in its directory.
+	Source string `json:"-"`
+
+	// Settings are the profile's settings from the config files and the
+	// environment, see config.Config.For. The credentials backend is up
+	// to CredentialsSpec.
+	Settings config.Settings `json:"-"`
+
+	// envCredentials is the backend set in the environment and
+	// defaultCredentials the one configured for all profiles.
+	envCredentials, defaultCredentials string
+}
+
+// CredentialsSpec returns the credentials backend of the profile: the
+// one set in the environment, the profile's own or the one configured
+// for all profiles.
+func (p *Profile) CredentialsSpec() string {
+	for _, spec := range []string{p.envCredentials, p.Credentials, p.defaultCredentials} {
+		if spec != "" {
+			return spec
+		}
+	}
+	return credentials.DefaultSpec
+}
+
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+func validateProfileName(name string) error {
+	if !profileNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q: use letters, digits, '.', '_' and '-'", name)
+	}
+	return nil
+}
+
+// profileStore manages the profiles of a user, see package paths for
+// where the directories are:
+//
+//	<config>/default_profile                 name of the default profile
+//	<config>/profiles/<name>/profile.json
+//	<config>/profiles/<name>/config.ovpn
+//	<state>/profiles/<name>/pid.json         runtime state, see package state
+//	<runtime>/profiles/<name>/management.sock
+//	<private>/profiles/<name>/               both, for tunnels run by root
+//
+// System-wide profiles live in /etc/svpn with the same layout; a user
+// profile of the same name takes precedence. Profiles can also be
+// declared in the config files, between the two.
+type profileStore struct {
+	dir        string
+	stateDir   string
+	runtimeDir string
+	privateDir string
+	systemDir  string
+	// legacyConfig is the .ovpn used by the implicit default profile
+	// when `svpn init` predates named profiles.
+	legacyConfig string
+	// settings are the config files and the environment
+	settings *config.Config
+}
+
+func newProfileStore(dirs *paths.Dirs, settings *config.Config) *profileStore {
+	return &profileStore{
+		dir:          dirs.Config,
+		stateDir:     dirs.State,
+		runtimeDir:   dirs.Runtime,
+		privateDir:   dirs.Private,
+		systemDir:    dirs.System,
+		legacyConfig: filepath.Join(dirs.Home, ".open_vpn", "config.ovpn"),
+		settings:     settings,
+	}
+}
+
+// userProfileStore returns the profile store of the user svpn acts for,
+// also when running under sudo.
+func userProfileStore() (*profileStore, error) {
+	dirs, err := paths.Current()
+	if err != nil {
+		return nil, err
+	}
+	settings, err := loadConfig(dirs)
+	if err != nil {
+		return nil, err
+	}
+	return newProfileStore(dirs, settings), nil
+}
+
+// Dir returns the directory holding a user profile's config.
+func (s *profileStore) Dir(name string) string {
+	return filepath.Join(s.dir, "profiles", name)
+}
+
+// StateDir returns the directory holding a profile's runtime state and
+// logs, for user and system profiles alike.
+func (s *profileStore) StateDir(name string) string {
+	return filepath.Join(s.stateDir, "profiles", name)
+}
+
+// RunDir returns the directory where svpn records a tunnel it runs, and
+// keeps its sockets and logs. Root uses PrivateDir: it never writes below
+// the user's directories, where a symlink could redirect its writes.
+func (s *profileStore) RunDir(name string) string {
+	if os.Geteuid() == 0 {
+		return s.PrivateDir(name)
+	}
+	return s.StateDir(name)
+}
+
+// TunnelDir returns the RunDir of the profile's last tunnel: PrivateDir
+// if root recorded a tunnel there, else the one whose log is newer.
+func (s *profileStore) TunnelDir(name string) string {
+	private, own := s.PrivateDir(name), s.StateDir(name)
+	for _, dir := range []string{private, own} {
+		if _, err := os.Stat(state.Open(dir).Path()); err == nil {
+			return dir
+		}
+	}
+	privateLog, err := os.Stat(profileLogPath(private))
+	if err != nil {
+		return own
+	}
+	if ownLog, err := os.Stat(profileLogPath(own)); err == nil && ownLog.ModTime().After(privateLog.ModTime()) {
+		return own
+	}
+	return private
+}
+
+// RuntimeDir returns the directory holding a profile's sockets.
+func (s *profileStore) RuntimeDir(name string) string {
+	return filepath.Join(s.runtimeDir, "profiles", name)
+}
+
+// PrivateDir returns the root-owned directory holding the state of a
+// profile that root acts on, see paths.Dirs.Private.
+func (s *profileStore) PrivateDir(name string) string {
+	return filepath.Join(s.privateDir, "profiles", name)
+}
+
+func (s *profileStore) systemProfileDir(name string) string {
+	return filepath.Join(s.systemDir, "profiles", name)
+}
+
+func (s *profileStore) profileFile(name string) string {
+	return filepath.Join(s.Dir(name), "profile.json")
+}
+
+// Exists reports whether a profile has been saved.
+func (s *profileStore) Exists(name string) bool {
+	_, err := os.Stat(s.profileFile(name))
+	return err == nil
+}
+
+// Load reads a user profile, or else a profile declared in the config
+// files or a system profile. The default profile falls back to the
+// legacy single-config layout if it has never been saved.
+func (s *profileStore) Load(name string) (*Profile, error) {
+	profile, err := s.load(name)
+	if err != nil {
+		return nil, err
+	}
+	profile.Settings = s.settings.For(name)
+	profile.envCredentials = s.settings.Env.Credentials
+	profile.defaultCredentials = s.settings.Credentials
+	return profile, nil
+}
+
+func (s *profileStore) load(name string) (*Profile, error) {
+	if err := validateProfileName(name); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(s.profileFile(name))
+	if os.IsNotExist(err) {
+		if declared := s.settings.Profiles[name]; declared != nil {
+			return &Profile{
+				Name:        name,
+				Config:      declared.Config,
+				Credentials: declared.Credentials,
+				Source:      declared.File,
+			}, nil
+		}
+	}
+	system := false
+	if os.IsNotExist(err) {
+		data, err = os.ReadFile(filepath.Join(s.systemProfileDir(name), "profile.json"))
+		system = err == nil
+	}
+	if os.IsNotExist(err) {
+		if name == defaultProfile {
+			return &Profile{
+				Name:        defaultProfile,
+				Config:      s.legacyConfig,
+				Credentials: loadCredentialsSpec(s.dir),
+			}, nil
+		}
+		return nil, fmt.Errorf("profile %q does not exist", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading profile %q: %v", name, err)
+	}
+
+	var profile Profile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return nil, fmt.Errorf("error parsing profile %q: %v", name, err)
+	}
+	profile.Name = name
+	profile.System = system
+	// Configs of system profiles are named relative to their directory
+	if system && !filepath.IsAbs(profile.Config) {
+		profile.Config = filepath.Join(s.systemProfileDir(name), profile.Config)
+	}
+	return &profile, nil
+}
+
+// Save writes a profile, creating its directory.
+func (s *profileStore) Save(profile *Profile) error {
+	if err := validateProfileName(profile.Name); err != nil {
+		return err
+	}
+	if err := ensureConfigDir(s.Dir(profile.Name)); err != nil {
+		return fmt.Errorf("error creating profile directory: %v", err)
+	}
+
+	data, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling profile: %v", err)
+	}
+	if err := os.WriteFile(s.profileFile(profile.Name), data, 0600); err != nil {
+		return fmt.Errorf("error writing profile: %v", err)
+	}
+	return nil
+}
+
+// Remove deletes a profile together with its directory.
+func (s *profileStore) Remove(name string) error {
+	if err := validateProfileName(name); err != nil {
+		return err
+	}
+	if !s.Exists(name) {
+		if declared := s.settings.Profiles[name]; declared != nil {
+			return fmt.Errorf("profile %q is declared in %s, remove it there", name, declared.File)
+		}
+		if _, err := os.Stat(s.systemProfileDir(name)); err == nil {
+			return fmt.Errorf("profile %q is a system profile, remove it from %s", name, s.systemProfileDir(name))
+		}
+		return fmt.Errorf("profile %q does not exist", name)
+	}
+	if err := os.RemoveAll(s.Dir(name)); err != nil {
+		return fmt.Errorf("error removing profile %q: %v", name, err)
+	}
+	os.RemoveAll(s.StateDir(name))
+	if s.DefaultName() == name {
+		os.Remove(filepath.Join(s.dir, "default_profile"))
+	}
+	return nil
+}
+
+// List returns the names of all user, declared and system profiles,
+// sorted.
+func (s *profileStore) List() ([]string, error) {
+	seen := map[string]bool{}
+	var names []string
+	for _, name := range s.settings.ProfileNames() {
+		if validateProfileName(name) == nil {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, dir := range []string{s.dir, s.systemDir} {
+		entries, err := os.ReadDir(filepath.Join(dir, "profiles"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error listing profiles: %v", err)
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if !entry.IsDir() || seen[name] || validateProfileName(name) != nil {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(dir, "profiles", name, "profile.json")); err == nil {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// DefaultName returns the name of the default profile: the one set in
+// the environment, chosen by the user with `svpn profile default`, set
+// in the config files or else chosen system-wide.
+func (s *profileStore) DefaultName() string {
+	if name := s.settings.EnvDefault; validateProfileName(name) == nil {
+		return name
+	}
+	if name := readDefaultProfile(s.dir); name != "" {
+		return name
+	}
+	if name := s.settings.DefaultProfile; validateProfileName(name) == nil {
+		return name
+	}
+	if name := readDefaultProfile(s.systemDir); name != "" {
+		return name
+	}
+	return defaultProfile
+}
+
+// readDefaultProfile returns the name in the default_profile file of a
+// config directory, if it holds a valid one.
+func readDefaultProfile(dir string) string {
+	data, err := os.ReadFile(filepath.Join(dir, "default_profile"))
+	if err != nil {
+		return ""
+	}
+	if name := strings.TrimSpace(string(data)); validateProfileName(name) == nil {
+		return name
+	}
+	return ""
+}
+
+// SetDefault makes name the default profile.
+func (s *profileStore) SetDefault(name string) error {
+	if _, err := s.Load(name); err != nil {
+		return err
+	}
+	if err := ensureConfigDir(s.dir); err != nil {
+		return fmt.Errorf("error creating config directory: %v", err)
+	}
+	return os.WriteFile(filepath.Join(s.dir, "default_profile"), []byte(name+"\n"), 0600)
+}
+
+// Resolve returns the named profile, or the default profile if name is
+// empty.
+func (s *profileStore) Resolve(name string) (*Profile, error) {
+	if name == "" {
+		name = s.DefaultName()
+	}
+	return s.Load(name)
+}
+
+// Running returns the names of the profiles whose tunnel is running.
+func (s *profileStore) Running() []string {
+	names, _ := s.List()
+	if !s.Exists(defaultProfile) {
+		names = append(names, defaultProfile)
+	}
+
+	var running []string
+	for _, name := range names {
+		if isRunning, _ := checkExistingVPN(s.TunnelDir(name)); isRunning {
+			running = append(running, name)
+		}
+	}
+	return running
+}
+
+// ResolveActive picks the profile a stop/status/reconnect without an
+// explicit name should act on: the only running tunnel if there is
+// exactly one, otherwise the default profile.
+func (s *profileStore) ResolveActive(name string) (*Profile, error) {
+	if name == "" {
+		if running := s.Running(); len(running) == 1 {
+			name = running[0]
+		}
+	}
+	return s.Resolve(name)
+}
+
+// parseInterspersed parses flags that may appear before or after the
+// positional arguments and returns the positional arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := parseFlags(fs, args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// profileArg returns the optional profile name argument of a command.
+func profileArg(fs *flag.FlagSet, args []string) (string, error) {
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return "", err
+	}
+	if len(positional) > 1 {
+		return "", failf(exitUsage, "too many arguments for %s: %s", fs.Name(), strings.Join(positional, " "))
+	}
+	if len(positional) == 1 {
+		return positional[0], nil
+	}
+	return "", nil
+}
+
+// withProfileStore runs a profile command with the store of the user.
+func withProfileStore(run func(store *profileStore, args []string) (any, error)) func(args []string) (any, error) {
+	return func(args []string) (any, error) {
+		store, err := userProfileStore()
+		if err != nil {
+			return nil, fail(exitFailure, err)
+		}
+		return run(store, args)
+	}
+}
+
+func profileAdd(fs *flag.FlagSet) func(args []string) (any, error) {
+	credSpec := fs.String("credentials", credentials.DefaultSpec,
+		"Credentials backend: prompt, env, file:<path>, secret-service[:<key>] or pass:<entry>")
+	makeDefault := fs.Bool("default", false, "Make this the default profile")
+	encrypt := fs.Bool("encrypt", false, "Store the config encrypted with a passphrase")
+	return withProfileStore(func(store *profileStore, args []string) (any, error) {
+		positional, err := parseInterspersed(fs, args)
+		if err != nil {
+			return nil, err
+		}
+		if spec := configuredCredentials(store.settings); spec != "" && !flagSet(fs, "credentials") {
+			*credSpec = spec
+		}
+
+		if len(positional) != 2 {
+			return nil, failf(exitUsage, "usage: svpn profile add [flags] <name> <file.ovpn>")
+		}
+		name, source := positional[0], positional[1]
+
+		if err := validateProfileName(name); err != nil {
+			return nil, fail(exitUsage, err)
+		}
+		if store.Exists(name) {
+			return nil, failf(exitFailure, "profile %q already exists, remove it first", name)
+		}
+
+		provider, err := credentials.New(*credSpec)
+		if err != nil {
+			return nil, fail(exitUsage, err)
+		}
+
+		// Encrypted bundles are checked with their passphrase and copied as is
+		plain, passphrase, err := unlockConfig(source)
+		if err != nil {
+			return nil, fail(exitConfig, err)
+		}
+
+		configName := configFileName(bundleFormat(source))
+		var encrypted []byte
+		if passphrase == "" && *encrypt {
+			if passphrase, err = newPassphrase(); err == nil {
+				encrypted, err = bundle.Encrypt(plain, passphrase)
+			}
+			if err != nil {
+				return nil, fail(exitFailure, err)
+			}
+			configName = configFileName(bundle.Age)
+		}
+
+		profile := &Profile{
+			Name:        name,
+			Config:      filepath.Join(store.Dir(name), configName),
+			Credentials: provider.Name(),
+		}
+		if err := store.Save(profile); err != nil {
+			return nil, fail(exitFailure, err)
+		}
+		if encrypted != nil {
+			err = os.WriteFile(profile.Config, encrypted, 0600)
+		} else {
+			err = copyFile(source, profile.Config, 0600)
+		}
+		if err != nil {
+			store.Remove(name)
+			return nil, fail(exitFailure, err)
+		}
+
+		if *makeDefault {
+			if err := store.SetDefault(name); err != nil {
+				return nil, failf(exitFailure, "error setting default profile: %v", err)
+			}
+		}
+
+		fmt.Printf("Profile %q added (config %s, credentials from %s)\n", name, profile.Config, profile.CredentialsSpec())
+		return profile, nil
+	})
+}
+
+// profileEntry is a profile in the result of `svpn profile list`.
+type profileEntry struct {
+	Name    string `json:"name"`
+	Default bool   `json:"default"`
+	Running bool   `json:"running"`
+	System  bool   `json:"system"`
+	// Source is the config file declaring the profile
+	Source string `json:"source,omitempty"`
+}
+
+func profileList(store *profileStore, args []string) (any, error) {
+	names, err := store.List()
+	if err != nil {
+		return nil, fail(exitFailure, err)
+	}
+	entries := []profileEntry{}
+	if len(names) == 0 {
+		fmt.Println("No profiles found, add one with 'svpn profile add' or run 'svpn init --sha256 <digest>'")
+		return entries, nil
+	}
+
+	defaultName := store.DefaultName()
+	for _, name := range names {
+		marker := " "
+		if name == defaultName {
+			marker = "*"
+		}
+		entry := profileEntry{Name: name, Default: name == defaultName}
+		if declared := store.settings.Profiles[name]; declared != nil && !store.Exists(name) {
+			entry.Source = declared.File
+		} else {
+			entry.System = !store.Exists(name)
+		}
+		entry.Running, _ = checkExistingVPN(store.TunnelDir(name))
+		entries = append(entries, entry)
+
+		state := "stopped"
+		if entry.Running {
+			state = "running"
+		}
+		if entry.System {
+			state += " (system)"
+		}
+		if entry.Source != "" {
+			state += " (" + entry.Source + ")"
+		}
+		fmt.Printf("%s %-20s %s\n", marker, name, state)
+	}
+	return entries, nil
+}
+
+func profileShow(store *profileStore, args []string) (any, error) {
+	if len(args) != 1 {
+		return nil, failf(exitUsage, "usage: svpn profile show <name>")
+	}
+
+	profile, err := store.Load(args[0])
+	if err != nil {
+		return nil, fail(exitConfig, err)
+	}
+
+	result := struct {
+		*Profile
+		Default   bool   `json:"default"`
+		Encrypted bool   `json:"encrypted"`
+		System    bool   `json:"system"`
+		Source    string `json:"source,omitempty"`
+		StateDir  string `json:"state_dir"`
+	}{profile, profile.Name == store.DefaultName(), bundleFormat(profile.Config) != bundle.Plain, profile.System, profile.Source, store.StateDir(profile.Name)}
+
+	fmt.Printf("Name:        %s\n", profile.Name)
+	fmt.Printf("Default:     %t\n", profile.Name == store.DefaultName())
+	fmt.Printf("Config:      %s\n", profile.Config)
+	fmt.Printf("Encrypted:   %t\n", bundleFormat(profile.Config) != bundle.Plain)
+	fmt.Printf("Credentials: %s\n", profile.CredentialsSpec())
+	fmt.Printf("System:      %t\n", profile.System)
+	if profile.Source != "" {
+		fmt.Printf("Declared in: %s\n", profile.Source)
+	}
+	fmt.Printf("State dir:   %s\n", store.StateDir(profile.Name))
+	return result, nil
+}
+
+func profileRemove(store *profileStore, args []string) (any, error) {
+	if len(args) != 1 {
+		return nil, failf(exitUsage, "usage: svpn profile remove <name>")
+	}
+	name := args[0]
+
+	if isRunning, _ := checkExistingVPN(store.TunnelDir(name)); isRunning {
+		return nil, failf(exitRunning, "profile %q is running, stop it first with 'svpn stop %s'", name, name)
+	}
+	if err := store.Remove(name); err != nil {
+		return nil, fail(exitFailure, err)
+	}
+	fmt.Printf("Profile %q removed\n", name)
+	return nil, nil
+}
+
+func profileDefault(store *profileStore, args []string) (any, error) {
+	if len(args) != 1 {
+		return nil, failf(exitUsage, "usage: svpn profile default <name>")
+	}
+	if err := store.SetDefault(args[0]); err != nil {
+		return nil, fail(exitFailure, err)
+	}
+	fmt.Printf("Default profile set to %q\n", args[0])
+	return nil, nil
+}
+
+// profileEncrypt replaces the plain config of a profile with an age
+// bundle.
+func profileEncrypt(store *profileStore, args []string) (any, error) {
+	if len(args) != 1 {
+		return nil, failf(exitUsage, "usage: svpn profile encrypt <name>")
+	}
+
+	profile, err := store.Load(args[0])
+	if err != nil {
+		return nil, fail(exitConfig, err)
+	}
+	if profile.System {
+		return nil, failf(exitFailure, "profile %q is a system profile and cannot be changed", profile.Name)
+	}
+	if profile.Source != "" {
+		return nil, failf(exitFailure, "profile %q is declared in %s, point its config there at an encrypted file instead", profile.Name, profile.Source)
+	}
+	if bundleFormat(profile.Config) != bundle.Plain {
+		fmt.Printf("Profile %q is already encrypted\n", profile.Name)
+		return nil, nil
+	}
+
+	plain, err := readProfileConfig(profile.Config, "")
+	if err != nil {
+		return nil, fail(exitFailure, err)
+	}
+	passphrase, err := newPassphrase()
+	if err != nil {
+		return nil, fail(exitFailure, err)
+	}
+	encrypted, err := bundle.Encrypt(plain, passphrase)
+	if err != nil {
+		return nil, fail(exitFailure, err)
+	}
+
+	if err := ensureConfigDir(store.Dir(profile.Name)); err != nil {
+		return nil, fail(exitFailure, err)
+	}
+	plainPath := profile.Config
+	profile.Config = filepath.Join(store.Dir(profile.Name), configFileName(bundle.Age))
+	if err := os.WriteFile(profile.Config, encrypted, 0600); err != nil {
+		return nil, fail(exitFailure, err)
+	}
+	if err := store.Save(profile); err != nil {
+		return nil, fail(exitFailure, err)
+	}
+	fmt.Printf("Profile %q now uses the encrypted config %s\n", profile.Name, profile.Config)
+
+	// Only remove the plain copy svpn made itself
+	if filepath.Dir(plainPath) == store.Dir(profile.Name) {
+		os.Remove(plainPath)
+	} else {
+		fmt.Printf("The plain config %s was left in place, delete it once you no longer need it\n", plainPath)
+	}
+	return nil, nil
+}
+
+// bundleFormat returns the format of a config file, Plain if it cannot be
+// read.
+func bundleFormat(path string) bundle.Format {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return bundle.Plain
+	}
+	return bundle.Detect(data)
+}
+
+// copyFile copies src to dst, creating dst with the given mode.
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %v", src, err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %v", dst, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("error copying %s: %v", src, err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"main/src/latency"
+	"main/src/ovpn"
+	"main/src/state"
+)
+
+// serversCacheTTL is how long measured latencies are reused by default.
+const serversCacheTTL = 10 * time.Minute
+
+// serverOptions control how the remotes of a profile are measured.
+type serverOptions struct {
+	MaxAge  time.Duration
+	Refresh bool
+	Workers int
+	Timeout time.Duration
+}
+
+func defaultServerOptions() serverOptions {
+	return serverOptions{MaxAge: serversCacheTTL, Workers: 16, Timeout: latency.DefaultTimeout}
+}
+
+// serversCache holds the last measurement of a profile's remotes.
+type serversCache struct {
+	Time    time.Time        `json:"time"`
+	Results []latency.Result `json:"results"`
+}
+
+func serversCachePath(stateDir string) string {
+	return filepath.Join(stateDir, "servers.json")
+}
+
+// serversCommand lists the remotes of a profile by latency.
+func serversCommand(fs *flag.FlagSet) func(args []string) (any, error) {
+	opts := defaultServerOptions()
+	fs.BoolVar(&opts.Refresh, "refresh", false, "Measure again even if recent results are cached")
+	fs.DurationVar(&opts.MaxAge, "max-age", opts.MaxAge, "How long measured latencies are reused")
+	fs.IntVar(&opts.Workers, "workers", opts.Workers, "How many servers are measured at a time")
+	fs.DurationVar(&opts.Timeout, "timeout", opts.Timeout, "How long to wait for each server")
+	return func(args []string) (any, error) {
+		profileName, err := profileArg(fs, args)
+		if err != nil {
+			return nil, err
+		}
+
+		store, err := userProfileStore()
+		if err != nil {
+			return nil, fail(exitFailure, err)
+		}
+		profile, err := store.Resolve(profileName)
+		if err != nil {
+			return nil, fail(exitConfig, err)
+		}
+		plain, _, err := unlockConfig(profile.Config)
+		if err != nil {
+			return nil, fail(exitConfig, err)
+		}
+		cfg, err := ovpn.Parse(bytes.NewReader(plain))
+		if err != nil {
+			return nil, fail(exitConfig, err)
+		}
+		cfg.Name = profile.Config
+
+		cache, err := measureServers(cfg, store.RunDir(profile.Name), opts)
+		if err != nil {
+			return nil, fail(exitFailure, err)
+		}
+		results := latency.Sorted(cache.Results)
+		err = nil
+		if _, ok := latency.Fastest(results); !ok {
+			err = failf(exitFailure, "no server answered")
+		}
+
+		if jsonOutput() {
+			return results, err
+		}
+		fmt.Printf("%-3s %-40s %-20s %s\n", "#", "REMOTE", "ADDRESS", "LATENCY")
+		for i, r := range results {
+			rank, addr, rtt := fmt.Sprint(i+1), "-", r.Error
+			if r.Addr.IsValid() {
+				addr = r.Addr.String()
+			}
+			if r.OK() {
+				rtt = r.RTT.Round(100 * time.Microsecond).String()
+			} else {
+				rank = "-"
+			}
+			fmt.Printf("%-3s %-40s %-20s %s\n", rank, r.Remote, addr, rtt)
+		}
+		if age := time.Since(cache.Time); age > time.Second {
+			fmt.Printf("Measured %s ago, 'svpn servers --refresh' measures again\n", age.Round(time.Second))
+		}
+		return results, err
+	}
+}
+
+// measureServers measures the remotes of cfg, or returns the cached
+// results if they are recent and the remotes have not changed.
+func measureServers(cfg *ovpn.Config, stateDir string, opts serverOptions) (*serversCache, error) {
+	remotes := cfg.Remotes()
+	if len(remotes) == 0 {
+		return nil, fmt.Errorf("%s has no remote", cfg.Name)
+	}
+
+	path := serversCachePath(stateDir)
+	if !opts.Refresh {
+		if cache, err := loadServersCache(path); err == nil && time.Since(cache.Time) < opts.MaxAge && sameRemotes(cache.Results, remotes) {
+			return cache, nil
+		}
+	}
+
+	handshake, err := latency.NewHandshake(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("cannot measure the servers: %v", err)
+	}
+	prober := &latency.Prober{Handshake: handshake, Timeout: opts.Timeout}
+	fmt.Printf("Measuring %d server(s)...\n", len(remotes))
+	cache := &serversCache{
+		Time:    time.Now(),
+		Results: latency.Measure(context.Background(), remotes, opts.Workers, prober.Probe),
+	}
+
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err == nil {
+		err = ensureRunDir(stateDir)
+	}
+	if err == nil {
+		err = state.WriteFile(path, append(data, '\n'), 0600)
+	}
+	if err != nil {
+		warnf("Could not cache the results: %v", err)
+	}
+	return cache, nil
+}
+
+func loadServersCache(path string) (*serversCache, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cache serversCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, err
+	}
+	return &cache, nil
+}
+
+// sameRemotes reports whether cached results cover exactly remotes.
+func sameRemotes(results []latency.Result, remotes []ovpn.Remote) bool {
+	if len(results) != len(remotes) {
+		return false
+	}
+	for i, r := range remotes {
+		if results[i].Remote.String() != r.String() {
+			return false
+		}
+	}
+	return true
+}
+
+// fastestRemote picks the remote of cfg with the lowest latency and
+// returns it in the form --remote takes, or "" if none answered.
+func fastestRemote(cfg *ovpn.Config, stateDir string) (string, error) {
+	cache, err := measureServers(cfg, stateDir, defaultServerOptions())
+	if err != nil {
+		return "", err
+	}
+	best, ok := latency.Fastest(cache.Results)
+	if !ok {
+		return "", nil
+	}
+	fmt.Printf("Fastest server: %s at %s (%s)\n", best.Remote, best.Addr, best.RTT.Round(100*time.Microsecond))
+	return net.JoinHostPort(best.Addr.String(), best.Remote.Port) + "/" + best.Remote.Proto, nil
+}
+
+// parseRemote parses a --remote value, <host>:<port>[/<proto>]. The
+// protocol is empty when the config's default applies.
+func parseRemote(spec string) (ovpn.Remote, error) {
+	hostPort, proto, _ := strings.Cut(spec, "/")
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil || host == "" || port == "" {
+		return ovpn.Remote{}, fmt.Errorf("invalid --remote %q (expected <host>:<port>[/<proto>])", spec)
+	}
+	return ovpn.Remote{Host: host, Port: port, Proto: proto}, nil
+}
+
+// pinRemote makes OpenVPN connect to the --remote server only.
+func (l *vpnLaunch) pinRemote() error {
+	remote, err := parseRemote(l.remote)
+	if err != nil {
+		return err
+	}
+	cfg, err := l.parseConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.Inline("connection") != nil {
+		return fmt.Errorf("--remote does not support <connection> blocks")
+	}
+
+	cfg.Remove("remote")
+	cfg.Remove("remote-random")
+	cfg.Remove("remote-random-hostname")
+	args := []string{remote.Host, remote.Port}
+	if remote.Proto != "" {
+		args = append(args, remote.Proto)
+	}
+	cfg.Add("remote", args...)
+	l.runConfig = cfg.Bytes()
+	fmt.Printf("Connecting to %s only\n", l.remote)
+	return nil
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+
+	"main/src/bundle"
+	"main/src/fetch"
+	"main/src/state"
+)
+
+// defaultConfigURL is where `svpn init` fetches the config from when no
+// --from is given.
+const defaultConfigURL = "https://github.com/cazzano/open_vpn/raw/main/config.ovpn.gpg"
+
+// profileSource records where a profile's config came from and how it
+// is verified, so that it can be fetched again.
+type profileSource struct {
+	fetch.Cache
+	SHA256    string `json:"sha256,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// sourceOptions are the --from, --sha256, --signature and --key flags.
+type sourceOptions struct {
+	From      string
+	SHA256    string
+	Signature string
+	Key       string
+}
+
+func sourcePath(dir string) string {
+	return filepath.Join(dir, "source.json")
+}
+
+// signingKeyPath is the OpenPGP key pinned for a profile's signatures.
+func signingKeyPath(dir string) string {
+	return filepath.Join(dir, "signing-key.asc")
+}
+
+// loadSource reads the source of a profile, or returns nil if it was
+// never fetched.
+func loadSource(dir string) (*profileSource, error) {
+	data, err := os.ReadFile(sourcePath(dir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading profile source: %v", err)
+	}
+	var source profileSource
+	if err := json.Unmarshal(data, &source); err != nil {
+		return nil, fmt.Errorf("error parsing profile source: %v", err)
+	}
+	return &source, nil
+}
+
+func saveSource(dir string, source *profileSource) error {
+	data, err := json.MarshalIndent(source, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling profile source: %v", err)
+	}
+	if err := state.WriteFile(sourcePath(dir), append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("error writing profile source: %v", err)
+	}
+	return nil
+}
+
+// pinnedKeyring returns the keys that sign a profile's configs. The
+// first key given with --key is pinned in the profile directory; later
+// keys must match it.
+func pinnedKeyring(dir, keyFile string) (openpgp.EntityList, error) {
+	pinned, err := os.ReadFile(signingKeyPath(dir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading pinned key: %v", err)
+	}
+
+	if keyFile == "" {
+		if pinned == nil {
+			return nil, errors.New("no signing key is pinned for this profile, pass --key <file>")
+		}
+		return fetch.ReadKeyring(pinned)
+	}
+
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key: %v", err)
+	}
+	keyring, err := fetch.ReadKeyring(data)
+	if err != nil {
+		return nil, err
+	}
+	if pinned != nil {
+		pinnedKeyring, err := fetch.ReadKeyring(pinned)
+		if err != nil {
+			return nil, err
+		}
+		if fetch.Fingerprint(pinnedKeyring) != fetch.Fingerprint(keyring) {
+			return nil, fmt.Errorf("%s is not the key pinned for this profile (%s); remove %s to pin another key",
+				keyFile, fetch.Fingerprint(pinnedKeyring), signingKeyPath(dir))
+		}
+		return keyring, nil
+	}
+
+	if err := state.WriteFile(signingKeyPath(dir), data, 0600); err != nil {
+		return nil, fmt.Errorf("error pinning key: %v", err)
+	}
+	fmt.Printf("Pinned signing key %s\n", fetch.Fingerprint(keyring))
+	return keyring, nil
+}
+
+// sourceVerifier builds the integrity check for a download. Every config
+// has to be checked against a SHA-256 digest or a signature.
+func sourceVerifier(fetcher *fetch.Fetcher, dir string, opts sourceOptions) (fetch.Verifier, error) {
+	switch {
+	case opts.SHA256 != "" && opts.Signature != "":
+		return nil, errors.New("use either --sha256 or --signature, not both")
+	case opts.SHA256 != "":
+		return fetch.ParseSHA256(opts.SHA256)
+	case opts.Signature != "":
+		keyring, err := pinnedKeyring(dir, opts.Key)
+		if err != nil {
+			return nil, err
+		}
+		sig, err := fetcher.Get(context.Background(), opts.Signature, nil)
+		if err != nil {
+			return nil, err
+		}
+		return &fetch.Signature{Keyring: keyring, Signature: sig.Data}, nil
+	}
+	return nil, errors.New("the config must be verified, pass --sha256 <digest> or --signature <url|file>")
+}
+
+// download is a verified config that has not been installed yet.
+type download struct {
+	Data []byte
+	// Unchanged is set when the installed config is still current.
+	Unchanged bool
+	Source    profileSource
+}
+
+// downloadConfig fetches and verifies the config of a profile without
+// touching the installed copy.
+func downloadConfig(profile *Profile, dir string, opts sourceOptions) (*download, error) {
+	fetcher := fetch.New()
+	verifier, err := sourceVerifier(fetcher, dir, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// A cached download only counts while the config it produced is there
+	var cache *fetch.Cache
+	installed, installedErr := os.ReadFile(profile.Config)
+	if source, err := loadSource(dir); err == nil && source != nil && installedErr == nil {
+		cache = &source.Cache
+	}
+
+	fmt.Printf("Fetching %s...\n", opts.From)
+	result, err := fetcher.Get(context.Background(), opts.From, cache)
+	if err != nil {
+		return nil, err
+	}
+
+	dl := &download{Data: result.Data, Source: profileSource{Cache: result.Cache}}
+	if result.NotModified {
+		dl.Data = installed
+	}
+	if err := verifier.Verify(dl.Data); err != nil {
+		return nil, err
+	}
+	fmt.Printf("Verified %s\n", verifier)
+
+	dl.Unchanged = installedErr == nil && bytes.Equal(dl.Data, installed)
+	if _, ok := verifier.(fetch.SHA256); ok {
+		dl.Source.SHA256 = opts.SHA256
+	} else {
+		dl.Source.Signature = opts.Signature
+	}
+	return dl, nil
+}
+
+// previousDir holds the config and source replaced by the last
+// install, for `svpn rollback`.
+func previousDir(dir string) string {
+	return filepath.Join(dir, "previous")
+}
+
+// installConfig writes a download into the profile directory and points
+// the profile at it. The config it replaces is kept in previousDir.
+func installConfig(store *profileStore, profile *Profile, dl *download) error {
+	dir := store.Dir(profile.Name)
+	if err := ensureConfigDir(dir); err != nil {
+		return fmt.Errorf("error creating profile directory: %v", err)
+	}
+	// An unchanged config only refreshes the cache
+	if dl.Unchanged {
+		if err := saveSource(dir, &dl.Source); err != nil {
+			return err
+		}
+		return store.Save(profile)
+	}
+	if err := keepPrevious(dir, profile); err != nil {
+		return err
+	}
+
+	previous := profile.Config
+	profile.Config = filepath.Join(dir, configFileName(bundle.Detect(dl.Data)))
+	if err := state.WriteFile(profile.Config, dl.Data, 0600); err != nil {
+		return fmt.Errorf("error writing config: %v", err)
+	}
+	if dl.Source.URL != "" {
+		if err := saveSource(dir, &dl.Source); err != nil {
+			return err
+		}
+	} else {
+		os.Remove(sourcePath(dir))
+	}
+	if err := store.Save(profile); err != nil {
+		return err
+	}
+
+	// The format may have changed, e.g. from OpenPGP to age
+	if previous != profile.Config && filepath.Dir(previous) == dir {
+		os.Remove(previous)
+	}
+	return nil
+}
+
+// keepPrevious copies the installed config and its source into
+// previousDir, replacing what was kept before.
+func keepPrevious(dir string, profile *Profile) error {
+	data, err := os.ReadFile(profile.Config)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading config: %v", err)
+	}
+
+	prev := previousDir(dir)
+	if err := os.RemoveAll(prev); err != nil {
+		return fmt.Errorf("error removing previous config: %v", err)
+	}
+	if err := ensureConfigDir(prev); err != nil {
+		return fmt.Errorf("error keeping previous config: %v", err)
+	}
+	if err := state.WriteFile(filepath.Join(prev, configFileName(bundle.Detect(data))), data, 0600); err != nil {
+		return fmt.Errorf("error keeping previous config: %v", err)
+	}
+	if source, err := os.ReadFile(sourcePath(dir)); err == nil {
+		if err := state.WriteFile(sourcePath(prev), source, 0600); err != nil {
+			return fmt.Errorf("error keeping previous source: %v", err)
+		}
+	}
+	return nil
+}
+
+// loadPrevious returns the config kept by the last install, or nil if
+// there is none.
+func loadPrevious(dir string) (*download, error) {
+	prev := previousDir(dir)
+	for _, format := range []bundle.Format{bundle.Plain, bundle.Age, bundle.OpenPGP} {
+		data, err := os.ReadFile(filepath.Join(prev, configFileName(format)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading previous config: %v", err)
+		}
+
+		dl := &download{Data: data}
+		source, err := loadSource(prev)
+		if err != nil {
+			return nil, err
+		}
+		if source != nil {
+			dl.Source = *source
+		}
+		return dl, nil
+	}
+	return nil, nil
+}
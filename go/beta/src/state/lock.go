@@ -0,0 +1,105 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrRunning is returned by Claim when another process owns the tunnel.
+var ErrRunning = errors.New("already running")
+
+// claimWait is how long Claim retries, so that a concurrent Claimed
+// probe does not make it fail.
+const claimWait = time.Second
+
+// lock takes the flock guarding the state file, exclusive for writers.
+// The lock file is readable by everyone so that users can take a shared
+// lock on state written by root.
+func (f *File) lock(exclusive bool) (func(), error) {
+	how, flags := unix.LOCK_SH, os.O_RDONLY
+	if exclusive {
+		how, flags = unix.LOCK_EX, os.O_RDWR|os.O_CREATE
+	}
+	file, err := openLock(filepath.Join(f.dir, lockName), flags)
+	if err != nil {
+		return nil, fmt.Errorf("error opening state lock: %w", err)
+	}
+	if err := unix.Flock(int(file.Fd()), how); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error locking state file: %v", err)
+	}
+	return func() { file.Close() }, nil
+}
+
+// Claim is the ownership of a profile's tunnel, held by the process that
+// runs it. The kernel releases it when that process dies, however it
+// dies.
+type Claim struct {
+	file *os.File
+}
+
+// Claim takes ownership of the tunnel, or fails with ErrRunning when
+// another process owns it. Two concurrent starts of the same profile
+// therefore cannot both succeed.
+func (f *File) Claim() (*Claim, error) {
+	file, err := openLock(filepath.Join(f.dir, runLockName), os.O_RDWR|os.O_CREATE)
+	if err != nil {
+		return nil, fmt.Errorf("error opening run lock: %w", err)
+	}
+
+	deadline := time.Now().Add(claimWait)
+	for {
+		err := unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+		if err == nil {
+			return &Claim{file: file}, nil
+		}
+		if err != unix.EWOULDBLOCK || time.Now().After(deadline) {
+			file.Close()
+			if err == unix.EWOULDBLOCK {
+				return nil, ErrRunning
+			}
+			return nil, fmt.Errorf("error locking run lock: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// Release gives up ownership of the tunnel.
+func (c *Claim) Release() {
+	c.file.Close()
+}
+
+// Claimed reports whether a process owns the tunnel.
+func (f *File) Claimed() bool {
+	file, err := openLock(filepath.Join(f.dir, runLockName), os.O_RDONLY)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	return unix.Flock(int(file.Fd()), unix.LOCK_SH|unix.LOCK_NB) == unix.EWOULDBLOCK
+}
+
+// openLock opens a lock file, never through a symlink and only if it is
+// a regular file with no other links, so that a lock file cannot stand
+// in for another file.
+func openLock(path string, flags int) (*os.File, error) {
+	file, err := os.OpenFile(path, flags|unix.O_NOFOLLOW, 0644)
+	if err != nil {
+		return nil, err
+	}
+	var stat unix.Stat_t
+	if err := unix.Fstat(int(file.Fd()), &stat); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if stat.Mode&unix.S_IFMT != unix.S_IFREG || stat.Nlink != 1 {
+		file.Close()
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+	return file, nil
+}
@@ -0,0 +1,36 @@
+package state
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestClaim(t *testing.T) {
+	file := Open(t.TempDir())
+	if file.Claimed() {
+		t.Fatal("Claimed() before any claim")
+	}
+
+	claim, err := file.Claim()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !file.Claimed() {
+		t.Error("Claimed() = false while claimed")
+	}
+
+	// A second start of the profile, e.g. from another process
+	if _, err := Open(file.dir).Claim(); !errors.Is(err, ErrRunning) {
+		t.Errorf("second Claim() = %v, want ErrRunning", err)
+	}
+
+	claim.Release()
+	if file.Claimed() {
+		t.Error("Claimed() = true after Release")
+	}
+	claim, err = file.Claim()
+	if err != nil {
+		t.Fatalf("Claim() after Release = %v", err)
+	}
+	claim.Release()
+}
@@ -0,0 +1,65 @@
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// StartTime returns when a process started, in clock ticks after boot as
+// reported by /proc/<pid>/stat. Together with the PID it identifies a
+// process, as PIDs are reused.
+func StartTime(pid int) (uint64, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	// The command name in parentheses may contain spaces and parentheses
+	end := bytes.LastIndexByte(data, ')')
+	if end < 0 {
+		return 0, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	// Field 22 is the start time; fields after the name start at 3
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// Cmdline returns the arguments of a process.
+func Cmdline(pid int) ([]string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimRight(string(data), "\x00"), "\x00"), nil
+}
+
+// ProcessMatches reports whether pid is still the process that was
+// recorded: it must have one argument named arg (compared by base name,
+// so "openvpn" matches "/usr/sbin/openvpn" and "sudo openvpn") and, if
+// start is not 0, the recorded start time.
+func ProcessMatches(pid int, start uint64, arg string) bool {
+	if pid <= 0 {
+		return false
+	}
+	if start != 0 {
+		if actual, err := StartTime(pid); err != nil || actual != start {
+			return false
+		}
+	}
+	args, err := Cmdline(pid)
+	if err != nil {
+		return false
+	}
+	for _, a := range args {
+		if filepath.Base(a) == arg {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProcessMatches(t *testing.T) {
+	pid := os.Getpid()
+	start, err := StartTime(pid)
+	if err != nil {
+		t.Skipf("no /proc: %v", err)
+	}
+	self := filepath.Base(os.Args[0])
+
+	tests := []struct {
+		name  string
+		pid   int
+		start uint64
+		arg   string
+		want  bool
+	}{
+		{"same process", pid, start, self, true},
+		{"no recorded start time", pid, 0, self, true},
+		// The PID now belongs to a process started at another time
+		{"reused PID", pid, start + 1, self, false},
+		{"other command", pid, start, "openvpn", false},
+		{"no PID", 0, 0, self, false},
+	}
+	for _, tt := range tests {
+		if got := ProcessMatches(tt.pid, tt.start, tt.arg); got != tt.want {
+			t.Errorf("%s: ProcessMatches(%d, %d, %q) = %v, want %v", tt.name, tt.pid, tt.start, tt.arg, got, tt.want)
+		}
+	}
+}
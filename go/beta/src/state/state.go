@@ -0,0 +1,187 @@
+// Package state keeps the runtime state of a profile's tunnel. Each
+// profile directory holds
+//
+//	pid.json  the state, replaced atomically on every write
+//	pid.lock  flock guarding pid.json
+//	run.lock  flock held by the process owning the tunnel while it runs
+//
+// The files are readable by everyone, so that users can read the state
+// of tunnels that root runs from a directory only root can write to.
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"main/src/health"
+)
+
+// SchemaVersion is the version of the state file written by this svpn.
+// Files without a version predate versioning and are read as version 0.
+const SchemaVersion = 1
+
+const (
+	fileName    = "pid.json"
+	lockName    = "pid.lock"
+	runLockName = "run.lock"
+)
+
+var (
+	// ErrNoState is returned by Read when no tunnel has recorded its state.
+	ErrNoState = errors.New("no state file")
+	// ErrCorrupt is wrapped by Read errors for files that cannot be parsed.
+	ErrCorrupt = errors.New("corrupt state file")
+)
+
+// Exit describes one exit of a supervised OpenVPN process.
+type Exit struct {
+	Time     time.Time `json:"time"`
+	PID      int       `json:"pid,omitempty"`
+	ExitCode int       `json:"exit_code"`
+	Signal   string    `json:"signal,omitempty"`
+	Uptime   string    `json:"uptime"`
+	Reason   string    `json:"reason"`
+}
+
+// Info is the state of a tunnel. Processes are identified by their PID
+// together with their start time, so that a reused PID is not taken for
+// the original process.
+type Info struct {
+	Version    int       `json:"version"`
+	PID        int       `json:"pid"`
+	PIDStart   uint64    `json:"pid_start,omitempty"`
+	StartTime  time.Time `json:"start_time"`
+	Profile    string    `json:"profile,omitempty"`
+	Config     string    `json:"config,omitempty"`
+	Management string    `json:"management,omitempty"`
+	Killswitch bool      `json:"killswitch,omitempty"`
+	// Interface is the tun device, known once the tunnel came up
+	Interface string `json:"interface,omitempty"`
+	// Health is the last round of health checks
+	Health *health.Report `json:"health,omitempty"`
+
+	// Set when the process is watched by `svpn start --supervise`
+	Supervisor      int    `json:"supervisor_pid,omitempty"`
+	SupervisorStart uint64 `json:"supervisor_start,omitempty"`
+	Restarts        int    `json:"restarts,omitempty"`
+	Exits           []Exit `json:"exits,omitempty"`
+}
+
+// OpenVPNAlive reports whether the recorded OpenVPN process still runs.
+func (i *Info) OpenVPNAlive() bool {
+	return ProcessMatches(i.PID, i.PIDStart, "openvpn")
+}
+
+// SupervisorAlive reports whether the recorded supervisor still runs.
+func (i *Info) SupervisorAlive() bool {
+	return i.Supervisor != 0 && ProcessMatches(i.Supervisor, i.SupervisorStart, "start")
+}
+
+// File is the state file of one profile.
+type File struct {
+	dir string
+}
+
+// Open returns the state file in dir. Nothing is read until Read.
+func Open(dir string) *File {
+	return &File{dir: dir}
+}
+
+// Path returns the path of the state file.
+func (f *File) Path() string {
+	return filepath.Join(f.dir, fileName)
+}
+
+// Read returns the recorded state, ErrNoState if there is none.
+func (f *File) Read() (*Info, error) {
+	// Readers that may not create the lock file read without it; writes
+	// are atomic, so they still never see a partial file.
+	if unlock, err := f.lock(false); err == nil {
+		defer unlock()
+	}
+
+	data, err := os.ReadFile(f.Path())
+	if os.IsNotExist(err) {
+		return nil, ErrNoState
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state file: %v", err)
+	}
+
+	var info Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("%w %s: %v", ErrCorrupt, f.Path(), err)
+	}
+	if info.Version > SchemaVersion {
+		return nil, fmt.Errorf("state file %s has schema version %d, this svpn only reads up to %d",
+			f.Path(), info.Version, SchemaVersion)
+	}
+	return &info, nil
+}
+
+// Write replaces the recorded state.
+func (f *File) Write(info *Info) error {
+	unlock, err := f.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	info.Version = SchemaVersion
+	if info.StartTime.IsZero() {
+		info.StartTime = time.Now()
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("error marshaling state: %v", err)
+	}
+	if err := WriteFile(f.Path(), data, 0644); err != nil {
+		return fmt.Errorf("error writing state file: %v", err)
+	}
+	return nil
+}
+
+// Remove deletes the recorded state. A missing file is not an error.
+func (f *File) Remove() error {
+	unlock, err := f.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := os.Remove(f.Path()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing state file: %w", err)
+	}
+	return nil
+}
+
+// WriteFile replaces path with data through a synced temporary file and
+// a rename, so readers never see a partly written file.
+func WriteFile(path string, data []byte, mode os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,114 @@
+package state
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWriteFileIsAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pid.json")
+	contents := [][]byte{bytes.Repeat([]byte("a"), 1<<20), bytes.Repeat([]byte("b"), 1<<19)}
+	if err := WriteFile(path, contents[0], 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Readers must see one whole version or the other, never a mix
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Errorf("ReadFile() = %v during writes", err)
+				return
+			}
+			if !bytes.Equal(data, contents[0]) && !bytes.Equal(data, contents[1]) {
+				t.Errorf("read a partial file of %d bytes", len(data))
+				return
+			}
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		if err := WriteFile(path, contents[i%2], 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("mode = %v, want 0640", info.Mode().Perm())
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("dir holds %d files, want only pid.json and no temporary files", len(entries))
+	}
+}
+
+func TestRead(t *testing.T) {
+	file := Open(t.TempDir())
+	if _, err := file.Read(); !errors.Is(err, ErrNoState) {
+		t.Errorf("Read() of a missing file = %v, want ErrNoState", err)
+	}
+
+	if err := file.Write(&Info{PID: 42, Profile: "work"}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := file.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != SchemaVersion || info.PID != 42 || info.Profile != "work" || info.StartTime.IsZero() {
+		t.Errorf("Read() = %+v, want the written state with version and start time", info)
+	}
+
+	tests := []struct {
+		data    string
+		wantErr string
+	}{
+		// Files from before versioning are version 0
+		{`{"pid": 7}`, ""},
+		{`{"version": 1, "pid": 7}`, ""},
+		{`{"version": 2, "pid": 7}`, "schema version 2"},
+		{`{"pid": `, "corrupt"},
+	}
+	for _, tt := range tests {
+		if err := os.WriteFile(file.Path(), []byte(tt.data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		info, err := file.Read()
+		switch {
+		case tt.wantErr == "" && (err != nil || info.PID != 7):
+			t.Errorf("Read() of %s = %+v, %v, want PID 7", tt.data, info, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("Read() of %s = %v, want an error about %q", tt.data, err, tt.wantErr)
+		}
+	}
+	if _, err := file.Read(); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Read() of a corrupt file = %v, want ErrCorrupt", err)
+	}
+
+	if err := file.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Remove(); err != nil {
+		t.Errorf("Remove() of a missing file = %v", err)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"main/src/health"
+	"main/src/ovpn"
+	"main/src/state"
+)
+
+// Exit codes for `svpn status`, so that scripts can tell the cases apart.
+const (
+	statusRunning = exitOK         // VPN is running
+	statusStale   = exitStale      // PID file exists but the process is gone, or is being restarted
+	statusStopped = exitNotRunning // VPN is not running
+	statusUnknown = exitUnknown    // status could not be determined
+)
+
+// defaultProfile is the name of the profile used when none is given.
+const defaultProfile = "default"
+
+// StatusReport describes the state of the VPN as printed by `svpn status`.
+type StatusReport struct {
+	State      string         `json:"state"`
+	PID        int            `json:"pid,omitempty"`
+	StartTime  *time.Time     `json:"start_time,omitempty"`
+	Uptime     string         `json:"uptime,omitempty"`
+	Connection string         `json:"connection,omitempty"`
+	Supervisor int            `json:"supervisor_pid,omitempty"`
+	Restarts   int            `json:"restarts,omitempty"`
+	LastExit   *state.Exit    `json:"last_exit,omitempty"`
+	Profile    string         `json:"profile"`
+	Interface  string         `json:"interface,omitempty"`
+	Address    string         `json:"address,omitempty"`
+	Remote     string         `json:"remote,omitempty"`
+	Health     *health.Report `json:"health,omitempty"`
+	Error      string         `json:"error,omitempty"`
+}
+
+func statusVPN(fs *flag.FlagSet) func(args []string) (any, error) {
+	return func(args []string) (any, error) {
+		profileName, err := profileArg(fs, args)
+		if err != nil {
+			return nil, err
+		}
+
+		var report *StatusReport
+		code := statusUnknown
+		store, err := userProfileStore()
+		if err != nil {
+			report = &StatusReport{State: "unknown", Profile: profileName, Error: err.Error()}
+		} else {
+			report, code = collectStatus(store, profileName)
+		}
+
+		if !jsonOutput() {
+			printStatus(report)
+		}
+		return report, exitCode(code)
+	}
+}
+
+// collectStatus gathers the status of a profile's tunnel and the
+// matching exit code.
+func collectStatus(store *profileStore, profileName string) (*StatusReport, int) {
+	report := &StatusReport{State: "unknown", Profile: profileName}
+
+	profile, err := store.ResolveActive(profileName)
+	if err != nil {
+		report.Error = err.Error()
+		return report, statusUnknown
+	}
+	report.Profile = profile.Name
+
+	pidInfo, err := state.Open(store.TunnelDir(profile.Name)).Read()
+	if errors.Is(err, state.ErrNoState) {
+		report.State = "stopped"
+		return report, statusStopped
+	}
+	if err != nil {
+		report.Error = err.Error()
+		return report, statusUnknown
+	}
+
+	report.PID = pidInfo.PID
+	report.Restarts = pidInfo.Restarts
+	if len(pidInfo.Exits) > 0 {
+		report.LastExit = &pidInfo.Exits[len(pidInfo.Exits)-1]
+	}
+	if pidInfo.SupervisorAlive() {
+		report.Supervisor = pidInfo.Supervisor
+	}
+
+	if !pidInfo.OpenVPNAlive() {
+		if report.Supervisor != 0 {
+			report.State = "restarting"
+			return report, statusStale
+		}
+		report.State = "stale"
+		report.Error = fmt.Sprintf("process %d is not running openvpn", pidInfo.PID)
+		return report, statusStale
+	}
+
+	report.State = "running"
+	report.Health = pidInfo.Health
+	report.StartTime = &pidInfo.StartTime
+	report.Uptime = time.Since(pidInfo.StartTime).Round(time.Second).String()
+
+	dev := ""
+	if cfg, err := ovpn.ParseFile(pidInfo.Config); err == nil {
+		if remotes := cfg.Remotes(); len(remotes) > 0 {
+			report.Remote = remotes[0].String()
+		}
+		if d := cfg.Get("dev"); d != nil {
+			dev = d.Arg(0)
+		}
+	}
+	report.Interface = pidInfo.Interface
+	if report.Interface == "" {
+		report.Interface = findTunInterface(dev)
+	}
+	if report.Interface != "" {
+		report.Address = interfaceAddress(report.Interface)
+	}
+
+	// Prefer the live view from the management interface when available
+	if client, err := dialManagement(pidInfo); err == nil {
+		defer client.Close()
+		if state, err := client.State(); err == nil {
+			report.Connection = state.Name
+			if state.LocalIP != "" {
+				report.Address = state.LocalIP
+			}
+			if state.RemoteIP != "" {
+				report.Remote = net.JoinHostPort(state.RemoteIP, state.RemotePort)
+			}
+		}
+	}
+
+	return report, statusRunning
+}
+
+func printStatus(report *StatusReport) {
+	fmt.Printf("Status:    %s\n", report.State)
+	fmt.Printf("Profile:   %s\n", report.Profile)
+	if report.PID != 0 {
+		fmt.Printf("PID:       %d\n", report.PID)
+	}
+	if report.Connection != "" {
+		fmt.Printf("Tunnel:    %s\n", report.Connection)
+	}
+	if report.Uptime != "" {
+		fmt.Printf("Uptime:    %s (since %s)\n", report.Uptime, report.StartTime.Format(time.RFC3339))
+	}
+	if report.Interface != "" {
+		fmt.Printf("Interface: %s\n", report.Interface)
+	}
+	if report.Address != "" {
+		fmt.Printf("Address:   %s\n", report.Address)
+	}
+	if report.Remote != "" {
+		fmt.Printf("Remote:    %s\n", report.Remote)
+	}
+	if report.Health != nil {
+		printHealth(report.Health)
+	}
+	if report.Supervisor != 0 {
+		fmt.Printf("Supervisor: PID %d, %d restart(s)\n", report.Supervisor, report.Restarts)
+	}
+	if report.LastExit != nil {
+		fmt.Printf("Last exit: %s, code %d, %s (%s)\n", report.LastExit.Time.Format(time.RFC3339),
+			report.LastExit.ExitCode, report.LastExit.Reason, report.LastExit.Uptime)
+	}
+	if report.Error != "" {
+		fmt.Printf("Error:     %s\n", report.Error)
+	}
+}
+
+func printHealth(report *health.Report) {
+	checked := time.Since(report.Time).Round(time.Second)
+	if report.Healthy() {
+		fmt.Printf("Health:    ok (checked %s ago)\n", checked)
+		return
+	}
+	fmt.Printf("Health:    failing, %d check(s) in a row (checked %s ago)\n", report.Failures, checked)
+	for _, result := range report.Results {
+		if result.Error != "" {
+			fmt.Printf("           %s: %s\n", result.Probe, result.Error)
+		}
+	}
+}
+
+// findTunInterface returns the tun/tap device used by the tunnel. A fixed
+// device name from the config wins; otherwise the first tun device found
+// in sysfs is used.
+func findTunInterface(dev string) string {
+	if dev != "" && dev != "tun" && dev != "tap" {
+		return dev
+	}
+
+	entries, err := os.ReadDir("/sys/class/net")
+	if err != nil {
+		return ""
+	}
+	for _, entry := range entries {
+		if _, err := os.Stat(filepath.Join("/sys/class/net", entry.Name(), "tun_flags")); err == nil {
+			return entry.Name()
+		}
+	}
+	return ""
+}
+
+// interfaceAddress returns the first IP address assigned to an interface.
+func interfaceAddress(name string) string {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return ""
+	}
+	addrs, err := iface.Addrs()
+	if err != nil || len(addrs) == 0 {
+		return ""
+	}
+	if ipnet, ok := addrs[0].(*net.IPNet); ok {
+		return ipnet.IP.String()
+	}
+	return addrs[0].String()
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"main/src/hooks"
+	"main/src/management"
+	"main/src/notify"
+	"main/src/state"
+)
+
+// maxExitRecords bounds the exit history kept in the PID file.
+const maxExitRecords = 20
+
+// stopTimeout is how long the supervisor waits for OpenVPN to exit
+// after asking it to stop before killing it. It is also the default of
+// `svpn stop --timeout`.
+const stopTimeout = 10 * time.Second
+
+// supervisorPolicy controls how the supervisor restarts OpenVPN.
+type supervisorPolicy struct {
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// MaxRestarts restarts within Window make the supervisor give up.
+	MaxRestarts int
+	Window      time.Duration
+}
+
+func defaultSupervisorPolicy() supervisorPolicy {
+	return supervisorPolicy{
+		InitialBackoff: time.Second,
+		MaxBackoff:     2 * time.Minute,
+		MaxRestarts:    5,
+		Window:         10 * time.Minute,
+	}
+}
+
+// backoff returns the delay before restart number attempt (0-based):
+// exponential growth capped at MaxBackoff, with the upper half jittered
+// so that several supervisors do not retry in lockstep.
+func (p supervisorPolicy) backoff(attempt int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 0; i < attempt && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// reconnectingEvent is handed to onUpDown like the tunnel up/down events
+// when OpenVPN starts to reconnect, with its reason such as
+// "ping-restart" in Env["reason"].
+const reconnectingEvent = "RECONNECTING"
+
+// eventTracker remembers the last state and fatal message OpenVPN
+// reported, to explain why it exited, and the bytes it received for the
+// health checks.
+// Tunnel up/down and reconnecting events are handed to onUpDown in
+// order, outside the event loop so that slow handlers do not make it
+// drop events.
+type eventTracker struct {
+	mu        sync.Mutex
+	lastState string
+	fatal     string
+	bytesIn   int64
+	counted   bool
+	done      chan struct{}
+}
+
+func trackEvents(client *management.Client, onUpDown func(*management.UpDown), metrics *tunnelMetrics) *eventTracker {
+	t := &eventTracker{done: make(chan struct{})}
+	updowns := make(chan *management.UpDown, 8)
+
+	go func() {
+		defer close(t.done)
+		for event := range updowns {
+			onUpDown(event)
+		}
+	}()
+
+	go func() {
+		defer close(updowns)
+		var reader management.UpDownReader
+		for event := range client.Events() {
+			if event.Type == "UPDOWN" {
+				if updown := reader.Add(event.Data); updown != nil {
+					updowns <- updown
+				}
+				continue
+			}
+
+			var reconnecting *management.UpDown
+			t.mu.Lock()
+			switch event.Type {
+			case "STATE":
+				if state, err := management.ParseState(event.Data); err == nil {
+					t.lastState = state.Name
+					metrics.stateChanged(state.Name, state.Time)
+					if state.Name == reconnectingEvent {
+						reconnecting = &management.UpDown{Event: reconnectingEvent, Env: map[string]string{"reason": state.Description}}
+					}
+				}
+			case "FATAL":
+				t.fatal = event.Data
+			case "BYTECOUNT":
+				if in, out, err := management.ParseByteCount(event.Data); err == nil {
+					t.bytesIn, t.counted = in, true
+					metrics.counted(in, out)
+				}
+			}
+			t.mu.Unlock()
+			if reconnecting != nil {
+				updowns <- reconnecting
+			}
+		}
+	}()
+	return t
+}
+
+// wait blocks until the management connection is closed and every
+// up/down event has been handled.
+func (t *eventTracker) wait() {
+	<-t.done
+}
+
+// connected reports whether the tunnel is up. OpenVPN is connected when
+// it is handed over, so no state yet means connected.
+func (t *eventTracker) connected() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lastState == "" || t.lastState == "CONNECTED"
+}
+
+// received returns the bytes OpenVPN last reported as received, and
+// false before the first report.
+func (t *eventTracker) received() (int64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.bytesIn, t.counted
+}
+
+func (t *eventTracker) reason() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case t.fatal != "":
+		return "fatal: " + t.fatal
+	case t.lastState != "":
+		return "exited in state " + t.lastState
+	}
+	return "exited"
+}
+
+// supervise runs OpenVPN and waits for it to exit. With a restart limit
+// it restarts OpenVPN whenever it exits, until it is stopped by
+// SIGINT/SIGTERM (e.g. from `svpn stop`), the credentials are rejected,
+// or the restart limit is reached. If the very first start fails,
+// supervise returns the error without retrying.
+func (l *vpnLaunch) supervise(policy supervisorPolicy) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	// Holding the claim for as long as we run keeps a second start of
+	// the profile from racing this one
+	file := state.Open(l.stateDir)
+	claim, err := file.Claim()
+	if errors.Is(err, state.ErrRunning) {
+		err = fmt.Errorf("VPN profile %q is already running", l.profile.Name)
+	} else if err == nil {
+		defer claim.Release()
+		if info, readErr := file.Read(); readErr == nil && info.OpenVPNAlive() {
+			err = fmt.Errorf("OpenVPN of profile %q is still running (PID: %d)", l.profile.Name, info.PID)
+		}
+	}
+	if err != nil {
+		if l.ready != nil {
+			l.ready(err)
+		}
+		return err
+	}
+	l.info.Supervisor = os.Getpid()
+	l.info.SupervisorStart, _ = state.StartTime(l.info.Supervisor)
+
+	server, err := l.serveMetrics()
+	if err != nil {
+		if l.ready != nil {
+			l.ready(err)
+		}
+		return err
+	}
+	if server != nil {
+		defer server.Close()
+	}
+
+	// Undo DNS changes a crashed run left behind
+	if err := l.restoreDNS(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+	}
+
+	if l.remote != "" {
+		if err := l.pinRemote(); err != nil {
+			if l.ready != nil {
+				l.ready(err)
+			}
+			return err
+		}
+	}
+
+	// The kill switch stays in place when OpenVPN or svpn die; only
+	// `svpn stop` and `svpn killswitch off` remove it.
+	if l.killswitch {
+		if err := l.enableKillswitch(); err != nil {
+			err = fmt.Errorf("error enabling the kill switch: %v", err)
+			if l.ready != nil {
+				l.ready(err)
+			}
+			return err
+		}
+	}
+
+	// Decrypted or rewritten configs reach OpenVPN only through memory
+	if l.runConfig == nil {
+		l.runConfig = l.configData
+	}
+	if l.runConfig != nil {
+		memfd, path, err := configMemfd(l.runConfig)
+		if err != nil {
+			if l.ready != nil {
+				l.ready(err)
+			}
+			return err
+		}
+		defer memfd.Close()
+		l.runConfigPath = path
+	}
+
+	var restarts []time.Time
+	attempt := 0
+	for first := true; ; first = false {
+		startedAt := time.Now()
+		cmd, client, err := l.start()
+
+		if first {
+			if l.ready != nil {
+				l.ready(err)
+			}
+			if err != nil {
+				l.removeState()
+				if l.info.Killswitch {
+					err = fmt.Errorf("%v (the kill switch is still on, remove it with 'svpn killswitch off')", err)
+				}
+				return err
+			}
+		}
+
+		var record state.Exit
+		if err != nil {
+			record = state.Exit{Time: time.Now(), ExitCode: -1, Reason: "start failed: " + err.Error()}
+		} else {
+			fmt.Println("OpenVPN authenticated, supervising")
+			l.metrics.started()
+			tracker := trackEvents(client, l.handleUpDown, l.metrics)
+			stopChecks := l.checkHealth(client, tracker)
+			if l.metrics != nil && l.health.Interval <= 0 {
+				if err := client.ByteCount(metricsByteCountInterval); err != nil {
+					fmt.Fprintln(os.Stderr, "Error enabling byte counts:", err)
+				}
+			}
+
+			exited := make(chan error, 1)
+			go func() { exited <- cmd.Wait() }()
+
+			select {
+			case waitErr := <-exited:
+				stopChecks()
+				record = exitRecord(cmd, waitErr, tracker.reason())
+			case sig := <-signals:
+				stopChecks()
+				fmt.Printf("Received %v, stopping OpenVPN...\n", sig)
+				l.stopping.Store(true)
+				client.Signal("SIGTERM")
+				select {
+				case <-exited:
+				case <-time.After(stopTimeout):
+					signalProcess(cmd.Process.Pid, "KILL")
+					<-exited
+				}
+				client.Close()
+				tracker.wait()
+				if err := l.restoreDNS(); err != nil {
+					fmt.Fprintln(os.Stderr, "Error:", err)
+				}
+				l.tunnelDown("stop")
+				l.removeState()
+				fmt.Println("VPN shutdown complete")
+				return nil
+			}
+			client.Close()
+			tracker.wait()
+			if err := l.restoreDNS(); err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+			}
+			l.tunnelDown("exit")
+		}
+
+		l.metrics.exited()
+		record.Uptime = time.Since(startedAt).Round(time.Second).String()
+		l.recordExit(record)
+		fmt.Printf("OpenVPN %s (exit code %d)\n", record.Reason, record.ExitCode)
+
+		if errors.Is(err, errAuthFailed) {
+			l.notify(notify.AuthFailed, "VPN authentication failed",
+				fmt.Sprintf("The server rejected the credentials of profile %s, svpn stopped reconnecting", l.profile.Name))
+			return fmt.Errorf("not restarting: %v", err)
+		}
+		if policy.MaxRestarts == 0 {
+			return nil
+		}
+
+		// A connection that stayed up for a while starts the backoff over
+		if time.Since(startedAt) > policy.MaxBackoff {
+			attempt = 0
+		}
+
+		now := time.Now()
+		recent := restarts[:0]
+		for _, t := range restarts {
+			if now.Sub(t) < policy.Window {
+				recent = append(recent, t)
+			}
+		}
+		restarts = recent
+		if len(restarts) >= policy.MaxRestarts {
+			l.recordExit(state.Exit{Time: now, ExitCode: record.ExitCode,
+				Reason: fmt.Sprintf("gave up after %d restarts within %s", len(restarts), policy.Window)})
+			return fmt.Errorf("OpenVPN restarted %d times within %s, giving up", len(restarts), policy.Window)
+		}
+
+		delay := policy.backoff(attempt)
+		attempt++
+		fmt.Printf("Restarting OpenVPN in %s...\n", delay.Round(time.Millisecond))
+		l.notify(notify.Reconnecting, "VPN reconnecting",
+			fmt.Sprintf("OpenVPN of profile %s exited, restarting it in %s", l.profile.Name, delay.Round(time.Second)))
+
+		select {
+		case <-time.After(delay):
+		case <-signals:
+			l.removeState()
+			fmt.Println("VPN shutdown complete")
+			return nil
+		}
+		restarts = append(restarts, time.Now())
+		l.infoMu.Lock()
+		l.info.Restarts++
+		l.infoMu.Unlock()
+		l.runHooks(hooks.Reconnect, "restart")
+	}
+}
+
+// recordExit appends an exit to the history in the PID file.
+func (l *vpnLaunch) recordExit(record state.Exit) {
+	l.info.Exits = append(l.info.Exits, record)
+	if len(l.info.Exits) > maxExitRecords {
+		l.info.Exits = l.info.Exits[len(l.info.Exits)-maxExitRecords:]
+	}
+	if err := l.saveState(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error saving PID:", err)
+	}
+}
+
+// saveState records the tunnel in the profile's state file.
+func (l *vpnLaunch) saveState() error {
+	return l.updateState(func(*state.Info) {})
+}
+
+// updateState changes the recorded state and saves it. Unlike changing
+// info directly, it is safe while OpenVPN runs.
+func (l *vpnLaunch) updateState(change func(info *state.Info)) error {
+	l.infoMu.Lock()
+	defer l.infoMu.Unlock()
+	change(&l.info)
+	return state.Open(l.stateDir).Write(&l.info)
+}
+
+// rootState reports whether the tunnel is recorded in the private
+// directory. As root, svpn keeps its state there, and svpnd only trusts
+// that state: the user's state directory is the user's to change.
+func (l *vpnLaunch) rootState() bool {
+	return os.Geteuid() == 0 && l.privateDir != ""
+}
+
+// removeState removes the record written by updateState.
+func (l *vpnLaunch) removeState() {
+	state.Open(l.stateDir).Remove()
+}
+
+// exitRecord builds the record for a process that has exited.
+func exitRecord(cmd *exec.Cmd, waitErr error, reason string) state.Exit {
+	record := state.Exit{Time: time.Now(), PID: cmd.Process.Pid, Reason: reason}
+
+	state := cmd.ProcessState
+	if state == nil {
+		record.ExitCode = -1
+		if waitErr != nil {
+			record.Reason = waitErr.Error()
+		}
+		return record
+	}
+
+	record.ExitCode = state.ExitCode()
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		record.Signal = status.Signal().String()
+		record.Reason = fmt.Sprintf("killed by %s", record.Signal)
+	}
+	return record
+}
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"main/src/config"
+	"main/src/dns"
+	"main/src/helper"
+	"main/src/killswitch"
+	"main/src/notify"
+	"main/src/paths"
+	"main/src/state"
+)
+
+// svpnd is the root helper the CLI talks to instead of running sudo.
+// It is the svpn binary installed under the name svpnd (or run as
+// `svpn svpnd`) and is meant to run as a systemd service. Callers are
+// identified with SO_PEERCRED; root and members of the svpn group may
+// use it, and always act on their own profiles.
+type svpnd struct {
+	group string
+
+	// mu serializes starts and stops so that two calls cannot race on
+	// the same profile
+	mu sync.Mutex
+}
+
+func runHelper(fs *flag.FlagSet) func(args []string) (any, error) {
+	socket := fs.String("socket", helper.DefaultSocket, "Unix socket to listen on")
+	group := fs.String("group", "svpn", "Group whose members may use svpnd besides root")
+	return func(args []string) (any, error) {
+		if err := parseFlags(fs, args); err != nil {
+			return nil, err
+		}
+
+		if os.Geteuid() != 0 {
+			return nil, failf(exitPermission, "svpnd must run as root")
+		}
+
+		if err := os.MkdirAll(filepath.Dir(*socket), 0755); err != nil {
+			return nil, fail(exitFailure, err)
+		}
+		// Remove a socket left behind by a previous run
+		os.Remove(*socket)
+		listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: *socket, Net: "unix"})
+		if err != nil {
+			return nil, fail(exitFailure, err)
+		}
+		// Anyone may connect; every call is authorized by peer credentials
+		if err := os.Chmod(*socket, 0666); err != nil {
+			return nil, fail(exitFailure, err)
+		}
+
+		d := &svpnd{group: *group}
+		server := &helper.Server{
+			Handlers: map[string]helper.HandlerFunc{
+				helper.MethodStart:      d.start,
+				helper.MethodStop:       d.stop,
+				helper.MethodStatus:     d.status,
+				helper.MethodKillswitch: d.killswitch,
+				helper.MethodDNS:        d.dns,
+			},
+			Authorize: d.authorize,
+			Log: func(format string, args ...any) {
+				fmt.Printf(format+"\n", args...)
+			},
+		}
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			<-signals
+			// Running tunnels keep going; they are separate processes
+			listener.Close()
+		}()
+
+		fmt.Printf("svpnd listening on %s\n", *socket)
+		err = server.Serve(listener)
+		os.Remove(*socket)
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			return nil, fail(exitFailure, err)
+		}
+		return nil, nil
+	}
+}
+
+// helperClient returns a client for svpnd, or nil when svpn runs as root
+// or svpnd is not running and svpn has to fall back to sudo.
+func helperClient() *helper.Client {
+	if os.Geteuid() == 0 {
+		return nil
+	}
+	client, err := helper.Dial(helper.DefaultSocket)
+	if err != nil {
+		if err != helper.ErrUnavailable {
+			warnf("%v, falling back to sudo", err)
+		}
+		return nil
+	}
+	return client
+}
+
+// authorize lets root and members of the svpn group in.
+func (d *svpnd) authorize(peer helper.Peer) error {
+	if peer.UID == 0 {
+		return nil
+	}
+	group, err := user.LookupGroup(d.group)
+	if err != nil {
+		return fmt.Errorf("permission denied: only root may use svpnd until the %q group exists", d.group)
+	}
+	if strconv.Itoa(peer.GID) == group.Gid {
+		return nil
+	}
+	u, err := user.LookupId(strconv.Itoa(peer.UID))
+	if err != nil {
+		return fmt.Errorf("permission denied: unknown user %d", peer.UID)
+	}
+	gids, err := u.GroupIds()
+	if err != nil {
+		return fmt.Errorf("permission denied: %v", err)
+	}
+	for _, gid := range gids {
+		if gid == group.Gid {
+			return nil
+		}
+	}
+	return fmt.Errorf("permission denied: user %s is not in the %q group", u.Username, d.group)
+}
+
+// peerStore returns the calling user, the environment a child acting
+// for them gets and their profile store.
+func peerStore(peer helper.Peer) (*user.User, []string, *profileStore, error) {
+	u, err := user.LookupId(strconv.Itoa(peer.UID))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("unknown user %d: %v", peer.UID, err)
+	}
+	env := peerEnv(u, peer)
+
+	// Resolve the directories exactly as the child will
+	values := map[string]string{}
+	for _, entry := range env {
+		name, value, _ := strings.Cut(entry, "=")
+		values[name] = value
+	}
+	pathEnv := paths.OSEnv()
+	pathEnv.Getenv = func(name string) string { return values[name] }
+	pathEnv.Euid = 0
+	dirs, err := paths.Resolve(pathEnv)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// svpnd's own environment is not the caller's
+	settings, err := config.Load(nil, paths.SystemConfigFile, dirs.ConfigFile)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return u, env, newProfileStore(dirs, settings), nil
+}
+
+// peerEnv makes a child act on behalf of the caller the same way it
+// would under sudo: package paths and chownToInvoker read these. The
+// caller's XDG directories are taken from its environment.
+func peerEnv(u *user.User, peer helper.Peer) []string {
+	env := []string{"SUDO_USER=" + u.Username, "SUDO_UID=" + u.Uid, "SUDO_GID=" + u.Gid}
+	if environ, err := os.ReadFile(fmt.Sprintf("/proc/%d/environ", peer.PID)); err == nil {
+		env = append(env, paths.XDGVars(strings.Split(string(environ), "\x00"))...)
+	}
+	return env
+}
+
+// checkConfigOwner refuses configs the caller could not have written:
+// OpenVPN runs them as root.
+func checkConfigOwner(path string, peer helper.Peer) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("cannot determine the owner of %s", path)
+	}
+	if int(stat.Uid) != peer.UID && stat.Uid != 0 {
+		return fmt.Errorf("%s is not owned by you or root", path)
+	}
+	if info.Mode().Perm()&0002 != 0 {
+		return fmt.Errorf("%s is writable by everyone", path)
+	}
+	return nil
+}
+
+func (d *svpnd) start(peer helper.Peer, params json.RawMessage) (any, error) {
+	var p helper.StartParams
+	if err := helper.Decode(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Credentials == nil {
+		return nil, fmt.Errorf("no credentials given")
+	}
+	if p.AuthVia == "" {
+		p.AuthVia = "management"
+	}
+	if p.AuthVia != "management" && p.AuthVia != "file" {
+		return nil, fmt.Errorf("unknown auth method %q", p.AuthVia)
+	}
+	if p.DNS == "" {
+		p.DNS = dns.MethodAuto
+	}
+	if !dns.ValidMethod(p.DNS) {
+		return nil, fmt.Errorf("unknown DNS method %q", p.DNS)
+	}
+	// The supervisor runs as root, so it runs no probes of the caller's
+	// choosing and serves metrics only where root lends the caller no
+	// privileges
+	if p.HealthProbe != "" {
+		return nil, fmt.Errorf("svpnd does not run custom health probes as root, start with --foreground to use --health-probe")
+	}
+	if p.MetricsAddr != "" {
+		if err := checkMetricsAddr(p.MetricsAddr); err != nil {
+			return nil, err
+		}
+	}
+
+	_, env, store, err := peerStore(peer)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := store.Resolve(p.Profile)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkConfigOwner(profile.Config, peer); err != nil {
+		return nil, err
+	}
+	configData, err := readProfileConfig(profile.Config, p.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := validateOVPNForRoot(profile.Config, configData); err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// The daemon records the tunnel where only root can write
+	stateDir := store.PrivateDir(profile.Name)
+	if err := ensurePrivateDir(stateDir); err != nil {
+		return nil, fmt.Errorf("error creating state directory: %v", err)
+	}
+	running, err := checkExistingVPN(store.TunnelDir(profile.Name))
+	if err != nil {
+		return nil, err
+	}
+	if running {
+		return nil, fmt.Errorf("VPN profile %q is already running", profile.Name)
+	}
+
+	args := []string{"start", profile.Name, "--auth-via", p.AuthVia, "--dns", p.DNS,
+		"--health-interval", p.HealthInterval.String(), "--restricted-config"}
+	if p.HealthFailures > 0 {
+		args = append(args, "--health-failures", strconv.Itoa(p.HealthFailures))
+	}
+	if p.Remote != "" {
+		args = append(args, "--remote", p.Remote)
+	}
+	if p.MetricsAddr != "" {
+		args = append(args, "--metrics-addr", p.MetricsAddr)
+	}
+	if p.Notify != "" {
+		if _, err := notify.ParseEvents(p.Notify); err != nil {
+			return nil, err
+		}
+		args = append(args, "--notify", p.Notify)
+	}
+	if p.Killswitch {
+		args = append(args, "--killswitch")
+	}
+	// Only root chooses the binary svpnd runs as root
+	system, err := config.Load(nil, paths.SystemConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	if system.OpenVPN != "" {
+		args = append(args, "--openvpn", system.OpenVPN)
+	}
+	if p.Supervise {
+		args = append(args, "--supervise")
+		if p.MaxRestarts > 0 {
+			args = append(args, "--max-restarts", strconv.Itoa(p.MaxRestarts))
+		}
+		if p.RestartWindow > 0 {
+			args = append(args, "--restart-window", p.RestartWindow.String())
+		}
+		if p.Backoff > 0 {
+			args = append(args, "--backoff", p.Backoff.String())
+		}
+		if p.MaxBackoff > 0 {
+			args = append(args, "--max-backoff", p.MaxBackoff.String())
+		}
+	}
+
+	cmd, err := launchDaemon(args, env, p.Credentials, p.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+	// Reap the supervisor when it exits
+	go cmd.Wait()
+
+	return helper.StartResult{
+		Profile:    profile.Name,
+		Supervisor: cmd.Process.Pid,
+		Log:        profileLogPath(stateDir),
+	}, nil
+}
+
+func (d *svpnd) stop(peer helper.Peer, params json.RawMessage) (any, error) {
+	var p helper.StopParams
+	if err := helper.Decode(params, &p); err != nil {
+		return nil, err
+	}
+	_, _, store, err := peerStore(peer)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := store.ResolveActive(p.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = stopTimeout
+	}
+	// The profile's state file is the caller's to write; only the copy
+	// root keeps in the private directory says what root may signal
+	privateDir := store.PrivateDir(profile.Name)
+	return stopVPN(profile.Name, state.Open(privateDir), privateDir, timeout)
+}
+
+func (d *svpnd) status(peer helper.Peer, params json.RawMessage) (any, error) {
+	var p helper.ProfileParams
+	if err := helper.Decode(params, &p); err != nil {
+		return nil, err
+	}
+	_, _, store, err := peerStore(peer)
+	if err != nil {
+		return nil, err
+	}
+	report, _ := collectStatus(store, p.Profile)
+	return report, nil
+}
+
+func (d *svpnd) killswitch(peer helper.Peer, params json.RawMessage) (any, error) {
+	var p helper.KillswitchParams
+	if err := helper.Decode(params, &p); err != nil {
+		return nil, err
+	}
+	switch p.Action {
+	case "off":
+		_, _, store, err := peerStore(peer)
+		if err != nil {
+			return nil, err
+		}
+		listing, err := killswitchTable()
+		if err != nil || listing == "" {
+			return nil, err
+		}
+		// Callers only remove the kill switch of their own tunnels
+		owner, _ := killswitch.Owner(listing)
+		if peer.UID != 0 && (owner == "" || store.PrivateDir(filepath.Base(owner)) != owner) {
+			return nil, fmt.Errorf("the kill switch belongs to another user's tunnel")
+		}
+		return nil, removeKillswitch()
+	case "status":
+		active, err := killswitchActive()
+		return helper.KillswitchResult{Active: active}, err
+	}
+	return nil, fmt.Errorf("unknown killswitch action %q", p.Action)
+}
+
+func (d *svpnd) dns(peer helper.Peer, params json.RawMessage) (any, error) {
+	var p helper.DNSParams
+	if err := helper.Decode(params, &p); err != nil {
+		return nil, err
+	}
+	_, _, store, err := peerStore(peer)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := store.ResolveActive(p.Profile)
+	if err != nil {
+		return nil, err
+	}
+	privateDir := store.PrivateDir(profile.Name)
+
+	switch p.Action {
+	case "restore":
+		return nil, restoreProfileDNS(profile.Name, privateDir)
+	case "apply":
+		if p.Method == "" {
+			p.Method = dns.MethodAuto
+		}
+		if !dns.ValidMethod(p.Method) {
+			return nil, fmt.Errorf("unknown DNS method %q", p.Method)
+		}
+		cfg := dns.Config{Interface: p.Interface, Domains: p.Domains}
+		for _, s := range p.Servers {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid DNS server %q", s)
+			}
+			cfg.Servers = append(cfg.Servers, addr)
+		}
+		if err := cfg.Validate(); err != nil {
+			return nil, err
+		}
+		if peer.UID != 0 {
+			if err := checkCallerTunnel(peer, cfg.Interface); err != nil {
+				return nil, err
+			}
+		}
+		launch := &vpnLaunch{profile: profile, privateDir: privateDir, dnsMethod: p.Method}
+		return nil, launch.applyDNS(cfg)
+	}
+	return nil, fmt.Errorf("unknown dns action %q", p.Action)
+}
+
+// checkMetricsAddr lets the supervisor svpnd starts serve metrics only
+// on an unprivileged port of the loopback interface.
+func checkMetricsAddr(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid metrics address %q", addr)
+	}
+	ip, err := netip.ParseAddr(host)
+	if host != "localhost" && (err != nil || !ip.IsLoopback()) {
+		return fmt.Errorf("svpnd only serves metrics on the loopback interface, not %q", host)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1024 || n > 65535 {
+		return fmt.Errorf("svpnd only serves metrics on ports 1024-65535, not %q", port)
+	}
+	return nil
+}
+
+// checkCallerTunnel checks that iface is the tun device of an OpenVPN
+// process the caller started. Tunnels run by root set up DNS
+// themselves; svpnd is only asked by foreground tunnels, whose OpenVPN
+// runs below the caller's process.
+func checkCallerTunnel(peer helper.Peer, iface string) error {
+	if iface == "" {
+		return fmt.Errorf("no tunnel interface given")
+	}
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+		if err != nil || strings.TrimSpace(string(comm)) != "openvpn" {
+			continue
+		}
+		if descendsFrom(pid, peer.PID) && holdsTun(pid, iface) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not the interface of a tunnel you started", iface)
+}
+
+// descendsFrom reports whether process pid runs below ancestor.
+func descendsFrom(pid, ancestor int) bool {
+	for pid > 1 {
+		data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil {
+			return false
+		}
+		// The parent is the second field after the command name, which
+		// may itself contain spaces and parentheses
+		stat := string(data)
+		fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+		if len(fields) < 2 {
+			return false
+		}
+		if pid, err = strconv.Atoi(fields[1]); err != nil {
+			return false
+		}
+		if pid == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+// holdsTun reports whether process pid has the tun device iface open.
+func holdsTun(pid int, iface string) bool {
+	fds, err := os.ReadDir(fmt.Sprintf("/proc/%d/fd", pid))
+	if err != nil {
+		return false
+	}
+	for _, fd := range fds {
+		if link, _ := os.Readlink(fmt.Sprintf("/proc/%d/fd/%s", pid, fd.Name())); link != "/dev/net/tun" {
+			continue
+		}
+		info, err := os.ReadFile(fmt.Sprintf("/proc/%d/fdinfo/%s", pid, fd.Name()))
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(info), "\n") {
+			if name, ok := strings.CutPrefix(line, "iff:"); ok && strings.TrimSpace(name) == iface {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestCheckMetricsAddr(t *testing.T) {
+	tests := []struct {
+		addr    string
+		wantErr bool
+	}{
+		{"127.0.0.1:9100", false},
+		{"[::1]:9100", false},
+		{"localhost:9100", false},
+		{"0.0.0.0:9100", true},
+		{":9100", true},
+		{"192.0.2.1:9100", true},
+		{"127.0.0.1:80", true},
+		{"127.0.0.1", true},
+	}
+	for _, tt := range tests {
+		if err := checkMetricsAddr(tt.addr); (err != nil) != tt.wantErr {
+			t.Errorf("checkMetricsAddr(%q) = %v, want error %v", tt.addr, err, tt.wantErr)
+		}
+	}
+}
+
+func TestDescendsFrom(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skipf("no /proc: %v", err)
+	}
+	if !descendsFrom(os.Getpid(), os.Getppid()) {
+		t.Error("descendsFrom() = false for the parent of the test")
+	}
+	if descendsFrom(os.Getppid(), os.Getpid()) {
+		t.Error("descendsFrom() = true for the child of the test")
+	}
+}
@@ -0,0 +1,210 @@
+package svpnlog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log entry, using the letters of OpenVPN's
+// management interface log format.
+type Level byte
+
+const (
+	LevelDebug   Level = 'D'
+	LevelInfo    Level = 'I'
+	LevelWarning Level = 'W'
+	LevelError   Level = 'N' // non-fatal error
+	LevelFatal   Level = 'F'
+)
+
+// rank orders levels from least to most severe.
+func (l Level) rank() int {
+	switch l {
+	case LevelDebug:
+		return 0
+	case LevelWarning:
+		return 2
+	case LevelError:
+		return 3
+	case LevelFatal:
+		return 4
+	}
+	return 1
+}
+
+// AtLeast reports whether l is as severe as min or more.
+func (l Level) AtLeast(min Level) bool {
+	return l.rank() >= min.rank()
+}
+
+// ParseLevel accepts a level letter or name such as "W" or "warning".
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "d", "debug":
+		return LevelDebug, nil
+	case "i", "info":
+		return LevelInfo, nil
+	case "w", "warn", "warning":
+		return LevelWarning, nil
+	case "n", "e", "error":
+		return LevelError, nil
+	case "f", "fatal":
+		return LevelFatal, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q (use debug, info, warning, error or fatal)", s)
+}
+
+// Entry is one line of a profile log.
+type Entry struct {
+	Time    time.Time
+	Level   Level
+	Source  string // "svpn" or "openvpn"
+	Message string
+}
+
+// timeLayout is the timestamp format of log lines.
+const timeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// String formats the entry as a log line without the trailing newline:
+// "2024-05-01T12:00:00.000+02:00 I openvpn: Initialization Sequence Completed".
+func (e Entry) String() string {
+	return fmt.Sprintf("%s %c %s: %s", e.Time.Format(timeLayout), e.Level, e.Source, e.Message)
+}
+
+// ParseEntry parses a line written by String.
+func ParseEntry(line string) (Entry, error) {
+	fields := strings.SplitN(line, " ", 4)
+	if len(fields) < 4 || len(fields[1]) != 1 || !strings.HasSuffix(fields[2], ":") {
+		return Entry{}, fmt.Errorf("malformed log line: %q", line)
+	}
+	t, err := time.Parse(timeLayout, fields[0])
+	if err != nil {
+		return Entry{}, fmt.Errorf("malformed log time: %v", err)
+	}
+	return Entry{
+		Time:    t,
+		Level:   Level(fields[1][0]),
+		Source:  strings.TrimSuffix(fields[2], ":"),
+		Message: fields[3],
+	}, nil
+}
+
+// OpenVPN message flags printed by --machine-readable-output.
+const (
+	openvpnFatal    = 1 << 4
+	openvpnNonFatal = 1 << 5
+	openvpnWarn     = 1 << 6
+	openvpnDebug    = 1 << 7
+)
+
+// ParseOpenVPNLine turns a line of OpenVPN output into an entry. Lines in
+// --machine-readable-output format ("<unix>.<usec> <hex flags> <message>")
+// keep their time and level; other lines are stamped with now and their
+// level is guessed from the message.
+func ParseOpenVPNLine(line string, now time.Time) Entry {
+	entry := Entry{Time: now, Level: LevelInfo, Source: "openvpn", Message: line}
+
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) == 3 {
+		secs, usecs, ok := strings.Cut(fields[0], ".")
+		sec, err1 := strconv.ParseInt(secs, 10, 64)
+		usec, err2 := strconv.ParseInt(usecs, 10, 64)
+		flags, err3 := strconv.ParseUint(fields[1], 16, 32)
+		if ok && err1 == nil && err2 == nil && err3 == nil {
+			entry.Time = time.Unix(sec, usec*int64(time.Microsecond))
+			entry.Message = fields[2]
+			switch {
+			case flags&openvpnFatal != 0:
+				entry.Level = LevelFatal
+			case flags&openvpnNonFatal != 0:
+				entry.Level = LevelError
+			case flags&openvpnWarn != 0:
+				entry.Level = LevelWarning
+			case flags&openvpnDebug != 0:
+				entry.Level = LevelDebug
+			}
+			return entry
+		}
+	}
+
+	entry.Level = GuessLevel(line)
+	return entry
+}
+
+// GuessLevel infers the level of a free-form message from the prefixes
+// OpenVPN and svpn use.
+func GuessLevel(message string) Level {
+	upper := strings.ToUpper(message)
+	switch {
+	case strings.Contains(upper, "FATAL"), strings.Contains(upper, "EXITING DUE TO"):
+		return LevelFatal
+	case strings.HasPrefix(upper, "ERROR"), strings.Contains(upper, " ERROR:"), strings.Contains(upper, "AUTH_FAILED"):
+		return LevelError
+	case strings.HasPrefix(upper, "WARNING"), strings.Contains(upper, " WARNING:"):
+		return LevelWarning
+	}
+	return LevelInfo
+}
+
+// LineWriter splits written data into lines, turns each into an Entry
+// with Parse and writes it to Out in log format.
+type LineWriter struct {
+	Out   io.Writer
+	Parse func(line string, now time.Time) Entry
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+// NewLineWriter returns a writer that logs every line as source with a
+// level guessed from its content.
+func NewLineWriter(out io.Writer, source string) *LineWriter {
+	return &LineWriter{Out: out, Parse: func(line string, now time.Time) Entry {
+		return Entry{Time: now, Level: GuessLevel(line), Source: source, Message: line}
+	}}
+}
+
+// NewOpenVPNWriter returns a writer that logs OpenVPN output.
+func NewOpenVPNWriter(out io.Writer) *LineWriter {
+	return &LineWriter{Out: out, Parse: ParseOpenVPNLine}
+}
+
+// Flush logs a last line that has no newline.
+func (w *LineWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	line := strings.TrimRight(string(w.buf), "\r")
+	w.buf = nil
+	if line == "" {
+		return nil
+	}
+	_, err := fmt.Fprintln(w.Out, w.Parse(line, time.Now()).String())
+	return err
+}
+
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimRight(string(w.buf[:i]), "\r")
+		w.buf = w.buf[i+1:]
+		if line == "" {
+			continue
+		}
+		if _, err := fmt.Fprintln(w.Out, w.Parse(line, time.Now()).String()); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,140 @@
+package svpnlog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseEntry(t *testing.T) {
+	zone := time.FixedZone("", 2*60*60)
+	entry := Entry{
+		Time:    time.Date(2024, 5, 1, 12, 0, 0, 123e6, zone),
+		Level:   LevelInfo,
+		Source:  "openvpn",
+		Message: "Initialization Sequence Completed: a: b",
+	}
+	line := entry.String()
+	if want := "2024-05-01T12:00:00.123+02:00 I openvpn: Initialization Sequence Completed: a: b"; line != want {
+		t.Errorf("String() = %q, want %q", line, want)
+	}
+	got, err := ParseEntry(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Time.Equal(entry.Time) || got.Level != entry.Level || got.Source != entry.Source || got.Message != entry.Message {
+		t.Errorf("ParseEntry(%q) = %+v, want %+v", line, got, entry)
+	}
+}
+
+func TestParseEntryMalformed(t *testing.T) {
+	for _, line := range []string{
+		"",
+		"Initialization Sequence Completed",
+		"2024-05-01T12:00:00.000Z I openvpn:",
+		"2024-05-01T12:00:00.000Z info openvpn: message",
+		"2024-05-01T12:00:00.000Z I openvpn message",
+		"yesterday I openvpn: message",
+	} {
+		if entry, err := ParseEntry(line); err == nil {
+			t.Errorf("ParseEntry(%q) = %+v, want an error", line, entry)
+		}
+	}
+}
+
+func TestParseOpenVPNLine(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	machine := time.Unix(1714564800, 250000*int64(time.Microsecond))
+	tests := []struct {
+		line string
+		want Entry
+	}{
+		{"1714564800.250000 1 Initialization Sequence Completed", Entry{machine, LevelInfo, "openvpn", "Initialization Sequence Completed"}},
+		{"1714564800.250000 10 Exiting due to fatal error", Entry{machine, LevelFatal, "openvpn", "Exiting due to fatal error"}},
+		{"1714564800.250000 20 TLS Error: handshake failed", Entry{machine, LevelError, "openvpn", "TLS Error: handshake failed"}},
+		{"1714564800.250000 41 WARNING: cipher", Entry{machine, LevelWarning, "openvpn", "WARNING: cipher"}},
+		{"1714564800.250000 80 MANAGEMENT: CMD 'state'", Entry{machine, LevelDebug, "openvpn", "MANAGEMENT: CMD 'state'"}},
+		// Other lines keep their text and get a guessed level
+		{"Options error: unknown option", Entry{now, LevelError, "openvpn", "Options error: unknown option"}},
+		{"OpenVPN 2.6.8 x86_64-pc-linux-gnu", Entry{now, LevelInfo, "openvpn", "OpenVPN 2.6.8 x86_64-pc-linux-gnu"}},
+		{"ERROR: Cannot open TUN/TAP dev", Entry{now, LevelError, "openvpn", "ERROR: Cannot open TUN/TAP dev"}},
+		{"1714564800 1 no microseconds", Entry{now, LevelInfo, "openvpn", "1714564800 1 no microseconds"}},
+		{"1714564800.250000 zz not hex", Entry{now, LevelInfo, "openvpn", "1714564800.250000 zz not hex"}},
+	}
+	for _, tt := range tests {
+		got := ParseOpenVPNLine(tt.line, now)
+		if !got.Time.Equal(tt.want.Time) || got.Level != tt.want.Level || got.Source != tt.want.Source || got.Message != tt.want.Message {
+			t.Errorf("ParseOpenVPNLine(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestGuessLevel(t *testing.T) {
+	tests := []struct {
+		message string
+		want    Level
+	}{
+		{"Initialization Sequence Completed", LevelInfo},
+		{"Exiting due to fatal error", LevelFatal},
+		{"SIGTERM[soft,auth-failure] received, process exiting: FATAL", LevelFatal},
+		{"Error: health check failed", LevelError},
+		{"TLS handshake ERROR: timeout", LevelError},
+		{"AUTH_FAILED", LevelError},
+		{"Warning: DNS was not restored", LevelWarning},
+		{"openvpn WARNING: insecure cipher", LevelWarning},
+		{"No warnings here", LevelInfo},
+	}
+	for _, tt := range tests {
+		if got := GuessLevel(tt.message); got != tt.want {
+			t.Errorf("GuessLevel(%q) = %c, want %c", tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Level
+	}{
+		{"D", LevelDebug}, {"info", LevelInfo}, {"warn", LevelWarning},
+		{"Warning", LevelWarning}, {"e", LevelError}, {"N", LevelError}, {"fatal", LevelFatal},
+	}
+	for _, tt := range tests {
+		if got, err := ParseLevel(tt.s); err != nil || got != tt.want {
+			t.Errorf("ParseLevel(%q) = %c, %v, want %c", tt.s, got, err, tt.want)
+		}
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Error(`ParseLevel("loud") succeeded`)
+	}
+	if !LevelFatal.AtLeast(LevelWarning) || LevelDebug.AtLeast(LevelInfo) || !LevelInfo.AtLeast(LevelInfo) {
+		t.Error("AtLeast() does not order debug < info < warning < error < fatal")
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := NewLineWriter(&out, "hook")
+	for _, chunk := range []string{"first li", "ne\r\n\nsecond\nWARNING: ", "last"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		entry, err := ParseEntry(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(entry.Level)+" "+entry.Source+": "+entry.Message)
+	}
+	want := []string{"I hook: first line", "I hook: second", "W hook: WARNING: last"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("LineWriter wrote %q, want %q", got, want)
+	}
+}
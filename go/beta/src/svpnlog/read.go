@@ -0,0 +1,103 @@
+package svpnlog
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// followInterval is how often Follow polls the log for new lines.
+const followInterval = 500 * time.Millisecond
+
+// ReadLines calls fn for every line of the given files in order.
+func ReadLines(files []string, fn func(line string)) error {
+	for _, path := range files {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			fn(scanner.Text())
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Follow calls fn for every line appended to the log at path from now
+// on, reopening it when it is rotated, until stop is closed.
+func Follow(path string, stop <-chan struct{}, fn func(line string)) error {
+	var file *os.File
+	var info os.FileInfo
+	var offset int64
+	var partial string
+
+	open := func(fromEnd bool) {
+		if file != nil {
+			file.Close()
+			file = nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return
+		}
+		st, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return
+		}
+		file, info, offset, partial = f, st, 0, ""
+		if fromEnd {
+			offset, _ = f.Seek(0, io.SeekEnd)
+		}
+	}
+	open(true)
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	buf := make([]byte, 32*1024)
+	for {
+		if file != nil {
+			for {
+				n, err := file.ReadAt(buf, offset)
+				if n > 0 {
+					offset += int64(n)
+					lines := strings.Split(partial+string(buf[:n]), "\n")
+					partial = lines[len(lines)-1]
+					for _, line := range lines[:len(lines)-1] {
+						fn(strings.TrimRight(line, "\r"))
+					}
+				}
+				if err != nil || n == 0 {
+					break
+				}
+			}
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(followInterval):
+		}
+
+		// Reopen from the start when the log was rotated or truncated
+		st, err := os.Stat(path)
+		switch {
+		case err != nil:
+			continue
+		case file == nil, !os.SameFile(st, info), st.Size() < offset:
+			open(false)
+		}
+	}
+}
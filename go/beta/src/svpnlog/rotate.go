@@ -0,0 +1,126 @@
+// Package svpnlog writes, rotates and reads the per-profile log files
+// that capture svpn's and OpenVPN's output.
+package svpnlog
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// Defaults for the size-based rotation of profile logs.
+const (
+	DefaultMaxSize = 1 << 20 // rotate once a file grows past 1 MiB
+	DefaultKeep    = 5       // keep openvpn.log.1 ... openvpn.log.5
+)
+
+// RotatingFile is an append-only log file that is rotated once it grows
+// past MaxSize bytes, keeping Keep old files named <path>.1 (newest) to
+// <path>.<Keep> (oldest). It is safe for concurrent use.
+type RotatingFile struct {
+	Path    string
+	MaxSize int64
+	Keep    int
+	// OnCreate is called with every file the log opens, e.g. to hand
+	// ownership to the invoking user. It must act on the open file, not
+	// on Path, which may have been replaced in the meantime.
+	OnCreate func(file *os.File)
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenRotating opens or creates the log file at path.
+func OpenRotating(path string, maxSize int64, keep int, onCreate func(*os.File)) (*RotatingFile, error) {
+	r := &RotatingFile{Path: path, MaxSize: maxSize, Keep: keep, OnCreate: onCreate}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	// Never write through a symlink, whoever's directory the log is in
+	file, err := os.OpenFile(r.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		return fmt.Errorf("error opening log file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error opening log file: %v", err)
+	}
+	r.file = file
+	r.size = info.Size()
+	if r.OnCreate != nil {
+		r.OnCreate(file)
+	}
+	return nil
+}
+
+// Write appends p, rotating first if p would push the file past MaxSize.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+	if r.MaxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.MaxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate shifts <path>.N to <path>.N+1, dropping the oldest file, and
+// starts a new empty log.
+func (r *RotatingFile) rotate() error {
+	r.file.Close()
+
+	for i := r.Keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.Path, i), fmt.Sprintf("%s.%d", r.Path, i+1))
+	}
+	if r.Keep > 0 {
+		os.Rename(r.Path, r.Path+".1")
+	} else {
+		os.Remove(r.Path)
+	}
+
+	return r.open()
+}
+
+// Close closes the log file.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// Files returns the paths of the log and its rotated files from oldest
+// to newest, skipping those that do not exist.
+func Files(path string, keep int) []string {
+	var files []string
+	for i := keep; i >= 1; i-- {
+		rotated := fmt.Sprintf("%s.%d", path, i)
+		if _, err := os.Stat(rotated); err == nil {
+			files = append(files, rotated)
+		}
+	}
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+	return files
+}
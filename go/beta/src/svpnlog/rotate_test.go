@@ -0,0 +1,163 @@
+package svpnlog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFileRotatesAtMaxSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "openvpn.log")
+	r, err := OpenRotating(path, 10, 3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for _, s := range []string{"aaaa\n", "bbbb\n", "cccc\n"} {
+		if _, err := r.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first two writes fill the file exactly, the third rotates it
+	want := map[string]string{path + ".1": "aaaa\nbbbb\n", path: "cccc\n"}
+	for file, content := range want {
+		if data, err := os.ReadFile(file); err != nil || string(data) != content {
+			t.Errorf("%s = %q, %v, want %q", filepath.Base(file), data, err, content)
+		}
+	}
+	if _, err := os.Stat(path + ".2"); !os.IsNotExist(err) {
+		t.Errorf("%s.2 exists after one rotation: %v", filepath.Base(path), err)
+	}
+}
+
+func TestRotatingFileKeepsOversizedWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "openvpn.log")
+	r, err := OpenRotating(path, 4, 3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// A write larger than MaxSize goes into an empty file whole
+	if _, err := r.Write([]byte("0123456789\n")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "0123456789\n" {
+		t.Errorf("log = %q, want the whole write", data)
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("an empty log was rotated: %v", err)
+	}
+}
+
+func TestRotatingFilePrunesOldFiles(t *testing.T) {
+	tests := []struct {
+		keep int
+		want []string // contents of Files, oldest first
+	}{
+		{keep: 0, want: []string{"9\n"}},
+		{keep: 1, want: []string{"8\n", "9\n"}},
+		{keep: 3, want: []string{"6\n", "7\n", "8\n", "9\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("keep %d", tt.keep), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "openvpn.log")
+			r, err := OpenRotating(path, 2, tt.keep, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 10; i++ {
+				if _, err := fmt.Fprintf(r, "%d\n", i); err != nil {
+					t.Fatal(err)
+				}
+			}
+			r.Close()
+
+			var got []string
+			if err := ReadLines(Files(path, tt.keep), func(line string) { got = append(got, line+"\n") }); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("logs = %q, want %q", got, tt.want)
+			}
+			if _, err := os.Stat(fmt.Sprintf("%s.%d", path, tt.keep+1)); !os.IsNotExist(err) {
+				t.Errorf("more than %d old files kept: %v", tt.keep, err)
+			}
+		})
+	}
+}
+
+func TestRotatingFileReopens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "openvpn.log")
+	var created []string
+	onCreate := func(file *os.File) { created = append(created, file.Name()) }
+
+	r, err := OpenRotating(path, 10, 2, onCreate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Write([]byte("one\n"))
+	r.Write([]byte("two\n"))
+	r.Close()
+	if _, err := r.Write([]byte("three\n")); err != os.ErrClosed {
+		t.Errorf("Write() after Close = %v, want %v", err, os.ErrClosed)
+	}
+
+	// Reopening counts what is there towards MaxSize and rotates it
+	r, err = OpenRotating(path, 10, 2, onCreate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Write([]byte("four\n"))
+	r.Close()
+
+	if data, _ := os.ReadFile(path + ".1"); string(data) != "one\ntwo\n" {
+		t.Errorf("%s.1 = %q, want the log from before reopening", filepath.Base(path), data)
+	}
+	if want := []string{path, path, path}; !reflect.DeepEqual(created, want) {
+		t.Errorf("OnCreate called with %q, want %q", created, want)
+	}
+	var got []string
+	ReadLines(Files(path, 2), func(line string) { got = append(got, line) })
+	if want := []string{"one", "two", "four"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("logs = %q, want %q", got, want)
+	}
+}
+
+func TestRotatingFileRefusesSymlink(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target")
+	if err := os.WriteFile(target, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "openvpn.log")
+	if err := os.Symlink(target, path); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := OpenRotating(path, DefaultMaxSize, DefaultKeep, nil); err == nil {
+		r.Close()
+		t.Error("OpenRotating() followed a symlink")
+	}
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "openvpn.log")
+	for _, name := range []string{"openvpn.log", "openvpn.log.1", "openvpn.log.3", "openvpn.log.9"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []string
+	for _, file := range Files(path, 5) {
+		got = append(got, strings.TrimPrefix(file, dir+"/"))
+	}
+	if want := []string{"openvpn.log.3", "openvpn.log.1", "openvpn.log"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Files() = %q, want %q", got, want)
+	}
+}
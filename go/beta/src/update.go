@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"main/src/bundle"
+	"main/src/fetch"
+	"main/src/ovpn"
+)
+
+// updateCommand fetches the profile's config again from where `svpn
+// init` got it and installs it once the changes are confirmed.
+func updateCommand(fs *flag.FlagSet) func(args []string) (any, error) {
+	yes := fs.Bool("yes", false, "Apply the update without asking")
+	sha := fs.String("sha256", "", "Expected SHA-256 digest of the new config")
+	return func(args []string) (any, error) {
+		profileName, err := profileArg(fs, args)
+		if err != nil {
+			return nil, err
+		}
+
+		store, err := userProfileStore()
+		if err != nil {
+			return nil, fail(exitFailure, err)
+		}
+		profile, err := store.Resolve(profileName)
+		if err != nil {
+			return nil, fail(exitConfig, err)
+		}
+		dir := store.Dir(profile.Name)
+
+		source, err := loadSource(dir)
+		if err != nil {
+			return nil, fail(exitFailure, err)
+		}
+		if source == nil {
+			return nil, failf(exitFailure, "profile %q has no source to update from, set one with 'svpn init --profile %s --from <url|file>'",
+				profile.Name, profile.Name)
+		}
+
+		// A new config usually comes with a new digest; signatures keep working
+		opts := sourceOptions{From: source.URL, SHA256: source.SHA256, Signature: source.Signature}
+		if *sha != "" {
+			opts.SHA256, opts.Signature = *sha, ""
+		}
+		dl, err := downloadConfig(profile, dir, opts)
+		if err != nil {
+			if opts.SHA256 != "" && errors.Is(err, fetch.ErrVerification) {
+				err = fmt.Errorf("%v (pass the digest of the new config with --sha256)", err)
+			}
+			return nil, fail(exitConfig, err)
+		}
+		if dl.Unchanged {
+			if err := installConfig(store, profile, dl); err != nil {
+				return nil, fail(exitFailure, err)
+			}
+			fmt.Printf("Profile %q is up to date\n", profile.Name)
+			return nil, nil
+		}
+
+		if err := applyConfigChange(store, profile, dl, *yes, "update"); err != nil {
+			return nil, err
+		}
+		fmt.Printf("Profile %q updated, 'svpn rollback %s' restores the previous config\n", profile.Name, profile.Name)
+		return nil, nil
+	}
+}
+
+// rollbackCommand restores the config an update or init replaced. The
+// replaced config is kept in turn, so a second rollback undoes the first.
+func rollbackCommand(fs *flag.FlagSet) func(args []string) (any, error) {
+	yes := fs.Bool("yes", false, "Roll back without asking")
+	return func(args []string) (any, error) {
+		profileName, err := profileArg(fs, args)
+		if err != nil {
+			return nil, err
+		}
+
+		store, err := userProfileStore()
+		if err != nil {
+			return nil, fail(exitFailure, err)
+		}
+		profile, err := store.Resolve(profileName)
+		if err != nil {
+			return nil, fail(exitConfig, err)
+		}
+
+		previous, err := loadPrevious(store.Dir(profile.Name))
+		if err != nil {
+			return nil, fail(exitFailure, err)
+		}
+		if previous == nil {
+			return nil, failf(exitFailure, "profile %q has no previous config to roll back to", profile.Name)
+		}
+
+		if err := applyConfigChange(store, profile, previous, *yes, "rollback"); err != nil {
+			return nil, err
+		}
+		fmt.Printf("Profile %q rolled back to its previous config\n", profile.Name)
+		return nil, nil
+	}
+}
+
+// applyConfigChange shows what replacing the profile's config with dl
+// changes and installs it once confirmed. Declining the change is an
+// error as well.
+func applyConfigChange(store *profileStore, profile *Profile, dl *download, yes bool, action string) error {
+	diff, err := diffConfigs(profile, dl.Data)
+	if err != nil {
+		return fail(exitConfig, err)
+	}
+	printDiff(diff)
+
+	if !yes && !confirm(fmt.Sprintf("Apply the %s to profile %q?", action, profile.Name)) {
+		return failf(exitFailure, "nothing changed")
+	}
+
+	if err := installConfig(store, profile, dl); err != nil {
+		return fail(exitFailure, err)
+	}
+	if isRunning, _ := checkExistingVPN(store.TunnelDir(profile.Name)); isRunning {
+		fmt.Println("The VPN is running with the old config, restart it to use the new one")
+	}
+	return nil
+}
+
+// diffConfigs compares the installed config of a profile with data. An
+// encrypted replacement is tried with the passphrase of the installed
+// config before asking for its own.
+func diffConfigs(profile *Profile, data []byte) (*ovpn.Diff, error) {
+	oldPlain, passphrase, err := unlockConfig(profile.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	var newPlain []byte
+	if passphrase != "" && bundle.Detect(data) != bundle.Plain {
+		if plain, err := bundle.Decrypt(data, passphrase); err == nil {
+			if _, err := validateOVPN("new config", plain); err != nil {
+				return nil, err
+			}
+			newPlain = plain
+		}
+	}
+	if newPlain == nil {
+		if newPlain, _, err = unlockConfigData("new config", data); err != nil {
+			return nil, err
+		}
+	}
+
+	oldCfg, err := ovpn.Parse(bytes.NewReader(oldPlain))
+	if err != nil {
+		return nil, err
+	}
+	newCfg, err := ovpn.Parse(bytes.NewReader(newPlain))
+	if err != nil {
+		return nil, err
+	}
+	return ovpn.Compare(oldCfg, newCfg), nil
+}
+
+func printDiff(diff *ovpn.Diff) {
+	if diff.Empty() {
+		fmt.Println("Only comments or formatting changed")
+		return
+	}
+	sections := []struct {
+		title   string
+		changes []ovpn.Change
+	}{
+		{"Remotes", diff.Remotes},
+		{"Certificates and keys", diff.Certificates},
+		{"Directives", diff.Directives},
+	}
+	for _, section := range sections {
+		if len(section.changes) == 0 {
+			continue
+		}
+		fmt.Printf("%s:\n", section.title)
+		for _, change := range section.changes {
+			fmt.Printf("  %s\n", change)
+		}
+	}
+}
+
+// confirm asks a yes/no question on the terminal; anything but yes is
+// no.
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
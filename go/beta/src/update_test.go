@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"main/src/config"
+	"main/src/fetch"
+	"main/src/paths"
+)
+
+func TestRollbackRestoresPreviousConfig(t *testing.T) {
+	tmp := t.TempDir()
+	store := newProfileStore(&paths.Dirs{
+		Home:    tmp,
+		Config:  filepath.Join(tmp, "config"),
+		State:   filepath.Join(tmp, "state"),
+		Runtime: filepath.Join(tmp, "runtime"),
+		Private: filepath.Join(tmp, "private"),
+		System:  filepath.Join(tmp, "system"),
+	}, &config.Config{})
+	profile := &Profile{Name: "work"}
+	dir := store.Dir(profile.Name)
+
+	first := []byte("client\nremote one.example.com 1194 udp\n")
+	second := []byte("client\nremote two.example.com 443 tcp\n")
+	if err := installConfig(store, profile, &download{Data: first, Source: profileSource{Cache: fetch.Cache{URL: "https://a"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := installConfig(store, profile, &download{Data: second, Source: profileSource{Cache: fetch.Cache{URL: "https://b"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	previous, err := loadPrevious(dir)
+	if err != nil || previous == nil {
+		t.Fatalf("loadPrevious() = %v, %v", previous, err)
+	}
+	if err := applyConfigChange(store, profile, previous, true, "rollback"); err != nil {
+		t.Fatalf("applyConfigChange() = %v", err)
+	}
+
+	data, err := os.ReadFile(profile.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(first) {
+		t.Errorf("config after rollback = %q, want %q", data, first)
+	}
+	if source, err := loadSource(dir); err != nil || source == nil || source.URL != "https://a" {
+		t.Errorf("loadSource() = %+v, %v, want URL https://a", source, err)
+	}
+
+	// The rolled back config is kept in turn
+	previous, err = loadPrevious(dir)
+	if err != nil || previous == nil {
+		t.Fatalf("loadPrevious() = %v, %v", previous, err)
+	}
+	if string(previous.Data) != string(second) || previous.Source.URL != "https://b" {
+		t.Errorf("loadPrevious() = %q from %q, want %q from https://b", previous.Data, previous.Source.URL, second)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"main/src/ovpn"
+)
+
+// validateOVPN parses and validates an OpenVPN config in memory, such
+// as a decrypted bundle, printing every issue with its line number. It
+// fails if the config has errors. name is used in the issue messages.
+func validateOVPN(name string, data []byte) (*ovpn.Config, error) {
+	return checkOVPN(name, data, (*ovpn.Config).Validate)
+}
+
+// validateOVPNForRoot is validateOVPN for the configs svpnd runs as root
+// for other users, see ovpn.Config.ValidateForRoot.
+func validateOVPNForRoot(name string, data []byte) (*ovpn.Config, error) {
+	return checkOVPN(name, data, (*ovpn.Config).ValidateForRoot)
+}
+
+func checkOVPN(name string, data []byte, validate func(*ovpn.Config) *ovpn.Issues) (*ovpn.Config, error) {
+	cfg, err := ovpn.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error reading OpenVPN config: %v", err)
+	}
+	cfg.Name = name
+
+	issues := validate(cfg)
+	for _, line := range issues.Strings() {
+		fmt.Println(line)
+	}
+	if issues.HasErrors() {
+		return nil, issues
+	}
+	return cfg, nil
+}
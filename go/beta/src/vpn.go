@@ -1,22 +1,35 @@
 package main
 
 import (
-	"encoding/json"
+	"bytes"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/fs"
+	"net"
 	"os"
 	"os/exec"
-	"os/user"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
-)
 
-// PIDInfo stores the process information
-type PIDInfo struct {
-	PID       int       `json:"pid"`
-	StartTime time.Time `json:"start_time"`
-}
+	"golang.org/x/sys/unix"
+
+	"main/src/credentials"
+	"main/src/dns"
+	"main/src/helper"
+	"main/src/hooks"
+	"main/src/management"
+	"main/src/notify"
+	"main/src/ovpn"
+	"main/src/paths"
+	"main/src/state"
+	"main/src/svpnlog"
+)
 
 func checkSudo() error {
 	cmd := exec.Command("sudo", "-v")
@@ -30,158 +43,548 @@ func ensureConfigDir(configPath string) error {
 	return os.MkdirAll(configPath, 0700)
 }
 
-func savePID(pid int, configPath string) error {
-	pidInfo := PIDInfo{
-		PID:       pid,
-		StartTime: time.Now(),
+// ensureRunDir creates a directory returned by profileStore.RunDir.
+func ensureRunDir(dir string) error {
+	if os.Geteuid() == 0 {
+		return ensurePrivateDir(dir)
 	}
+	return ensureConfigDir(dir)
+}
 
-	data, err := json.Marshal(pidInfo)
-	if err != nil {
-		return fmt.Errorf("error marshaling PID info: %v", err)
+// ensurePrivateDir creates a profile's directory below paths.PrivateDir.
+// Only root may do so, and nothing in it may belong to the user.
+func ensurePrivateDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
 	}
-
-	pidFile := filepath.Join(configPath, "pid.json")
-	err = os.WriteFile(pidFile, data, 0600)
+	info, err := os.Lstat(dir)
 	if err != nil {
-		return fmt.Errorf("error writing PID file: %v", err)
+		return err
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); !ok || !info.IsDir() || stat.Uid != 0 {
+		return fmt.Errorf("%s is not a directory owned by root", dir)
 	}
-
 	return nil
 }
 
+// checkExistingVPN reports whether the tunnel of a profile is running,
+// and removes state left behind by one that is not. Only root removes
+// what a tunnel run by root left behind.
 func checkExistingVPN(configPath string) (bool, error) {
-	pidFile := filepath.Join(configPath, "pid.json")
-
-	// Check if PID file exists
-	if _, err := os.Stat(pidFile); os.IsNotExist(err) {
-		return false, nil
-	}
-
-	// Read the PID file
-	data, err := os.ReadFile(pidFile)
-	if err != nil {
-		return false, fmt.Errorf("error reading PID file: %v", err)
+	file := state.Open(configPath)
+	if file.Claimed() {
+		return true, nil
 	}
 
-	var pidInfo PIDInfo
-	if err := json.Unmarshal(data, &pidInfo); err != nil {
-		// If PID file is corrupted, remove it
-		os.Remove(pidFile)
+	info, err := file.Read()
+	switch {
+	case errors.Is(err, state.ErrNoState):
 		return false, nil
+	case errors.Is(err, state.ErrCorrupt):
+		return false, removeStaleState(file)
+	case err != nil:
+		return false, err
 	}
 
-	// Check if the process exists
-	process, err := os.FindProcess(pidInfo.PID)
-	if err != nil {
-		// Process not found, clean up PID file
-		os.Remove(pidFile)
-		return false, nil
+	// A supervisor waiting to restart OpenVPN counts as running
+	if info.SupervisorAlive() || info.OpenVPNAlive() {
+		return true, nil
 	}
+	return false, removeStaleState(file)
+}
 
-	// Check if process is still running
-	if err := process.Signal(syscall.Signal(0)); err != nil {
-		// Process is not running, clean up PID file
-		os.Remove(pidFile)
-		return false, nil
+func removeStaleState(file *state.File) error {
+	if err := file.Remove(); err != nil && !errors.Is(err, fs.ErrPermission) {
+		return err
 	}
-
-	// Process exists and is running
-	return true, nil
+	return nil
 }
 
-func main_vpn() {
-	// Check sudo permissions first
-	fmt.Println("Checking sudo permissions...")
-	if err := checkSudo(); err != nil {
-		fmt.Println("Error: This program requires sudo privileges")
-		fmt.Println("Please run with sudo or enter your password when prompted")
-		os.Exit(1)
-	}
+func main_vpn(fs *flag.FlagSet) func(args []string) (any, error) {
+	credSpec := fs.String("credentials", "", "Credentials backend to use instead of the one saved by init")
+	authVia := fs.String("auth-via", "management", "How to hand credentials to OpenVPN: management or file")
+	foreground := fs.Bool("foreground", false, "Stay attached to the terminal instead of running in the background")
+	supervise := fs.Bool("supervise", false, "Restart OpenVPN when it exits")
+	policy := defaultSupervisorPolicy()
+	fs.IntVar(&policy.MaxRestarts, "max-restarts", policy.MaxRestarts, "Give up after this many restarts within --restart-window")
+	fs.DurationVar(&policy.Window, "restart-window", policy.Window, "Time window for --max-restarts")
+	fs.DurationVar(&policy.InitialBackoff, "backoff", policy.InitialBackoff, "Delay before the first restart")
+	fs.DurationVar(&policy.MaxBackoff, "max-backoff", policy.MaxBackoff, "Upper bound for the restart delay")
+	dnsMethod := fs.String("dns", dns.MethodAuto, "How to apply pushed DNS servers: auto, resolved, resolvconf or off")
+	killswitch := fs.Bool("killswitch", false, "Block all traffic outside the tunnel with nftables")
+	checks := defaultHealthPolicy()
+	fs.DurationVar(&checks.Interval, "health-interval", checks.Interval, "Time between health checks of the tunnel, 0 to turn them off")
+	fs.IntVar(&checks.Failures, "health-failures", checks.Failures, "Reconnect after this many failed health checks in a row")
+	fs.StringVar(&checks.Probe, "health-probe", "", "Extra health check: tcp:<host>:<port>, dns:<name> or an http(s) URL")
+	remote := fs.String("remote", "", "Connect only to this server: <host>:<port>[/<proto>]")
+	fastest := fs.Bool("fastest", false, "Connect to the server with the lowest latency, see 'svpn servers'")
+	metricsAddr := fs.String("metrics-addr", "", "Serve Prometheus metrics at http://<host:port>/metrics (requires --supervise)")
+	openvpn := fs.String("openvpn", defaultOpenVPN, "OpenVPN binary to run")
+	notifyEvents := fs.String("notify", "all", "Desktop notifications when supervising: all, none or a comma-separated list of connected, disconnected, reconnecting and auth-failed")
+	stage := fs.Int("daemon-stage", 0, "Internal: step of starting the background process")
+	forRoot := fs.Bool("restricted-config", false, "Internal: the config is svpnd's to vet, see validateOVPNForRoot")
+	return func(args []string) (any, error) {
+		profileName, err := profileArg(fs, args)
+		if err != nil {
+			return nil, err
+		}
 
-	// Get current username
-	currentUser, err := user.Current()
-	if err != nil {
-		fmt.Println("Error getting current user:", err)
-		os.Exit(1)
-	}
+		// Get the user who started svpn, also when running under sudo
+		dirs, err := paths.Current()
+		if err != nil {
+			return nil, fail(exitFailure, err)
+		}
+		username := dirs.User.Username
 
-	username := currentUser.Username
+		// Look up the profile to start
+		settings, err := loadConfig(dirs)
+		if err != nil {
+			return nil, fail(exitConfig, err)
+		}
+		store := newProfileStore(dirs, settings)
+		profile, err := store.Resolve(profileName)
+		if err != nil {
+			return nil, fail(exitConfig, err)
+		}
 
-	// Create config directory path
-	configPath := filepath.Join(currentUser.HomeDir, ".config", "secret_vpn")
+		// Flags left out come from the config files and the environment.
+		// The later stages get them on their command line.
+		if *stage == 0 {
+			if problems := checkSettings("", profile.Settings); len(problems) > 0 {
+				return nil, failf(exitConfig, "invalid setting %s", strings.Join(problems, "; "))
+			}
+			configured, err := applySettings(fs, settingFlags(settings, profile.Settings))
+			if err != nil {
+				return nil, fail(exitConfig, err)
+			}
+			args = append(args, configured...)
+		}
 
-	// Ensure config directory exists
-	if err := ensureConfigDir(configPath); err != nil {
-		fmt.Println("Error creating config directory:", err)
-		os.Exit(1)
-	}
+		if *authVia != "management" && *authVia != "file" {
+			return nil, failf(exitUsage, "unknown --auth-via value %q (expected management or file)", *authVia)
+		}
+		if !dns.ValidMethod(*dnsMethod) {
+			return nil, failf(exitUsage, "unknown --dns value %q (expected auto, resolved, resolvconf or off)", *dnsMethod)
+		}
+		if !*supervise {
+			policy.MaxRestarts = 0
+		}
+		if *metricsAddr != "" {
+			if !*supervise {
+				return nil, failf(exitUsage, "--metrics-addr requires --supervise")
+			}
+			if _, _, err := net.SplitHostPort(*metricsAddr); err != nil {
+				return nil, failf(exitUsage, "invalid --metrics-addr %q (expected <host>:<port>)", *metricsAddr)
+			}
+		}
+		if err := checks.validate(); err != nil {
+			return nil, fail(exitUsage, err)
+		}
+		notifyOn, err := notify.ParseEvents(*notifyEvents)
+		if err != nil {
+			return nil, failf(exitUsage, "invalid --notify: %v", err)
+		}
+		if *remote != "" {
+			if _, err := parseRemote(*remote); err != nil {
+				return nil, fail(exitUsage, err)
+			}
+		}
 
-	// Check for existing VPN process
-	isRunning, err := checkExistingVPN(configPath)
-	if err != nil {
-		fmt.Printf("Error checking existing VPN process: %v\n", err)
-		os.Exit(1)
-	}
-	if isRunning {
-		fmt.Println("VPN is already running")
-		fmt.Println("Use 'svpn stop' to stop the existing VPN before starting a new one")
-		os.Exit(1)
-	}
+		// svpnd starts the VPN in the background without sudo when it runs
+		var client *helper.Client
+		if *stage == 0 && !*foreground {
+			client = helperClient()
+		}
 
-	// Wait group to prevent the main program from exiting immediately
-	var wg sync.WaitGroup
-	wg.Add(1)
+		// The background stages already run as root through sudo
+		if *stage == 0 && client == nil {
+			// Check sudo permissions first
+			fmt.Println("Checking sudo permissions...")
+			if err := checkSudo(); err != nil {
+				return nil, failf(exitPermission, "this program requires sudo privileges, please run with sudo or enter your password when prompted")
+			}
+		}
 
-	// Run OpenVPN in a goroutine
-	go func() {
-		defer wg.Done()
+		ovpnConfig := profile.Config
 
-		// Build the OpenVPN command
-		sudoCmd := exec.Command("sudo", append([]string{"/usr/sbin/openvpn"},
-			"--config", "/home/"+username+"/.open_vpn/config.ovpn",
-			"--auth-user-pass", "/etc/openvpn/auth.txt")...)
+		// Each profile keeps its runtime state in its own directory, in
+		// the private one for tunnels run by root
+		configPath := store.RunDir(profile.Name)
+		runtimePath := store.RuntimeDir(profile.Name)
+		if os.Geteuid() == 0 {
+			runtimePath = configPath
+		}
 
-		// Redirect stdout and stderr to the background
-		sudoCmd.Stdout = nil
-		sudoCmd.Stderr = nil
+		// The passphrase of an encrypted profile travels with the credentials
+		var configData []byte
+		var passphrase string
+		if *stage == 0 {
+			// Refuse to launch OpenVPN with a broken or unsafe config
+			configData, passphrase, err = unlockConfig(ovpnConfig)
+			if err != nil {
+				return nil, fail(exitConfig, err)
+			}
 
-		fmt.Println("Starting OpenVPN as root in the background...")
+			// Ensure the state directories exist
+			for _, dir := range []string{configPath, runtimePath} {
+				if err := ensureRunDir(dir); err != nil {
+					return nil, failf(exitFailure, "error creating state directory: %v", err)
+				}
+			}
 
-		// Execute the command
-		err := sudoCmd.Start()
+			// Check for existing VPN process
+			isRunning, err := checkExistingVPN(store.TunnelDir(profile.Name))
+			if err != nil {
+				return nil, failf(exitFailure, "error checking existing VPN process: %v", err)
+			}
+			if isRunning {
+				return nil, failf(exitRunning, "VPN profile %q is already running, use 'svpn stop %s' to stop it before starting a new one", profile.Name, profile.Name)
+			}
+
+			// The later stages start on the server picked here
+			if *fastest && *remote == "" {
+				cfg, err := ovpn.Parse(bytes.NewReader(configData))
+				if err == nil {
+					cfg.Name = ovpnConfig
+					*remote, err = fastestRemote(cfg, configPath)
+				}
+				switch {
+				case err != nil:
+					warnf("Could not find the fastest server: %v", err)
+				case *remote == "":
+					warnf("No server answered, trying them in the config's order")
+				default:
+					args = append(args, "--remote", *remote)
+				}
+			}
+		}
+
+		// Fetch the credentials before launching so prompts happen up front.
+		// The background stages receive them from the previous stage.
+		var creds *credentials.Credentials
+		switch *stage {
+		case 0:
+			if *credSpec == "" {
+				*credSpec = profile.CredentialsSpec()
+			}
+			provider, err := credentials.New(*credSpec)
+			if err != nil {
+				return nil, fail(exitUsage, err)
+			}
+			creds, err = provider.Get()
+			if err != nil {
+				return nil, failf(exitAuth, "error reading credentials from %s: %v", provider.Name(), err)
+			}
+		case 1:
+			creds, passphrase, err = readHandoff(os.Stdin)
+		case 2:
+			creds, passphrase, err = readHandoff(os.NewFile(daemonCredentialsFD, "credentials"))
+			if err == nil {
+				configData, err = readProfileConfig(ovpnConfig, passphrase)
+			}
+			// The caller may change the file after svpnd vetted it, so
+			// vet what is read here and run exactly that
+			if err == nil && *forRoot {
+				_, err = validateOVPNForRoot(ovpnConfig, configData)
+			}
+		}
 		if err != nil {
-			fmt.Println("Error starting OpenVPN command:", err)
-			return
+			return nil, fail(exitFailure, err)
 		}
 
-		// Get and save the PID
-		pid := sudoCmd.Process.Pid
-		if err := savePID(pid, configPath); err != nil {
-			fmt.Println("Error saving PID:", err)
-		} else {
-			fmt.Printf("OpenVPN started with PID: %d (saved to %s)\n", pid, filepath.Join(configPath, "pid.json"))
+		launch := &vpnLaunch{
+			profile:    profile,
+			stateDir:   configPath,
+			runtimeDir: runtimePath,
+			privateDir: store.PrivateDir(profile.Name),
+			username:   username,
+			creds:      creds,
+			authVia:    *authVia,
+
+			dnsMethod:  *dnsMethod,
+			killswitch: *killswitch,
+			health:     checks,
+			remote:     *remote,
+			openvpn:    *openvpn,
+
+			metricsAddr: *metricsAddr,
+		}
+		// Encrypted profiles run from memory, never from a decrypted file
+		if passphrase != "" || *forRoot {
+			launch.configData = configData
+		}
+		if err := launch.loadHooks(dirs); err != nil {
+			return nil, fail(exitConfig, err)
+		}
+		// Only a supervisor notices the tunnel change while nobody watches
+		if *supervise && len(notifyOn) > 0 {
+			desktop, err := desktopNotifier(dirs.User)
+			if err != nil {
+				return nil, fail(exitFailure, err)
+			}
+			launch.notifier, launch.notifyOn = desktop, notifyOn
 		}
 
-		// Process runs in background, we don't wait for it
-		go func() {
-			err := sudoCmd.Wait()
+		switch {
+		case *stage == 1:
+			return nil, spawnDaemon(args, creds, passphrase)
+		case *stage == 2:
+			return nil, runDaemon(launch, policy)
+		case *foreground:
+			fmt.Printf("Running OpenVPN profile %q in the foreground, press Ctrl+C or run 'svpn stop %s' to stop\n", profile.Name, profile.Name)
+			logFile, err := openProfileLog(configPath)
 			if err != nil {
-				fmt.Println("OpenVPN process exited with error:", err)
+				return nil, fail(exitFailure, err)
 			}
-		}()
-	}()
+			defer logFile.Close()
+			launch.output = io.MultiWriter(os.Stdout, svpnlog.NewOpenVPNWriter(logFile))
+			launch.log = io.MultiWriter(os.Stdout, logFile)
+
+			if err := launch.supervise(policy); err != nil {
+				return nil, fail(startExitCode(err), err)
+			}
+		case client != nil:
+			fmt.Printf("Asking svpnd to start OpenVPN profile %q...\n", profile.Name)
+			params := helper.StartParams{
+				Profile:     profile.Name,
+				Credentials: creds,
+				Passphrase:  passphrase,
+				AuthVia:     *authVia,
+				DNS:         *dnsMethod,
+				Killswitch:  *killswitch,
+				Supervise:   *supervise,
+
+				HealthInterval: checks.Interval,
+				HealthFailures: checks.Failures,
+				HealthProbe:    checks.Probe,
+				Remote:         *remote,
+				MetricsAddr:    *metricsAddr,
+				Notify:         *notifyEvents,
+			}
+			if *supervise {
+				params.MaxRestarts = policy.MaxRestarts
+				params.RestartWindow = policy.Window
+				params.Backoff = policy.InitialBackoff
+				params.MaxBackoff = policy.MaxBackoff
+			}
+			var result helper.StartResult
+			if err := client.Call(helper.MethodStart, params, &result); err != nil {
+				return nil, fail(startExitCode(err), err)
+			}
+			fmt.Printf("OpenVPN authenticated and is running in the background (supervisor PID: %d)\n", result.Supervisor)
+			fmt.Printf("Logs are written to %s, view them with 'svpn logs %s'\n", result.Log, profile.Name)
+			return &result, nil
+		default:
+			fmt.Printf("Starting OpenVPN profile %q as root in the background...\n", profile.Name)
+			if err := startBackground(args, creds, passphrase); err != nil {
+				return nil, fail(startExitCode(err), err)
+			}
+			// The tunnel runs as root and records itself in the private directory
+			tunnelDir := store.TunnelDir(profile.Name)
+			result := &helper.StartResult{Profile: profile.Name, Log: profileLogPath(tunnelDir)}
+			if info, err := state.Open(tunnelDir).Read(); err == nil {
+				result.Supervisor = info.Supervisor
+			}
+			fmt.Printf("Logs are written to %s, view them with 'svpn logs %s'\n", result.Log, profile.Name)
+			return result, nil
+		}
+		return nil, nil
+	}
+}
+
+// vpnLaunch holds everything needed to (re)start OpenVPN for a profile.
+type vpnLaunch struct {
+	profile    *Profile
+	stateDir   string
+	runtimeDir string
+	// privateDir holds the DNS journal and, when svpn runs as root, the
+	// state root acts on later, see profileStore.PrivateDir
+	privateDir string
+	username   string
+	creds      *credentials.Credentials
+	authVia    string
+
+	// dnsMethod is how pushed DNS settings are applied, see package dns
+	dnsMethod string
+
+	// configData is the decrypted config of an encrypted profile
+	configData []byte
+
+	// killswitch installs the nftables kill switch before the first
+	// start; runConfig is then the config with resolved remotes.
+	killswitch bool
+
+	// health controls the checks of the running tunnel
+	health healthPolicy
+
+	// remote is the only server OpenVPN connects to, see parseRemote
+	remote string
+	// openvpn is the openvpn binary
+	openvpn string
+
+	// metricsAddr is where the supervisor serves Prometheus metrics
+	metricsAddr string
+	metrics     *tunnelMetrics
+
+	// runConfig is the config OpenVPN runs with when it is not the
+	// profile's file as is. It is handed over in memory at runConfigPath.
+	runConfig     []byte
+	runConfigPath string
+
+	// output receives OpenVPN's stdout and stderr
+	output io.Writer
+	// log is the profile log, which receives the output of hooks
+	log io.Writer
+
+	// hooks run on events of the tunnel. tunnel is the environment of
+	// the last up event until the tunnel goes down; only the event
+	// handler, or the supervisor once it has stopped, uses it.
+	hooks  *hooks.Runner
+	tunnel map[string]string
+	// notifier tells the user about the events in notifyOn, nil when
+	// svpn does not supervise OpenVPN
+	notifier notify.Notifier
+	notifyOn map[notify.Event]bool
+	// stopping is set once the supervisor is asked to stop
+	stopping atomic.Bool
+	// ready is called once with the result of the first start
+	ready func(error)
+
+	// info is saved to the PID file on every start; the supervisor
+	// keeps its restart history here.
+	info state.Info
+	// infoMu guards info against the event and health check goroutines
+	infoMu sync.Mutex
+}
+
+// start launches OpenVPN, records its PID and hands it the credentials.
+// On success it returns the running command and a connected management
+// client; the caller must close the client.
+func (l *vpnLaunch) start() (*exec.Cmd, *management.Client, error) {
+	reason := "start"
+	if l.info.Restarts > 0 {
+		reason = "restart"
+	}
+	if err := l.runHooks(hooks.PreUp, reason); err != nil {
+		return nil, nil, fmt.Errorf("not starting OpenVPN: %v", err)
+	}
 
-	// Give the goroutine a moment to start the process
-	time.Sleep(1 * time.Second)
+	// Remove a socket left behind by a previous run
+	socketPath := managementSocketPath(l.runtimeDir)
+	os.Remove(socketPath)
 
-	fmt.Println("OpenVPN launcher has started the process, continuing execution...")
+	// Build the OpenVPN command. OpenVPN waits on the management hold
+	// until we have connected, so no prompt can be missed.
+	openvpnArgs := []string{"--config", l.profile.Config}
+	if l.runConfigPath != "" {
+		// Relative paths in the config stay relative to its directory
+		openvpnArgs = []string{"--cd", filepath.Dir(l.profile.Config), "--config", l.runConfigPath}
+	}
+	openvpnArgs = append(openvpnArgs, managementArgs(socketPath, l.username)...)
+	openvpnArgs = append(openvpnArgs, "--management-hold")
+	if l.dnsMethod != dns.MethodOff || l.hooks.Has(hooks.Up) || l.hooks.Has(hooks.Down) || l.hooks.Has(hooks.Reconnect) || l.notifier != nil {
+		// Report tunnel up/down with the pushed options for handleUpDown
+		openvpnArgs = append(openvpnArgs, "--management-up-down")
+	}
+
+	creds := l.creds
+	if l.authVia == "file" {
+		authFile, err := writeAuthFile(l.stateDir, creds)
+		if err != nil {
+			return nil, nil, err
+		}
+		// OpenVPN keeps the credentials in memory once it has read them
+		defer os.Remove(authFile)
+		openvpnArgs = append(openvpnArgs, "--auth-user-pass", authFile)
+		creds = nil
+	} else {
+		openvpnArgs = append(openvpnArgs, "--auth-user-pass", "--management-query-passwords")
+	}
+
+	// Timestamped lines with level flags for the profile log
+	if l.output != nil {
+		openvpnArgs = append(openvpnArgs, "--machine-readable-output")
+	}
+
+	sudoCmd := rootCommand(l.openvpn, openvpnArgs...)
+
+	// Capture stdout and stderr in the profile log
+	sudoCmd.Stdout = l.output
+	sudoCmd.Stderr = l.output
+
+	// Execute the command
+	if err := sudoCmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("error starting OpenVPN command: %v", err)
+	}
+
+	// Get and save the PID
+	pid := sudoCmd.Process.Pid
+	l.info.PID = pid
+	l.info.PIDStart, _ = state.StartTime(pid)
+	l.info.StartTime = time.Now()
+	l.info.Profile = l.profile.Name
+	l.info.Config = l.profile.Config
+	l.info.Management = socketPath
+	l.info.Interface = ""
+	l.info.Health = nil
+	if err := l.saveState(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error saving PID:", err)
+	} else {
+		fmt.Printf("OpenVPN started with PID: %d (saved to %s)\n", pid, state.Open(l.stateDir).Path())
+	}
+
+	client, err := waitForManagement(socketPath, authTimeout)
+	if err != nil {
+		signalProcess(pid, "KILL")
+		sudoCmd.Wait()
+		return nil, nil, fmt.Errorf("error connecting to the OpenVPN management interface: %v", err)
+	}
+
+	if err := authenticate(client, creds); err != nil {
+		client.Signal("SIGTERM")
+		client.Close()
+		sudoCmd.Wait()
+		return nil, nil, err
+	}
 
-	// If you want the program to exit after launching OpenVPN,
-	// you can comment out the wg.Wait() below
-	// Uncomment it if you want the program to stay running
+	return sudoCmd, client, nil
+}
 
-	// wg.Wait()
+// rootCommand returns a command running as root, directly when svpn
+// already is root and through sudo otherwise.
+func rootCommand(name string, args ...string) *exec.Cmd {
+	if os.Geteuid() == 0 {
+		return exec.Command(name, args...)
+	}
+	return exec.Command("sudo", append([]string{name}, args...)...)
+}
 
-	// The program will exit here, but OpenVPN will continue running in the background
+// parseConfig parses the config OpenVPN runs with: the rewritten config
+// if there is one, else the profile's config, decrypted if it is
+// encrypted.
+func (l *vpnLaunch) parseConfig() (*ovpn.Config, error) {
+	data := l.runConfig
+	if data == nil {
+		data = l.configData
+	}
+	if data == nil {
+		return ovpn.ParseFile(l.profile.Config)
+	}
+	cfg, err := ovpn.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	cfg.Name = l.profile.Config
+	return cfg, nil
+}
+
+// signalProcess sends a signal such as "TERM" or "KILL" to a process,
+// through sudo when it belongs to root.
+func signalProcess(pid int, signal string) error {
+	if sig := unix.SignalNum("SIG" + signal); sig != 0 {
+		if err := syscall.Kill(pid, sig); err != syscall.EPERM {
+			return err
+		}
+	}
+	return rootCommand("kill", "-"+signal, fmt.Sprintf("%d", pid)).Run()
 }